@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for account cap options
+const maxAccountsKey = "maxAccounts"
+
+// Define objectType names for the account counter
+const accountCountPrefix = "accountCount"
+
+// accountCountShards is the number of keys the account counter is spread over,
+// so that concurrent account creations rarely conflict on the same key
+const accountCountShards = 16
+
+// accountCountResponse is the JSON document returned by CurrentAccountCount
+type accountCountResponse struct {
+	Token       string `json:"token"`
+	Count       int    `json:"count"`
+	MaxAccounts int    `json:"maxAccounts"`
+}
+
+// SetMaxAccounts sets the maximum number of distinct balance keys; 0 removes the cap
+// Only admins can change the cap. Credits to existing accounts are never blocked.
+func (s *SmartContract) SetMaxAccounts(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	maxAccounts, err := strconv.Atoi(args[0])
+	if err != nil || maxAccounts < 0 {
+		return shim.Error("Invalid account cap. Expecting a non-negative numeric string")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(maxAccountsKey, []byte(strconv.Itoa(maxAccounts)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", maxAccountsKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CurrentAccountCount returns the number of balance keys created since account counting was introduced
+func (s *SmartContract) CurrentAccountCount(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	count, err := getAccountCount(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	maxAccounts, err := getMaxAccounts(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(accountCountResponse{Token: symbol, Count: count, MaxAccounts: maxAccounts})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// registerAccount counts a new balance key, failing with ERR_ACCOUNT_CAP if the cap is reached
+// Reads do not see the writes of the transaction itself, so the accounts registered earlier in the
+// transaction are taken from the txStub.
+func registerAccount(APIstub shim.ChaincodeStubInterface, account string) error {
+	tx, ok := APIstub.(*txStub)
+	if !ok {
+		return fmt.Errorf("accounts can only be registered within a transaction")
+	}
+	maxAccounts, err := getMaxAccounts(APIstub)
+	if err != nil {
+		return err
+	}
+	if maxAccounts > 0 {
+		count, err := getAccountCount(APIstub)
+		if err != nil {
+			return err
+		}
+		if count+tx.newAccounts >= maxAccounts {
+			return fmt.Errorf("ERR_ACCOUNT_CAP: the maximum of %d accounts has been reached", maxAccounts)
+		}
+	}
+
+	shardKey, err := accountCountShardKey(APIstub, account)
+	if err != nil {
+		return err
+	}
+	shardCount, written := tx.accountShards[shardKey]
+	if !written {
+		shardBytes, err := APIstub.GetState(shardKey)
+		if err != nil {
+			return stateError(APIstub, "GetState", accountCountPrefix, err)
+		}
+		shardCount, _ = strconv.Atoi(string(shardBytes))
+	}
+	err = APIstub.PutState(shardKey, []byte(strconv.Itoa(shardCount+1)))
+	if err != nil {
+		return stateError(APIstub, "PutState", accountCountPrefix, err)
+	}
+	if tx.accountShards == nil {
+		tx.accountShards = make(map[string]int)
+	}
+	tx.accountShards[shardKey] = shardCount + 1
+	tx.newAccounts++
+	return nil
+}
+
+// getAccountCount sums the account counter over all shards
+func getAccountCount(APIstub shim.ChaincodeStubInterface) (int, error) {
+	count := 0
+	_, err := iterate(APIstub, accountCountPrefix, []string{}, 0, "", func(attributes []string, value []byte) error {
+		shardCount, _ := strconv.Atoi(string(value))
+		count += shardCount
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// getMaxAccounts returns the account cap, or 0 if none is set
+func getMaxAccounts(APIstub shim.ChaincodeStubInterface) (int, error) {
+	return getIntSetting(APIstub, maxAccountsKey)
+}
+
+// accountCountShardKey returns the counter shard `account` is counted in
+func accountCountShardKey(APIstub shim.ChaincodeStubInterface, account string) (string, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(account))
+	shard := strconv.Itoa(int(hash.Sum32() % accountCountShards))
+	return buildKey(APIstub, accountCountPrefix, []string{shard})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for dormancy options
+const dormancyThresholdKey = "dormancyThreshold"
+
+// Define objectType names for account activity
+const activityPrefix = "activity"
+
+// maxPageSize bounds the number of records a paginated query reads at once
+const maxPageSize = 100
+
+// accountActivity describes when an account balance last changed
+type accountActivity struct {
+	Account      string `json:"account"`
+	LastActivity int64  `json:"lastActivity"`
+}
+
+// dormancyResponse is the JSON document returned by IsDormant
+type dormancyResponse struct {
+	Token        string `json:"token"`
+	Account      string `json:"account"`
+	LastActivity int64  `json:"lastActivity"`
+	Dormant      bool   `json:"dormant"`
+}
+
+// dormantAccountsResponse is the JSON document returned by ListDormantAccounts
+type dormantAccountsResponse struct {
+	Token    string            `json:"token"`
+	Accounts []accountActivity `json:"accounts"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// SetDormancyThreshold sets how many seconds without activity make an account dormant
+// Only admins can change the threshold.
+func (s *SmartContract) SetDormancyThreshold(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	threshold, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || threshold <= 0 {
+		return shim.Error("Invalid threshold. Expecting a positive number of seconds")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(dormancyThresholdKey, []byte(strconv.FormatInt(threshold, 10)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", dormancyThresholdKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// IsDormant reports whether `account` has had no balance change for at least the dormancy threshold
+// Without a threshold no account is dormant.
+// Accounts whose balance predates activity tracking report a last activity of 0.
+func (s *SmartContract) IsDormant(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	threshold, err := getIntSetting(APIstub, dormancyThresholdKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lastActivity, err := getLastActivity(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	response := dormancyResponse{
+		Token:        symbol,
+		Account:      account,
+		LastActivity: lastActivity,
+		Dormant:      threshold > 0 && now-lastActivity >= int64(threshold),
+	}
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// ListDormantAccounts returns accounts without activity for at least `olderThanSeconds`
+// Results are paginated: pass the returned bookmark to continue. Only auditors can list.
+func (s *SmartContract) ListDormantAccounts(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	olderThan, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || olderThan < 0 {
+		return shim.Error("Invalid age. Expecting a non-negative number of seconds")
+	}
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return shim.Error(fmt.Sprintf("Invalid limit. Expecting a number between 1 and %d", maxPageSize))
+	}
+	bookmark := args[2]
+
+	err = requireRole(APIstub, auditorRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	accounts := []accountActivity{}
+	bookmark, err = iterate(APIstub, activityPrefix, []string{}, limit, bookmark, func(attributes []string, value []byte) error {
+		lastActivity, _ := strconv.ParseInt(string(value), 10, 64)
+		if now-lastActivity >= olderThan {
+			accounts = append(accounts, accountActivity{Account: attributes[0], LastActivity: lastActivity})
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(dormantAccountsResponse{Token: symbol, Accounts: accounts, Bookmark: bookmark})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// recordActivity stores the transaction time as the last activity of `account`
+func recordActivity(APIstub shim.ChaincodeStubInterface, account string) error {
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	activityKey, err := buildKey(APIstub, activityPrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(activityKey, []byte(strconv.FormatInt(now, 10)))
+	if err != nil {
+		return stateError(APIstub, "PutState", activityPrefix, err)
+	}
+	return nil
+}
+
+// getLastActivity returns the last activity of `account`, or 0 if none was recorded
+func getLastActivity(APIstub shim.ChaincodeStubInterface, account string) (int64, error) {
+	activityKey, err := buildKey(APIstub, activityPrefix, []string{account})
+	if err != nil {
+		return 0, err
+	}
+	activityBytes, err := APIstub.GetState(activityKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", activityPrefix, err)
+	}
+	if activityBytes == nil {
+		return 0, nil
+	}
+	lastActivity, _ := strconv.ParseInt(string(activityBytes), 10, 64)
+	return lastActivity, nil
+}
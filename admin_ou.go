@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Define key names for the admin OU option
+const requireAdminOUKey = "requireAdminOU"
+
+// adminOU is the organizational unit the CA issues to administrators
+const adminOU = "admin"
+
+// adminOUFunctions are the privileged functions that also require the admin OU when requireAdminOU is set
+// The OU is checked in addition to the function's own role or minter check, so a tampered
+// role registry alone does not grant them.
+var adminOUFunctions = map[string]bool{
+	"Mint":                true,
+	"ForceTransfer":       true,
+	"ForceBurn":           true,
+	"CreateMinterSession": true,
+}
+
+// checkAdminOU returns ERR_ADMIN_OU_REQUIRED if `function` is privileged, requireAdminOU is set
+// and the client certificate was not issued with the admin OU
+func checkAdminOU(APIstub shim.ChaincodeStubInterface, function string) error {
+	if !adminOUFunctions[function] {
+		return nil
+	}
+	required, err := getBoolSetting(APIstub, requireAdminOUKey)
+	if err != nil || !required {
+		return err
+	}
+
+	units, err := getClientOUs(APIstub)
+	if err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if unit == adminOU {
+			return nil
+		}
+	}
+	params := map[string]string{"function": function, "ou": adminOU, "certificateOU": strings.Join(units, ",")}
+	return newCodedError(APIstub, errAdminOURequired, params,
+		"%s requires a certificate issued with OU %s besides the caller's role, the client certificate has OU [%s]",
+		function, adminOU, strings.Join(units, ","))
+}
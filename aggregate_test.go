@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// aggregateQueries are the queries that assemble their document from several sections
+var aggregateQueries = []struct {
+	caller   chaintest.Identity
+	function string
+	args     []string
+}{
+	{alice, "AccountDashboard", []string{alice.Account}},
+	{admin, "GetConfiguration", nil},
+	{admin, "ExportConfigurationDigest", nil},
+}
+
+// aggregateStates build the states every aggregate query is compared on
+var aggregateStates = map[string]func(t *testing.T) *chaintest.Ledger{
+	"fresh": func(t *testing.T) *chaintest.Ledger {
+		return newToken(t, "")
+	},
+	"configured": func(t *testing.T) *chaintest.Ledger {
+		ledger := newToken(t, `{"humanAmounts":true,"recentActivity":true,"strictQueries":true}`)
+		// Grants made out of role and account order
+		mustInvoke(t, ledger, admin, "GrantRole", pauserRole, carol.Account)
+		mustInvoke(t, ledger, admin, "GrantRole", complianceRole, bob.Account)
+		mustInvoke(t, ledger, admin, "GrantRole", pauserRole, alice.Account, "1800000000")
+		mustInvoke(t, ledger, admin, "SetMaxAccounts", "50")
+		mustInvoke(t, ledger, admin, "SetDormancyThreshold", "3600")
+		mustInvoke(t, ledger, alice, "SetMemoRequired", "true")
+		fund(t, ledger, alice.Account, 100)
+		mustInvoke(t, ledger, alice, "Transfer", bob.Account, "30")
+		mustInvoke(t, ledger, alice, "Approve", carol.Account, "20")
+		ledger.Now += 60
+		return ledger
+	},
+}
+
+// TestAggregateQueriesAgreeAcrossEndorsers runs every aggregate query on two endorsers, each with
+// its own handler instance and a copy of the same state, and requires byte-identical payloads
+// whose object keys are in canonical order at every level
+func TestAggregateQueriesAgreeAcrossEndorsers(t *testing.T) {
+	for name, build := range aggregateStates {
+		first := build(t)
+		// The second endorser gets the state key by key, in reverse order
+		second := chaintest.NewLedger(new(SmartContract))
+		second.Now = first.Now
+		keys := first.Keys()
+		for i := len(keys) - 1; i >= 0; i-- {
+			second.SetState(keys[i], first.State(keys[i]))
+		}
+
+		for _, query := range aggregateQueries {
+			firstPayload := mustInvoke(t, first, query.caller, query.function, query.args...)
+			secondPayload := mustInvoke(t, second, query.caller, query.function, query.args...)
+			if firstPayload != secondPayload {
+				t.Errorf("%s: %s returned different payloads\n%s\n%s", name, query.function, firstPayload, secondPayload)
+			}
+			if !canonicalOrder(t, []byte(firstPayload)) {
+				t.Errorf("%s: %s returned %s, whose object keys are not in canonical order", name, query.function, firstPayload)
+			}
+		}
+	}
+}
+
+// TestConfigurationRolesOrder checks the documented sort key of the roles section: role, then account
+func TestConfigurationRolesOrder(t *testing.T) {
+	ledger := aggregateStates["configured"](t)
+	var config map[string]json.RawMessage
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "GetConfiguration")), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grants []roleGrant
+	err = json.Unmarshal(config[rolesSection], &grants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 4 {
+		t.Fatalf("roles section lists %+v, expected the 4 grants", grants)
+	}
+	for i := 1; i < len(grants); i++ {
+		previous, current := grants[i-1], grants[i]
+		if previous.Role > current.Role || (previous.Role == current.Role && previous.Account >= current.Account) {
+			t.Fatalf("roles section lists %+v, expected grants sorted by role, then account", grants)
+		}
+	}
+}
+
+// canonicalOrder reports whether re-encoding `payload` with sorted object keys leaves it unchanged
+func canonicalOrder(t *testing.T, payload []byte) bool {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(sorted, payload)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// allowanceHistoryEntry is the value of an allowance as written by one transaction
+type allowanceHistoryEntry struct {
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+	Value     int    `json:"value"`
+}
+
+// allowanceHistoryResponse is the JSON document returned by AllowanceHistory
+type allowanceHistoryResponse struct {
+	Token   string                  `json:"token"`
+	Owner   string                  `json:"owner"`
+	Spender string                  `json:"spender"`
+	History []allowanceHistoryEntry `json:"history"`
+}
+
+// AllowanceHistory returns up to `limit` past values of the allowance `owner` granted `spender`, oldest first
+// A removed allowance is reported with a value of 0. Only the owner, the spender and auditors can read it.
+// The history of the legacy concatenated key comes first, see MigrateLegacyAllowances; a migrated
+// allowance shows as removed there and set under the allowance key in the same transaction.
+func (s *SmartContract) AllowanceHistory(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	owner := args[0]
+	spender := args[1]
+	limit, err := strconv.Atoi(args[2])
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return shim.Error(fmt.Sprintf("Invalid limit. Expecting a number between 1 and %d", maxPageSize))
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != owner && clientID != spender {
+		err = requireRole(APIstub, auditorRole)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	legacyKey, err := buildLegacyAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	history := []allowanceHistoryEntry{}
+	for _, key := range []string{legacyKey, allowanceKey} {
+		history, err = appendAllowanceHistory(APIstub, key, history, limit)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(allowanceHistoryResponse{Token: symbol, Owner: owner, Spender: spender, History: history})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// appendAllowanceHistory appends the values written under `key` to `history` until it holds `limit` entries
+func appendAllowanceHistory(APIstub shim.ChaincodeStubInterface, key string, history []allowanceHistoryEntry, limit int) ([]allowanceHistoryEntry, error) {
+	iterator, err := APIstub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, stateError(APIstub, "GetHistoryForKey", allowancePrefix, err)
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() && len(history) < limit {
+		modification, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry := allowanceHistoryEntry{TxID: modification.TxId}
+		if modification.Timestamp != nil {
+			entry.Timestamp = modification.Timestamp.Seconds
+		}
+		if !modification.IsDelete {
+			entry.Value, _ = strconv.Atoi(string(modification.Value))
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
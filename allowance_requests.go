@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for allowance requests
+const allowanceRequestPrefix = "allowanceRequest"
+const allowanceRequestByOwnerPrefix = "allowanceRequestByOwner"
+const allowanceRequestBySpenderPrefix = "allowanceRequestBySpender"
+
+// Define allowance request statuses
+const allowanceRequestPending = "pending"
+const allowanceRequestConfirmed = "confirmed"
+const allowanceRequestExpired = "expired"
+
+// maxAllowanceRequestOwners bounds the number of owners in a single RequestAllowance call
+const maxAllowanceRequestOwners = 100
+
+// allowanceRequest asks `Owner` to approve `Spender` for `Amount`
+type allowanceRequest struct {
+	ID        string `json:"id"`
+	Owner     string `json:"owner"`
+	Spender   string `json:"spender"`
+	Amount    int    `json:"amount"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Status    string `json:"status"`
+}
+
+// allowanceRequestsEvent provides an organized struct for emitting allowance request events
+type allowanceRequestsEvent struct {
+	Token    string             `json:"token"`
+	Requests []allowanceRequest `json:"requests"`
+}
+
+// allowanceRequestsResponse is the JSON document returned by ListAllowanceRequests
+type allowanceRequestsResponse struct {
+	Token    string             `json:"token"`
+	Requests []allowanceRequest `json:"requests"`
+}
+
+// ApproveBulkByOwner sets the allowance of `spender` over the caller's account to `amount`
+// It predates Approve taking the caller as owner and is kept for existing clients;
+// unlike Approve it cannot restrict the recipient.
+// This function triggers an Approval event
+func (s *SmartContract) ApproveBulkByOwner(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	spender := args[0]
+	amount, err := parseNonNegativeAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var reference string
+	if len(args) == 3 {
+		reference, err = sanitizeText("reference", args[2], maxReferenceLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = setAllowance(APIstub, owner, spender, amount, reference, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitApproval(APIstub, owner, spender, amount, reference, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// RequestAllowance asks each owner in the JSON array `ownersJSON` to approve the caller for `amount`
+// Each owner confirms with ConfirmAllowanceRequest before `expiryTs`. The request IDs are returned as a JSON array.
+// This function triggers an AllowanceRequested event
+func (s *SmartContract) RequestAllowance(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	var owners []string
+	err := json.Unmarshal([]byte(args[0]), &owners)
+	if err != nil || len(owners) == 0 {
+		return shim.Error("Invalid owners. Expecting a non-empty JSON array of account IDs")
+	}
+	if len(owners) > maxAllowanceRequestOwners {
+		return shim.Error(fmt.Sprintf("Too many owners. Expecting at most %d", maxAllowanceRequestOwners))
+	}
+	amount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiresAt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid expiry. Expecting a unix timestamp in seconds")
+	}
+
+	spender, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiresAt <= now {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	seen := map[string]bool{}
+	requests := []allowanceRequest{}
+	ids := []string{}
+	for _, owner := range owners {
+		if owner == "" || owner == spender {
+			return shim.Error("Owners must be accounts other than the caller")
+		}
+		if seen[owner] {
+			return shim.Error(fmt.Sprintf("Duplicate owner %s", owner))
+		}
+		seen[owner] = true
+
+		id, err := newDeterministicID(APIstub, allowanceRequestPrefix)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		request := allowanceRequest{
+			ID:        id,
+			Owner:     owner,
+			Spender:   spender,
+			Amount:    amount,
+			ExpiresAt: expiresAt,
+			Status:    allowanceRequestPending,
+		}
+		err = putAllowanceRequest(APIstub, request)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		for _, index := range []struct{ prefix, party string }{
+			{allowanceRequestByOwnerPrefix, owner},
+			{allowanceRequestBySpenderPrefix, spender},
+		} {
+			indexKey, err := buildKey(APIstub, index.prefix, []string{index.party, request.ID})
+			if err != nil {
+				return shim.Error(notCommitted(err).Error())
+			}
+			err = APIstub.PutState(indexKey, []byte{0x00})
+			if err != nil {
+				return shim.Error(notCommitted(stateError(APIstub, "PutState", index.prefix, err)).Error())
+			}
+		}
+		requests = append(requests, request)
+		ids = append(ids, request.ID)
+	}
+
+	err = emitAllowanceRequestsEvent(APIstub, "AllowanceRequested", requests)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	idsBytes, err := json.Marshal(ids)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	return shim.Success(idsBytes)
+}
+
+// ConfirmAllowanceRequest approves the requested allowance; only the owner can confirm
+// The amount is taken from the request, so the owner does not type it again.
+// This function triggers an AllowanceRequestConfirmed event
+func (s *SmartContract) ConfirmAllowanceRequest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getAllowanceRequest(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request == nil {
+		return shim.Error("Allowance request not found")
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != request.Owner {
+		return shim.Error("Only the owner can confirm an allowance request")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	status := request.effectiveStatus(now)
+	if status != allowanceRequestPending {
+		return shim.Error(fmt.Sprintf("Allowance request is %s", status))
+	}
+
+	err = setAllowance(APIstub, request.Owner, request.Spender, request.Amount, "", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	request.Status = allowanceRequestConfirmed
+	err = putAllowanceRequest(APIstub, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitAllowanceRequestsEvent(APIstub, "AllowanceRequestConfirmed", []allowanceRequest{*request})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ListAllowanceRequests returns the caller's allowance requests as "owner" or "spender",
+// filtered by status unless the status is empty
+func (s *SmartContract) ListAllowanceRequests(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var indexPrefix string
+	switch args[0] {
+	case "owner":
+		indexPrefix = allowanceRequestByOwnerPrefix
+	case "spender":
+		indexPrefix = allowanceRequestBySpenderPrefix
+	default:
+		return shim.Error("Invalid side. Expecting owner or spender")
+	}
+	status := args[1]
+	switch status {
+	case "", allowanceRequestPending, allowanceRequestConfirmed, allowanceRequestExpired:
+	default:
+		return shim.Error("Invalid status. Expecting pending, confirmed, expired or empty")
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requests := []allowanceRequest{}
+	_, err = iterate(APIstub, indexPrefix, []string{clientID}, 0, "", func(attributes []string, value []byte) error {
+		request, err := getAllowanceRequest(APIstub, attributes[1])
+		if err != nil || request == nil {
+			return err
+		}
+		request.Status = request.effectiveStatus(now)
+		if status == "" || request.Status == status {
+			requests = append(requests, *request)
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requestsBytes, err := json.Marshal(allowanceRequestsResponse{Token: symbol, Requests: requests})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(requestsBytes)
+}
+
+// effectiveStatus returns the status of the request at `now`, taking expiry into account
+func (r allowanceRequest) effectiveStatus(now int64) string {
+	if r.Status == allowanceRequestPending && now >= r.ExpiresAt {
+		return allowanceRequestExpired
+	}
+	return r.Status
+}
+
+// getAllowanceRequest returns the stored allowance request, or nil if there is none
+func getAllowanceRequest(APIstub shim.ChaincodeStubInterface, id string) (*allowanceRequest, error) {
+	requestKey, err := buildKey(APIstub, allowanceRequestPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	requestBytes, err := APIstub.GetState(requestKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", allowanceRequestPrefix, err)
+	}
+	if requestBytes == nil {
+		return nil, nil
+	}
+
+	var request allowanceRequest
+	err = json.Unmarshal(requestBytes, &request)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// putAllowanceRequest stores the allowance request under its ID
+func putAllowanceRequest(APIstub shim.ChaincodeStubInterface, request allowanceRequest) error {
+	requestKey, err := buildKey(APIstub, allowanceRequestPrefix, []string{request.ID})
+	if err != nil {
+		return err
+	}
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(requestKey, requestBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", allowanceRequestPrefix, err)
+	}
+	return nil
+}
+
+// emitAllowanceRequestsEvent emits `name` describing the current state of the requests
+func emitAllowanceRequestsEvent(APIstub shim.ChaincodeStubInterface, name string, requests []allowanceRequest) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(allowanceRequestsEvent{Token: symbol, Requests: requests})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxRevocationsPerCall bounds the allowances RevokeSpenderAllowances zeroes in one transaction
+const maxRevocationsPerCall = 100
+
+// spenderRevokedEvent provides an organized struct for emitting the SpenderAllowancesRevoked event
+// Fabric keeps a single event per transaction, so the event lists the resulting allowance of
+// every owner, as their Approval events would; Revoked is the total taken away.
+type spenderRevokedEvent struct {
+	Token     string             `json:"token"`
+	Spender   string             `json:"spender"`
+	CaseID    string             `json:"caseId"`
+	Approvals []rotatedAllowance `json:"approvals"`
+	Revoked   int                `json:"revoked"`
+	Complete  bool               `json:"complete"`
+}
+
+// revokeSpenderResponse is the JSON document returned by RevokeSpenderAllowances
+type revokeSpenderResponse struct {
+	Token    string `json:"token"`
+	Spender  string `json:"spender"`
+	Owners   int    `json:"owners"`
+	Complete bool   `json:"complete"`
+}
+
+// RevokeSpenderAllowances zeroes every allowance naming `spender`, across all owners, under the
+// compliance case `caseID`; only the compliance role can revoke
+// At most 100 allowances are revoked per call. Revoked allowances leave the spender index, so
+// calling again resumes with the next owners until the response reports complete. Owners can
+// approve the spender again afterwards.
+// This function triggers a SpenderAllowancesRevoked event
+func (s *SmartContract) RevokeSpenderAllowances(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	spender := args[0]
+	if spender == "" {
+		return shim.Error("Spender must be a non-empty string")
+	}
+	caseID := args[1]
+
+	err := requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = getOpenCase(APIstub, caseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Read one owner more than revoked to tell whether another call is needed
+	var owners []string
+	_, err = iterate(APIstub, spenderIndexPrefix, []string{spender}, 0, "", func(attributes []string, value []byte) error {
+		owners = append(owners, attributes[1])
+		if len(owners) > maxRevocationsPerCall {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	complete := len(owners) <= maxRevocationsPerCall
+	if !complete {
+		owners = owners[:maxRevocationsPerCall]
+	}
+
+	approvals := []rotatedAllowance{}
+	revoked := 0
+	for _, owner := range owners {
+		value, err := revokeAllowance(APIstub, owner, spender)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		approvals = append(approvals, rotatedAllowance{Owner: owner, Spender: spender, Value: 0})
+		revoked += value
+	}
+
+	err = linkCaseAction(APIstub, caseID, caseAction{Action: "RevokeSpenderAllowances", Account: spender, Amount: revoked})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventData := spenderRevokedEvent{Token: symbol, Spender: spender, CaseID: caseID, Approvals: approvals, Revoked: revoked, Complete: complete}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent("SpenderAllowancesRevoked", eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	responseBytes, err := json.Marshal(revokeSpenderResponse{Token: symbol, Spender: spender, Owners: len(owners), Complete: complete})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// revokeAllowance sets the allowance of `spender` from `owner` to zero, removes its spender index
+// entry and returns the allowance it had. The owner can approve the spender again with Approve.
+func revokeAllowance(APIstub shim.ChaincodeStubInterface, owner string, spender string) (int, error) {
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return 0, err
+	}
+	allowanceBytes, err := APIstub.GetState(allowanceKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", allowancePrefix, err)
+	}
+	allowance, _ := strconv.Atoi(string(allowanceBytes))
+
+	if allowanceBytes != nil {
+		err = APIstub.PutState(allowanceKey, []byte("0"))
+		if err != nil {
+			return 0, stateError(APIstub, "PutState", allowancePrefix, err)
+		}
+	}
+	indexKey, err := buildKey(APIstub, spenderIndexPrefix, []string{spender, owner})
+	if err != nil {
+		return 0, err
+	}
+	err = APIstub.DelState(indexKey)
+	if err != nil {
+		return 0, stateError(APIstub, "DelState", spenderIndexPrefix, err)
+	}
+	return allowance, nil
+}
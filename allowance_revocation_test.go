@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestRevokeSpenderAllowances approves bob from `owners` owners and revokes his allowances until
+// the response reports complete. Every call revokes at most maxRevocationsPerCall allowances and
+// resumes with the owners the previous call left; carol's allowances are left alone.
+func TestRevokeSpenderAllowances(t *testing.T) {
+	tests := []struct {
+		owners int
+		calls  []int
+	}{
+		{0, []int{0}},
+		{1, []int{1}},
+		{maxRevocationsPerCall, []int{maxRevocationsPerCall}},
+		{maxRevocationsPerCall + 1, []int{maxRevocationsPerCall, 1}},
+		{2*maxRevocationsPerCall + 5, []int{maxRevocationsPerCall, maxRevocationsPerCall, 5}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d owners", test.owners), func(t *testing.T) {
+			ledger := newComplianceToken(t)
+			owners := make([]chaintest.Identity, test.owners)
+			for i := range owners {
+				owners[i] = chaintest.NewIdentity("Org1MSP", fmt.Sprintf("owner%d", i))
+				mustInvoke(t, ledger, owners[i], "Approve", bob.Account, "30")
+				mustInvoke(t, ledger, owners[i], "Approve", carol.Account, "20")
+			}
+
+			for call, expected := range test.calls {
+				result := ledger.Invoke(admin, "RevokeSpenderAllowances", bob.Account, "case-1")
+				if result.Status != shim.OK {
+					t.Fatalf("call %d failed with %q", call+1, result.Message)
+				}
+				var response revokeSpenderResponse
+				err := json.Unmarshal(result.Payload, &response)
+				if err != nil {
+					t.Fatal(err)
+				}
+				last := call == len(test.calls)-1
+				if response.Owners != expected || response.Complete != last {
+					t.Fatalf("call %d returned %s, expected %d owners and complete %t", call+1, result.Payload, expected, last)
+				}
+				var event spenderRevokedEvent
+				err = json.Unmarshal(result.Events[len(result.Events)-1].Payload, &event)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(event.Approvals) != expected || event.Revoked != 30*expected || event.CaseID != "case-1" {
+					t.Fatalf("call %d emitted %s, expected %d zeroed approvals revoking %d", call+1, result.Events[len(result.Events)-1].Payload, expected, 30*expected)
+				}
+			}
+
+			for i, owner := range owners {
+				if got := mustInvoke(t, ledger, admin, "Allowance", owner.Account, bob.Account); got != "0" {
+					t.Fatalf("allowance of bob from owner%d is %s after the revocation, expected 0", i, got)
+				}
+				if got := mustInvoke(t, ledger, admin, "Allowance", owner.Account, carol.Account); got != "20" {
+					t.Fatalf("allowance of carol from owner%d is %s after revoking bob, expected 20", i, got)
+				}
+			}
+			var record caseResponse
+			err := json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "GetCase", "case-1")), &record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(record.Actions) != len(test.calls) || record.Actions[0].Action != "RevokeSpenderAllowances" {
+				t.Fatalf("case-1 records %+v, expected one RevokeSpenderAllowances action per call", record.Actions)
+			}
+		})
+	}
+}
+
+func TestRevokeSpenderAllowancesAllowsReapproval(t *testing.T) {
+	ledger := newComplianceToken(t)
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "30")
+	mustInvoke(t, ledger, admin, "RevokeSpenderAllowances", bob.Account, "case-1")
+
+	message := mustFail(t, ledger, bob, "TransferFrom", alice.Account, bob.Account, "10")
+	if !strings.HasPrefix(message, errAllowanceExceeded) {
+		t.Fatalf("TransferFrom after the revocation failed with %q, expected %s", message, errAllowanceExceeded)
+	}
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "25")
+	mustInvoke(t, ledger, bob, "TransferFrom", alice.Account, bob.Account, "10")
+	if got := mustInvoke(t, ledger, admin, "Allowance", alice.Account, bob.Account); got != "15" {
+		t.Fatalf("allowance is %s after the re-approval and a spend of 10, expected 15", got)
+	}
+
+	// The re-approval is back in the spender index, so a later case revokes it again
+	mustInvoke(t, ledger, admin, "OpenCase", "case-2", "offboarding again")
+	mustInvoke(t, ledger, admin, "RevokeSpenderAllowances", bob.Account, "case-2")
+	if got := mustInvoke(t, ledger, admin, "Allowance", alice.Account, bob.Account); got != "0" {
+		t.Fatalf("re-approved allowance is %s after the second revocation, expected 0", got)
+	}
+}
+
+func TestRevokeSpenderAllowancesRefusals(t *testing.T) {
+	tests := []struct {
+		name     string
+		caller   chaintest.Identity
+		args     []string
+		expected string
+	}{
+		{"no spender", admin, []string{"", "case-1"}, "Spender must be a non-empty string"},
+		{"no compliance role", alice, []string{bob.Account, "case-1"}, errUnauthorized},
+		{"unknown case", admin, []string{bob.Account, "case-2"}, "ERR_UNKNOWN_CASE"},
+		{"closed case", admin, []string{bob.Account, "case-0"}, "ERR_CASE_CLOSED"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newComplianceToken(t)
+			mustInvoke(t, ledger, admin, "OpenCase", "case-0", "done")
+			mustInvoke(t, ledger, admin, "CloseCase", "case-0")
+			mustInvoke(t, ledger, alice, "Approve", bob.Account, "30")
+
+			message := mustFail(t, ledger, test.caller, "RevokeSpenderAllowances", test.args...)
+			if !strings.Contains(message, test.expected) {
+				t.Fatalf("RevokeSpenderAllowances%q failed with %q, expected it to mention %q", test.args, message, test.expected)
+			}
+			if got := mustInvoke(t, ledger, admin, "Allowance", alice.Account, bob.Account); got != "30" {
+				t.Fatalf("allowance is %s after a refused revocation, expected 30", got)
+			}
+		})
+	}
+}
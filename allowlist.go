@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for the incoming-transfer allowlist
+const allowlistEnabledPrefix = "allowlistEnabled"
+const allowedSenderPrefix = "allowedSender"
+
+// allowedSendersResponse is the JSON document returned by ListAllowedSenders
+type allowedSendersResponse struct {
+	Token   string   `json:"token"`
+	Account string   `json:"account"`
+	Senders []string `json:"senders"`
+}
+
+// SetIncomingAllowlist turns the incoming-transfer allowlist of the caller's account on or off.
+// While enabled, Transfer and TransferFrom into the account only succeed from listed senders.
+// Mint credits are not subject to the allowlist.
+func (s *SmartContract) SetIncomingAllowlist(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	enabled, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Invalid enabled flag. Expecting true or false")
+	}
+
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	enabledKey, err := buildKey(APIstub, allowlistEnabledPrefix, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if enabled {
+		err = APIstub.PutState(enabledKey, []byte(strconv.FormatBool(enabled)))
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", allowlistEnabledPrefix, err).Error())
+		}
+	} else {
+		err = APIstub.DelState(enabledKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", allowlistEnabledPrefix, err).Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// AddAllowedSender adds `sender` to the incoming-transfer allowlist of the caller's account
+func (s *SmartContract) AddAllowedSender(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	sender := args[0]
+	if sender == "" {
+		return shim.Error("Sender must be a non-empty string")
+	}
+
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	senderKey, err := buildKey(APIstub, allowedSenderPrefix, []string{owner, sender})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(senderKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", allowedSenderPrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// RemoveAllowedSender removes `sender` from the incoming-transfer allowlist of the caller's account
+func (s *SmartContract) RemoveAllowedSender(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	sender := args[0]
+
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	senderKey, err := buildKey(APIstub, allowedSenderPrefix, []string{owner, sender})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(senderKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", allowedSenderPrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ListAllowedSenders returns the allowlist of the given account
+func (s *SmartContract) ListAllowedSenders(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	senders := []string{}
+	_, err := iterate(APIstub, allowedSenderPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		senders = append(senders, attributes[1])
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sendersBytes, err := json.Marshal(allowedSendersResponse{Token: symbol, Account: account, Senders: senders})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(sendersBytes)
+}
+
+// checkIncomingAllowed returns an error if `to` has its allowlist enabled and `from` is not listed.
+// Only Transfer and TransferFrom consult the allowlist; minting into an account bypasses it.
+func checkIncomingAllowed(APIstub shim.ChaincodeStubInterface, from string, to string) error {
+	enabledKey, err := buildKey(APIstub, allowlistEnabledPrefix, []string{to})
+	if err != nil {
+		return err
+	}
+	enabledBytes, err := APIstub.GetState(enabledKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", allowlistEnabledPrefix, err)
+	}
+	if enabledBytes == nil {
+		return nil
+	}
+
+	senderKey, err := buildKey(APIstub, allowedSenderPrefix, []string{to, from})
+	if err != nil {
+		return err
+	}
+	senderBytes, err := APIstub.GetState(senderKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", allowedSenderPrefix, err)
+	}
+	if senderBytes == nil {
+		return fmt.Errorf("ERR_SENDER_NOT_ALLOWED: sender is not on the recipient's incoming allowlist")
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIncomingAllowlistRejectsUnlistedSender(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, bob, "SetIncomingAllowlist", "true")
+
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "10")
+	if !strings.HasPrefix(message, "ERR_SENDER_NOT_ALLOWED") {
+		t.Fatalf("Transfer to an allowlisted account failed with %q, expected ERR_SENDER_NOT_ALLOWED", message)
+	}
+
+	mustInvoke(t, ledger, alice, "Approve", carol.Account, "10")
+	message = mustFail(t, ledger, carol, "TransferFrom", alice.Account, bob.Account, "10")
+	if !strings.HasPrefix(message, "ERR_SENDER_NOT_ALLOWED") {
+		t.Fatalf("TransferFrom to an allowlisted account failed with %q, expected ERR_SENDER_NOT_ALLOWED", message)
+	}
+
+	if got := balanceOf(t, ledger, alice.Account); got != 100 {
+		t.Fatalf("sender balance is %d after rejected transfers, expected 100", got)
+	}
+	if got := balanceOf(t, ledger, bob.Account); got != 0 {
+		t.Fatalf("recipient balance is %d after rejected transfers, expected 0", got)
+	}
+}
+
+func TestIncomingAllowlistAcceptsListedSender(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, bob, "SetIncomingAllowlist", "true")
+	mustInvoke(t, ledger, bob, "AddAllowedSender", alice.Account)
+
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+	mustInvoke(t, ledger, alice, "Approve", carol.Account, "5")
+	mustInvoke(t, ledger, carol, "TransferFrom", alice.Account, bob.Account, "5")
+	if got := balanceOf(t, ledger, bob.Account); got != 15 {
+		t.Fatalf("recipient balance is %d, expected 15", got)
+	}
+
+	var list allowedSendersResponse
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, bob, "ListAllowedSenders", bob.Account)), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Senders) != 1 || list.Senders[0] != alice.Account {
+		t.Fatalf("ListAllowedSenders returned %q, expected only alice", list.Senders)
+	}
+
+	mustInvoke(t, ledger, bob, "RemoveAllowedSender", alice.Account)
+	mustFail(t, ledger, alice, "Transfer", bob.Account, "10")
+
+	// Turning the allowlist off accepts every sender again
+	mustInvoke(t, ledger, bob, "SetIncomingAllowlist", "false")
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+}
+
+func TestIncomingAllowlistIsBypassedByMint(t *testing.T) {
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, bob, "SetIncomingAllowlist", "true")
+
+	fund(t, ledger, bob.Account, 50)
+	if got := balanceOf(t, ledger, bob.Account); got != 50 {
+		t.Fatalf("balance is %d after a mint into an allowlisted account, expected 50", got)
+	}
+}
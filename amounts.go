@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Define key names for amount options
+const humanAmountsKey = "humanAmounts"
+
+// parseAmount parses a client supplied amount into raw token units
+// Integer strings are always raw units. When the humanAmounts option is set, a string with
+// a decimal point such as "10.5" is scaled by the token decimals; it may not have more
+// fractional digits than the decimals allow.
+// A leading "+" is rejected, and a value outside the int range is reported as such.
+// Functions that move tokens should use parsePositiveAmount or parseNonNegativeAmount instead.
+func parseAmount(APIstub shim.ChaincodeStubInterface, value string) (int, error) {
+	if strings.HasPrefix(value, "+") {
+		return 0, invalidAmount(APIstub, value, "expecting a numeric string")
+	}
+	if !strings.Contains(value, ".") {
+		amount, err := strconv.Atoi(value)
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return 0, invalidAmount(APIstub, value, "the amount is out of range")
+		}
+		if err != nil {
+			return 0, invalidAmount(APIstub, value, "expecting a numeric string")
+		}
+		return amount, nil
+	}
+
+	humanAmounts, err := getBoolSetting(APIstub, humanAmountsKey)
+	if err != nil {
+		return 0, err
+	}
+	if !humanAmounts {
+		return 0, invalidAmount(APIstub, value, "expecting a numeric string")
+	}
+
+	decimalsBytes, err := APIstub.GetState(decimalsKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", decimalsKey, err)
+	}
+	decimals, _ := strconv.Atoi(string(decimalsBytes))
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || parts[0]+parts[1] == "" || !isDigits(parts[0]) || !isDigits(parts[1]) {
+		return 0, invalidAmount(APIstub, value, "expecting a decimal string such as 10.5")
+	}
+	if len(parts[1]) > decimals {
+		return 0, newCodedError(APIstub, errInvalidAmount, map[string]string{"amount": value, "decimals": strconv.Itoa(decimals)}, "invalid amount, at most %d fractional digits are allowed", decimals)
+	}
+
+	// Scale by appending the fraction padded to the token decimals
+	raw := parts[0] + parts[1] + strings.Repeat("0", decimals-len(parts[1]))
+	amount, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, invalidAmount(APIstub, value, "expecting a decimal string such as 10.5")
+	}
+	return amount, nil
+}
+
+// parsePositiveAmount parses an amount of tokens to move, which must be above zero
+func parsePositiveAmount(APIstub shim.ChaincodeStubInterface, value string) (int, error) {
+	amount, err := parseNonNegativeAmount(APIstub, value)
+	if err != nil {
+		return 0, err
+	}
+	if amount == 0 {
+		return 0, invalidAmount(APIstub, value, "the amount must be greater than zero")
+	}
+	return amount, nil
+}
+
+// parseNonNegativeAmount parses an amount where zero is meaningful, such as an allowance or a limit
+func parseNonNegativeAmount(APIstub shim.ChaincodeStubInterface, value string) (int, error) {
+	amount, err := parseAmount(APIstub, value)
+	if err != nil {
+		return 0, err
+	}
+	if amount < 0 {
+		return 0, invalidAmount(APIstub, value, "negative amounts are not allowed")
+	}
+	return amount, nil
+}
+
+// checkedAdd returns `a` + `b`, or ERR_ARITHMETIC_OVERFLOW if the sum does not fit in an int
+// Balances and the total supply are only added to through checkedAdd, so they never wrap around.
+func checkedAdd(APIstub shim.ChaincodeStubInterface, a int, b int) (int, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, arithmeticOverflow(APIstub, "add", a, b)
+	}
+	return a + b, nil
+}
+
+// checkedSub returns `a` - `b`, or ERR_ARITHMETIC_OVERFLOW if the difference does not fit in an int
+func checkedSub(APIstub shim.ChaincodeStubInterface, a int, b int) (int, error) {
+	if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+		return 0, arithmeticOverflow(APIstub, "subtract", a, b)
+	}
+	return a - b, nil
+}
+
+// arithmeticOverflow returns the ERR_ARITHMETIC_OVERFLOW error of `operation` on `a` and `b`
+func arithmeticOverflow(APIstub shim.ChaincodeStubInterface, operation string, a int, b int) error {
+	params := map[string]string{"operation": operation, "left": strconv.Itoa(a), "right": strconv.Itoa(b)}
+	return newCodedError(APIstub, errArithmeticOverflow, params, "arithmetic overflow")
+}
+
+// isDigits reports whether `value` consists of ASCII digits only
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
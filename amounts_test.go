@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMintOverflow mints MaxInt64-1 to alice, then checks that a mint past the int range fails
+// with ERR_ARITHMETIC_OVERFLOW and leaves the balances and the supply as they were
+func TestMintOverflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		amount  int
+	}{
+		{"balance", alice.Account, 2},
+		{"balance by the largest amount", alice.Account, math.MaxInt64},
+		{"supply", bob.Account, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			fund(t, ledger, alice.Account, math.MaxInt64-1)
+
+			message := mustFail(t, ledger, admin, "Mint", test.account, strconv.Itoa(test.amount))
+			if !strings.HasPrefix(message, errArithmeticOverflow) {
+				t.Fatalf("Mint of %d failed with %q, expected %s", test.amount, message, errArithmeticOverflow)
+			}
+			if got := balanceOf(t, ledger, alice.Account); got != math.MaxInt64-1 {
+				t.Fatalf("alice's balance is %d after the failed mint, expected %d", got, math.MaxInt64-1)
+			}
+			if got := balanceOf(t, ledger, bob.Account); got != 0 {
+				t.Fatalf("bob's balance is %d after the failed mint, expected 0", got)
+			}
+			if got := mustInvoke(t, ledger, admin, "TotalSupply"); got != strconv.Itoa(math.MaxInt64-1) {
+				t.Fatalf("total supply is %s after the failed mint, expected %d", got, math.MaxInt64-1)
+			}
+		})
+	}
+
+	// The last unit still fits
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, math.MaxInt64-1)
+	fund(t, ledger, alice.Account, 1)
+	if got := balanceOf(t, ledger, alice.Account); got != math.MaxInt64 {
+		t.Fatalf("balance is %d after minting up to the int range, expected %d", got, math.MaxInt64)
+	}
+}
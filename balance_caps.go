@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for account balance caps
+const balanceCapPrefix = "balanceCap"
+
+// balanceCap is the maximum balance of a program-limited account
+// With Spillover, a credit over the cap is accepted up to the cap and the rest stays with the sender.
+type balanceCap struct {
+	MaxBalance int  `json:"maxBalance"`
+	Spillover  bool `json:"spillover"`
+}
+
+// balanceCapEvent provides an organized struct for emitting BalanceCapSet events
+type balanceCapEvent struct {
+	Token   string `json:"token"`
+	Account string `json:"account"`
+	balanceCap
+}
+
+// balanceCapResponse is the JSON document returned by BalanceCap
+type balanceCapResponse struct {
+	Token   string `json:"token"`
+	Account string `json:"account"`
+	balanceCap
+	Balance  int `json:"balance"`
+	Headroom int `json:"headroom"`
+}
+
+// SetBalanceCap caps the balance of `account` at `maxBalance`; only the compliance role can set caps
+// A maxBalance of 0 removes the cap. The optional third argument "true" enables spillover.
+// This function triggers a BalanceCapSet event
+func (s *SmartContract) SetBalanceCap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	account := args[0]
+	if account == "" {
+		return shim.Error("Account must be a non-empty string")
+	}
+	maxBalance, err := parseNonNegativeAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var spillover bool
+	if len(args) == 3 {
+		spillover, err = strconv.ParseBool(args[2])
+		if err != nil {
+			return shim.Error("Invalid spillover flag. Expecting true or false")
+		}
+	}
+
+	err = requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	capKey, err := buildKey(APIstub, balanceCapPrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balanceCapData := balanceCap{MaxBalance: maxBalance, Spillover: spillover}
+	if maxBalance == 0 {
+		balanceCapData.Spillover = false
+		err = APIstub.DelState(capKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", balanceCapPrefix, err).Error())
+		}
+	} else {
+		capBytes, err := json.Marshal(balanceCapData)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = APIstub.PutState(capKey, capBytes)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", balanceCapPrefix, err).Error())
+		}
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventBytes, err := json.Marshal(balanceCapEvent{Token: symbol, Account: account, balanceCap: balanceCapData})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.SetEvent("BalanceCapSet", eventBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// BalanceCap returns the balance cap of `account` with its current balance and headroom
+// An account without a cap reports a maxBalance of 0 and a headroom of 0.
+func (s *SmartContract) BalanceCap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	accountCap, err := getBalanceCap(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balance, _, err := getBalance(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	headroom := 0
+	if accountCap.MaxBalance > balance {
+		headroom = accountCap.MaxBalance - balance
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(balanceCapResponse{Token: symbol, Account: account, balanceCap: accountCap, Balance: balance, Headroom: headroom})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// applyBalanceCap returns how much of a credit of `amount` to `account`, currently holding `balance`, is accepted
+// Without a cap the whole amount is accepted. Over the cap, a spillover account accepts up to the cap
+// and any other account rejects the credit with ERR_BALANCE_CAP.
+func applyBalanceCap(APIstub shim.ChaincodeStubInterface, account string, balance int, amount int) (int, error) {
+	accountCap, err := getBalanceCap(APIstub, account)
+	if err != nil {
+		return 0, err
+	}
+	if accountCap.MaxBalance == 0 || balance+amount <= accountCap.MaxBalance {
+		return amount, nil
+	}
+
+	headroom := 0
+	if accountCap.MaxBalance > balance {
+		headroom = accountCap.MaxBalance - balance
+	}
+	if !accountCap.Spillover {
+		return 0, balanceCapExceeded(APIstub, account, headroom)
+	}
+	return headroom, nil
+}
+
+// balanceCapExceeded returns the ERR_BALANCE_CAP error of a credit over the cap of `account`
+func balanceCapExceeded(APIstub shim.ChaincodeStubInterface, account string, headroom int) error {
+	params := map[string]string{"account": account, "headroom": strconv.Itoa(headroom)}
+	return newCodedError(APIstub, errBalanceCap, params, "%s can receive at most %d", account, headroom)
+}
+
+// getBalanceCap returns the balance cap of `account`; an account without a cap has a zero cap
+func getBalanceCap(APIstub shim.ChaincodeStubInterface, account string) (balanceCap, error) {
+	var accountCap balanceCap
+	capKey, err := buildKey(APIstub, balanceCapPrefix, []string{account})
+	if err != nil {
+		return accountCap, err
+	}
+	capBytes, err := APIstub.GetState(capKey)
+	if err != nil {
+		return accountCap, stateError(APIstub, "GetState", balanceCapPrefix, err)
+	}
+	if capBytes == nil {
+		return accountCap, nil
+	}
+	err = json.Unmarshal(capBytes, &accountCap)
+	return accountCap, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// balanceRecord is the JSON document a balance is stored as in the record state format
+// Counter is incremented on every write of the balance, see putBalance, so a client can tell
+// whether the account changed since it read it. Balances stored as a bare integer read with a
+// counter of 0.
+type balanceRecord struct {
+	Balance int   `json:"balance"`
+	Counter int64 `json:"counter"`
+}
+
+// decodeBalanceRecord returns the balance record of `account` stored as `balanceBytes`
+// The format is detected per value, not from the state format marker, since balances written
+// before an upgrade stay in the integer format until their next write: a JSON object is a
+// balanceRecord and digits only are a bare integer. Anything else is an error rather than a
+// balance of 0, which would silently destroy the tokens.
+func decodeBalanceRecord(account string, balanceBytes []byte) (balanceRecord, error) {
+	var record balanceRecord
+	if len(balanceBytes) > 0 && balanceBytes[0] == '{' {
+		err := json.Unmarshal(balanceBytes, &record)
+		if err != nil || record.Balance < 0 || record.Counter < 0 {
+			return balanceRecord{}, fmt.Errorf("ERR_STATE: invalid balance record %q stored for account %s", balanceBytes, account)
+		}
+		return record, nil
+	}
+
+	balance, err := strconv.Atoi(string(balanceBytes))
+	if err != nil || !isDigits(string(balanceBytes)) {
+		return balanceRecord{}, fmt.Errorf("ERR_STATE: invalid balance %q stored for account %s", balanceBytes, account)
+	}
+	record.Balance = balance
+	return record, nil
+}
+
+// encodeBalanceRecord returns `record` in the state format `format`
+// The integer format cannot hold the counter, which restarts from 0 once the record format is in use.
+func encodeBalanceRecord(record balanceRecord, format int) ([]byte, error) {
+	if format < stateFormatRecord {
+		return []byte(strconv.Itoa(record.Balance)), nil
+	}
+	return json.Marshal(record)
+}
+
+// checkExpectedCounter returns an ERR_STALE_READ error unless `account` still has the modification counter `expectedCounter`
+// It gives read-modify-write clients compare-and-swap semantics: a client that read the counter
+// with BalanceOf in JSON mode passes it back, and the transaction fails if any balance write
+// happened in between. An empty expectedCounter skips the check. Counters are only kept in state
+// format 2, so the check fails until UpgradeStateFormat recorded it rather than comparing against
+// a counter that never moves.
+func checkExpectedCounter(APIstub shim.ChaincodeStubInterface, account string, expectedCounter string) error {
+	if expectedCounter == "" {
+		return nil
+	}
+	expected, err := strconv.ParseInt(expectedCounter, 10, 64)
+	if err != nil || expected < 0 {
+		return fmt.Errorf("invalid expected counter %q, expecting a non-negative number", expectedCounter)
+	}
+	format, err := getStateFormat(APIstub)
+	if err != nil {
+		return err
+	}
+	if format < stateFormatRecord {
+		return fmt.Errorf("modification counters are not kept before state format %d, see UpgradeStateFormat", stateFormatRecord)
+	}
+	record, _, err := getBalanceRecord(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if record.Counter != expected {
+		params := map[string]string{"account": account, "expected": expectedCounter, "current": strconv.FormatInt(record.Counter, 10)}
+		return newCodedError(APIstub, errStaleRead, params, "account %s changed since it was read, counter is %d", account, record.Counter)
+	}
+	return nil
+}
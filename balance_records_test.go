@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// counterOf returns the modification counter of `account` reported by BalanceOf in JSON mode, "null" if none
+func counterOf(t *testing.T, ledger *chaintest.Ledger, account string) string {
+	t.Helper()
+	var balance balanceResponse
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "BalanceOf", account, "json")), &balance)
+	if err != nil {
+		t.Fatalf("BalanceOf %s in JSON mode: %s", account, err)
+	}
+	if balance.Counter == nil {
+		return "null"
+	}
+	return strconv.FormatInt(*balance.Counter, 10)
+}
+
+func TestExpectedCounterMatches(t *testing.T) {
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, admin, "UpgradeStateFormat", "2")
+	fund(t, ledger, alice.Account, 100)
+
+	counter := counterOf(t, ledger, alice.Account)
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10", "", "", "", counter)
+	if got := balanceOf(t, ledger, bob.Account); got != 10 {
+		t.Fatalf("recipient balance is %d, expected 10", got)
+	}
+
+	// Approve does not change the balance, so the counter read after the transfer still holds
+	counter = counterOf(t, ledger, alice.Account)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "50", "", "", counter)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "40", "", "", counter)
+	if got := counterOf(t, ledger, alice.Account); got != counter {
+		t.Fatalf("counter moved from %s to %s on Approve", counter, got)
+	}
+}
+
+func TestExpectedCounterStale(t *testing.T) {
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, admin, "UpgradeStateFormat", "2")
+	fund(t, ledger, alice.Account, 100)
+	counter := counterOf(t, ledger, alice.Account)
+
+	// The account changes between the client's read and its submission
+	fund(t, ledger, alice.Account, 5)
+
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "10", "", "", "", counter)
+	if !strings.HasPrefix(message, errStaleRead) {
+		t.Fatalf("transfer with a stale counter failed with %q, expected %s", message, errStaleRead)
+	}
+	if got := balanceOf(t, ledger, alice.Account); got != 105 {
+		t.Fatalf("sender balance is %d after a stale transfer, expected 105", got)
+	}
+
+	message = mustFail(t, ledger, alice, "Approve", bob.Account, "50", "", "", counter)
+	if !strings.HasPrefix(message, errStaleRead) {
+		t.Fatalf("approval with a stale counter failed with %q, expected %s", message, errStaleRead)
+	}
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, bob.Account); got != "0" {
+		t.Fatalf("allowance is %s after a stale approval, expected 0", got)
+	}
+}
+
+func TestExpectedCounterNeedsRecordFormat(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	if got := counterOf(t, ledger, alice.Account); got != "null" {
+		t.Fatalf("counter is %s before the upgrade, expected null", got)
+	}
+
+	// Integer balances keep no counter, so no expected counter can be confirmed
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "10", "", "", "", "0")
+	if strings.HasPrefix(message, errStaleRead) {
+		t.Fatalf("transfer with a counter before the upgrade failed with %q, expected it to name the state format", message)
+	}
+	if got := balanceOf(t, ledger, bob.Account); got != 0 {
+		t.Fatalf("recipient balance is %d, expected 0", got)
+	}
+}
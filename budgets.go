@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for spending categories
+const categoryBudgetPrefix = "categoryBudget"
+const categoryUsagePrefix = "categoryUsage"
+const uncategorizedBlockedPrefix = "uncategorizedBlocked"
+
+// categoryBudget caps the outgoing transfers of an account tagged with `Category`
+// Periods are aligned to multiples of PeriodSeconds since the unix epoch.
+type categoryBudget struct {
+	Category      string `json:"category"`
+	MaxPerPeriod  int    `json:"maxPerPeriod"`
+	PeriodSeconds int64  `json:"periodSeconds"`
+}
+
+// categoryUsage is the spend of a category in the current period
+type categoryUsage struct {
+	categoryBudget
+	PeriodStart int64 `json:"periodStart"`
+	Used        int   `json:"used"`
+	Remaining   int   `json:"remaining"`
+}
+
+// categoryUsageResponse is the JSON document returned by CategoryUsage
+type categoryUsageResponse struct {
+	Token                string          `json:"token"`
+	Account              string          `json:"account"`
+	UncategorizedBlocked bool            `json:"uncategorizedBlocked"`
+	Categories           []categoryUsage `json:"categories"`
+}
+
+// SetCategoryBudget caps the caller's transfers tagged with `category` to `maxPerPeriod` every `periodSeconds`
+// A maxPerPeriod of 0 removes the category. Once an account has a budget, only its configured
+// categories can be used on its transfers.
+func (s *SmartContract) SetCategoryBudget(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	category, err := sanitizeText("category", args[0], maxCategoryLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if category == "" {
+		return shim.Error("Category must be a non-empty string")
+	}
+	maxPerPeriod, err := parseNonNegativeAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	periodSeconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || periodSeconds <= 0 {
+		return shim.Error("Invalid period. Expecting a positive number of seconds")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	budgetKey, err := buildKey(APIstub, categoryBudgetPrefix, []string{account, category})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if maxPerPeriod == 0 {
+		err = APIstub.DelState(budgetKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", categoryBudgetPrefix, err).Error())
+		}
+		return shim.Success(nil)
+	}
+	budgetBytes, err := json.Marshal(categoryBudget{Category: category, MaxPerPeriod: maxPerPeriod, PeriodSeconds: periodSeconds})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(budgetKey, budgetBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", categoryBudgetPrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// SetUncategorizedSpend allows ("true") or blocks ("false") transfers without a category from the caller's account
+// Blocking only takes effect while the account has at least one category budget.
+func (s *SmartContract) SetUncategorizedSpend(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	allowed, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Invalid flag. Expecting true or false")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	blockedKey, err := buildKey(APIstub, uncategorizedBlockedPrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if allowed {
+		err = APIstub.DelState(blockedKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", uncategorizedBlockedPrefix, err).Error())
+		}
+		return shim.Success(nil)
+	}
+	err = APIstub.PutState(blockedKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", uncategorizedBlockedPrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CategoryUsage returns the budgets of `account` with the spend of the current period
+// Only the account itself and auditors can see its budgets.
+func (s *SmartContract) CategoryUsage(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != account {
+		err = requireRole(APIstub, auditorRole)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	budgets, err := getCategoryBudgets(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	categories := []categoryUsage{}
+	for _, budget := range budgets {
+		periodStart := budget.periodStart(now)
+		used, err := getCategoryUsage(APIstub, account, budget.Category, periodStart)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		remaining := budget.MaxPerPeriod - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		categories = append(categories, categoryUsage{categoryBudget: budget, PeriodStart: periodStart, Used: used, Remaining: remaining})
+	}
+	blocked, err := isUncategorizedBlocked(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(categoryUsageResponse{Token: symbol, Account: account, UncategorizedBlocked: blocked, Categories: categories})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// spendCategory checks a transfer of `amount` from `account` tagged with `category` against its budgets
+// and adds it to the usage of the current period. Accounts without budgets can use any category.
+// Call it at most once per transaction, since the usage key is read and written.
+func spendCategory(APIstub shim.ChaincodeStubInterface, account string, category string, amount int) error {
+	budgets, err := getCategoryBudgets(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if len(budgets) == 0 {
+		return nil
+	}
+
+	if category == "" {
+		blocked, err := isUncategorizedBlocked(APIstub, account)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return fmt.Errorf("ERR_UNCATEGORIZED_BLOCKED: transfers from this account need a category")
+		}
+		return nil
+	}
+
+	var budget *categoryBudget
+	for i := range budgets {
+		if budgets[i].Category == category {
+			budget = &budgets[i]
+		}
+	}
+	if budget == nil {
+		return fmt.Errorf("ERR_UNKNOWN_CATEGORY: %s is not a category of this account", category)
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	periodStart := budget.periodStart(now)
+	used, err := getCategoryUsage(APIstub, account, category, periodStart)
+	if err != nil {
+		return err
+	}
+	if used+amount > budget.MaxPerPeriod {
+		params := map[string]string{
+			"category":  category,
+			"available": strconv.Itoa(budget.MaxPerPeriod - used),
+			"limit":     strconv.Itoa(budget.MaxPerPeriod),
+			"requested": strconv.Itoa(amount),
+		}
+		return newCodedError(APIstub, errBudgetExceeded, params, "%s has %d of %d left in this period", category, budget.MaxPerPeriod-used, budget.MaxPerPeriod)
+	}
+
+	usageKey, err := categoryUsageKey(APIstub, account, category, periodStart)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(usageKey, []byte(strconv.Itoa(used+amount)))
+	if err != nil {
+		return stateError(APIstub, "PutState", categoryUsagePrefix, err)
+	}
+	return nil
+}
+
+// periodStart returns the start of the budget period containing `now`
+func (b categoryBudget) periodStart(now int64) int64 {
+	return now - now%b.PeriodSeconds
+}
+
+// getCategoryBudgets returns every budget of `account` in category order
+func getCategoryBudgets(APIstub shim.ChaincodeStubInterface, account string) ([]categoryBudget, error) {
+	budgets := []categoryBudget{}
+	_, err := iterate(APIstub, categoryBudgetPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		var budget categoryBudget
+		err := json.Unmarshal(value, &budget)
+		if err != nil {
+			return err
+		}
+		budgets = append(budgets, budget)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// getCategoryUsage returns the spend of `category` by `account` in the period starting at `periodStart`
+func getCategoryUsage(APIstub shim.ChaincodeStubInterface, account string, category string, periodStart int64) (int, error) {
+	usageKey, err := categoryUsageKey(APIstub, account, category, periodStart)
+	if err != nil {
+		return 0, err
+	}
+	usageBytes, err := APIstub.GetState(usageKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", categoryUsagePrefix, err)
+	}
+	used, _ := strconv.Atoi(string(usageBytes))
+	return used, nil
+}
+
+// categoryUsageKey returns the key of the spend of `category` by `account` in one period
+func categoryUsageKey(APIstub shim.ChaincodeStubInterface, account string, category string, periodStart int64) (string, error) {
+	return buildKey(APIstub, categoryUsagePrefix, []string{account, category, fmt.Sprintf("%020d", periodStart)})
+}
+
+// isUncategorizedBlocked reports whether `account` blocks transfers without a category
+func isUncategorizedBlocked(APIstub shim.ChaincodeStubInterface, account string) (bool, error) {
+	blockedKey, err := buildKey(APIstub, uncategorizedBlockedPrefix, []string{account})
+	if err != nil {
+		return false, err
+	}
+	blockedBytes, err := APIstub.GetState(blockedKey)
+	if err != nil {
+		return false, stateError(APIstub, "GetState", uncategorizedBlockedPrefix, err)
+	}
+	return blockedBytes != nil, nil
+}
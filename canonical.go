@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// canonicalize returns the canonical encoding of `record`, the bytes integrity hashes are computed over
+// The record is first encoded with json.Marshal, so struct tags and omitempty apply as usual, and
+// the result is rewritten as follows:
+//   - object keys are sorted by their UTF-8 bytes;
+//   - there is no whitespace outside strings;
+//   - strings are written as by encoding/json without HTML escaping: only ", \, control characters,
+//     U+2028 and U+2029 are escaped, and invalid UTF-8 becomes U+FFFD;
+//   - numbers must be integers and are written in minimal decimal form, without exponent, leading
+//     zeros or a plus sign, and -0 as 0. Records with fractional numbers are rejected, as token
+//     amounts are always whole units.
+//
+// Changing any of these rules changes every hash produced so far.
+func canonicalize(record interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = writeCanonical(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hashRecord returns the hex encoded sha256 of the canonical encoding of `record`
+func hashRecord(record interface{}) (string, error) {
+	canonical, err := canonicalize(record)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// writeCanonical appends the canonical encoding of a value decoded with UseNumber
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			return fmt.Errorf("Cannot canonicalize number %s. Expecting an integer", v)
+		}
+		n, ok := new(big.Int).SetString(string(v), 10)
+		if !ok {
+			return fmt.Errorf("Cannot canonicalize number %s. Expecting an integer", v)
+		}
+		buf.WriteString(n.String())
+	case string:
+		return writeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeCanonical(buf, element)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeCanonicalString(buf, key)
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			err = writeCanonical(buf, v[key])
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("Cannot canonicalize value of type %T", value)
+	}
+	return nil
+}
+
+// writeCanonicalString appends `s` as a JSON string without HTML escaping
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(s)
+	if err != nil {
+		return err
+	}
+	// Encode terminates the document with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
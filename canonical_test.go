@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files with the current output instead of comparing against them
+var update = flag.Bool("update", false, "rewrite testdata/*.golden")
+
+// canonicalRecords are representative records whose canonical encoding and hash are pinned in
+// testdata/canonical_<name>.golden
+var canonicalRecords = map[string]interface{}{
+	"transfer_event": event{Token: "TKN", From: "alice", To: "bob", Value: 10, Category: "groceries", Memo: "<invoice & co>"},
+	"nested": map[string]interface{}{
+		"zeta":  []interface{}{3, "x", nil, true, false},
+		"alpha": map[string]interface{}{"b": 2, "a": 1},
+		"émoji": "line\u2028sep\ttab \"q\" \\",
+	},
+	"amounts": json.RawMessage(`{"supply": 123456789012345678901234567890, "negative": -7, "zero": -0, "list": [1, 2]}`),
+}
+
+// TestCanonicalGolden pins the canonical bytes and hash of every canonical record
+// A difference means every hash produced so far changes; run go test -update only if that is intended.
+func TestCanonicalGolden(t *testing.T) {
+	for name, record := range canonicalRecords {
+		canonical, err := canonicalize(record)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		hash, err := hashRecord(record)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		got := append(append(canonical, '\n'), hash+"\n"...)
+
+		path := filepath.Join("testdata", "canonical_"+name+".golden")
+		if *update {
+			err = os.WriteFile(path, got, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: canonical encoding and hash are\n%s\nexpected\n%s", name, got, want)
+		}
+	}
+}
+
+func TestCanonicalizeRejectsFractions(t *testing.T) {
+	for _, record := range []interface{}{1.5, json.RawMessage(`{"amount":1e3}`), map[string]float64{"amount": 0.1}} {
+		if canonical, err := canonicalize(record); err == nil {
+			t.Errorf("canonicalize(%v) = %s, expected an error", record, canonical)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for compliance case files
+const casePrefix = "case"
+const caseActionPrefix = "caseAction"
+
+// Define case statuses
+const caseOpen = "open"
+const caseClosed = "closed"
+
+// complianceCase ties enforcement actions across features to one investigation
+// Cases are never deleted; closing one only stops new actions from referencing it.
+type complianceCase struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	OpenedBy    string `json:"openedBy"`
+	OpenedAt    int64  `json:"openedAt"`
+	ClosedBy    string `json:"closedBy,omitempty"`
+	ClosedAt    int64  `json:"closedAt,omitempty"`
+}
+
+// caseAction is an enforcement action recorded against a case by the function that performed it
+type caseAction struct {
+	Action    string `json:"action"`
+	Account   string `json:"account"`
+	Amount    int    `json:"amount,omitempty"`
+	Actor     string `json:"actor"`
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// caseEvent provides an organized struct for emitting case events
+type caseEvent struct {
+	Token string `json:"token"`
+	complianceCase
+}
+
+// caseResponse is the JSON document returned by GetCase
+type caseResponse struct {
+	Token string `json:"token"`
+	complianceCase
+	Actions []caseAction `json:"actions"`
+}
+
+// OpenCase opens the compliance case `caseID`; only the compliance role can open cases
+// This function triggers a CaseOpened event
+func (s *SmartContract) OpenCase(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	caseID, err := sanitizeText("case ID", args[0], maxReferenceLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if caseID == "" {
+		return shim.Error("Case ID must be a non-empty string")
+	}
+	description, err := sanitizeText("description", args[1], maxNoteLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getCase(APIstub, caseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("Case already exists")
+	}
+
+	officer, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record := complianceCase{ID: caseID, Description: description, Status: caseOpen, OpenedBy: officer, OpenedAt: now}
+	err = putCase(APIstub, record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitCaseEvent(APIstub, "CaseOpened", record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CloseCase closes the compliance case `caseID`; only the compliance role can close cases
+// This function triggers a CaseClosed event
+func (s *SmartContract) CloseCase(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record, err := getOpenCase(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	officer, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record.Status = caseClosed
+	record.ClosedBy = officer
+	record.ClosedAt = now
+	err = putCase(APIstub, *record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitCaseEvent(APIstub, "CaseClosed", *record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetCase returns the compliance case `caseID` with every action linked to it, oldest first
+// Only the compliance and auditor roles can read cases.
+func (s *SmartContract) GetCase(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	caseID := args[0]
+
+	err := requireRole(APIstub, complianceRole)
+	if err != nil {
+		err = requireRole(APIstub, auditorRole)
+		if err != nil {
+			return shim.Error("Caller does not have the compliance or auditor role")
+		}
+	}
+
+	record, err := getCase(APIstub, caseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if record == nil {
+		return shim.Error(fmt.Sprintf("ERR_UNKNOWN_CASE: case %s does not exist", caseID))
+	}
+
+	actions := []caseAction{}
+	_, err = iterate(APIstub, caseActionPrefix, []string{caseID}, 0, "", func(attributes []string, value []byte) error {
+		var action caseAction
+		err := json.Unmarshal(value, &action)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(caseResponse{Token: symbol, complianceCase: *record, Actions: actions})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// getOpenCase returns the case `caseID`, or an error unless it exists and is open
+// Enforcement functions call it to validate their case ID argument before acting.
+func getOpenCase(APIstub shim.ChaincodeStubInterface, caseID string) (*complianceCase, error) {
+	record, err := getCase(APIstub, caseID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("ERR_UNKNOWN_CASE: case %s does not exist", caseID)
+	}
+	if record.Status != caseOpen {
+		return nil, fmt.Errorf("ERR_CASE_CLOSED: case %s is closed", caseID)
+	}
+	return record, nil
+}
+
+// linkCaseAction records `action` against the case `caseID` in the per-case index read by GetCase
+// The transaction ID and time are filled in here, so every linked action is attributable.
+func linkCaseAction(APIstub shim.ChaincodeStubInterface, caseID string, action caseAction) error {
+	actor, err := getClientID(APIstub)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	action.Actor = actor
+	action.TxID = APIstub.GetTxID()
+	action.Timestamp = now
+
+	// Keys sort by time; the generated ID keeps several actions of one transaction apart
+	id, err := newDeterministicID(APIstub, caseActionPrefix)
+	if err != nil {
+		return err
+	}
+	actionKey, err := buildKey(APIstub, caseActionPrefix, []string{caseID, fmt.Sprintf("%020d", now), id})
+	if err != nil {
+		return err
+	}
+	actionBytes, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(actionKey, actionBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", caseActionPrefix, err)
+	}
+	return nil
+}
+
+// getCase returns the case `caseID`, or nil if it does not exist
+func getCase(APIstub shim.ChaincodeStubInterface, caseID string) (*complianceCase, error) {
+	caseKey, err := buildKey(APIstub, casePrefix, []string{caseID})
+	if err != nil {
+		return nil, err
+	}
+	caseBytes, err := APIstub.GetState(caseKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", casePrefix, err)
+	}
+	if caseBytes == nil {
+		return nil, nil
+	}
+
+	var record complianceCase
+	err = json.Unmarshal(caseBytes, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// putCase stores the case under its ID
+func putCase(APIstub shim.ChaincodeStubInterface, record complianceCase) error {
+	caseKey, err := buildKey(APIstub, casePrefix, []string{record.ID})
+	if err != nil {
+		return err
+	}
+	caseBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(caseKey, caseBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", casePrefix, err)
+	}
+	return nil
+}
+
+// emitCaseEvent emits `name` describing the current state of the case
+func emitCaseEvent(APIstub shim.ChaincodeStubInterface, name string, record complianceCase) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(caseEvent{Token: symbol, complianceCase: record})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// complianceNotesCollection is the private data collection holding compliance notes
+// It must be defined in the collection config passed when the chaincode is instantiated
+// or upgraded, with a member policy naming only the compliance organization, e.g.
+//
+//	[{
+//	  "name": "complianceNotes",
+//	  "policy": "OR('ComplianceMSP.member')",
+//	  "requiredPeerCount": 0,
+//	  "maxPeerCount": 1,
+//	  "blockToLive": 0,
+//	  "memberOnlyRead": true
+//	}]
+//
+// Peers outside the policy never receive the notes, only their hashes.
+const complianceNotesCollection = "complianceNotes"
+
+// Define objectType names for compliance notes
+const complianceNotePrefix = "complianceNote"
+
+// complianceNoteTransientKey is the transient field SetComplianceNote reads the note from
+// when it is not passed as an argument, which keeps the note out of the transaction itself
+const complianceNoteTransientKey = "note"
+
+// complianceNote is a note attached to an account by a compliance officer
+type complianceNote struct {
+	Account   string `json:"account"`
+	Note      string `json:"note"`
+	Author    string `json:"author"`
+	Timestamp int64  `json:"timestamp"`
+	TxID      string `json:"txId"`
+}
+
+// complianceNotesResponse is the JSON document returned by GetComplianceNotes
+type complianceNotesResponse struct {
+	Token   string           `json:"token"`
+	Account string           `json:"account"`
+	Notes   []complianceNote `json:"notes"`
+}
+
+// SetComplianceNote appends a note to `account`; only the compliance role can annotate
+// Notes are never changed or removed. Pass the note in the transient field "note" rather than as
+// an argument to keep it out of the block, since arguments are visible to every channel member.
+func (s *SmartContract) SetComplianceNote(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	account := args[0]
+	if account == "" {
+		return shim.Error("Account must be a non-empty string")
+	}
+	var note string
+	if len(args) == 2 {
+		note = args[1]
+	} else {
+		transient, err := APIstub.GetTransient()
+		if err != nil {
+			return shim.Error("Failed to get transient data")
+		}
+		note = string(transient[complianceNoteTransientKey])
+	}
+	note, err := sanitizeText("note", note, maxNoteLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if note == "" {
+		return shim.Error("Note must be a non-empty string")
+	}
+
+	err = requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	author, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	entry := complianceNote{Account: account, Note: note, Author: author, Timestamp: now, TxID: APIstub.GetTxID()}
+	// Keys sort by time, so notes are listed in the order they were written
+	noteKey, err := buildKey(APIstub, complianceNotePrefix, []string{account, fmt.Sprintf("%020d", now), entry.TxID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := APIstub.GetPrivateData(complianceNotesCollection, noteKey)
+	if err != nil {
+		return shim.Error(collectionError(APIstub, complianceNotesCollection, "GetPrivateData", err).Error())
+	}
+	if existing != nil {
+		return shim.Error("A note was already written for this account in this transaction")
+	}
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutPrivateData(complianceNotesCollection, noteKey, entryBytes)
+	if err != nil {
+		return shim.Error(collectionError(APIstub, complianceNotesCollection, "PutPrivateData", err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetComplianceNotes returns the notes attached to `account`, oldest first
+// Only the compliance role can read them, and only on peers of the compliance organization.
+func (s *SmartContract) GetComplianceNotes(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	err := requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	iterator, err := APIstub.GetPrivateDataByPartialCompositeKey(complianceNotesCollection, complianceNotePrefix, []string{account})
+	if err != nil {
+		return shim.Error(collectionError(APIstub, complianceNotesCollection, "GetPrivateDataByPartialCompositeKey", err).Error())
+	}
+	defer iterator.Close()
+
+	notes := []complianceNote{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var note complianceNote
+		err = json.Unmarshal(kv.Value, &note)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		notes = append(notes, note)
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(complianceNotesResponse{Token: symbol, Account: account, Notes: notes})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// collectionError wraps the error of a private data operation on `collection` with guidance,
+// since the usual cause is a collection missing from the chaincode's collection config
+func collectionError(APIstub shim.ChaincodeStubInterface, collection string, operation string, err error) error {
+	return fmt.Errorf("ERR_COLLECTION_UNAVAILABLE: %s; check that the %s collection is defined in the collection config of this chaincode and that this peer belongs to its member organization",
+		stateError(APIstub, operation, collection, err), collection)
+}
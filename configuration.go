@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// rolesSection is the configuration section listing every role grant
+const rolesSection = "roles"
+
+// configurationSection names a group of settings stored under single keys
+type configurationSection struct {
+	name string
+	keys []string
+}
+
+// configurationSections lists the settings of GetConfiguration, grouped in sections
+// Balances, supply and other state that changes in normal operation are not configuration,
+// nor are per-account settings such as balance caps.
+var configurationSections = []configurationSection{
+	{"token", []string{nameKey, symbolKey, decimalsKey}},
+	{"options", []string{identityModeKey, termsRequiredKey, documentHashKey, humanAmountsKey, strictQueriesKey,
+		minterOrgKey, requireAdminOUKey, devModeKey, faucetCapKey, recentActivitySizeKey, privateBalancesKey, keySchemaKey, experimentalEnabledKey,
+		maintenanceUntilKey, strictModeKey}},
+	{"limits", []string{maxSupplyKey, maxSupplyDelayKey, maxAccountsKey, dormancyThresholdKey, travelRuleThresholdKey, supplyAlarmKey}},
+}
+
+// configurationDigest is the JSON document returned by ExportConfigurationDigest
+type configurationDigest struct {
+	Token    string            `json:"token"`
+	Digest   string            `json:"digest"`
+	Sections map[string]string `json:"sections"`
+}
+
+// GetConfiguration returns the settings of the token by section
+// Settings that were never set are omitted. The roles section lists every stored grant, including
+// expired grants that were not revoked, so the document does not change with time alone. Grants
+// are sorted by role, then account, and the document is encoded in canonical order, see aggregateResponse.
+func (s *SmartContract) GetConfiguration(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	config, err := getConfiguration(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return aggregateResponse(config)
+}
+
+// ExportConfigurationDigest returns the hash of the GetConfiguration document and of each of its sections
+// The hashes are those of hashRecord, so channels with the same settings have the same digest and
+// comparing the section hashes shows which section differs.
+func (s *SmartContract) ExportConfigurationDigest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	config, err := getConfiguration(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	digest, err := hashRecord(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sections := make(map[string]string, len(config))
+	for name, section := range config {
+		sections[name], err = hashRecord(section)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return aggregateResponse(configurationDigest{Token: symbol, Digest: digest, Sections: sections})
+}
+
+// getConfiguration returns the configuration document, a map from section name to its settings
+func getConfiguration(APIstub shim.ChaincodeStubInterface) (map[string]interface{}, error) {
+	config := make(map[string]interface{}, len(configurationSections)+1)
+	for _, section := range configurationSections {
+		settings := make(map[string]string)
+		for _, key := range section.keys {
+			value, err := APIstub.GetState(key)
+			if err != nil {
+				return nil, stateError(APIstub, "GetState", key, err)
+			}
+			if value != nil {
+				settings[key] = string(value)
+			}
+		}
+		config[section.name] = settings
+	}
+
+	grants := []roleGrant{}
+	_, err := iterate(APIstub, rolePrefix, []string{}, 0, "", func(attributes []string, value []byte) error {
+		var grant roleGrant
+		err := json.Unmarshal(value, &grant)
+		if err != nil {
+			return err
+		}
+		grants = append(grants, grant)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].Role != grants[j].Role {
+			return grants[i].Role < grants[j].Role
+		}
+		return grants[i].Account < grants[j].Account
+	})
+	config[rolesSection] = grants
+
+	return config, nil
+}
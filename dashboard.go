@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// accountDashboardResponse is the JSON document returned by AccountDashboard
+// Each section holds the payload of the corresponding query, or null when that
+// query is unavailable, e.g. because its feature is disabled.
+type accountDashboardResponse struct {
+	Token          string          `json:"token"`
+	Account        string          `json:"account"`
+	Balance        json.RawMessage `json:"balance"`
+	Counter        json.RawMessage `json:"counter"`
+	RecentActivity json.RawMessage `json:"recentActivity"`
+	Dormancy       json.RawMessage `json:"dormancy"`
+	Terms          json.RawMessage `json:"terms"`
+	MemoRequired   json.RawMessage `json:"memoRequired"`
+}
+
+// AccountDashboard aggregates the per-account queries into a single document
+// It calls BalanceOf, RecentActivity, IsDormant, HasAcceptedTerms and MemoRequired, so every section
+// follows the rules of its own query. The counter section is the modification counter reported by
+// BalanceOf in JSON mode, see checkExpectedCounter. The document is encoded in canonical order, see
+// aggregateResponse; the movements of the recent activity section are listed newest first.
+func (s *SmartContract) AccountDashboard(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dashboard := accountDashboardResponse{
+		Token:          symbol,
+		Account:        account,
+		Balance:        dashboardSection(s.BalanceOf(APIstub, []string{account})),
+		Counter:        counterSection(s.BalanceOf(APIstub, []string{account, "json"})),
+		RecentActivity: dashboardSection(s.RecentActivity(APIstub, []string{account})),
+		Dormancy:       dashboardSection(s.IsDormant(APIstub, []string{account})),
+		Terms:          dashboardSection(s.HasAcceptedTerms(APIstub, []string{account})),
+		MemoRequired:   dashboardSection(s.MemoRequired(APIstub, []string{account})),
+	}
+
+	return aggregateResponse(dashboard)
+}
+
+// dashboardSection returns the payload of a successful query response, or nil so the section is null
+func dashboardSection(response peer.Response) json.RawMessage {
+	if response.Status != shim.OK || len(response.Payload) == 0 {
+		return nil
+	}
+	return json.RawMessage(response.Payload)
+}
+
+// counterSection returns the modification counter of a successful BalanceOf response in JSON mode, or nil
+func counterSection(response peer.Response) json.RawMessage {
+	payload := dashboardSection(response)
+	if payload == nil {
+		return nil
+	}
+	var balance balanceResponse
+	err := json.Unmarshal(payload, &balance)
+	if err != nil || balance.Counter == nil {
+		return nil
+	}
+	return json.RawMessage(strconv.FormatInt(*balance.Counter, 10))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// termsHash is the terms document of the token with every dashboard feature on
+const termsHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// dashboardTokens build the tokens whose AccountDashboard of alice is pinned in
+// testdata/dashboard_<name>.golden: one with every feature a section depends on, one with none
+var dashboardTokens = map[string]func(t *testing.T) *chaintest.Ledger{
+	"all_on": func(t *testing.T) *chaintest.Ledger {
+		ledger := newToken(t, `{"termsRequired":true,"documentHash":"`+termsHash+`","humanAmounts":true,"recentActivity":true,"privateBalances":true}`)
+		mustInvoke(t, ledger, admin, "UpgradeStateFormat", "2")
+		mustInvoke(t, ledger, admin, "SetDormancyThreshold", "3600")
+		mustInvoke(t, ledger, alice, "AcceptTerms", termsHash)
+		mustInvoke(t, ledger, bob, "AcceptTerms", termsHash)
+		mustInvoke(t, ledger, alice, "SetMemoRequired", "true")
+		fund(t, ledger, alice.Account, 100)
+		ledger.Now += 60
+		mustInvoke(t, ledger, alice, "Transfer", bob.Account, "30")
+		ledger.Now += 7200
+		return ledger
+	},
+	"all_off": func(t *testing.T) *chaintest.Ledger {
+		ledger := newToken(t, "")
+		fund(t, ledger, alice.Account, 100)
+		ledger.Now += 60
+		mustInvoke(t, ledger, alice, "Transfer", bob.Account, "30")
+		ledger.Now += 7200
+		return ledger
+	},
+}
+
+// TestAccountDashboardGolden pins the document AccountDashboard returns to alice with every
+// feature on and with every feature off; run go test -update to accept an intended change
+func TestAccountDashboardGolden(t *testing.T) {
+	for name, build := range dashboardTokens {
+		ledger := build(t)
+		result := ledger.Invoke(alice, "AccountDashboard", alice.Account)
+		if len(result.Writes) != 0 || len(result.Events) != 0 {
+			t.Fatalf("%s: AccountDashboard made %d writes and %d events, expected none", name, len(result.Writes), len(result.Events))
+		}
+		got := append([]byte(mustInvoke(t, ledger, alice, "AccountDashboard", alice.Account)), '\n')
+
+		path := filepath.Join("testdata", "dashboard_"+name+".golden")
+		if *update {
+			err := os.WriteFile(path, got, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: AccountDashboard returned\n%s\nexpected\n%s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// determinismArgs are argument lists every function runs with in TestEndorsersAgree
+// Most functions fail on most of them; failures must agree as well.
+var determinismArgs = [][]string{
+	{},
+	{"true"},
+	{"10"},
+	{bob.Account},
+	{bob.Account, "10"},
+	{bob.Account, "10", "groceries", "memo"},
+	{alice.Account, bob.Account, "5"},
+	{`[{"to":"` + bob.Account + `","amount":3}]`},
+}
+
+// outcomeDifference describes how two results of the same transaction differ, "" if they do not
+// Reads and writes are compared as a peer's read-write set would hold them.
+func outcomeDifference(first chaintest.Result, second chaintest.Result) string {
+	switch {
+	case first.Status != second.Status || first.Message != second.Message:
+		return fmt.Sprintf("responses differ: %d %q and %d %q", first.Status, first.Message, second.Status, second.Message)
+	case string(first.Payload) != string(second.Payload):
+		return fmt.Sprintf("payloads differ: %q and %q", first.Payload, second.Payload)
+	case !reflect.DeepEqual(chaintest.ReadSet(first.Reads), chaintest.ReadSet(second.Reads)):
+		return fmt.Sprintf("read sets differ: %+v and %+v", chaintest.ReadSet(first.Reads), chaintest.ReadSet(second.Reads))
+	case !reflect.DeepEqual(chaintest.WriteSet(first.Writes), chaintest.WriteSet(second.Writes)):
+		return fmt.Sprintf("write sets differ: %q and %q", chaintest.WriteSet(first.Writes), chaintest.WriteSet(second.Writes))
+	case !reflect.DeepEqual(first.Events, second.Events):
+		return fmt.Sprintf("events differ: %q and %q", first.Events, second.Events)
+	}
+	return ""
+}
+
+// TestEndorsersAgree runs every registered function as the same transaction on two copies of a
+// ledger, as two endorsers would, and checks that both produce the same read-write set, events
+// and response. Functions added to the registry are covered without changes here.
+func TestEndorsersAgree(t *testing.T) {
+	base := newToken(t, "")
+	fund(t, base, alice.Account, 1000)
+	fund(t, base, admin.Account, 1000)
+	mustInvoke(t, base, alice, "Approve", bob.Account, "100")
+
+	functions := make([]string, 0, len(contractFunctions))
+	for name := range contractFunctions {
+		functions = append(functions, name)
+	}
+	sort.Strings(functions)
+
+	for _, function := range functions {
+		for _, caller := range []chaintest.Identity{alice, admin} {
+			for _, args := range determinismArgs {
+				tx := chaintest.Tx{ID: "determinism", Caller: caller, Function: function, Args: args, Timestamp: base.Now}
+				first := base.Fork().Submit(tx)
+				second := base.Fork().Submit(tx)
+				if difference := outcomeDifference(first, second); difference != "" {
+					t.Errorf("%s%q as %s: %s", function, args, caller.Account, difference)
+				}
+			}
+		}
+	}
+}
+
+// wallClockFunctions are the functions of package time whose result depends on when a peer runs
+// the chaincode; handlers must use the transaction timestamp instead, see getTxTime
+var wallClockFunctions = map[string]bool{"Now": true, "Since": true, "Until": true}
+
+// TestNoWallClock checks that no chaincode source of either variant reads the wall clock or an
+// unseeded random source, which would make endorsers of the same proposal disagree
+func TestNoWallClock(t *testing.T) {
+	for _, pattern := range []string{"*.go", "go/*.go"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			fset := token.NewFileSet()
+			parsed, err := parser.ParseFile(fset, file, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, spec := range parsed.Imports {
+				if spec.Path.Value == `"math/rand"` || spec.Path.Value == `"crypto/rand"` {
+					t.Errorf("%s imports %s", file, spec.Path.Value)
+				}
+			}
+			ast.Inspect(parsed, func(node ast.Node) bool {
+				selector, ok := node.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				if pkg, ok := selector.X.(*ast.Ident); ok && pkg.Name == "time" && wallClockFunctions[selector.Sel.Name] {
+					t.Errorf("%s: time.%s reads the wall clock", fset.Position(selector.Pos()), selector.Sel.Name)
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxPooledBufferSize keeps the occasional huge document from pinning a large buffer in the pool
+const maxPooledBufferSize = 64 * 1024
+
+// jsonBufferPool holds the buffers encodeJSON serializes into
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// encodeJSON returns the same bytes as json.Marshal, serializing through a pooled buffer
+// It is meant for the event payloads of hot handlers such as Transfer and TransferFrom.
+// The result is copied out of the buffer, so nothing written by one invocation can be seen by
+// another: the stub keeps event payloads until the transaction ends.
+func encodeJSON(v interface{}) ([]byte, error) {
+	buf := jsonBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			jsonBufferPool.Put(buf)
+		}
+	}()
+
+	err := json.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	// Encode terminates the document with a newline, which json.Marshal does not
+	encoded := make([]byte, buf.Len()-1)
+	copy(encoded, buf.Bytes())
+	return encoded, nil
+}
+
+// aggregateResponse returns the successful response of a query that assembles its document from
+// several sections, encoded in canonical order
+// Object keys are sorted by their UTF-8 bytes at every level, including within sections copied
+// from the payloads of other queries, so every endorser returns the same bytes for the same
+// state whatever code path built each section. Numbers are kept as written. Lists keep their
+// order: each aggregate query sorts its lists and documents the sort key.
+func aggregateResponse(document interface{}) peer.Response {
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// encoding/json writes map keys sorted
+	sorted, err := json.Marshal(value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(sorted)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestEncodeJSONMatchesMarshal(t *testing.T) {
+	for _, v := range []interface{}{
+		event{Token: "TKN", From: alice.Account, To: bob.Account, Value: 10, Memo: "<invoice & co>"},
+		map[string]int{"b": 2, "a": 1},
+		[]string{},
+		nil,
+	} {
+		encoded, err := encodeJSON(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		marshaled, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, marshaled) {
+			t.Errorf("encodeJSON(%v) = %s, json.Marshal gives %s", v, encoded, marshaled)
+		}
+	}
+}
+
+func TestEncodeJSONDoesNotShareBuffers(t *testing.T) {
+	first, err := encodeJSON(event{Token: "TKN", Memo: strings.Repeat("a", 100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := string(first)
+
+	// Reusing the pooled buffer must not change a payload handed out earlier
+	for i := 0; i < 10; i++ {
+		_, err = encodeJSON(event{Token: "TKN", Memo: strings.Repeat("b", 100)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(first) != kept {
+		t.Fatalf("payload changed to %s after later encodings, was %s", first, kept)
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	eventData := event{Token: "TKN", From: alice.Account, To: bob.Account, Value: 10, Memo: "invoice 42"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := encodeJSON(eventData)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMarshalJSON is the json.Marshal baseline of BenchmarkEncodeJSON
+func BenchmarkMarshalJSON(b *testing.B) {
+	eventData := event{Token: "TKN", From: alice.Account, To: bob.Account, Value: 10, Memo: "invoice 42"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := json.Marshal(eventData)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransfer(b *testing.B) {
+	ledger := newToken(b, "")
+	fund(b, ledger, alice.Account, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := ledger.Invoke(alice, "Transfer", bob.Account, "1")
+		if result.Status != shim.OK {
+			b.Fatal(result.Message)
+		}
+	}
+}
+
+func BenchmarkTransferBatch(b *testing.B) {
+	const recipients = 10
+	entries := make([]string, recipients)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"to":"recipient%d","amount":1}`, i)
+	}
+	batch := "[" + strings.Join(entries, ",") + "]"
+
+	ledger := newToken(b, "")
+	fund(b, ledger, alice.Account, recipients*b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := ledger.Invoke(alice, "TransferBatch", batch)
+		if result.Status != shim.OK {
+			b.Fatal(result.Message)
+		}
+	}
+	b.StopTimer()
+	if got := balanceOf(b, ledger, "recipient0"); got != b.N {
+		b.Fatalf("recipient balance is %d after %d batches, expected %d", got, b.N, b.N)
+	}
+}
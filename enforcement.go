@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// forceTransferEvent describes a transfer made by an operator out of someone else's account
+// It carries the Transfer fields as well, since Fabric only keeps one event per transaction.
+type forceTransferEvent struct {
+	event
+	Operator string `json:"operator"`
+	CaseID   string `json:"caseId"`
+}
+
+// ForceTransfer moves `amount` tokens from `from` to `to` without the owner's participation
+// Only admins can force transfers, and every one must reference the open compliance case `caseID`,
+// to which it is linked. The recipient's allowlist and terms acceptance still apply.
+// This function triggers a ForceTransfer event
+func (s *SmartContract) ForceTransfer(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	from := args[0]
+	to := args[1]
+	amount, err := parsePositiveAmount(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caseID := args[3]
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = getOpenCase(APIstub, caseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	operator, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	credited, err := transferBalance(APIstub, from, to, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = linkCaseAction(APIstub, caseID, caseAction{Action: "ForceTransfer", Account: from, Amount: credited})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventData := forceTransferEvent{
+		event:    event{Token: symbol, From: from, To: to, Value: credited, Spillover: amount - credited},
+		Operator: operator,
+		CaseID:   caseID,
+	}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent("ForceTransfer", eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ForceBurn burns `amount` tokens from `account` without the owner's participation
+// Only the burner role can force burns, and every one must reference the open compliance case `caseID`,
+// to which it is linked. An optional fourth argument records the reason on the retirement certificate,
+// whose ID is returned as payload.
+// This function triggers a Retirement event
+func (s *SmartContract) ForceBurn(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
+	}
+
+	account := args[0]
+	amount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caseID := args[2]
+	var reason string
+	if len(args) == 4 {
+		reason, err = sanitizeText("reason", args[3], maxMemoLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	operator, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	burner, err := hasRole(APIstub, burnerRole, operator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !burner {
+		params := map[string]string{"account": account, "role": burnerRole}
+		return shim.Error(newCodedError(APIstub, errUnauthorized, params, "not authorized to burn from %s", account).Error())
+	}
+	_, err = getOpenCase(APIstub, caseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	certificate, err := burnBalance(APIstub, account, amount, reason, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = linkCaseAction(APIstub, caseID, caseAction{Action: "ForceBurn", Account: account, Amount: amount})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitRetirement(APIstub, *certificate)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success([]byte(certificate.CertificateID))
+}
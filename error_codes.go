@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define the codes of common failures that carry parameters
+const errInsufficientBalance = "ERR_INSUFFICIENT_BALANCE"
+const errAllowanceExceeded = "ERR_ALLOWANCE_EXCEEDED"
+const errUnauthorized = "ERR_UNAUTHORIZED"
+const errNotInitialized = "ERR_NOT_INITIALIZED"
+const errInvalidAmount = "ERR_INVALID_AMOUNT"
+const errInvalidAccount = "ERR_INVALID_ACCOUNT"
+const errMemoRequired = "ERR_MEMO_REQUIRED"
+const errMintPaused = "ERR_MINT_PAUSED"
+const errBalanceCap = "ERR_BALANCE_CAP"
+const errBudgetExceeded = "ERR_BUDGET_EXCEEDED"
+const errAdminOURequired = "ERR_ADMIN_OU_REQUIRED"
+const errSessionCapExceeded = "ERR_SESSION_CAP_EXCEEDED"
+const errFunctionDisabled = "ERR_FUNCTION_DISABLED"
+const errArithmeticOverflow = "ERR_ARITHMETIC_OVERFLOW"
+const errSelfTestFailed = "ERR_SELF_TEST_FAILED"
+const errContractPaused = "ERR_CONTRACT_PAUSED"
+const errAccountFrozen = "ERR_ACCOUNT_FROZEN"
+const errFreezeLockout = "ERR_FREEZE_LOCKOUT"
+const errStaleRead = "ERR_STALE_READ"
+
+// errorCodePattern matches the code at the start of an error message
+var errorCodePattern = regexp.MustCompile(`^ERR_[A-Z_]+`)
+
+// codedError is a failure whose code and parameters are returned to clients as JSON,
+// so they can present their own localized message instead of the English one
+type codedError struct {
+	Code   string            `json:"code"`
+	Params map[string]string `json:"params,omitempty"`
+	detail string
+}
+
+// Error returns the compact message used as the shim.Error message
+func (e *codedError) Error() string {
+	return e.Code + ": " + e.detail
+}
+
+// newCodedError returns a codedError and remembers it for the response of this transaction
+// Handlers keep returning shim.Error(err.Error()); Invoke attaches the code and parameters of
+// the error the message came from as the payload. Param values are strings so clients can
+// format them without guessing types.
+func newCodedError(APIstub shim.ChaincodeStubInterface, code string, params map[string]string, format string, args ...interface{}) error {
+	err := &codedError{Code: code, Params: params, detail: fmt.Sprintf(format, args...)}
+	// Queries run on a read-only view of the transaction stub
+	if view, ok := APIstub.(readOnlyStub); ok {
+		APIstub = view.ChaincodeStubInterface
+	}
+	if tx, ok := APIstub.(*txStub); ok {
+		tx.codedErrors = append(tx.codedErrors, err)
+	}
+	return err
+}
+
+// withErrorPayload sets the JSON payload {"code":..., "params":{...}} of a failed response
+// The parameters are those of the coded error the message starts with. Any other message
+// starting with an ERR_ code gets a payload with the code only.
+func withErrorPayload(APIstub shim.ChaincodeStubInterface, response peer.Response) peer.Response {
+	if response.Status < shim.ERRORTHRESHOLD || response.Payload != nil {
+		return response
+	}
+
+	var payload *codedError
+	if tx, ok := APIstub.(*txStub); ok {
+		// The most recent match wins, as a handler fails on the last error it ran into
+		for i := len(tx.codedErrors) - 1; i >= 0; i-- {
+			if strings.HasPrefix(response.Message, tx.codedErrors[i].Error()) {
+				payload = tx.codedErrors[i]
+				break
+			}
+		}
+	}
+	if payload == nil {
+		code := errorCodePattern.FindString(response.Message)
+		if code == "" {
+			return response
+		}
+		payload = &codedError{Code: code}
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return response
+	}
+	response.Payload = payloadBytes
+	return response
+}
+
+// insufficientBalance returns the ERR_INSUFFICIENT_BALANCE error of a debit of `requested` from `available`
+func insufficientBalance(APIstub shim.ChaincodeStubInterface, available int, requested int) error {
+	params := map[string]string{"available": strconv.Itoa(available), "requested": strconv.Itoa(requested)}
+	return newCodedError(APIstub, errInsufficientBalance, params, "insufficient balance")
+}
+
+// invalidAmount returns the ERR_INVALID_AMOUNT error of the client supplied `amount`
+func invalidAmount(APIstub shim.ChaincodeStubInterface, amount string, detail string) error {
+	return newCodedError(APIstub, errInvalidAmount, map[string]string{"amount": amount}, "invalid amount, %s", detail)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for experimental functions
+const experimentalEnabledKey = "experimentalEnabled"
+
+// experimentalFunctions are only routed when experimental functions are enabled
+// Otherwise they fail with ERR_FUNCTION_DISABLED and are left out of GetContractMetadata.
+// A function becomes stable by moving its entry to contractFunctions.
+var experimentalFunctions = map[string]contractFunction{}
+
+// SetExperimentalEnabled enables ("true") or disables ("false") the experimental functions
+// Only admins can change it. The initial value is the experimentalEnabled option of Initialize.
+func (s *SmartContract) SetExperimentalEnabled(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	enabled, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Invalid flag. Expecting true or false")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(experimentalEnabledKey, []byte(strconv.FormatBool(enabled)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", experimentalEnabledKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkExperimentalEnabled returns ERR_FUNCTION_DISABLED unless experimental functions are enabled
+func checkExperimentalEnabled(APIstub shim.ChaincodeStubInterface, function string) error {
+	enabled, err := getBoolSetting(APIstub, experimentalEnabledKey)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return newCodedError(APIstub, errFunctionDisabled, map[string]string{"function": function}, "%s is disabled on this channel", function)
+	}
+	return nil
+}
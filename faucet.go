@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for dev mode options
+const devModeKey = "devMode"
+const faucetCapKey = "faucetCap"
+
+// Define objectType names for faucet usage
+const faucetUsagePrefix = "faucetUsage"
+
+// defaultFaucetCap is the lifetime faucet allowance of an identity when devMode sets no faucetCap
+const defaultFaucetCap = 1000
+
+// supplyReasonFaucet marks supply created by the dev mode faucet in the supply history
+const supplyReasonFaucet = "faucet"
+
+// devFunctions are only routed when the token was initialized with devMode
+// Outside dev mode they are reported as invalid function names and left out of GetContractMetadata.
+var devFunctions = map[string]contractFunction{
+	"Faucet": {invokeFunction, (*SmartContract).Faucet},
+}
+
+// faucetEvent describes a faucet credit
+// It carries the Transfer fields as well, since Fabric only keeps one event per transaction,
+// and DevMode so indexers never count faucet credits as real issuance.
+type faucetEvent struct {
+	event
+	DevMode   bool `json:"devMode"`
+	Remaining int  `json:"remaining"`
+}
+
+// Faucet credits `amount` test tokens to the client account, up to a lifetime cap per identity
+// It is only available on tokens initialized with devMode. The tokens count towards the total
+// supply like minted tokens.
+// This function triggers a Faucet event
+func (s *SmartContract) Faucet(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	amount, err := parsePositiveAmount(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMaxSupply(APIstub, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	faucetCap, err := getFaucetCap(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	usageKey, err := buildKey(APIstub, faucetUsagePrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	usageBytes, err := APIstub.GetState(usageKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "GetState", faucetUsagePrefix, err).Error())
+	}
+	used, _ := strconv.Atoi(string(usageBytes))
+	if used+amount > faucetCap {
+		return shim.Error(fmt.Sprintf("Faucet cap reached. %d of %d left for this identity", faucetCap-used, faucetCap))
+	}
+
+	balance, _, err := getBalance(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	credited, err := applyBalanceCap(APIstub, account, balance, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putBalance(APIstub, account, balance+credited)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.PutState(usageKey, []byte(strconv.Itoa(used+credited)))
+	if err != nil {
+		return shim.Error(notCommitted(stateError(APIstub, "PutState", faucetUsagePrefix, err)).Error())
+	}
+	err = adjustSupply(APIstub, credited, supplyReasonFaucet)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = recordMovements(APIstub, movement{To: account, Value: credited})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventData := faucetEvent{
+		event:     event{Token: symbol, To: account, Value: credited, Spillover: amount - credited},
+		DevMode:   true,
+		Remaining: faucetCap - used - credited,
+	}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent("Faucet", eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// isDevMode reports whether the token was initialized with devMode
+func isDevMode(APIstub shim.ChaincodeStubInterface) (bool, error) {
+	return getBoolSetting(APIstub, devModeKey)
+}
+
+// getFaucetCap returns the lifetime faucet allowance of an identity
+func getFaucetCap(APIstub shim.ChaincodeStubInterface) (int, error) {
+	return getIntSetting(APIstub, faucetCapKey)
+}
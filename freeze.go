@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for account freezes
+const frozenPrefix = "frozen"
+
+// freezeExemptFunctions can still change the balance of a frozen account
+// They are the case-linked enforcement actions a court order may call for alongside the freeze.
+var freezeExemptFunctions = map[string]bool{
+	"ForceTransfer": true,
+	"ForceBurn":     true,
+}
+
+// freezeEvent provides an organized struct for emitting the AccountFrozen and AccountUnfrozen events
+type freezeEvent struct {
+	Token    string `json:"token"`
+	Account  string `json:"account"`
+	Operator string `json:"operator"`
+	CaseID   string `json:"caseId,omitempty"`
+}
+
+// FreezeAccount stops every debit and credit of `account`, except by ForceTransfer and ForceBurn
+// Only the compliance role can freeze accounts, and every freeze is linked to the open compliance
+// case `caseID`. The caller and the last active admin cannot be frozen.
+// This function triggers an AccountFrozen event
+func (s *SmartContract) FreezeAccount(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return setFrozen(APIstub, args, true)
+}
+
+// UnfreezeAccount lifts the freeze of `account`
+// Only the compliance role can unfreeze accounts, and the action is linked to the open compliance
+// case `caseID`.
+// This function triggers an AccountUnfrozen event
+func (s *SmartContract) UnfreezeAccount(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return setFrozen(APIstub, args, false)
+}
+
+// IsFrozen returns "true" if `account` is frozen, otherwise "false"
+func (s *SmartContract) IsFrozen(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	frozen, err := isFrozen(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.FormatBool(frozen)))
+}
+
+// setFrozen freezes or unfreezes an account for FreezeAccount and UnfreezeAccount
+func setFrozen(APIstub shim.ChaincodeStubInterface, args []string, freeze bool) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	account := args[0]
+	caseID := args[1]
+	if account == "" || caseID == "" {
+		return shim.Error("Account and case ID must be non-empty strings")
+	}
+
+	err := requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = getOpenCase(APIstub, caseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if freeze {
+		err = checkFreezable(APIstub, account)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	frozen, err := isFrozen(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if frozen == freeze {
+		if freeze {
+			return shim.Error("Account is already frozen")
+		}
+		return shim.Error("Account is not frozen")
+	}
+
+	frozenKey, err := buildKey(APIstub, frozenPrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	action := "UnfreezeAccount"
+	eventName := "AccountUnfrozen"
+	if freeze {
+		action = "FreezeAccount"
+		eventName = "AccountFrozen"
+		err = APIstub.PutState(frozenKey, []byte{0x00})
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", frozenPrefix, err).Error())
+		}
+	} else {
+		err = APIstub.DelState(frozenKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", frozenPrefix, err).Error())
+		}
+	}
+
+	err = linkCaseAction(APIstub, caseID, caseAction{Action: action, Account: account})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	operator, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventBytes, err := json.Marshal(freezeEvent{Token: symbol, Account: account, Operator: operator, CaseID: caseID})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent(eventName, eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkFreezable returns ERR_FREEZE_LOCKOUT if freezing `account` could lock the contract out
+// A caller cannot freeze itself, and the last active admin cannot be frozen, so an admin is always
+// left to recover.
+func checkFreezable(APIstub shim.ChaincodeStubInterface, account string) error {
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return err
+	}
+	if account == clientID {
+		return newCodedError(APIstub, errFreezeLockout, map[string]string{"account": account}, "cannot freeze the calling account")
+	}
+
+	admins, err := activeMembers(APIstub, adminRole)
+	if err != nil {
+		return err
+	}
+	unfrozen := 0
+	isAdmin := false
+	for _, admin := range admins {
+		if admin.Account == account {
+			isAdmin = true
+			continue
+		}
+		frozen, err := isFrozen(APIstub, admin.Account)
+		if err != nil {
+			return err
+		}
+		if !frozen {
+			unfrozen++
+		}
+	}
+	if isAdmin && unfrozen == 0 {
+		return newCodedError(APIstub, errFreezeLockout, map[string]string{"account": account}, "cannot freeze the last active admin")
+	}
+	return nil
+}
+
+// isFrozen reports whether `account` is frozen
+func isFrozen(APIstub shim.ChaincodeStubInterface, account string) (bool, error) {
+	frozenKey, err := buildKey(APIstub, frozenPrefix, []string{account})
+	if err != nil {
+		return false, err
+	}
+	frozenBytes, err := APIstub.GetState(frozenKey)
+	if err != nil {
+		return false, stateError(APIstub, "GetState", frozenPrefix, err)
+	}
+	return frozenBytes != nil, nil
+}
+
+// checkNotFrozen returns ERR_ACCOUNT_FROZEN if `account` is frozen and the invoked function is not exempt
+func checkNotFrozen(APIstub shim.ChaincodeStubInterface, account string) error {
+	function, _ := APIstub.GetFunctionAndParameters()
+	if freezeExemptFunctions[function] {
+		return nil
+	}
+	frozen, err := isFrozen(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return newCodedError(APIstub, errAccountFrozen, map[string]string{"account": account}, "account %s is frozen", account)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newComplianceToken returns a token where admin holds the compliance role and case-1 is open
+func newComplianceToken(t *testing.T) *chaintest.Ledger {
+	t.Helper()
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, admin, "GrantRole", complianceRole, admin.Account)
+	mustInvoke(t, ledger, admin, "OpenCase", "case-1", "court order")
+	return ledger
+}
+
+func TestFreezeAccount(t *testing.T) {
+	ledger := newComplianceToken(t)
+	fund(t, ledger, alice.Account, 100)
+	fund(t, ledger, bob.Account, 100)
+	mustInvoke(t, ledger, admin, "FreezeAccount", alice.Account, "case-1")
+
+	if got := mustInvoke(t, ledger, admin, "IsFrozen", alice.Account); got != "true" {
+		t.Fatalf("IsFrozen of a frozen account is %s, expected true", got)
+	}
+	for _, test := range []struct {
+		caller chaintest.Identity
+		call   []string
+	}{
+		{alice, []string{"Transfer", bob.Account, "10"}},
+		{bob, []string{"Transfer", alice.Account, "10"}},
+		{alice, []string{"Burn", "10"}},
+	} {
+		message := mustFail(t, ledger, test.caller, test.call[0], test.call[1:]...)
+		if !strings.HasPrefix(message, errAccountFrozen) {
+			t.Fatalf("%s%q involving a frozen account failed with %q, expected %s", test.call[0], test.call[1:], message, errAccountFrozen)
+		}
+	}
+
+	mustInvoke(t, ledger, admin, "UnfreezeAccount", alice.Account, "case-1")
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+}
+
+func TestFreezeAccountRequiresOpenCase(t *testing.T) {
+	ledger := newComplianceToken(t)
+	mustInvoke(t, ledger, admin, "OpenCase", "case-2", "closed")
+	mustInvoke(t, ledger, admin, "CloseCase", "case-2")
+
+	for _, args := range [][]string{
+		{alice.Account},
+		{alice.Account, ""},
+		{alice.Account, "case-unknown"},
+		{alice.Account, "case-2"},
+	} {
+		result := ledger.Invoke(admin, "FreezeAccount", args...)
+		if result.Status == shim.OK || len(result.Writes) != 0 {
+			t.Fatalf("FreezeAccount%q returned %d %q, expected an error before any write", args, result.Status, result.Message)
+		}
+	}
+	if got := mustInvoke(t, ledger, admin, "IsFrozen", alice.Account); got != "false" {
+		t.Fatalf("IsFrozen is %s after refused freezes, expected false", got)
+	}
+}
+
+func TestFreezeAccountLockout(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(ledger *chaintest.Ledger)
+		caller chaintest.Identity
+		target chaintest.Identity
+		// allowed is whether the freeze may go ahead
+		allowed bool
+	}{
+		{"own account", nil, admin, admin, false},
+		{"last admin", func(ledger *chaintest.Ledger) {
+			mustInvoke(t, ledger, admin, "GrantRole", complianceRole, alice.Account)
+		}, alice, admin, false},
+		{"admin with another active admin", func(ledger *chaintest.Ledger) {
+			mustInvoke(t, ledger, admin, "GrantRole", complianceRole, alice.Account)
+			mustInvoke(t, ledger, admin, "GrantRole", adminRole, bob.Account)
+		}, alice, admin, true},
+		{"admin whose only peer is frozen", func(ledger *chaintest.Ledger) {
+			mustInvoke(t, ledger, admin, "GrantRole", complianceRole, alice.Account)
+			mustInvoke(t, ledger, admin, "GrantRole", adminRole, bob.Account)
+			mustInvoke(t, ledger, admin, "FreezeAccount", bob.Account, "case-1")
+		}, alice, admin, false},
+		{"admin whose only peer expired", func(ledger *chaintest.Ledger) {
+			mustInvoke(t, ledger, admin, "GrantRole", complianceRole, alice.Account)
+			mustInvoke(t, ledger, admin, "GrantRole", adminRole, bob.Account, strconv.FormatInt(ledger.Now+100, 10))
+			ledger.Now += 100
+		}, alice, admin, false},
+		{"other account", nil, admin, alice, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newComplianceToken(t)
+			if test.setup != nil {
+				test.setup(ledger)
+			}
+
+			result := ledger.Invoke(test.caller, "FreezeAccount", test.target.Account, "case-1")
+			if test.allowed {
+				if result.Status != shim.OK {
+					t.Fatalf("FreezeAccount failed with %q, expected it to succeed", result.Message)
+				}
+				return
+			}
+			if !strings.HasPrefix(result.Message, errFreezeLockout) || len(result.Writes) != 0 {
+				t.Fatalf("FreezeAccount returned %d %q, expected %s before any write", result.Status, result.Message, errFreezeLockout)
+			}
+		})
+	}
+}
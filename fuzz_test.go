@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// fuzzSeedArgs are argument lists tried with every function, on top of chaintest.NastyArgs
+var fuzzSeedArgs = [][]string{
+	{alice.Account},
+	{alice.Account, "10"},
+	{bob.Account, "10", "groceries", "memo"},
+	{alice.Account, bob.Account, "5"},
+	{`[{"to":"` + bob.Account + `","amount":3}]`},
+	{"\u0000balance\u0000" + alice.Account + "\u0000", "1"},
+	{alice.Account, "10", "", "", "", "", "", "", ""},
+}
+
+// FuzzInvoke checks that Invoke never lets a panic escape, that a failed transaction made no write
+// and set no event, and that every response is a well-formed peer.Response
+func FuzzInvoke(f *testing.F) {
+	base := newToken(f, "")
+	fund(f, base, alice.Account, 1000)
+	fund(f, base, admin.Account, 1000)
+	mustInvoke(f, base, alice, "Approve", bob.Account, "100")
+
+	functions := []string{"", "Invalid", "Init", "invoke", "transfer", "\x00Transfer", "Transfer\x00"}
+	for _, registry := range []map[string]contractFunction{contractFunctions, devFunctions, experimentalFunctions} {
+		for name := range registry {
+			functions = append(functions, name)
+		}
+	}
+	seeds := append(append([][]string{}, chaintest.NastyArgs...), fuzzSeedArgs...)
+	for _, function := range functions {
+		for _, args := range seeds {
+			f.Add(function, chaintest.EncodeArgs(args...), false)
+			f.Add(function, chaintest.EncodeArgs(args...), true)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, function string, encodedArgs []byte, asAdmin bool) {
+		caller := alice
+		if asAdmin {
+			caller = admin
+		}
+		args := chaintest.DecodeArgs(encodedArgs)
+		result := base.Fork().Invoke(caller, function, args...)
+		chaintest.CheckInvokeResult(t, function, args, result)
+	})
+}
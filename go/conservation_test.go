@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// conservationCallers are the identities generated sequences run as and move tokens between
+var conservationCallers = []chaintest.Identity{admin, alice, bob}
+
+// initialSupply is the supply alice holds after Initialize in checkConservation
+const initialSupply = 100
+
+// conservationModel is the expected state of a token, kept independently of the chaincode
+type conservationModel struct {
+	supply     int
+	balances   map[string]int
+	allowances map[[2]string]int
+}
+
+// apply predicts the outcome of `step` and, if it can succeed, applies it to the model
+// It returns why the step must fail, "" if it may succeed; the model only changes if it did.
+func (m *conservationModel) apply(step chaintest.Step, succeeded bool) string {
+	caller := step.Caller.Account
+	amount := func(i int) int {
+		value, _ := strconv.Atoi(step.Args[i])
+		return value
+	}
+	switch step.Function {
+	case "Initialize":
+		return "the token is already initialized"
+	case "Mint":
+		if amount(0) < 0 || step.Caller.MSPID != alice.MSPID {
+			return "the amount is negative or the caller is not of the minter organization"
+		}
+		if succeeded {
+			m.balances[caller] += amount(0)
+			m.supply += amount(0)
+		}
+	case "transfer":
+		if amount(1) < 0 || amount(1) > m.balances[caller] {
+			return "the amount is negative or exceeds the balance"
+		}
+		if succeeded {
+			m.balances[caller] -= amount(1)
+			m.balances[step.Args[0]] += amount(1)
+		}
+	case "Approve":
+		if amount(1) < 0 {
+			return "the amount is negative"
+		}
+		if succeeded {
+			m.allowances[[2]string{caller, step.Args[0]}] = amount(1)
+		}
+	case "transferFrom":
+		owner, allowance := step.Args[0], [2]string{step.Args[0], caller}
+		if amount(2) < 0 || amount(2) > m.balances[owner] || amount(2) > m.allowances[allowance] {
+			return "the amount is negative or exceeds the balance or the allowance"
+		}
+		if succeeded {
+			m.balances[owner] -= amount(2)
+			m.balances[step.Args[1]] += amount(2)
+			m.allowances[allowance] -= amount(2)
+		}
+	}
+	return ""
+}
+
+// generateConservationSteps returns `n` random steps; amounts include zero and negative ones
+func generateConservationSteps(rng *rand.Rand, n int) []chaintest.Step {
+	account := func() string {
+		return conservationCallers[rng.Intn(len(conservationCallers))].Account
+	}
+	amount := func() string {
+		return strconv.Itoa(rng.Intn(40) - 3)
+	}
+	steps := make([]chaintest.Step, n)
+	for i := range steps {
+		step := chaintest.Step{Caller: conservationCallers[rng.Intn(len(conservationCallers))]}
+		switch rng.Intn(8) {
+		case 0:
+			step.Function, step.Args = "Initialize", []string{"Token", "TKN", amount(), "0"}
+		case 1:
+			step.Function, step.Args = "Mint", []string{amount()}
+		case 2, 3:
+			step.Function, step.Args = "transfer", []string{account(), amount()}
+		case 4:
+			step.Function, step.Args = "Approve", []string{account(), amount()}
+		default:
+			step.Function, step.Args = "transferFrom", []string{account(), account(), amount()}
+		}
+		steps[i] = step
+	}
+	return steps
+}
+
+// checkConservation replays `steps` on a new token and returns how the chaincode first departed
+// from the model, "" if it never did. After every step each balance and allowance must match the
+// model, and the balances must add up to the total supply.
+func checkConservation(steps []chaintest.Step) string {
+	ledger := chaintest.NewLedger(new(TokenERC20Chaincode))
+	if result := ledger.Invoke(alice, "Initialize", "Token", "TKN", strconv.Itoa(initialSupply), "0"); result.Status != shim.OK {
+		return "Initialize: " + result.Message
+	}
+	model := conservationModel{
+		supply:     initialSupply,
+		balances:   map[string]int{alice.Account: initialSupply},
+		allowances: map[[2]string]int{},
+	}
+
+	for i, step := range steps {
+		result := ledger.Run(step)
+		succeeded := result.Status == shim.OK
+		if reason := model.apply(step, succeeded); reason != "" && succeeded {
+			return fmt.Sprintf("step %d succeeded although %s", i+1, reason)
+		}
+
+		sum := 0
+		for _, owner := range conservationCallers {
+			balance := ledger.Invoke(admin, "balanceOf", owner.Account)
+			if string(balance.Payload) != strconv.Itoa(model.balances[owner.Account]) {
+				return fmt.Sprintf("after step %d the balance of %s is %q, expected %d", i+1, owner.Account, balance.Payload, model.balances[owner.Account])
+			}
+			sum += model.balances[owner.Account]
+			for _, spender := range conservationCallers {
+				allowance := ledger.Invoke(admin, "Allowance", owner.Account, spender.Account)
+				expected := model.allowances[[2]string{owner.Account, spender.Account}]
+				if string(allowance.Payload) != strconv.Itoa(expected) {
+					return fmt.Sprintf("after step %d the allowance of %s from %s is %q, expected %d", i+1, spender.Account, owner.Account, allowance.Payload, expected)
+				}
+			}
+		}
+		supply := ledger.Invoke(admin, "totalSupply")
+		if string(supply.Payload) != strconv.Itoa(model.supply) || sum != model.supply {
+			return fmt.Sprintf("after step %d the total supply is %q and the balances add up to %d, expected %d", i+1, supply.Payload, sum, model.supply)
+		}
+	}
+	return ""
+}
+
+// TestConservation runs random sequences of movements and checks that no sequence creates or
+// destroys tokens other than through Initialize and Mint. A failing sequence is shrunk before it
+// is reported; the seed is fixed so a failure reproduces.
+func TestConservation(t *testing.T) {
+	rng := rand.New(rand.NewSource(2001))
+	runs := 50
+	if testing.Short() {
+		runs = 10
+	}
+	for run := 0; run < runs; run++ {
+		steps := generateConservationSteps(rng, 40)
+		if failure := checkConservation(steps); failure != "" {
+			steps, failure = chaintest.Shrink(steps, checkConservation)
+			t.Fatalf("run %d: %s\n%s", run, failure, chaintest.FormatSteps(steps))
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// fuzzFunctions are the function names Invoke dispatches, plus near misses
+var fuzzFunctions = []string{
+	"Initialize", "Mint", "MigrateState", "ClientAccountBalance", "ClientAccountID", "transfer", "Approve",
+	"Allowance", "transferFrom", "balanceOf", "name", "symbol", "totalSupply",
+	"", "Invalid", "Init", "Transfer", "\x00transfer", "transfer\x00",
+}
+
+// fuzzSeedArgs are argument lists tried with every function, on top of chaintest.NastyArgs
+var fuzzSeedArgs = [][]string{
+	{alice.Account},
+	{bob.Account, "10"},
+	{alice.Account, bob.Account},
+	{alice.Account, bob.Account, "5"},
+	{alice.Account, "\x00", "5"},
+	{alice.Account, "\xff\xfe", "5"},
+	{"Token", "TKN", "100", "0"},
+	{"Token", "TKN", "100", "256"},
+}
+
+// FuzzInvoke checks that Invoke never lets a panic escape, that a failed transaction made no write
+// and set no event, and that every response is a well-formed peer.Response
+func FuzzInvoke(f *testing.F) {
+	base := newToken(f, "1000")
+	mustInvoke(f, base, alice, "Approve", bob.Account, "100")
+
+	seeds := append(append([][]string{}, chaintest.NastyArgs...), fuzzSeedArgs...)
+	for _, function := range fuzzFunctions {
+		for _, args := range seeds {
+			f.Add(function, chaintest.EncodeArgs(args...), false)
+			f.Add(function, chaintest.EncodeArgs(args...), true)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, function string, encodedArgs []byte, asSpender bool) {
+		caller := alice
+		if asSpender {
+			caller = bob
+		}
+		args := chaintest.DecodeArgs(encodedArgs)
+		result := base.Fork().Invoke(caller, function, args...)
+		chaintest.CheckInvokeResult(t, function, args, result)
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Test identities; alice initializes every token and holds its initial supply
+var (
+	admin = chaintest.NewIdentity("Org1MSP", "admin", chaintest.WithAttrs(map[string]string{"hf.Type": "admin"}))
+	alice = chaintest.NewIdentity("Org1MSP", "alice")
+	bob   = chaintest.NewIdentity("Org2MSP", "bob")
+)
+
+// newToken returns a ledger with a token initialized by alice with `supply` tokens
+func newToken(t testing.TB, supply string) *chaintest.Ledger {
+	t.Helper()
+	ledger := chaintest.NewLedger(new(TokenERC20Chaincode))
+	mustInvoke(t, ledger, alice, "Initialize", "Token", "TKN", supply, "0")
+	return ledger
+}
+
+// mustInvoke submits `function` and fails the test unless it succeeds; it returns the payload
+func mustInvoke(t testing.TB, ledger *chaintest.Ledger, caller chaintest.Identity, function string, args ...string) string {
+	t.Helper()
+	result := ledger.Invoke(caller, function, args...)
+	if result.Status != shim.OK {
+		t.Fatalf("%s%q: %s", function, args, result.Message)
+	}
+	return string(result.Payload)
+}
+
+// mustFail submits `function` and fails the test if it succeeds; it returns the error message
+func mustFail(t testing.TB, ledger *chaintest.Ledger, caller chaintest.Identity, function string, args ...string) string {
+	t.Helper()
+	result := ledger.Invoke(caller, function, args...)
+	if result.Status == shim.OK {
+		t.Fatalf("%s%q succeeded with %q, expected an error", function, args, result.Payload)
+	}
+	return result.Message
+}
+
+// balanceOf returns the balance of `account` reported by balanceOf
+func balanceOf(t testing.TB, ledger *chaintest.Ledger, account string) string {
+	t.Helper()
+	return mustInvoke(t, ledger, admin, "balanceOf", account)
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"sort"
 	"strconv"
+	"strings"
 
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -14,7 +19,24 @@ import (
 type TokenERC20Chaincode struct {
 }
 
-// Token represents an ERC20 token
+// Define key names for the token metadata
+const nameKey = "name"
+const symbolKey = "symbol"
+const decimalsKey = "decimals"
+const totalSupplyKey = "totalSupply"
+
+// minterOrgKey holds the MSP ID whose clients can mint, see checkMinter
+const minterOrgKey = "minterOrg"
+
+// Define objectType names for balances and allowances
+const balancePrefix = "balance"
+const allowancePrefix = "allowance"
+
+// legacyTokenKey holds the whole token as one JSON document in chaincode versions before MigrateState
+const legacyTokenKey = "token"
+
+// Token represents an ERC20 token as stored under legacyTokenKey
+// Balance holds the balance of every account and, under "<owner>_<spender>", every allowance.
 type Token struct {
 	Name     string            `json:"name"`
 	Symbol   string            `json:"symbol"`
@@ -23,6 +45,14 @@ type Token struct {
 	Balance  map[string]uint64 `json:"balance"`
 }
 
+// ApprovalEvent is the payload of the Approval event
+// Value is the allowance of the spender after the transaction.
+type ApprovalEvent struct {
+	Owner   string   `json:"owner"`
+	Spender string   `json:"spender"`
+	Value   *big.Int `json:"value"`
+}
+
 func (t *TokenERC20Chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	return shim.Success(nil)
 }
@@ -37,7 +67,7 @@ func (t *TokenERC20Chaincode) Initialize(stub shim.ChaincodeStubInterface, args
 	// Retrieve information from the arguments
 	name := args[0]
 	symbol := args[1]
-	totalSupply, err := strconv.ParseUint(args[2], 10, 64)
+	totalSupply, err := parseAmount(args[2])
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Invalid total supply: %s", err))
 	}
@@ -46,30 +76,39 @@ func (t *TokenERC20Chaincode) Initialize(stub shim.ChaincodeStubInterface, args
 		return shim.Error(fmt.Sprintf("Invalid decimals: %s", err))
 	}
 
-	// Initialize the token
-	token := Token{
-		Name:     name,
-		Symbol:   symbol,
-		Total:    totalSupply,
-		Decimals: uint8(decimals),
-		Balance:  make(map[string]uint64),
+	// Initializing again would reset the total supply and the creator's balance but no other balance
+	for _, key := range []string{symbolKey, legacyTokenKey} {
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
+		}
+		if existing != nil {
+			return shim.Error("Token already initialized")
+		}
 	}
 
 	// Get information of the transaction creator
-	creator, err := stub.GetCreator()
+	creator, err := clientAccountID(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get transaction creator information: %s", err))
 	}
 
-	// Set total supply to the balance of the transaction creator
-	token.Balance[hex.EncodeToString(creator)] = totalSupply
+	// Save the token metadata and set total supply to the balance of the transaction creator
+	err = putMetadata(stub, name, symbol, totalSupply, uint8(decimals))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to save state: %s", err))
+	}
+	err = putBalance(stub, creator, totalSupply)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to save state: %s", err))
+	}
 
-	// Save the token state to the ledger
-	tokenJSON, err := json.Marshal(token)
+	// Only clients of the creator's organization can mint
+	mspID, err := cid.GetMSPID(stub)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to encode token: %s", err))
+		return shim.Error(fmt.Sprintf("Failed to get transaction creator information: %s", err))
 	}
-	err = stub.PutState("token", tokenJSON)
+	err = stub.PutState(minterOrgKey, []byte(mspID))
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to save state: %s", err))
 	}
@@ -77,39 +116,48 @@ func (t *TokenERC20Chaincode) Initialize(stub shim.ChaincodeStubInterface, args
 	return shim.Success(nil)
 }
 
-func (t *TokenERC20Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+func (t *TokenERC20Chaincode) Invoke(stub shim.ChaincodeStubInterface) (response pb.Response) {
 	function, args := stub.GetFunctionAndParameters()
+	// Report a panic in any function as an error instead of crashing the chaincode
+	defer func() {
+		if r := recover(); r != nil {
+			response = shim.Error(fmt.Sprintf("ERR_PANIC: %s panicked: %v", function, r))
+		}
+	}()
 
 	switch function {
 	case "Initialize":
 		return t.Initialize(stub, args)
 	case "Mint":
 		return t.Mint(stub, args)
+	case "MigrateState":
+		return t.MigrateState(stub, args)
 	case "ClientAccountBalance":
-		return t.ClientAccountBalance(stub)
+		return queryOnly(stub, t.ClientAccountBalance)
 	case "ClientAccountID":
-		return t.ClientAccountID(stub)
+		return queryOnly(stub, t.ClientAccountID)
 	case "transfer":
 		return t.Transfer(stub, args)
 	case "Approve":
 		return t.Approve(stub, args)
 	case "Allowance":
-		return t.Allowance(stub, args)
+		return queryOnly(stub, func(ro shim.ChaincodeStubInterface) pb.Response { return t.Allowance(ro, args) })
 	case "transferFrom":
 		return t.TransferFrom(stub, args)
 	case "balanceOf":
-		return t.BalanceOf(stub, args)
+		return queryOnly(stub, func(ro shim.ChaincodeStubInterface) pb.Response { return t.BalanceOf(ro, args) })
 	case "name":
-		return t.Name(stub)
+		return queryOnly(stub, t.Name)
 	case "symbol":
-		return t.Symbol(stub)
+		return queryOnly(stub, t.Symbol)
 	case "totalSupply":
-		return t.TotalSupply(stub)
+		return queryOnly(stub, t.TotalSupply)
 	}
 	return shim.Error("Invalid function name")
 }
 
 // Mint creates new tokens and adds them to the minter's account balance
+// Only clients of the minter organization can mint, see checkMinter.
 // This function triggers a Transfer event
 func (t *TokenERC20Chaincode) Mint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// Check number of arguments
@@ -118,43 +166,47 @@ func (t *TokenERC20Chaincode) Mint(stub shim.ChaincodeStubInterface, args []stri
 	}
 
 	// Parse amount
-	amount, err := strconv.ParseUint(args[0], 10, 64)
+	amount, err := parseAmount(args[0])
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Invalid amount: %s", err))
 	}
 
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	// Check the minter before anything is written
+	err = checkMinter(stub)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
+		return shim.Error(fmt.Sprintf("Not authorized to mint: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
+
+	// Load total supply
+	total, err := getTotalSupply(stub)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
+		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
 
 	// Add amount to total supply and minter's balance
-	creator, err := stub.GetCreator()
+	creator, err := clientAccountID(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
 	}
-	creatorHex := hex.EncodeToString(creator)
-	token.Total += amount
-	token.Balance[creatorHex] += amount
+	balance, _, err := getCreatorBalance(stub, creator, true)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
+	}
+	total.Add(total, amount)
+	balance.Add(balance, amount)
 
 	// Update token state
-	tokenJSON, err = json.Marshal(token)
+	err = stub.PutState(totalSupplyKey, []byte(total.String()))
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to marshal token: %s", err))
+		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
 	}
-	err = stub.PutState("token", tokenJSON)
+	err = putBalance(stub, creator, balance)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
 	}
 
 	// Trigger Transfer event
-	err = stub.SetEvent("Transfer", []byte(fmt.Sprintf("Minted %d tokens to %s", amount, creatorHex)))
+	err = stub.SetEvent("Transfer", []byte(fmt.Sprintf("Minted %d tokens to %s", amount, creator)))
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to set event: %s", err))
 	}
@@ -162,55 +214,127 @@ func (t *TokenERC20Chaincode) Mint(stub shim.ChaincodeStubInterface, args []stri
 	return shim.Success(nil)
 }
 
-// ClientAccountBalance retrieves the account balance of the client's account
-func (t *TokenERC20Chaincode) ClientAccountBalance(stub shim.ChaincodeStubInterface) pb.Response {
-	// Get client ID
-	clientID, err := stub.GetCreator()
+// MigrateState moves the token from the JSON document of earlier chaincode versions to the
+// metadata keys and per-account balance and allowance keys; call it once after the upgrade
+// Only admins can migrate. It refuses a document whose balances do not add up to the total supply.
+func (t *TokenERC20Chaincode) MigrateState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// Check number of arguments
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	err := cid.AssertAttributeValue(stub, "hf.Type", "admin")
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to get client ID: %s", err))
+		return shim.Error(fmt.Sprintf("Only admins can migrate the token state: %s", err))
 	}
 
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	// Load legacy token state
+	tokenJSON, err := stub.GetState(legacyTokenKey)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
+	if tokenJSON == nil {
+		return shim.Error("No token state to migrate")
+	}
+	symbolBytes, err := stub.GetState(symbolKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
+	}
+	if symbolBytes != nil {
+		return shim.Error("Token state is already migrated")
+	}
 	var token Token
 	err = json.Unmarshal(tokenJSON, &token)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
 	}
-	// Get balance of client ID
-	clientIDHex := hex.EncodeToString(clientID)
-	balance, exists := token.Balance[clientIDHex]
-	if !exists {
-		// Initialize balance to 0 if client ID does not exist in map
-		balance = 0
-		token.Balance[clientIDHex] = balance
-		tokenJSON, err := json.Marshal(token)
-		if err != nil {
-			return shim.Error(fmt.Sprintf("Failed to marshal token: %s", err))
+
+	// Sort the entries so every endorser writes and fails alike
+	keys := make([]string, 0, len(token.Balance))
+	for key := range token.Balance {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Check that the balances add up to the total supply before writing anything
+	sum := new(big.Int)
+	for _, key := range keys {
+		if _, _, isAllowance := splitLegacyAllowance(key); isAllowance {
+			continue
+		}
+		sum.Add(sum, new(big.Int).SetUint64(token.Balance[key]))
+	}
+	if sum.Cmp(new(big.Int).SetUint64(token.Total)) != 0 {
+		return shim.Error(fmt.Sprintf("Balances of the token state add up to %d instead of the total supply %d", sum, token.Total))
+	}
+
+	balances := 0
+	allowances := 0
+	for _, key := range keys {
+		owner, spender, isAllowance := splitLegacyAllowance(key)
+		if isAllowance {
+			err = putAllowance(stub, owner, spender, new(big.Int).SetUint64(token.Balance[key]))
+			allowances++
+		} else {
+			err = putBalance(stub, key, new(big.Int).SetUint64(token.Balance[key]))
+			balances++
 		}
-		err = stub.PutState("token", tokenJSON)
 		if err != nil {
-			return shim.Error(fmt.Sprintf("Failed to update token state: %s", err))
+			return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
 		}
 	}
 
-	return shim.Success([]byte(fmt.Sprintf("%d", balance)))
+	err = putMetadata(stub, token.Name, token.Symbol, new(big.Int).SetUint64(token.Total), token.Decimals)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
+	}
+	err = stub.PutState(minterOrgKey, []byte(mspID))
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
+	}
+	err = stub.DelState(legacyTokenKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to delete state: %s", err))
+	}
+
+	return shim.Success([]byte(fmt.Sprintf("Migrated %d balances and %d allowances", balances, allowances)))
+}
+
+// ClientAccountBalance retrieves the account balance of the client's account
+// It is a query: a client without a balance gets 0 and nothing is written.
+func (t *TokenERC20Chaincode) ClientAccountBalance(stub shim.ChaincodeStubInterface) pb.Response {
+	// Get client ID
+	clientID, err := clientAccountID(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get client ID: %s", err))
+	}
+
+	err = checkMigrated(stub)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
+	}
+	// Get balance of client ID; a legacy balance is read but not moved
+	balance, _, err := getCreatorBalance(stub, clientID, false)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get client ID: %s", err))
+	}
+
+	return shim.Success([]byte(balance.String()))
 }
 
 // ClientAccountID retrieves the client account ID
 func (t *TokenERC20Chaincode) ClientAccountID(stub shim.ChaincodeStubInterface) pb.Response {
 	// Get client ID
-	clientID, err := stub.GetCreator()
+	clientID, err := clientAccountID(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get client ID: %s", err))
 	}
 
-	// encodedClientID := base64.StdEncoding.EncodeToString(clientID)
-	addressHex := hex.EncodeToString([]byte(clientID))
-	return shim.Success([]byte(addressHex))
+	return shim.Success([]byte(clientID))
 }
 
 // Transfer transfers tokens from client account to recipient account
@@ -221,53 +345,44 @@ func (t *TokenERC20Chaincode) Transfer(stub shim.ChaincodeStubInterface, args []
 	if len(args) != 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2: to address and amount")
 	}
+	err := validateAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Invalid receiver: %s", err))
+	}
 
 	// Parse amount
-	amount, err := strconv.ParseUint(args[1], 10, 64)
+	amount, err := parseAmount(args[1])
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Invalid amount: %s", err))
 	}
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	err = checkMigrated(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
 	// Deduct amount from sender's balance
-	sender, err := stub.GetCreator()
+	sender, err := clientAccountID(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
 	}
-	senderHex := hex.EncodeToString(sender)
-	senderBalance := token.Balance[senderHex]
-	if senderBalance < amount {
+	senderBalance, _, err := getCreatorBalance(stub, sender, true)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
+	}
+	if senderBalance.Cmp(amount) < 0 {
 		return shim.Error("Insufficient balance")
 	}
-	token.Balance[senderHex] -= amount
-
-	// Add amount to receiver's balance
-	receiver := args[0]
-	token.Balance[receiver] += amount
 
-	// Update token state
-	tokenJSON, err = json.Marshal(token)
+	// Move amount to receiver's balance
+	err = moveBalance(stub, sender, senderBalance, args[0], amount)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to marshal token: %s", err))
-	}
-	err = stub.PutState("token", tokenJSON)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
+		return shim.Error(fmt.Sprintf("Failed to transfer: %s", err))
 	}
 
 	return shim.Success(nil)
 }
 
-// Approve allows spender to withdraw from owner's account multiple times, up to the amount
+// Approve allows spender to withdraw from the creator's account multiple times, up to the amount
 // This function triggers an Approval event
 func (t *TokenERC20Chaincode) Approve(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// Check number of arguments
@@ -276,45 +391,30 @@ func (t *TokenERC20Chaincode) Approve(stub shim.ChaincodeStubInterface, args []s
 	}
 
 	// Parse amount
-	amount, err := strconv.ParseUint(args[1], 10, 64)
+	amount, err := parseAmount(args[1])
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Invalid amount: %s", err))
 	}
-
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	err = checkMigrated(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
 	// Get miner's address
-	miner, err := stub.GetCreator()
+	miner, err := clientAccountID(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
 	}
-	minerHex := hex.EncodeToString(miner)
 
 	// Set allowance of spender from owner
 	spender := args[0]
-	token.Balance[minerHex+"_"+spender] = amount
-
-	// Update token state
-	tokenJSON, err = json.Marshal(token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to marshal token: %s", err))
-	}
-	err = stub.PutState("token", tokenJSON)
+	err = putAllowance(stub, miner, spender, amount)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
 	}
 
 	// Trigger Approval event
-	err = stub.SetEvent("Approval", []byte(fmt.Sprintf("Approved %d tokens to %s", amount, spender)))
+	err = emitApproval(stub, miner, spender, amount)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to set event: %s", err))
 	}
@@ -322,7 +422,7 @@ func (t *TokenERC20Chaincode) Approve(stub shim.ChaincodeStubInterface, args []s
 	return shim.Success(nil)
 }
 
-// Allowance returns the amount which spender is still allowed to withdraw from owner
+// Allowance returns the amount which spender is still allowed to withdraw from owner, 0 if never approved
 func (t *TokenERC20Chaincode) Allowance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// Check number of arguments
 	if len(args) != 2 {
@@ -332,26 +432,22 @@ func (t *TokenERC20Chaincode) Allowance(stub shim.ChaincodeStubInterface, args [
 	miner := args[0]
 	spender := args[1]
 
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	err := checkMigrated(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
-	// Get allowance of spender from owner
-	allowance, exists := token.Balance[miner+"_"+spender]
-	if !exists {
-		return shim.Error("No allowance found")
+	// Get allowance of spender from owner; an allowance that was never approved is 0
+	allowance, _, err := getAllowance(stub, miner, spender)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get allowance: %s", err))
 	}
 
-	return shim.Success([]byte(fmt.Sprintf("%d", allowance)))
+	return shim.Success([]byte(allowance.String()))
 }
 
+// TransferFrom transfers tokens from sender to receiver using the caller's allowance
+// This function triggers an Approval event with the remaining allowance
 func (t *TokenERC20Chaincode) TransferFrom(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// Check number of arguments
 	if len(args) != 3 {
@@ -361,67 +457,319 @@ func (t *TokenERC20Chaincode) TransferFrom(stub shim.ChaincodeStubInterface, arg
 	// Load token state
 	sender := args[0]
 	receiver := args[1]
+	err := validateAccount(stub, receiver)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Invalid receiver: %s", err))
+	}
 
 	// Parse amount
-	amount, err := strconv.ParseUint(args[2], 10, 64)
+	amount, err := parseAmount(args[2])
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Invalid amount: %s", err))
 	}
-
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	err = checkMigrated(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
-	// Get spider's address
-	spender, err := stub.GetCreator()
+	// The spender is always the transaction creator, never an argument
+	spender, err := clientAccountID(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get creator: %s", err))
 	}
-	spenderHex := hex.EncodeToString(spender)
 
-	// Check the allowance of the sender
-	allowance, exists := token.Balance[sender+"_"+spenderHex]
+	// Only the caller's own allowance can be spent
+	allowance, exists, err := getAllowance(stub, sender, spender)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get allowance: %s", err))
+	}
 	if !exists {
-		return shim.Error("No allowance found")
+		return shim.Error(fmt.Sprintf("Insufficient allowance (have 0, need %s)", amount))
 	}
-	if allowance < amount {
+	if allowance.Cmp(amount) < 0 {
 		return shim.Error("Insufficient allowance")
 	}
-
-	// Deduct the amount from the sender's allowance
-	token.Balance[sender+"_"+spenderHex] -= amount
+	allowance.Sub(allowance, amount)
 
 	// Deduct amount from sender's balance
-	senderBalance := token.Balance[sender]
-	if senderBalance < amount {
+	senderBalance, _, err := getBalance(stub, sender)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to get balance: %s", err))
+	}
+	if senderBalance.Cmp(amount) < 0 {
 		return shim.Error("Insufficient balance")
 	}
-	token.Balance[sender] -= amount
-
-	// Add amount to receiver's balance
-	token.Balance[receiver] += amount
 
-	// Update token state
-	tokenJSON, err = json.Marshal(token)
+	// Move the amount to receiver's balance and deduct it from the sender's allowance
+	err = moveBalance(stub, sender, senderBalance, receiver, amount)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to marshal token: %s", err))
+		return shim.Error(fmt.Sprintf("Failed to transfer: %s", err))
 	}
-	err = stub.PutState("token", tokenJSON)
+	err = putAllowance(stub, sender, spender, allowance)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to put state: %s", err))
 	}
 
+	// Trigger Approval event with the remaining allowance
+	err = emitApproval(stub, sender, spender, allowance)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed to set event: %s", err))
+	}
+
 	return shim.Success(nil)
 }
 
-// BalanceOf returns the balance of the given account
+// checkMinter returns an error unless the transaction creator may mint
+// Initialize and MigrateState store the MSP ID of their caller as the minter organization. A token
+// initialized before that has none, and only admins can mint it.
+func checkMinter(stub shim.ChaincodeStubInterface) error {
+	minterOrg, err := stub.GetState(minterOrgKey)
+	if err != nil {
+		return err
+	}
+	if minterOrg == nil {
+		err = cid.AssertAttributeValue(stub, "hf.Type", "admin")
+		if err != nil {
+			return fmt.Errorf("only admins can mint a token without a minter organization: %s", err)
+		}
+		return nil
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return err
+	}
+	if mspID != string(minterOrg) {
+		return fmt.Errorf("only clients of MSP %s can mint", minterOrg)
+	}
+	return nil
+}
+
+// clientAccountID returns the account ID of the transaction creator
+// It is the MSP ID and the base64 X.509 identity returned by cid.GetID, joined by "::".
+func clientAccountID(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", err
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return "", err
+	}
+	return mspID + "::" + id, nil
+}
+
+// getCreatorBalance returns the balance of the creator's `account` and whether it exists
+// The creator's legacy account, the hex-encoded serialized identity used before creators were
+// parsed, counts as well: it may hold the whole balance, or part of it if `account` was credited
+// before the creator's first write. With `move` the legacy balance is deleted, and the caller's next
+// putBalance of `account` completes the move; queries only read it.
+func getCreatorBalance(stub shim.ChaincodeStubInterface, account string, move bool) (*big.Int, bool, error) {
+	balance, exists, err := getBalance(stub, account)
+	if err != nil {
+		return nil, false, err
+	}
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return nil, false, err
+	}
+	legacyAccount := hex.EncodeToString(creator)
+	legacyBalance, legacyExists, err := getBalance(stub, legacyAccount)
+	if err != nil || !legacyExists {
+		return balance, exists, err
+	}
+	if move {
+		legacyKey, err := stub.CreateCompositeKey(balancePrefix, []string{legacyAccount})
+		if err != nil {
+			return nil, false, err
+		}
+		err = stub.DelState(legacyKey)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return balance.Add(balance, legacyBalance), true, nil
+}
+
+// splitLegacyAllowance splits a key of the legacy token document stored as "<owner>_<spender>"
+// Owners are client account IDs, "<MSP ID>::<base64 identity>", or hex-encoded creators in older
+// versions, so the first underscore that ends such an owner separates the spender. Any other key
+// is a balance.
+func splitLegacyAllowance(key string) (string, string, bool) {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '_' {
+			continue
+		}
+		owner := key[:i]
+		if isLegacyOwner(owner) {
+			return owner, key[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// isLegacyOwner reports whether `owner` is a client account ID or a hex-encoded creator
+func isLegacyOwner(owner string) bool {
+	if separator := strings.Index(owner, "::"); separator > 0 {
+		_, err := base64.StdEncoding.DecodeString(owner[separator+2:])
+		return err == nil
+	}
+	_, err := hex.DecodeString(owner)
+	return owner != "" && err == nil
+}
+
+// validateAccount returns an error unless `account` is non-empty and can key a balance
+// A receiver is checked with it before anything is written, so an invalid receiver fails the
+// transaction without a partial write.
+func validateAccount(stub shim.ChaincodeStubInterface, account string) error {
+	if account == "" {
+		return fmt.Errorf("account must be a non-empty string")
+	}
+	_, err := stub.CreateCompositeKey(balancePrefix, []string{account})
+	return err
+}
+
+// getBalance returns the balance of `account` and whether the account exists
+func getBalance(stub shim.ChaincodeStubInterface, account string) (*big.Int, bool, error) {
+	balanceKey, err := stub.CreateCompositeKey(balancePrefix, []string{account})
+	if err != nil {
+		return nil, false, err
+	}
+	return getAmount(stub, balanceKey)
+}
+
+// putBalance sets the balance of `account`
+func putBalance(stub shim.ChaincodeStubInterface, account string, balance *big.Int) error {
+	balanceKey, err := stub.CreateCompositeKey(balancePrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(balanceKey, []byte(balance.String()))
+}
+
+// moveBalance moves `amount` from `sender`, whose balance is `senderBalance`, to `receiver`
+// Fabric does not let a transaction read its own writes, so a transfer to oneself writes once.
+func moveBalance(stub shim.ChaincodeStubInterface, sender string, senderBalance *big.Int, receiver string, amount *big.Int) error {
+	if sender == receiver {
+		return putBalance(stub, sender, senderBalance)
+	}
+	receiverBalance, _, err := getBalance(stub, receiver)
+	if err != nil {
+		return err
+	}
+	err = putBalance(stub, sender, new(big.Int).Sub(senderBalance, amount))
+	if err != nil {
+		return err
+	}
+	return putBalance(stub, receiver, new(big.Int).Add(receiverBalance, amount))
+}
+
+// getAllowance returns the allowance of `spender` from `owner` and whether it was ever approved
+func getAllowance(stub shim.ChaincodeStubInterface, owner string, spender string) (*big.Int, bool, error) {
+	allowanceKey, err := stub.CreateCompositeKey(allowancePrefix, []string{owner, spender})
+	if err != nil {
+		return nil, false, err
+	}
+	return getAmount(stub, allowanceKey)
+}
+
+// putAllowance sets the allowance of `spender` from `owner`
+func putAllowance(stub shim.ChaincodeStubInterface, owner string, spender string, allowance *big.Int) error {
+	allowanceKey, err := stub.CreateCompositeKey(allowancePrefix, []string{owner, spender})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(allowanceKey, []byte(allowance.String()))
+}
+
+// getAmount returns the amount stored under `key` and whether the key exists; a missing amount is 0
+// Amounts are stored as decimal strings, as strconv wrote them before amounts were unbounded.
+func getAmount(stub shim.ChaincodeStubInterface, key string) (*big.Int, bool, error) {
+	amountBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if amountBytes == nil {
+		return new(big.Int), false, nil
+	}
+	amount, err := parseAmount(string(amountBytes))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount stored under %q", key)
+	}
+	return amount, true, nil
+}
+
+// parseAmount parses a non-negative integer amount of any size written in decimal
+func parseAmount(value string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok || strings.HasPrefix(value, "+") || amount.Sign() < 0 {
+		return nil, fmt.Errorf("expecting a non-negative integer, got %q", value)
+	}
+	return amount, nil
+}
+
+// putMetadata saves the name, symbol, total supply and decimals of the token under their own keys
+func putMetadata(stub shim.ChaincodeStubInterface, name string, symbol string, total *big.Int, decimals uint8) error {
+	metadata := map[string]string{
+		nameKey:        name,
+		symbolKey:      symbol,
+		totalSupplyKey: total.String(),
+		decimalsKey:    strconv.FormatUint(uint64(decimals), 10),
+	}
+	for _, key := range []string{nameKey, symbolKey, totalSupplyKey, decimalsKey} {
+		err := stub.PutState(key, []byte(metadata[key]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMetadata returns the metadata stored under `key`
+// It fails if the token was never initialized, or is still stored under legacyTokenKey.
+func getMetadata(stub shim.ChaincodeStubInterface, key string) (string, error) {
+	valueBytes, err := stub.GetState(key)
+	if err != nil {
+		return "", err
+	}
+	if valueBytes != nil {
+		return string(valueBytes), nil
+	}
+	legacyJSON, err := stub.GetState(legacyTokenKey)
+	if err != nil {
+		return "", err
+	}
+	if legacyJSON != nil {
+		return "", fmt.Errorf("token state has not been migrated, call MigrateState")
+	}
+	return "", fmt.Errorf("token state does not exist")
+}
+
+// getTotalSupply returns the total supply of the token
+func getTotalSupply(stub shim.ChaincodeStubInterface) (*big.Int, error) {
+	total, err := getMetadata(stub, totalSupplyKey)
+	if err != nil {
+		return nil, err
+	}
+	return parseAmount(total)
+}
+
+// checkMigrated returns an error unless the token is initialized and stored under the per-account keys
+// It reads the symbol, which never changes after Initialize, so transfers do not conflict on it.
+func checkMigrated(stub shim.ChaincodeStubInterface) error {
+	_, err := getMetadata(stub, symbolKey)
+	return err
+}
+
+// emitApproval sets the Approval event with the allowance of spender from owner
+func emitApproval(stub shim.ChaincodeStubInterface, owner string, spender string, value *big.Int) error {
+	eventJSON, err := json.Marshal(ApprovalEvent{Owner: owner, Spender: spender, Value: value})
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent("Approval", eventJSON)
+}
+
+// BalanceOf returns the balance of the given account, 0 if it never held tokens
 func (t *TokenERC20Chaincode) BalanceOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// Check number of arguments
 	if len(args) != 1 {
@@ -432,78 +780,101 @@ func (t *TokenERC20Chaincode) BalanceOf(stub shim.ChaincodeStubInterface, args [
 		return shim.Error("Address argument must be a non-empty string")
 	}
 
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	err := checkMigrated(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	if tokenJSON == nil {
-		return shim.Error("Token state does not exist")
-	}
 
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
+	// Get balance of specified address; an address that never held tokens has 0, as in ERC-20
+	balance, _, err := getBalance(stub, address)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
-
-	// Get balance of specified address
-	balance, exists := token.Balance[address]
-	if !exists {
-		return shim.Error(fmt.Sprintf("No balance found for address: %s", address))
+		return shim.Error(fmt.Sprintf("Failed to get balance: %s", err))
 	}
 
-	return shim.Success([]byte(fmt.Sprintf("%d", balance)))
+	return shim.Success([]byte(balance.String()))
 }
 
 // Name returns a descriptive name for fungible tokens in this contract
 // returns {String} Returns the name of the token
 func (t *TokenERC20Chaincode) Name(stub shim.ChaincodeStubInterface) pb.Response {
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	// Load token metadata
+	value, err := getMetadata(stub, nameKey)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
-	return shim.Success([]byte(token.Name))
+	return shim.Success([]byte(value))
 }
 
 // Symbol returns an abbreviated name for fungible tokens in this contract.
 // returns {String} Returns the symbol of the token
 func (t *TokenERC20Chaincode) Symbol(stub shim.ChaincodeStubInterface) pb.Response {
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	// Load token metadata
+	value, err := getMetadata(stub, symbolKey)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
-	return shim.Success([]byte(token.Symbol))
+	return shim.Success([]byte(value))
 }
 
 // TotalSupply returns the total token supply
 func (t *TokenERC20Chaincode) TotalSupply(stub shim.ChaincodeStubInterface) pb.Response {
-	// Load token state
-	tokenJSON, err := stub.GetState("token")
+	// Load total supply
+	total, err := getTotalSupply(stub)
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Failed to get token: %s", err))
 	}
-	var token Token
-	err = json.Unmarshal(tokenJSON, &token)
-	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to unmarshal token: %s", err))
-	}
 
-	return shim.Success([]byte(fmt.Sprintf("%d", token.Total)))
+	return shim.Success([]byte(total.String()))
+}
+
+// writeInQuery is the panic value raised when a query attempts a write
+type writeInQuery struct {
+	operation string
+	key       string
+}
+
+// readOnlyStub is handed to query functions in place of the real stub
+// Every operation that would add to the write set or emit an event panics.
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func (r readOnlyStub) PutState(key string, value []byte) error {
+	panic(writeInQuery{operation: "PutState", key: key})
+}
+
+func (r readOnlyStub) DelState(key string) error {
+	panic(writeInQuery{operation: "DelState", key: key})
+}
+
+func (r readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	panic(writeInQuery{operation: "PutPrivateData", key: key})
+}
+
+func (r readOnlyStub) DelPrivateData(collection string, key string) error {
+	panic(writeInQuery{operation: "DelPrivateData", key: key})
+}
+
+func (r readOnlyStub) SetEvent(name string, payload []byte) error {
+	panic(writeInQuery{operation: "SetEvent", key: name})
+}
+
+// queryOnly runs `query` against a read-only view of the stub
+// A write attempted by the query is converted to an ERR_WRITE_IN_QUERY error.
+func queryOnly(stub shim.ChaincodeStubInterface, query func(shim.ChaincodeStubInterface) pb.Response) (response pb.Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			write, ok := r.(writeInQuery)
+			if !ok {
+				panic(r)
+			}
+			response = shim.Error(fmt.Sprintf("ERR_WRITE_IN_QUERY: query attempted %s on %q", write.operation, write.key))
+		}
+	}()
+
+	return query(readOnlyStub{stub})
 }
 
 func main() {
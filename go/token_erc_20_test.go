@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCreatorCanSpendInitialSupply(t *testing.T) {
+	ledger := newToken(t, "100")
+	// Initialize credits and transfer debits the same account
+	if got := mustInvoke(t, ledger, alice, "ClientAccountID"); got != alice.Account {
+		t.Fatalf("ClientAccountID is %q, expected %q", got, alice.Account)
+	}
+
+	mustInvoke(t, ledger, alice, "transfer", bob.Account, "40")
+	if got := balanceOf(t, ledger, alice.Account); got != "60" {
+		t.Fatalf("creator balance is %s after the transfer, expected 60", got)
+	}
+	if got := balanceOf(t, ledger, bob.Account); got != "40" {
+		t.Fatalf("recipient balance is %s after the transfer, expected 40", got)
+	}
+	if got := mustInvoke(t, ledger, alice, "ClientAccountBalance"); got != "60" {
+		t.Fatalf("ClientAccountBalance of the creator is %s, expected 60", got)
+	}
+}
+
+func TestLegacyCreatorBalanceIsSpendable(t *testing.T) {
+	ledger := chaintest.NewLedger(new(TokenERC20Chaincode))
+	mustInvoke(t, ledger, bob, "Initialize", "Token", "TKN", "0", "0")
+	// Before creators were parsed, balances were stored under the hex-encoded serialized identity
+	legacyKey := "\x00" + balancePrefix + "\x00" + hex.EncodeToString(alice.Creator) + "\x00"
+	ledger.SetState(legacyKey, []byte("50"))
+	ledger.SetState(totalSupplyKey, []byte("50"))
+
+	mustInvoke(t, ledger, alice, "transfer", bob.Account, "20")
+	if got := balanceOf(t, ledger, alice.Account); got != "30" {
+		t.Fatalf("creator balance is %s after spending a legacy balance, expected 30", got)
+	}
+	if ledger.State(legacyKey) != nil {
+		t.Fatalf("legacy balance is still stored as %q after the transfer", ledger.State(legacyKey))
+	}
+}
+
+func TestLegacyCreatorBalanceCreditedUnderNewKey(t *testing.T) {
+	ledger := newToken(t, "100")
+	legacyKey := "\x00" + balancePrefix + "\x00" + hex.EncodeToString(bob.Creator) + "\x00"
+	ledger.SetState(legacyKey, []byte("50"))
+	ledger.SetState(totalSupplyKey, []byte("150"))
+
+	// alice cannot see bob's legacy key, so her transfer creates his new balance
+	mustInvoke(t, ledger, alice, "transfer", bob.Account, "20")
+	if got := mustInvoke(t, ledger, bob, "ClientAccountBalance"); got != "70" {
+		t.Fatalf("balance with parts under both keys is %s, expected 70", got)
+	}
+
+	mustInvoke(t, ledger, bob, "transfer", alice.Account, "60")
+	if got := balanceOf(t, ledger, bob.Account); got != "10" {
+		t.Fatalf("balance is %s after spending both parts, expected 10", got)
+	}
+	if ledger.State(legacyKey) != nil {
+		t.Fatalf("legacy balance is still stored as %q after the transfer", ledger.State(legacyKey))
+	}
+}
+
+func TestClientAccountBalanceWritesNothing(t *testing.T) {
+	ledger := newToken(t, "100")
+
+	result := ledger.Invoke(bob, "ClientAccountBalance")
+	if string(result.Payload) != "0" || result.Message != "" {
+		t.Fatalf("ClientAccountBalance of a client without a balance returned %q %q, expected 0", result.Payload, result.Message)
+	}
+	if len(result.Writes) != 0 {
+		t.Fatalf("ClientAccountBalance wrote %v, expected no writes", chaintest.WriteSet(result.Writes))
+	}
+}
+
+func TestClientAccountBalanceLeavesLegacyBalance(t *testing.T) {
+	ledger := chaintest.NewLedger(new(TokenERC20Chaincode))
+	mustInvoke(t, ledger, bob, "Initialize", "Token", "TKN", "0", "0")
+	legacyKey := "\x00" + balancePrefix + "\x00" + hex.EncodeToString(alice.Creator) + "\x00"
+	ledger.SetState(legacyKey, []byte("50"))
+
+	// A query reads the legacy balance but leaves moving it to the creator's next transfer
+	result := ledger.Invoke(alice, "ClientAccountBalance")
+	if string(result.Payload) != "50" {
+		t.Fatalf("ClientAccountBalance of a legacy balance returned %q %q, expected 50", result.Payload, result.Message)
+	}
+	if len(result.Writes) != 0 {
+		t.Fatalf("ClientAccountBalance wrote %v, expected no writes", chaintest.WriteSet(result.Writes))
+	}
+}
+
+func TestBalanceOfUnknownAccount(t *testing.T) {
+	ledger := newToken(t, "100")
+
+	result := ledger.Invoke(admin, "balanceOf", bob.Account)
+	if string(result.Payload) != "0" || result.Message != "" {
+		t.Fatalf("balanceOf of an account that never held tokens returned %q %q, expected 0", result.Payload, result.Message)
+	}
+	mustFail(t, ledger, admin, "balanceOf", "")
+}
+
+func TestBalanceOfEmptiedAccount(t *testing.T) {
+	ledger := newToken(t, "100")
+	mustInvoke(t, ledger, alice, "transfer", bob.Account, "100")
+
+	// Both read as 0, but only the emptied account exists
+	if got := balanceOf(t, ledger, alice.Account); got != "0" {
+		t.Fatalf("balance of an emptied account is %s, expected 0", got)
+	}
+	if got := balanceOf(t, ledger, admin.Account); got != "0" {
+		t.Fatalf("balance of an unknown account is %s, expected 0", got)
+	}
+	if got := string(ledger.State("\x00" + balancePrefix + "\x00" + alice.Account + "\x00")); got != "0" {
+		t.Fatalf("emptied account is stored as %q, expected a zero balance", got)
+	}
+	if stored := ledger.State("\x00" + balancePrefix + "\x00" + admin.Account + "\x00"); stored != nil {
+		t.Fatalf("unknown account is stored as %q after balanceOf, expected nothing", stored)
+	}
+}
+
+func TestMintRequiresMinterOrganization(t *testing.T) {
+	carol := chaintest.NewIdentity("Org2MSP", "carol", chaintest.WithAttrs(map[string]string{"hf.Type": "admin"}))
+	tests := []struct {
+		name      string
+		minterOrg bool
+		caller    chaintest.Identity
+		// balance is the caller's balance after minting 50, "" if Mint must fail
+		balance string
+	}{
+		{"creator", true, alice, "150"},
+		{"client of the minter organization", true, admin, "50"},
+		{"client of another organization", true, bob, ""},
+		{"admin of another organization", true, carol, ""},
+		{"admin without a minter organization", false, admin, "50"},
+		{"client without a minter organization", false, alice, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "100")
+			if !test.minterOrg {
+				ledger.SetState(minterOrgKey, nil)
+			}
+
+			result := ledger.Invoke(test.caller, "Mint", "50")
+			if test.balance == "" {
+				if result.Status == shim.OK || len(result.Writes) != 0 || len(result.Events) != 0 {
+					t.Fatalf("Mint returned %d %q with %d writes, expected an error before any write", result.Status, result.Message, len(result.Writes))
+				}
+				if got := mustInvoke(t, ledger, admin, "totalSupply"); got != "100" {
+					t.Fatalf("total supply is %s after a refused Mint, expected 100", got)
+				}
+				return
+			}
+			if result.Status != shim.OK {
+				t.Fatalf("Mint failed with %q, expected it to succeed", result.Message)
+			}
+			if got := balanceOf(t, ledger, test.caller.Account); got != test.balance {
+				t.Fatalf("minter balance is %s after minting 50, expected %s", got, test.balance)
+			}
+		})
+	}
+}
+
+func TestTransferRejectsInvalidReceiver(t *testing.T) {
+	ledger := newToken(t, "100")
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "50")
+
+	for _, receiver := range []string{"", "bad\x00receiver", "bad\xffreceiver"} {
+		for _, call := range [][]string{
+			{"transfer", receiver, "10"},
+			{"transferFrom", alice.Account, receiver, "10"},
+		} {
+			caller := alice
+			if call[0] == "transferFrom" {
+				caller = bob
+			}
+			result := ledger.Invoke(caller, call[0], call[1:]...)
+			if result.Status == shim.OK || len(result.Writes) != 0 || !strings.HasPrefix(result.Message, "Invalid receiver") {
+				t.Fatalf("%s to %q returned %d %q with %d writes, expected an invalid receiver", call[0], receiver, result.Status, result.Message, len(result.Writes))
+			}
+		}
+	}
+	if got := balanceOf(t, ledger, alice.Account); got != "100" {
+		t.Fatalf("balance is %s after refused transfers, expected 100", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Test identities; admin initializes every token and becomes its first admin
+var (
+	admin = chaintest.NewIdentity("Org1MSP", "admin")
+	alice = chaintest.NewIdentity("Org1MSP", "alice")
+	bob   = chaintest.NewIdentity("Org2MSP", "bob")
+	carol = chaintest.NewIdentity("Org2MSP", "carol")
+)
+
+// newToken returns a ledger with a token initialized by admin with the Initialize `options`
+// JSON, "" for none
+func newToken(t testing.TB, options string) *chaintest.Ledger {
+	t.Helper()
+	ledger := chaintest.NewLedger(new(SmartContract))
+	args := []string{"Token", "TKN", "0", "0"}
+	if options != "" {
+		args = append(args, options)
+	}
+	mustInvoke(t, ledger, admin, "Initialize", args...)
+	return ledger
+}
+
+// mustInvoke submits `function` and fails the test unless it succeeds; it returns the payload
+func mustInvoke(t testing.TB, ledger *chaintest.Ledger, caller chaintest.Identity, function string, args ...string) string {
+	t.Helper()
+	result := ledger.Invoke(caller, function, args...)
+	if result.Status != shim.OK {
+		t.Fatalf("%s%q: %s", function, args, result.Message)
+	}
+	return string(result.Payload)
+}
+
+// mustFail submits `function` and fails the test if it succeeds; it returns the error message
+func mustFail(t testing.TB, ledger *chaintest.Ledger, caller chaintest.Identity, function string, args ...string) string {
+	t.Helper()
+	result := ledger.Invoke(caller, function, args...)
+	if result.Status == shim.OK {
+		t.Fatalf("%s%q succeeded with %q, expected an error", function, args, result.Payload)
+	}
+	return result.Message
+}
+
+// balanceOf returns the balance of `account` reported by BalanceOf
+func balanceOf(t testing.TB, ledger *chaintest.Ledger, account string) int {
+	t.Helper()
+	balance, err := strconv.Atoi(mustInvoke(t, ledger, admin, "BalanceOf", account))
+	if err != nil {
+		t.Fatalf("BalanceOf %s: %s", account, err)
+	}
+	return balance
+}
+
+// fund mints `amount` tokens to `account`
+func fund(t testing.TB, ledger *chaintest.Ledger, account string, amount int) {
+	t.Helper()
+	mustInvoke(t, ledger, admin, "Mint", account, strconv.Itoa(amount))
+}
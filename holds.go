@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for balance holds
+const holdPrefix = "hold"
+const holdByAccountPrefix = "holdByAccount"
+
+// Define hold statuses
+const holdActive = "held"
+const holdCaptured = "captured"
+const holdReleased = "released"
+const holdExpired = "expired"
+
+// hold reserves part of an account's balance for a beneficiary, e.g. from order to shipment
+// The amount leaves the spendable balance when the hold is placed and stays in the held bucket
+// until the beneficiary captures it or it is released.
+// Holds survive a freeze of either party: a hold cannot be captured while its owner or beneficiary
+// is frozen, nor released while its owner is, and one that expires meanwhile is released once the
+// owner is unfrozen.
+type hold struct {
+	ID          string `json:"id"`
+	Account     string `json:"account"`
+	Beneficiary string `json:"beneficiary"`
+	Amount      int    `json:"amount"`
+	Captured    int    `json:"captured,omitempty"`
+	ExpiresAt   int64  `json:"expiresAt"`
+	Status      string `json:"status"`
+}
+
+// holdEvent provides an organized struct for emitting hold events
+// ReleasedHolds lists the expired holds of the owner that placing the hold released.
+type holdEvent struct {
+	Token string `json:"token"`
+	hold
+	ReleasedHolds []string `json:"releasedHolds,omitempty"`
+}
+
+// Hold moves `amount` tokens of the caller into the held bucket until `beneficiary` captures them
+// or the hold is released; it expires at `expiryTs`, after which anyone can release it
+// The caller's expired holds are released first, and the tokens they free count towards the new hold.
+// The hold ID is returned as payload.
+// This function triggers a HoldPlaced event
+func (s *SmartContract) Hold(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	amount, err := parsePositiveAmount(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	beneficiary := args[1]
+	expiresAt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || expiresAt < 0 {
+		return shim.Error("Invalid expiry time. Expecting a unix timestamp in seconds")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if beneficiary == "" || beneficiary == account {
+		return shim.Error("Beneficiary must be a different account")
+	}
+	// The balance of a frozen account may be left untouched below when expired holds cover the new one
+	err = checkNotFrozen(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiresAt <= now {
+		return shim.Error("Expiry time must be in the future")
+	}
+
+	id, err := newDeterministicID(APIstub, holdPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	placed := hold{
+		ID:          id,
+		Account:     account,
+		Beneficiary: beneficiary,
+		Amount:      amount,
+		ExpiresAt:   expiresAt,
+		Status:      holdActive,
+	}
+
+	// Release the expired holds first. GetState does not see this transaction's own writes,
+	// so the refund and the new hold settle as one balance change.
+	released, refunded, err := releaseExpiredHolds(APIstub, account, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if refunded >= amount {
+		if refunded > amount {
+			err = creditBalance(APIstub, account, refunded-amount)
+		}
+	} else {
+		err = debitBalance(APIstub, account, amount-refunded)
+	}
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = putHold(APIstub, placed)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	indexKey, err := buildKey(APIstub, holdByAccountPrefix, []string{account, placed.ID})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(notCommitted(stateError(APIstub, "PutState", holdByAccountPrefix, err)).Error())
+	}
+
+	err = emitHoldEvent(APIstub, "HoldPlaced", placed, released)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success([]byte(placed.ID))
+}
+
+// CaptureHold transfers `amount` of the hold `holdID` to its beneficiary and releases the rest to its owner
+// Only the beneficiary can capture a hold, at most once and before it expires.
+// This function triggers a HoldCaptured event
+func (s *SmartContract) CaptureHold(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	amount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	h, err := getActiveHold(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if h.effectiveStatus(now) != holdActive {
+		return shim.Error("Hold has expired")
+	}
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != h.Beneficiary {
+		return shim.Error("Only the beneficiary can capture a hold")
+	}
+	err = checkMSPBinding(APIstub, clientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount > h.Amount {
+		return shim.Error(fmt.Sprintf("Capture amount exceeds the held amount of %d", h.Amount))
+	}
+
+	// A hold of a frozen owner stays in place, even when a full capture releases nothing to the owner
+	err = checkNotFrozen(APIstub, h.Account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkIncomingAllowed(APIstub, h.Account, h.Beneficiary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkTermsAccepted(APIstub, h.Beneficiary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = creditBalance(APIstub, h.Beneficiary, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount < h.Amount {
+		err = creditBalance(APIstub, h.Account, h.Amount-amount)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+	}
+	err = recordMovements(APIstub, movement{From: h.Account, To: h.Beneficiary, Value: amount})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	h.Captured = amount
+	h.Status = holdCaptured
+	err = closeHold(APIstub, *h)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitHoldEvent(APIstub, "HoldCaptured", *h, nil)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ReleaseHold returns the hold `holdID` to its owner's spendable balance
+// The owner and the beneficiary can release a hold at any time; once it has expired anyone can.
+// This function triggers a HoldReleased or HoldExpired event
+func (s *SmartContract) ReleaseHold(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	h, err := getActiveHold(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	status := h.effectiveStatus(now)
+	if status == holdActive {
+		clientID, err := getClientID(APIstub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if clientID != h.Account && clientID != h.Beneficiary {
+			return shim.Error("Only the owner or the beneficiary can release a hold before it expires")
+		}
+		status = holdReleased
+	}
+
+	err = creditBalance(APIstub, h.Account, h.Amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	h.Status = status
+	err = closeHold(APIstub, *h)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	eventName := "HoldReleased"
+	if status == holdExpired {
+		eventName = "HoldExpired"
+	}
+	err = emitHoldEvent(APIstub, eventName, *h, nil)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetHold returns the hold `holdID`, with the expired status once it is past its expiry
+func (s *SmartContract) GetHold(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	h, err := getHold(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if h == nil {
+		return shim.Error("Hold not found")
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	h.Status = h.effectiveStatus(now)
+
+	holdBytes, err := json.Marshal(h)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(holdBytes)
+}
+
+// effectiveStatus returns the status of the hold at `now`, taking expiry into account
+func (h hold) effectiveStatus(now int64) string {
+	if h.Status == holdActive && now >= h.ExpiresAt {
+		return holdExpired
+	}
+	return h.Status
+}
+
+// getHeldAmounts returns the tokens of `account` in active holds and in expired holds not released yet
+func getHeldAmounts(APIstub shim.ChaincodeStubInterface, account string, now int64) (int, int, error) {
+	var held, releasable int
+	_, err := iterate(APIstub, holdByAccountPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		h, err := getHold(APIstub, attributes[1])
+		if err != nil || h == nil {
+			return err
+		}
+		if h.effectiveStatus(now) == holdExpired {
+			releasable += h.Amount
+		} else {
+			held += h.Amount
+		}
+		return nil
+	})
+	return held, releasable, err
+}
+
+// releaseExpiredHolds marks the expired holds of `account` released and returns their IDs and total amount
+// The caller must credit the total back to the account.
+func releaseExpiredHolds(APIstub shim.ChaincodeStubInterface, account string, now int64) ([]string, int, error) {
+	var expired []hold
+	_, err := iterate(APIstub, holdByAccountPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		h, err := getHold(APIstub, attributes[1])
+		if err != nil || h == nil {
+			return err
+		}
+		if h.effectiveStatus(now) == holdExpired {
+			expired = append(expired, *h)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ids []string
+	var total int
+	for _, h := range expired {
+		total, err = checkedAdd(APIstub, total, h.Amount)
+		if err != nil {
+			return nil, 0, err
+		}
+		h.Status = holdExpired
+		err = closeHold(APIstub, h)
+		if err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, h.ID)
+	}
+	return ids, total, nil
+}
+
+// getActiveHold returns the hold with the given ID if it was not captured or released yet
+func getActiveHold(APIstub shim.ChaincodeStubInterface, id string) (*hold, error) {
+	h, err := getHold(APIstub, id)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, fmt.Errorf("Hold not found")
+	}
+	if h.Status != holdActive {
+		return nil, fmt.Errorf("Hold is %s", h.Status)
+	}
+	return h, nil
+}
+
+// getHold returns the stored hold, or nil if there is none
+func getHold(APIstub shim.ChaincodeStubInterface, id string) (*hold, error) {
+	holdKey, err := buildKey(APIstub, holdPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	holdBytes, err := APIstub.GetState(holdKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", holdPrefix, err)
+	}
+	if holdBytes == nil {
+		return nil, nil
+	}
+	var h hold
+	err = json.Unmarshal(holdBytes, &h)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// putHold stores the hold under its ID
+func putHold(APIstub shim.ChaincodeStubInterface, h hold) error {
+	holdKey, err := buildKey(APIstub, holdPrefix, []string{h.ID})
+	if err != nil {
+		return err
+	}
+	holdBytes, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(holdKey, holdBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", holdPrefix, err)
+	}
+	return nil
+}
+
+// closeHold stores the final status of a hold and removes it from the owner's index
+func closeHold(APIstub shim.ChaincodeStubInterface, h hold) error {
+	err := putHold(APIstub, h)
+	if err != nil {
+		return err
+	}
+	indexKey, err := buildKey(APIstub, holdByAccountPrefix, []string{h.Account, h.ID})
+	if err != nil {
+		return err
+	}
+	err = APIstub.DelState(indexKey)
+	if err != nil {
+		return stateError(APIstub, "DelState", holdByAccountPrefix, err)
+	}
+	return nil
+}
+
+// emitHoldEvent emits `name` describing the current state of the hold
+func emitHoldEvent(APIstub shim.ChaincodeStubInterface, name string, h hold, released []string) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(holdEvent{Token: symbol, hold: h, ReleasedHolds: released})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
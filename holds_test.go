@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newHoldToken returns a compliance token where alice holds 100, 60 of them in a hold for bob
+// expiring 100 seconds after the start; it also returns the hold ID
+func newHoldToken(t *testing.T) (*chaintest.Ledger, string) {
+	t.Helper()
+	ledger := newComplianceToken(t)
+	fund(t, ledger, alice.Account, 100)
+	holdID := mustInvoke(t, ledger, alice, "Hold", "60", bob.Account, fmt.Sprint(chaintest.StartTime+100))
+	return ledger, holdID
+}
+
+// heldBalance returns the document BalanceOf returns for `account` in JSON mode
+func heldBalance(t *testing.T, ledger *chaintest.Ledger, account string) balanceResponse {
+	t.Helper()
+	var response balanceResponse
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "BalanceOf", account, "json")), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestHoldRefusals(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"above the balance", []string{"101", bob.Account, fmt.Sprint(chaintest.StartTime + 100)}, errInsufficientBalance},
+		{"zero amount", []string{"0", bob.Account, fmt.Sprint(chaintest.StartTime + 100)}, errInvalidAmount},
+		{"own account", []string{"10", alice.Account, fmt.Sprint(chaintest.StartTime + 100)}, "Beneficiary must be a different account"},
+		{"no beneficiary", []string{"10", "", fmt.Sprint(chaintest.StartTime + 100)}, "Beneficiary must be a different account"},
+		{"expiry now", []string{"10", bob.Account, fmt.Sprint(chaintest.StartTime)}, "Expiry time must be in the future"},
+		{"invalid expiry", []string{"10", bob.Account, "soon"}, "Invalid expiry time"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newComplianceToken(t)
+			fund(t, ledger, alice.Account, 100)
+			before := ledger.Fork()
+
+			message := mustFail(t, ledger, alice, "Hold", test.args...)
+			if !strings.HasPrefix(message, test.expected) {
+				t.Fatalf("Hold%q failed with %q, expected %q", test.args, message, test.expected)
+			}
+			if !sameState(before, ledger) {
+				t.Fatalf("refused Hold%q changed the state", test.args)
+			}
+		})
+	}
+}
+
+// TestCaptureHold captures `amount` of the hold of 60 `offset` seconds after it was placed
+func TestCaptureHold(t *testing.T) {
+	tests := []struct {
+		name     string
+		caller   chaintest.Identity
+		amount   int
+		offset   int64
+		expected string
+	}{
+		{"full capture", bob, 60, 0, ""},
+		{"partial capture", bob, 25, 0, ""},
+		{"smallest capture", bob, 1, 0, ""},
+		{"just before the expiry", bob, 60, 99, ""},
+		{"at the expiry", bob, 60, 100, "Hold has expired"},
+		{"above the held amount", bob, 61, 0, "Capture amount exceeds the held amount of 60"},
+		{"zero amount", bob, 0, 0, errInvalidAmount},
+		{"owner", alice, 60, 0, "Only the beneficiary can capture a hold"},
+		{"third party", carol, 60, 0, "Only the beneficiary can capture a hold"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger, holdID := newHoldToken(t)
+			ledger.Now += test.offset
+
+			result := ledger.Invoke(test.caller, "CaptureHold", holdID, fmt.Sprint(test.amount))
+			captured := 0
+			if test.expected != "" {
+				if result.Status == shim.OK || !strings.HasPrefix(result.Message, test.expected) {
+					t.Fatalf("CaptureHold(%d) returned %q, expected %q", test.amount, result.Message, test.expected)
+				}
+			} else {
+				if result.Status != shim.OK {
+					t.Fatalf("CaptureHold(%d) failed with %q", test.amount, result.Message)
+				}
+				captured = test.amount
+				var event holdEvent
+				err := json.Unmarshal(result.Events[len(result.Events)-1].Payload, &event)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Events[len(result.Events)-1].Name != "HoldCaptured" || event.Captured != captured || event.Status != holdCaptured {
+					t.Fatalf("CaptureHold(%d) emitted %s, expected HoldCaptured of %d", test.amount, result.Events[len(result.Events)-1].Payload, captured)
+				}
+				if message := mustFail(t, ledger, bob, "CaptureHold", holdID, "1"); message != "Hold is captured" {
+					t.Fatalf("second CaptureHold failed with %q, expected Hold is captured", message)
+				}
+			}
+
+			// The remainder of a capture is released; a refused capture leaves the hold in place
+			owner := heldBalance(t, ledger, alice.Account)
+			held := 60
+			if captured != 0 {
+				held = 0
+			}
+			if owner.Balance != 100-captured-held || owner.Held+owner.Releasable != held {
+				t.Fatalf("owner has %+v after CaptureHold(%d), expected a balance of %d with %d held", owner, test.amount, 100-captured-held, held)
+			}
+			if got := balanceOf(t, ledger, bob.Account); got != captured {
+				t.Fatalf("beneficiary holds %d after CaptureHold(%d), expected %d", got, test.amount, captured)
+			}
+		})
+	}
+}
+
+// TestReleaseHold releases the hold of 60 `offset` seconds after it was placed; before the expiry
+// only the owner and the beneficiary can, from the expiry on anyone can
+func TestReleaseHold(t *testing.T) {
+	tests := []struct {
+		caller  chaintest.Identity
+		offset  int64
+		allowed bool
+		event   string
+	}{
+		{alice, 0, true, "HoldReleased"},
+		{bob, 0, true, "HoldReleased"},
+		{carol, 0, false, ""},
+		{carol, 99, false, ""},
+		{carol, 100, true, "HoldExpired"},
+		{alice, 100, true, "HoldExpired"},
+		{carol, 101, true, "HoldExpired"},
+	}
+	for _, test := range tests {
+		ledger, holdID := newHoldToken(t)
+		ledger.Now += test.offset
+
+		result := ledger.Invoke(test.caller, "ReleaseHold", holdID)
+		if test.allowed != (result.Status == shim.OK) {
+			t.Fatalf("ReleaseHold after %d seconds returned %q, expected it to succeed: %t", test.offset, result.Message, test.allowed)
+		}
+		balance := 40
+		if test.allowed {
+			balance = 100
+			if len(result.Events) != 1 || result.Events[0].Name != test.event {
+				t.Fatalf("ReleaseHold after %d seconds emitted %+v, expected %s", test.offset, result.Events, test.event)
+			}
+			if message := mustFail(t, ledger, alice, "ReleaseHold", holdID); !strings.HasPrefix(message, "Hold is ") {
+				t.Fatalf("second ReleaseHold failed with %q, expected the hold to be closed", message)
+			}
+		} else if result.Message != "Only the owner or the beneficiary can release a hold before it expires" {
+			t.Fatalf("ReleaseHold after %d seconds failed with %q", test.offset, result.Message)
+		}
+		if got := balanceOf(t, ledger, alice.Account); got != balance {
+			t.Fatalf("owner holds %d after ReleaseHold after %d seconds, expected %d", got, test.offset, balance)
+		}
+	}
+}
+
+// TestHoldReleasesExpiredHolds places a new hold of `amount` once the hold of 60 has expired:
+// the expired hold is released in the same transaction and its tokens count towards the new one
+func TestHoldReleasesExpiredHolds(t *testing.T) {
+	tests := []struct {
+		amount  int
+		allowed bool
+	}{
+		{30, true},
+		{60, true},
+		{80, true},
+		{100, true},
+		{101, false},
+	}
+	for _, test := range tests {
+		ledger, holdID := newHoldToken(t)
+		ledger.Now += 100
+		if got := heldBalance(t, ledger, alice.Account); got.Balance != 40 || got.Held != 0 || got.Releasable != 60 {
+			t.Fatalf("owner has %+v once the hold expired, expected 60 releasable", got)
+		}
+
+		result := ledger.Invoke(alice, "Hold", fmt.Sprint(test.amount), carol.Account, fmt.Sprint(ledger.Now+100))
+		if test.allowed != (result.Status == shim.OK) {
+			t.Fatalf("Hold(%d) returned %q, expected it to succeed: %t", test.amount, result.Message, test.allowed)
+		}
+		if !test.allowed {
+			continue
+		}
+		var event holdEvent
+		err := json.Unmarshal(result.Events[0].Payload, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(event.ReleasedHolds) != 1 || event.ReleasedHolds[0] != holdID {
+			t.Fatalf("Hold(%d) emitted %s, expected it to release %s", test.amount, result.Events[0].Payload, holdID)
+		}
+		if got := heldBalance(t, ledger, alice.Account); got.Balance != 100-test.amount || got.Held != test.amount || got.Releasable != 0 {
+			t.Fatalf("owner has %+v after Hold(%d), expected a balance of %d with %d held", got, test.amount, 100-test.amount, test.amount)
+		}
+		var released hold
+		err = json.Unmarshal([]byte(mustInvoke(t, ledger, alice, "GetHold", holdID)), &released)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released.Status != holdExpired {
+			t.Fatalf("expired hold is %s after the new Hold, expected %s", released.Status, holdExpired)
+		}
+	}
+}
+
+// TestHoldsSurviveFreezes freezes `frozen` and checks the documented rule: a hold cannot be
+// captured while either party is frozen, nor released while its owner is; it stays in place and
+// the refused action succeeds once the account is unfrozen
+func TestHoldsSurviveFreezes(t *testing.T) {
+	tests := []struct {
+		name     string
+		frozen   chaintest.Identity
+		caller   chaintest.Identity
+		function string
+		args     []string
+		offset   int64
+		allowed  bool
+	}{
+		{"capture, owner frozen", alice, bob, "CaptureHold", []string{"60"}, 0, false},
+		{"capture, beneficiary frozen", bob, bob, "CaptureHold", []string{"60"}, 0, false},
+		{"capture, other account frozen", carol, bob, "CaptureHold", []string{"60"}, 0, true},
+		{"release, owner frozen", alice, bob, "ReleaseHold", nil, 0, false},
+		{"release, beneficiary frozen", bob, alice, "ReleaseHold", nil, 0, true},
+		{"expired release, owner frozen", alice, carol, "ReleaseHold", nil, 100, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger, holdID := newHoldToken(t)
+			mustInvoke(t, ledger, admin, "FreezeAccount", test.frozen.Account, "case-1")
+			ledger.Now += test.offset
+			args := append([]string{holdID}, test.args...)
+
+			result := ledger.Invoke(test.caller, test.function, args...)
+			if test.allowed != (result.Status == shim.OK) {
+				t.Fatalf("%s returned %q, expected it to succeed: %t", test.function, result.Message, test.allowed)
+			}
+			if test.allowed {
+				return
+			}
+			if !strings.HasPrefix(result.Message, errAccountFrozen) {
+				t.Fatalf("%s failed with %q, expected %s", test.function, result.Message, errAccountFrozen)
+			}
+			if got := heldBalance(t, ledger, alice.Account); got.Held+got.Releasable != 60 {
+				t.Fatalf("owner has %+v after the refused %s, expected the hold in place", got, test.function)
+			}
+
+			mustInvoke(t, ledger, admin, "UnfreezeAccount", test.frozen.Account, "case-1")
+			mustInvoke(t, ledger, test.caller, test.function, args...)
+		})
+	}
+}
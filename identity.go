@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Define key names for identity options
+const identityModeKey = "identityMode"
+
+// Define identity modes
+const identityModeCreator = "creator"
+const identityModeAttribute = "attribute"
+
+// accountIDAttribute is the certificate attribute holding the account ID in attribute mode
+const accountIDAttribute = "accountID"
+
+// identityResolver maps the requesting client to an account and answers questions about its identity
+// Handlers only use it through getClientID and getClientMSP.
+type identityResolver interface {
+	ResolveAccount(APIstub shim.ChaincodeStubInterface) (string, error)
+	ResolveMSP(APIstub shim.ChaincodeStubInterface) (string, error)
+}
+
+// identityResolvers holds the resolver of every identity mode selectable at Initialize
+var identityResolvers = map[string]identityResolver{
+	identityModeCreator:   creatorIdentityResolver{},
+	identityModeAttribute: attributeIdentityResolver{attribute: accountIDAttribute},
+}
+
+// creatorIdentityResolver identifies accounts by the X.509 identity of the transaction creator
+// It is the default. Balances stored under the raw serialized identity by earlier versions are
+// still found, see legacyBalanceKey.
+type creatorIdentityResolver struct{}
+
+// ResolveAccount returns the MSP ID and the X.509 identity of the requesting client, joined by "::"
+// The identity is the base64 subject and issuer of the certificate returned by cid.GetID.
+func (creatorIdentityResolver) ResolveAccount(APIstub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := resolveMSP(APIstub)
+	if err != nil {
+		return "", err
+	}
+	id, err := cid.GetID(APIstub)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get client's certificate")
+	}
+	return mspID + "::" + id, nil
+}
+
+// ResolveMSP returns the MSP ID of the requesting client
+func (creatorIdentityResolver) ResolveMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return resolveMSP(APIstub)
+}
+
+// attributeIdentityResolver identifies accounts by a certificate attribute issued by the CA
+type attributeIdentityResolver struct {
+	attribute string
+}
+
+// ResolveAccount returns the value of the account attribute of the requesting client
+func (r attributeIdentityResolver) ResolveAccount(APIstub shim.ChaincodeStubInterface) (string, error) {
+	account, found, err := cid.GetAttributeValue(APIstub, r.attribute)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get client's %s attribute", r.attribute)
+	}
+	if !found || account == "" {
+		return "", fmt.Errorf("Client certificate has no %s attribute", r.attribute)
+	}
+	return account, nil
+}
+
+// ResolveMSP returns the MSP ID of the requesting client
+func (attributeIdentityResolver) ResolveMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return resolveMSP(APIstub)
+}
+
+// getIdentityResolver returns the resolver of the identity mode chosen at Initialize
+func getIdentityResolver(APIstub shim.ChaincodeStubInterface) (identityResolver, error) {
+	mode, err := getSetting(APIstub, identityModeKey)
+	if err != nil {
+		return nil, err
+	}
+	resolver, ok := identityResolvers[mode]
+	if !ok {
+		return nil, fmt.Errorf("Unknown identity mode %s", mode)
+	}
+	return resolver, nil
+}
+
+// getClientID returns the account ID of the requesting client
+func getClientID(APIstub shim.ChaincodeStubInterface) (string, error) {
+	resolver, err := getIdentityResolver(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return resolver.ResolveAccount(APIstub)
+}
+
+// legacyBalanceKey returns the key the balance of `account` was stored under before creator
+// identities were parsed: the raw serialized identity of the requesting client, when `account` is
+// its account in creator mode. Other accounts have no legacy key and get "".
+func legacyBalanceKey(APIstub shim.ChaincodeStubInterface, account string) (string, error) {
+	resolver, err := getIdentityResolver(APIstub)
+	if err != nil {
+		return "", err
+	}
+	if _, creatorMode := resolver.(creatorIdentityResolver); !creatorMode {
+		return "", nil
+	}
+	clientID, err := resolver.ResolveAccount(APIstub)
+	if err != nil {
+		return "", err
+	}
+	if clientID != account {
+		return "", nil
+	}
+	creator, err := APIstub.GetCreator()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get client's certificate")
+	}
+	return string(creator), nil
+}
+
+// getClientMSP returns the MSP ID of the requesting client
+func getClientMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	resolver, err := getIdentityResolver(APIstub)
+	if err != nil {
+		return "", err
+	}
+	return resolver.ResolveMSP(APIstub)
+}
+
+// resolveMSP returns the MSP ID of the requesting client using the client identity library
+func resolveMSP(APIstub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(APIstub)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get client's MSP ID")
+	}
+	return mspID, nil
+}
+
+// getClientOUs returns the organizational units of the requesting client's certificate
+func getClientOUs(APIstub shim.ChaincodeStubInterface) ([]string, error) {
+	cert, err := cid.GetX509Certificate(APIstub)
+	if err != nil || cert == nil {
+		return nil, fmt.Errorf("Failed to get client's certificate")
+	}
+	return cert.Subject.OrganizationalUnit, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+func TestLegacyCreatorBalance(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, bob.Account, 100)
+	// Before creators were parsed, balances were stored under the raw serialized identity
+	legacyKey := string(alice.Creator)
+	ledger.SetState(legacyKey, []byte("50"))
+
+	if got := mustInvoke(t, ledger, alice, "ClientAccountBalance"); got != "50" {
+		t.Fatalf("balance under the legacy key is %s, expected 50", got)
+	}
+	mustInvoke(t, ledger, alice, "Transfer", carol.Account, "20")
+	if got := balanceOf(t, ledger, alice.Account); got != 30 {
+		t.Fatalf("balance is %d after spending from the legacy key, expected 30", got)
+	}
+	if ledger.State(legacyKey) != nil {
+		t.Fatalf("legacy balance is still stored as %q after the transfer", ledger.State(legacyKey))
+	}
+}
+
+func TestLegacyCreatorBalanceCreditedUnderNewKey(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, bob.Account, 100)
+	legacyKey := string(alice.Creator)
+	ledger.SetState(legacyKey, []byte("50"))
+
+	// bob cannot see alice's legacy key, so his transfer creates her prefixed balance
+	mustInvoke(t, ledger, bob, "Transfer", alice.Account, "20")
+	if got := mustInvoke(t, ledger, alice, "ClientAccountBalance"); got != "70" {
+		t.Fatalf("balance with parts under both keys is %s, expected 70", got)
+	}
+
+	mustInvoke(t, ledger, alice, "Transfer", carol.Account, "60")
+	if got := balanceOf(t, ledger, alice.Account); got != 10 {
+		t.Fatalf("balance is %d after spending both parts, expected 10", got)
+	}
+	if ledger.State(legacyKey) != nil {
+		t.Fatalf("legacy balance is still stored as %q after the transfer", ledger.State(legacyKey))
+	}
+}
+
+// impersonation is a client posing as the victim of a token in one identity mode
+type impersonation struct {
+	name     string
+	mode     identityModeClients
+	attacker chaintest.Identity
+}
+
+// identityModeClients are the clients of a token in one identity mode, with Account set to their
+// account in that mode
+type identityModeClients struct {
+	options string
+	admin   chaintest.Identity
+	victim  chaintest.Identity
+	spender chaintest.Identity
+}
+
+// impersonations returns the ways a client can pose as alice
+// A certificate naming alice under another MSP is a different account, an accountID attribute
+// counts only in attribute mode, and accounts passed as arguments are never taken as the caller.
+func impersonations() []impersonation {
+	withAccountID := func(account string) chaintest.IdentityOption {
+		return chaintest.WithAttrs(map[string]string{accountIDAttribute: account})
+	}
+	creatorMode := identityModeClients{admin: admin, victim: alice, spender: carol}
+	attributeMode := identityModeClients{
+		options: `{"identityMode":"attribute"}`,
+		admin:   chaintest.NewIdentity("Org1MSP", "admin", withAccountID("acct-admin")),
+		victim:  chaintest.NewIdentity("Org1MSP", "alice", withAccountID("acct-alice")),
+		spender: chaintest.NewIdentity("Org2MSP", "carol", withAccountID("acct-carol")),
+	}
+	attributeMode.victim.Account = "acct-alice"
+	attributeMode.spender.Account = "acct-carol"
+
+	return []impersonation{
+		{"argument as caller", creatorMode, bob},
+		{"same subject under another MSP", creatorMode, chaintest.NewIdentity("Org2MSP", "alice", func(cert *x509.Certificate) {
+			cert.Subject.Organization = []string{alice.MSPID}
+		})},
+		{"accountID attribute in creator mode", creatorMode, chaintest.NewIdentity("Org2MSP", "mallory", withAccountID(alice.Account))},
+		{"other account in attribute mode", attributeMode, chaintest.NewIdentity("Org2MSP", "bob", withAccountID("acct-bob"))},
+		{"other attributes in attribute mode", attributeMode, chaintest.NewIdentity("Org1MSP", "acct-alice",
+			chaintest.WithAttrs(map[string]string{"hf.EnrollmentID": "acct-alice", "account": "acct-alice"}))},
+	}
+}
+
+// newImpersonationToken returns a token in the identity mode of `mode` where the victim holds 100
+func newImpersonationToken(t *testing.T, mode identityModeClients) *chaintest.Ledger {
+	t.Helper()
+	ledger := chaintest.NewLedger(new(SmartContract))
+	args := []string{"Token", "TKN", "0", "0"}
+	if mode.options != "" {
+		args = append(args, mode.options)
+	}
+	mustInvoke(t, ledger, mode.admin, "Initialize", args...)
+	mustInvoke(t, ledger, mode.admin, "Mint", mode.victim.Account, "100")
+	return ledger
+}
+
+func TestNoImpersonation(t *testing.T) {
+	for _, test := range impersonations() {
+		t.Run(test.name, func(t *testing.T) {
+			mode, attacker := test.mode, test.attacker
+			victim, spender := mode.victim.Account, mode.spender.Account
+			ledger := newImpersonationToken(t, mode)
+			if result := ledger.Invoke(attacker, "ClientAccountBalance"); string(result.Payload) == "100" {
+				t.Fatalf("the attacker sees the victim's balance")
+			}
+
+			for _, call := range [][]string{
+				{"Transfer", victim, "10"},
+				{"Transfer", spender, "10"},
+				{"Transfer", victim, spender, "10"},
+				{"TransferFrom", victim, spender, "10"},
+				{"Burn", "10"},
+				{"Burn", victim, "10"},
+			} {
+				ledger.Invoke(attacker, call[0], call[1:]...)
+			}
+			if got := mustInvoke(t, ledger, mode.admin, "BalanceOf", victim); got != "100" {
+				t.Fatalf("victim balance is %s after the attacker's calls, expected 100", got)
+			}
+			// The victim itself still can
+			mustInvoke(t, ledger, mode.victim, "Transfer", spender, "10")
+		})
+	}
+}
+
+func TestNoApprovalImpersonation(t *testing.T) {
+	for _, test := range impersonations() {
+		t.Run(test.name, func(t *testing.T) {
+			mode, attacker := test.mode, test.attacker
+			victim, spender := mode.victim.Account, mode.spender.Account
+			ledger := newImpersonationToken(t, mode)
+
+			// An allowance the attacker approves is on its own account, never the victim's
+			for _, args := range [][]string{{spender, "50"}, {victim, spender, "50"}} {
+				result := ledger.Invoke(attacker, "Approve", args...)
+				for _, event := range result.Events {
+					if strings.Contains(string(event.Payload), `"owner":"`+victim+`"`) {
+						t.Fatalf("Approve%q by the attacker emitted %s naming the victim as owner", args, event.Payload)
+					}
+				}
+			}
+			if got := mustInvoke(t, ledger, mode.admin, "Allowance", victim, spender); got != "0" {
+				t.Fatalf("allowance from the victim is %s after the attacker's approvals, expected 0", got)
+			}
+			message := mustFail(t, ledger, mode.spender, "TransferFrom", victim, spender, "10")
+			if !strings.HasPrefix(message, errAllowanceExceeded) {
+				t.Fatalf("TransferFrom of the victim's tokens failed with %q, expected %s", message, errAllowanceExceeded)
+			}
+
+			// The victim's own approval names her as owner
+			result := ledger.Invoke(mode.victim, "Approve", spender, "50")
+			if len(result.Events) == 0 || !strings.Contains(string(result.Events[len(result.Events)-1].Payload), `"owner":"`+victim+`"`) {
+				t.Fatalf("Approve by the victim returned %q with events %v, expected an Approval naming her as owner", result.Message, result.Events)
+			}
+			mustInvoke(t, ledger, mode.spender, "TransferFrom", victim, spender, "10")
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// idLength is the number of hex characters in a generated ID,
+// short enough to fit comfortably in a QR code
+const idLength = 16
+
+// txStub wraps the stub for the duration of one Invoke to carry per-transaction state
+type txStub struct {
+	shim.ChaincodeStubInterface
+	idSequence int
+	// supplyAlarm is set when this transaction's mint trips the supply alarm
+	supplyAlarm *supplyAlarmEvent
+	// codedErrors are the errors created with newCodedError, see withErrorPayload
+	codedErrors []*codedError
+	// keySchema holds the SetKeySchema overrides once read, see resolveObjectType
+	keySchema map[string]string
+	// accountShards holds the account counter shards written by this transaction, see registerAccount
+	accountShards map[string]int
+	// newAccounts is the number of accounts registered by this transaction
+	newAccounts int
+}
+
+// newDeterministicID returns a new ID for an object stored under `objectType`
+// IDs are derived from the txID and a per-transaction sequence number, so every endorser
+// generates the same IDs and several objects created in one transaction get distinct IDs.
+// An ID that is already in use is reported as an error rather than overwritten.
+func newDeterministicID(APIstub shim.ChaincodeStubInterface, objectType string) (string, error) {
+	tx, ok := APIstub.(*txStub)
+	if !ok {
+		return "", fmt.Errorf("IDs can only be generated within a transaction")
+	}
+	sequence := tx.idSequence
+	tx.idSequence++
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", tx.GetTxID(), objectType, sequence)))
+	id := hex.EncodeToString(hash[:])[:idLength]
+
+	err := checkIDUnused(APIstub, objectType, id)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// checkIDUnused returns an error if an object is already stored under `objectType` with `id`
+func checkIDUnused(APIstub shim.ChaincodeStubInterface, objectType string, id string) error {
+	objectKey, err := buildKey(APIstub, objectType, []string{id})
+	if err != nil {
+		return err
+	}
+	existing, err := APIstub.GetState(objectKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", objectType, err)
+	}
+	if existing != nil {
+		return fmt.Errorf("%s ID %s already in use", objectType, id)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define keys for the genesis balance import
+const activatedKey = "activated"
+const balanceImportKey = "balanceImport"
+
+// Define balance import statuses
+const importInProgress = "inProgress"
+const importComplete = "complete"
+const importFailed = "failed"
+
+// maxImportChunkSize bounds the number of entries in a single ImportBalances chunk
+const maxImportChunkSize = 500
+
+// importEntry is one balance of a legacy snapshot, in raw units
+type importEntry struct {
+	Account string `json:"account"`
+	Amount  int    `json:"amount"`
+}
+
+// balanceImport is the progress of a genesis balance import
+// RunningHash is the hex SHA-256 chain over the chunks imported so far, see ImportBalances.
+type balanceImport struct {
+	TotalChunks  int    `json:"totalChunks"`
+	NextChunk    int    `json:"nextChunk"`
+	SnapshotHash string `json:"snapshotHash"`
+	RunningHash  string `json:"runningHash"`
+	Imported     int    `json:"imported"`
+	Status       string `json:"status"`
+}
+
+// balanceImportEvent provides an organized struct for emitting balance import events
+type balanceImportEvent struct {
+	Token string `json:"token"`
+	balanceImport
+}
+
+// ImportBalances credits one chunk of a legacy balance snapshot; only admins can import
+// `chunkJSON` is a JSON array of {"account","amount"} objects with amounts in raw units. Chunks must
+// be sent in order, starting at 0, and are credited in array order. The running hash is
+// sha256(chunk 0), then sha256(previous hash bytes + chunk i) over the exact chunkJSON bytes.
+// On the last chunk it must equal `snapshotHash`, otherwise the last chunk is not credited and the
+// import is marked failed for good. The total supply follows the credits: the first chunk sets it
+// to its sum and every further chunk adds its own, so balances add up to the supply between chunks.
+// Importing is only possible until the token is activated by FinalizeImport or its first movement,
+// and no movement can activate it while an import is in progress, see markActivated.
+// This function triggers a BalancesImported event, or an ImportFailed event on a hash mismatch
+func (s *SmartContract) ImportBalances(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	var entries []importEntry
+	err := json.Unmarshal([]byte(args[0]), &entries)
+	if err != nil || len(entries) == 0 {
+		return shim.Error("Invalid chunk. Expecting a non-empty JSON array of account balances")
+	}
+	if len(entries) > maxImportChunkSize {
+		return shim.Error(fmt.Sprintf("Chunk too large. Expecting at most %d entries", maxImportChunkSize))
+	}
+	chunkIndex, err := strconv.Atoi(args[1])
+	if err != nil || chunkIndex < 0 {
+		return shim.Error("Invalid chunk index. Expecting a non-negative number")
+	}
+	totalChunks, err := strconv.Atoi(args[2])
+	if err != nil || totalChunks <= chunkIndex {
+		return shim.Error("Invalid total chunks. Expecting a number greater than the chunk index")
+	}
+	snapshotHash := strings.ToLower(args[3])
+	if snapshotHash == "" {
+		return shim.Error("Snapshot hash must be a non-empty string")
+	}
+
+	// Credit each account once, even if it appears several times in the chunk
+	var accounts []string
+	amounts := map[string]int{}
+	chunkSum := 0
+	for _, entry := range entries {
+		if entry.Account == "" || entry.Amount <= 0 {
+			return shim.Error("Every entry needs an account and a positive amount")
+		}
+		if _, seen := amounts[entry.Account]; !seen {
+			accounts = append(accounts, entry.Account)
+		}
+		amounts[entry.Account] += entry.Amount
+		chunkSum += entry.Amount
+	}
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkNotActivated(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	progress, err := getBalanceImport(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if progress == nil {
+		progress = &balanceImport{TotalChunks: totalChunks, SnapshotHash: snapshotHash, Status: importInProgress}
+	}
+	if progress.Status != importInProgress {
+		return shim.Error(fmt.Sprintf("Balance import is %s", progress.Status))
+	}
+	if chunkIndex != progress.NextChunk {
+		return shim.Error(fmt.Sprintf("Out of order chunk. Expecting chunk %d", progress.NextChunk))
+	}
+	if totalChunks != progress.TotalChunks || snapshotHash != progress.SnapshotHash {
+		return shim.Error("Total chunks and snapshot hash must match the first chunk")
+	}
+
+	previousHash, _ := hex.DecodeString(progress.RunningHash)
+	runningHash := sha256.Sum256(append(previousHash, []byte(args[0])...))
+	progress.RunningHash = hex.EncodeToString(runningHash[:])
+	progress.NextChunk++
+	lastChunk := progress.NextChunk == progress.TotalChunks
+
+	eventName := "BalancesImported"
+	if lastChunk && progress.RunningHash != progress.SnapshotHash {
+		progress.Status = importFailed
+		eventName = "ImportFailed"
+	} else {
+		maxSupply, err := getMaxSupply(APIstub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if maxSupply > 0 && progress.Imported+chunkSum > maxSupply {
+			return shim.Error(fmt.Sprintf("Importing %d would exceed the supply cap of %d", progress.Imported+chunkSum, maxSupply))
+		}
+		for _, account := range accounts {
+			err = creditBalance(APIstub, account, amounts[account])
+			if err != nil {
+				return shim.Error(notCommitted(err).Error())
+			}
+		}
+		// The imported sum replaces the supply set by Initialize, which no balance backs
+		delta := chunkSum
+		if progress.Imported == 0 {
+			totalSupply, err := getTotalSupply(APIstub)
+			if err != nil {
+				return shim.Error(notCommitted(err).Error())
+			}
+			delta -= totalSupply
+		}
+		err = adjustSupply(APIstub, delta, supplyReasonImport)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		progress.Imported += chunkSum
+		if lastChunk {
+			progress.Status = importComplete
+		}
+	}
+
+	err = putBalanceImport(APIstub, *progress)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventBytes, err := json.Marshal(balanceImportEvent{Token: symbol, balanceImport: *progress})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent(eventName, eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(eventBytes)
+}
+
+// FinalizeImport activates the token, closing the import window; only admins can finalize
+// A token without an import can be finalized too. An unfinished or failed import cannot.
+// This function triggers a TokenActivated event
+func (s *SmartContract) FinalizeImport(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	err := checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkNotActivated(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	progress, err := getBalanceImport(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if progress != nil && progress.Status != importComplete {
+		return shim.Error(fmt.Sprintf("Balance import is %s", progress.Status))
+	}
+
+	err = APIstub.PutState(activatedKey, []byte("true"))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", activatedKey, err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var eventData balanceImportEvent
+	eventData.Token = symbol
+	if progress != nil {
+		eventData.balanceImport = *progress
+	}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.SetEvent("TokenActivated", eventBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkNotActivated returns an error once the token has been activated
+func checkNotActivated(APIstub shim.ChaincodeStubInterface) error {
+	activatedBytes, err := APIstub.GetState(activatedKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", activatedKey, err)
+	}
+	if activatedBytes != nil {
+		return fmt.Errorf("ERR_TOKEN_ACTIVATED: balances can only be imported before the token is activated")
+	}
+	return nil
+}
+
+// markActivated activates the token on its first balance movement
+// recordMovements calls it, so it runs at most once per transaction. A movement while an import is
+// in progress fails instead: it would close the import window with only some chunks credited.
+func markActivated(APIstub shim.ChaincodeStubInterface) error {
+	activatedBytes, err := APIstub.GetState(activatedKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", activatedKey, err)
+	}
+	if activatedBytes != nil {
+		return nil
+	}
+	progress, err := getBalanceImport(APIstub)
+	if err != nil {
+		return err
+	}
+	if progress != nil && progress.Status == importInProgress {
+		return fmt.Errorf("ERR_IMPORT_IN_PROGRESS: tokens cannot move until the balance import of %d chunks completes", progress.TotalChunks)
+	}
+	err = APIstub.PutState(activatedKey, []byte("true"))
+	if err != nil {
+		return stateError(APIstub, "PutState", activatedKey, err)
+	}
+	return nil
+}
+
+// getBalanceImport returns the progress of the balance import, or nil if none was started
+func getBalanceImport(APIstub shim.ChaincodeStubInterface) (*balanceImport, error) {
+	progressBytes, err := APIstub.GetState(balanceImportKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", balanceImportKey, err)
+	}
+	if progressBytes == nil {
+		return nil, nil
+	}
+
+	var progress balanceImport
+	err = json.Unmarshal(progressBytes, &progress)
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// putBalanceImport stores the progress of the balance import
+func putBalanceImport(APIstub shim.ChaincodeStubInterface, progress balanceImport) error {
+	progressBytes, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(balanceImportKey, progressBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", balanceImportKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// snapshotHash returns the running hash ImportBalances expects after `chunks`
+func snapshotHash(chunks ...string) string {
+	var hash []byte
+	for _, chunk := range chunks {
+		sum := sha256.Sum256(append(hash, chunk...))
+		hash = sum[:]
+	}
+	return hex.EncodeToString(hash)
+}
+
+var importChunks = []string{
+	`[{"account":"` + alice.Account + `","amount":70}]`,
+	`[{"account":"` + bob.Account + `","amount":20},{"account":"` + alice.Account + `","amount":5}]`,
+	`[{"account":"` + carol.Account + `","amount":5}]`,
+}
+
+func TestImportBalances(t *testing.T) {
+	ledger := newToken(t, "")
+	hash := snapshotHash(importChunks...)
+
+	for i, chunk := range importChunks {
+		mustInvoke(t, ledger, admin, "ImportBalances", chunk, strconv.Itoa(i), "3", hash)
+		// Balances add up to the supply between chunks
+		sum := balanceOf(t, ledger, alice.Account) + balanceOf(t, ledger, bob.Account) + balanceOf(t, ledger, carol.Account)
+		if got := mustInvoke(t, ledger, admin, "TotalSupply"); got != strconv.Itoa(sum) {
+			t.Fatalf("total supply is %s after chunk %d, expected the imported sum %d", got, i, sum)
+		}
+	}
+	if got := balanceOf(t, ledger, alice.Account); got != 75 {
+		t.Fatalf("imported balance is %d, expected 75", got)
+	}
+	mustInvoke(t, ledger, admin, "FinalizeImport")
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+}
+
+func TestImportBalancesHashMismatch(t *testing.T) {
+	ledger := newToken(t, "")
+	hash := snapshotHash(importChunks[0], importChunks[1], `[]`)
+
+	mustInvoke(t, ledger, admin, "ImportBalances", importChunks[0], "0", "3", hash)
+	mustInvoke(t, ledger, admin, "ImportBalances", importChunks[1], "1", "3", hash)
+	result := ledger.Invoke(admin, "ImportBalances", importChunks[2], "2", "3", hash)
+	if !strings.Contains(string(result.Payload), `"status":"failed"`) || len(result.Events) != 1 || result.Events[0].Name != "ImportFailed" {
+		t.Fatalf("import with a mismatching hash returned %q %q, expected a failed import", result.Payload, result.Message)
+	}
+
+	// The last chunk is not credited, and the supply still covers the earlier ones
+	if got := balanceOf(t, ledger, carol.Account); got != 0 {
+		t.Fatalf("balance from the mismatching chunk is %d, expected 0", got)
+	}
+	if got := mustInvoke(t, ledger, admin, "TotalSupply"); got != "95" {
+		t.Fatalf("total supply is %s after a failed import, expected the credited 95", got)
+	}
+	message := mustFail(t, ledger, admin, "ImportBalances", importChunks[2], "2", "3", hash)
+	if !strings.Contains(message, "failed") {
+		t.Fatalf("import after a failure failed with %q, expected the import to be failed", message)
+	}
+	mustFail(t, ledger, admin, "FinalizeImport")
+}
+
+func TestImportBalancesOutOfOrder(t *testing.T) {
+	ledger := newToken(t, "")
+	hash := snapshotHash(importChunks...)
+
+	message := mustFail(t, ledger, admin, "ImportBalances", importChunks[1], "1", "3", hash)
+	if !strings.Contains(message, "Expecting chunk 0") {
+		t.Fatalf("import of chunk 1 first failed with %q, expected an out of order chunk", message)
+	}
+	mustInvoke(t, ledger, admin, "ImportBalances", importChunks[0], "0", "3", hash)
+	mustFail(t, ledger, admin, "ImportBalances", importChunks[0], "0", "3", hash)
+	mustFail(t, ledger, admin, "ImportBalances", importChunks[2], "2", "3", hash)
+	mustFail(t, ledger, admin, "ImportBalances", importChunks[1], "1", "2", hash)
+	mustFail(t, ledger, alice, "ImportBalances", importChunks[1], "1", "3", hash)
+	if got := mustInvoke(t, ledger, admin, "TotalSupply"); got != "70" {
+		t.Fatalf("total supply is %s after refused chunks, expected 70", got)
+	}
+}
+
+func TestImportBalancesBlocksMovements(t *testing.T) {
+	ledger := newToken(t, "")
+	hash := snapshotHash(importChunks...)
+	mustInvoke(t, ledger, admin, "ImportBalances", importChunks[0], "0", "3", hash)
+
+	// A movement between chunks would activate the token with the import half done
+	for _, call := range [][]string{{"Transfer", bob.Account, "10"}, {"Burn", "10"}} {
+		message := mustFail(t, ledger, alice, call[0], call[1:]...)
+		if !strings.HasPrefix(message, "ERR_IMPORT_IN_PROGRESS") {
+			t.Fatalf("%s during an import failed with %q, expected ERR_IMPORT_IN_PROGRESS", call[0], message)
+		}
+	}
+	message := mustFail(t, ledger, admin, "Mint", bob.Account, "10")
+	if !strings.HasPrefix(message, "ERR_IMPORT_IN_PROGRESS") {
+		t.Fatalf("Mint during an import failed with %q, expected ERR_IMPORT_IN_PROGRESS", message)
+	}
+	mustFail(t, ledger, admin, "FinalizeImport")
+
+	mustInvoke(t, ledger, admin, "ImportBalances", importChunks[1], "1", "3", hash)
+	mustInvoke(t, ledger, admin, "ImportBalances", importChunks[2], "2", "3", hash)
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+}
+
+func TestImportBalancesAfterActivation(t *testing.T) {
+	for name, activate := range map[string][]string{
+		"FinalizeImport": {"FinalizeImport"},
+		"Mint":           {"Mint", alice.Account, "10"},
+	} {
+		ledger := newToken(t, "")
+		mustInvoke(t, ledger, admin, activate[0], activate[1:]...)
+
+		hash := snapshotHash(importChunks[0])
+		message := mustFail(t, ledger, admin, "ImportBalances", importChunks[0], "0", "1", hash)
+		if !strings.HasPrefix(message, "ERR_TOKEN_ACTIVATED") {
+			t.Fatalf("import after %s failed with %q, expected ERR_TOKEN_ACTIVATED", name, message)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// initializeArgs are the arguments every Initialize of this file runs with
+var initializeArgs = []string{"Token", "TKN", "100", "2"}
+
+// TestInitializeWriteFailures fails each write of Initialize in turn. The failure must name the
+// write and say nothing was committed, and the token must stay uninitialized. A peer that had kept
+// the writes before the failure would be left with a partial token; CheckInitialized must then
+// report exactly the metadata keys it lacks.
+func TestInitializeWriteFailures(t *testing.T) {
+	baseline := chaintest.NewLedger(new(SmartContract)).Invoke(admin, "Initialize", initializeArgs...)
+	if baseline.Status != shim.OK {
+		t.Fatalf("Initialize: %s", baseline.Message)
+	}
+
+	for position := 1; position <= len(baseline.Writes); position++ {
+		ledger := chaintest.NewLedger(new(SmartContract))
+		result := ledger.Submit(chaintest.Tx{Caller: admin, Function: "Initialize", Args: initializeArgs, FailWrite: position})
+
+		if result.Status == shim.OK {
+			t.Fatalf("Initialize succeeded with write %d failing", position)
+		}
+		for _, part := range []string{"ERR_STATE", "failed in Initialize", chaintest.ErrInjectedWrite.Error(), "no changes were committed"} {
+			if !strings.Contains(result.Message, part) {
+				t.Fatalf("Initialize with write %d failing failed with %q, expected it to mention %q", position, result.Message, part)
+			}
+		}
+		if keys := ledger.Keys(); len(keys) != 0 {
+			t.Fatalf("Initialize with write %d failing committed %q", position, keys)
+		}
+		message := mustFail(t, ledger, admin, "CheckInitialized")
+		if !strings.HasPrefix(message, errNotInitialized) {
+			t.Fatalf("CheckInitialized after a failed Initialize failed with %q, expected %s", message, errNotInitialized)
+		}
+
+		// Replay the writes made before the failure, as a faulty peer would have kept them
+		written := map[string]bool{}
+		partial := ledger.Fork()
+		for _, write := range result.Writes {
+			partial.SetState(write.Key, write.Value)
+			written[write.Key] = true
+		}
+		var missing []string
+		for _, key := range []string{nameKey, symbolKey, decimalsKey, totalSupplyKey} {
+			if !written[key] {
+				missing = append(missing, key)
+			}
+		}
+		check := partial.Invoke(admin, "CheckInitialized")
+		switch {
+		case written[initializedKey]:
+			if check.Status != shim.OK {
+				t.Fatalf("CheckInitialized with the initialized flag written failed with %q", check.Message)
+			}
+		case len(missing) == 4:
+			if !strings.HasPrefix(check.Message, errNotInitialized) {
+				t.Fatalf("CheckInitialized without metadata returned %q, expected %s", check.Message, errNotInitialized)
+			}
+		default:
+			expected := "Token metadata incomplete, missing keys: " + strings.Join(missing, ", ")
+			if check.Message != expected {
+				t.Fatalf("CheckInitialized after writes %d of %d returned %q, expected %q", position-1, len(baseline.Writes), check.Message, expected)
+			}
+		}
+
+		// The failed Initialize left nothing behind, so it can simply be retried
+		mustInvoke(t, ledger, admin, "Initialize", initializeArgs...)
+	}
+}
+
+func TestCheckInitializedReportsMissingKeys(t *testing.T) {
+	tests := []struct {
+		present  []string
+		expected string
+	}{
+		{nil, errNotInitialized + ": contract not initialized"},
+		{[]string{nameKey}, "Token metadata incomplete, missing keys: symbol, decimals, totalSupply"},
+		{[]string{nameKey, decimalsKey}, "Token metadata incomplete, missing keys: symbol, totalSupply"},
+		{[]string{nameKey, symbolKey, decimalsKey}, "Token metadata incomplete, missing keys: totalSupply"},
+		{[]string{nameKey, symbolKey, decimalsKey, totalSupplyKey}, ""},
+	}
+	for _, test := range tests {
+		ledger := chaintest.NewLedger(new(SmartContract))
+		for _, key := range test.present {
+			ledger.SetState(key, []byte("0"))
+		}
+		result := ledger.Invoke(admin, "CheckInitialized")
+		if test.expected == "" {
+			if result.Status != shim.OK {
+				t.Fatalf("CheckInitialized with %q failed with %q", test.present, result.Message)
+			}
+			continue
+		}
+		if result.Message != test.expected {
+			t.Fatalf("CheckInitialized with %q returned %q, expected %q", test.present, result.Message, test.expected)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package chaintest
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// EncodeArgs packs arguments as length-prefixed strings, the form a fuzz target decodes with DecodeArgs
+func EncodeArgs(args ...string) []byte {
+	var encoded []byte
+	for _, arg := range args {
+		encoded = binary.AppendUvarint(encoded, uint64(len(arg)))
+		encoded = append(encoded, arg...)
+	}
+	return encoded
+}
+
+// DecodeArgs unpacks arguments packed by EncodeArgs; any input decodes to something
+func DecodeArgs(encoded []byte) []string {
+	var args []string
+	for len(encoded) > 0 {
+		length, n := binary.Uvarint(encoded)
+		if n <= 0 {
+			return append(args, string(encoded))
+		}
+		encoded = encoded[n:]
+		if length > uint64(len(encoded)) {
+			length = uint64(len(encoded))
+		}
+		args = append(args, string(encoded[:length]))
+		encoded = encoded[length:]
+	}
+	return args
+}
+
+// CheckInvokeResult fails `t` unless `result` holds for any input to Invoke: no panic escaped
+// as ERR_PANIC, a failed transaction made no write and set no event, and the response is a
+// well-formed peer.Response
+func CheckInvokeResult(t *testing.T, function string, args []string, result Result) {
+	t.Helper()
+	switch {
+	case result.Status == shim.OK:
+	case result.Status == shim.ERROR:
+		if result.Message == "" {
+			t.Errorf("%q%q: error response without a message", function, args)
+		}
+		if strings.HasPrefix(result.Message, "ERR_PANIC") {
+			t.Errorf("%q%q: %s", function, args, result.Message)
+		}
+		if len(result.Writes) != 0 || len(result.Events) != 0 {
+			t.Errorf("%q%q failed with %q after %d writes and %d events", function, args, result.Message, len(result.Writes), len(result.Events))
+		}
+	default:
+		t.Errorf("%q%q: unexpected status %d", function, args, result.Status)
+	}
+
+	encoded, err := proto.Marshal(&result.Response)
+	if err != nil {
+		t.Fatalf("%q%q: response does not marshal: %s", function, args, err)
+	}
+	var decoded peer.Response
+	err = proto.Unmarshal(encoded, &decoded)
+	if err != nil || !proto.Equal(&decoded, &result.Response) {
+		t.Errorf("%q%q: response does not round-trip: %v", function, args, err)
+	}
+}
+
+// NastyArgs are argument lists that every function of a fuzz target is seeded with
+// They are huge, empty, NUL-laden, non-UTF-8 or almost-numbers; targets add their own account IDs.
+var NastyArgs = [][]string{
+	{},
+	{"10"},
+	{"0"},
+	{"-1"},
+	{"9223372036854775807"},
+	{"9223372036854775808"},
+	{"1e3", "0x10", " 7", "+3"},
+	{"{}"},
+	{"[]"},
+	{`{"a":` + strings.Repeat("[", 10000) + `}`},
+	{"true"},
+	{"\x00", "\x00\x00"},
+	{"\xff\xfe\xfd", "\xc3\x28"},
+	{strings.Repeat("9", 1<<20)},
+	{strings.Repeat("a", 70000), "1"},
+}
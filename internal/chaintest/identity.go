@@ -0,0 +1,95 @@
+package chaintest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/attrmgr"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/protos/msp"
+)
+
+// Identity is a client enrolled with an MSP
+type Identity struct {
+	MSPID string
+	// Creator is the serialized identity a peer hands to the chaincode
+	Creator []byte
+	// Account is the client's account in the default identity mode: the MSP ID and the
+	// cid.GetID identity joined by "::"
+	Account string
+}
+
+// IdentityOption configures the certificate of a new identity
+type IdentityOption func(*x509.Certificate)
+
+// WithAttrs adds Fabric CA attributes to the certificate
+func WithAttrs(attrs map[string]string) IdentityOption {
+	return func(cert *x509.Certificate) {
+		value, err := json.Marshal(attrmgr.Attributes{Attrs: attrs})
+		if err != nil {
+			panic(err)
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{Id: attrmgr.AttrOID, Value: value})
+	}
+}
+
+// WithOUs sets the organizational units of the certificate's subject
+func WithOUs(ous ...string) IdentityOption {
+	return func(cert *x509.Certificate) {
+		cert.Subject.OrganizationalUnit = ous
+	}
+}
+
+var (
+	signerOnce sync.Once
+	signer     *ecdsa.PrivateKey
+)
+
+// NewIdentity returns the identity of client `name` of `mspID`
+// The certificate is self-signed by a key shared by every test identity.
+func NewIdentity(mspID string, name string, options ...IdentityOption) Identity {
+	signerOnce.Do(func() {
+		var err error
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{mspID}},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, option := range options {
+		option(template)
+	}
+	template.Issuer = template.Subject
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &signer.PublicKey, signer)
+	if err != nil {
+		panic(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	id, err := cid.GetID(&Stub{creator: creator})
+	if err != nil {
+		panic(err)
+	}
+	return Identity{MSPID: mspID, Creator: creator, Account: mspID + "::" + id}
+}
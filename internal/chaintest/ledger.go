@@ -0,0 +1,162 @@
+package chaintest
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// StartTime is the transaction timestamp of a new Ledger, in seconds since the epoch
+const StartTime = 1700000000
+
+// Ledger is the world state of one chaincode and the peer that runs transactions against it
+// Transactions run one at a time. Only successful ones change the state, as only they would be
+// committed.
+type Ledger struct {
+	stub *Stub
+	cc   shim.Chaincode
+	// Now is the timestamp, in seconds, of the next transaction unless it sets its own
+	Now int64
+	txs int
+}
+
+// Tx is a transaction proposal
+type Tx struct {
+	// ID defaults to a txID unique within the ledger
+	ID        string
+	Caller    Identity
+	Function  string
+	Args      []string
+	Transient map[string][]byte
+	// Timestamp defaults to the ledger's Now
+	Timestamp int64
+	// FailWrite makes the write at this position, counting from 1, fail with ErrInjectedWrite
+	// Writes are PutState, DelState and PutPrivateData calls; 0 fails none.
+	FailWrite int
+}
+
+// Result is the outcome of a transaction
+type Result struct {
+	peer.Response
+	// Reads holds every read in call order
+	Reads []Read
+	// Writes holds every write in call order, even of a failed transaction
+	Writes []Write
+	// Events holds every SetEvent call; a peer only keeps the last one
+	Events []Event
+}
+
+// NewLedger returns an empty ledger for `cc`
+func NewLedger(cc shim.Chaincode) *Ledger {
+	stub := &Stub{MockStub: shim.NewMockStub("token", cc), history: map[string][]*queryresult.KeyModification{}}
+	return &Ledger{stub: stub, cc: cc, Now: StartTime}
+}
+
+// Invoke submits `function` with `args` as `caller`
+func (l *Ledger) Invoke(caller Identity, function string, args ...string) Result {
+	return l.Submit(Tx{Caller: caller, Function: function, Args: args})
+}
+
+// Submit runs `tx` and commits its writes if the chaincode returns success
+func (l *Ledger) Submit(tx Tx) Result {
+	l.txs++
+	if tx.ID == "" {
+		tx.ID = fmt.Sprintf("tx%06d", l.txs)
+	}
+	if tx.Timestamp == 0 {
+		tx.Timestamp = l.Now
+	}
+
+	s := l.stub
+	s.creator = tx.Caller.Creator
+	s.args = [][]byte{[]byte(tx.Function)}
+	for _, arg := range tx.Args {
+		s.args = append(s.args, []byte(arg))
+	}
+	s.transient = tx.Transient
+	s.reads = nil
+	s.writes = nil
+	s.events = nil
+	s.failWrite = tx.FailWrite
+
+	s.MockTransactionStart(tx.ID)
+	s.TxTimestamp = &timestamp.Timestamp{Seconds: tx.Timestamp}
+	response := l.cc.Invoke(s)
+	result := Result{Response: response, Reads: s.reads, Writes: s.writes, Events: s.events}
+	if response.Status < shim.ERRORTHRESHOLD {
+		l.commit(tx.ID, tx.Timestamp, s.writes)
+	}
+	s.MockTransactionEnd(tx.ID)
+	return result
+}
+
+// commit applies the write set of transaction `txID` to the state and records it in the key history
+func (l *Ledger) commit(txID string, txTimestamp int64, writes []Write) {
+	for _, write := range writes {
+		switch {
+		case write.Collection != "":
+			l.stub.MockStub.PutPrivateData(write.Collection, write.Key, write.Value)
+			continue
+		case write.Value == nil:
+			l.stub.MockStub.DelState(write.Key)
+		default:
+			l.stub.MockStub.PutState(write.Key, write.Value)
+		}
+		l.stub.history[write.Key] = append(l.stub.history[write.Key], &queryresult.KeyModification{
+			TxId:      txID,
+			Value:     write.Value,
+			Timestamp: &timestamp.Timestamp{Seconds: txTimestamp},
+			IsDelete:  write.Value == nil,
+		})
+	}
+}
+
+// State returns the committed value of `key`, nil if it does not exist
+func (l *Ledger) State(key string) []byte {
+	return l.stub.State[key]
+}
+
+// Keys returns every committed key in key order
+func (l *Ledger) Keys() []string {
+	keys := make([]string, 0, l.stub.Keys.Len())
+	for element := l.stub.Keys.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(string))
+	}
+	return keys
+}
+
+// SetState writes `value` under `key` outside of any transaction, e.g. to seed state written by
+// an earlier chaincode version; a nil value deletes the key
+func (l *Ledger) SetState(key string, value []byte) {
+	l.stub.MockTransactionStart("setup")
+	l.commit("setup", l.Now, []Write{{Key: key, Value: value}})
+	l.stub.MockTransactionEnd("setup")
+}
+
+// Fork returns an independent copy of the ledger, with the same state, clock and txID sequence
+func (l *Ledger) Fork() *Ledger {
+	fork := NewLedger(l.cc)
+	fork.Now = l.Now
+	fork.txs = l.txs
+	for _, key := range l.Keys() {
+		fork.SetState(key, append([]byte(nil), l.State(key)...))
+	}
+	for collection, values := range l.stub.PvtState {
+		for key, value := range values {
+			fork.stub.MockStub.PutPrivateData(collection, key, append([]byte(nil), value...))
+		}
+	}
+	for name, other := range l.stub.Invokables {
+		fork.stub.Invokables[name] = other
+	}
+	return fork
+}
+
+// PeerChaincode installs `cc` on the channel as `name`, so the chaincode under test can call it
+// with InvokeChaincode. Its state is its own and is not forked.
+func (l *Ledger) PeerChaincode(name string, cc shim.Chaincode) {
+	l.stub.MockPeerChaincode(name, shim.NewMockStub(name, cc))
+}
@@ -0,0 +1,50 @@
+package chaintest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Step is one transaction of a generated sequence
+type Step struct {
+	Caller   Identity
+	Function string
+	Args     []string
+}
+
+// String formats the step for failure reports
+func (s Step) String() string {
+	return fmt.Sprintf("%s%q as %s", s.Function, s.Args, s.Caller.Account)
+}
+
+// Run submits `step` to `l`
+func (l *Ledger) Run(step Step) Result {
+	return l.Invoke(step.Caller, step.Function, step.Args...)
+}
+
+// FormatSteps lists `steps` one per line, numbered from 1
+func FormatSteps(steps []Step) string {
+	var lines []string
+	for i, step := range steps {
+		lines = append(lines, fmt.Sprintf("%3d. %s", i+1, step))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Shrink returns a subsequence of `steps` that still fails, and the failure `check` reports for it
+// `check` replays a sequence from an empty ledger and returns "" if the property holds. Steps are
+// removed one at a time, from the last, for as long as the rest still fails, until no single step
+// can be removed; the result is usually a handful of steps instead of the generated dozens.
+func Shrink(steps []Step, check func([]Step) string) ([]Step, string) {
+	failure := check(steps)
+	for shrunk := true; shrunk; {
+		shrunk = false
+		for i := len(steps) - 1; i >= 0; i-- {
+			candidate := append(append([]Step{}, steps[:i]...), steps[i+1:]...)
+			if candidateFailure := check(candidate); candidateFailure != "" {
+				steps, failure, shrunk = candidate, candidateFailure, true
+			}
+		}
+	}
+	return steps, failure
+}
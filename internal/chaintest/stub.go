@@ -0,0 +1,293 @@
+// Package chaintest runs chaincode against a shim.MockStub that behaves like an endorsing peer
+// It is shared by the tests of both chaincode variants, which are main packages and cannot import
+// each other.
+package chaintest
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Write is one entry of a transaction's write set; Value is nil for a deletion
+type Write struct {
+	Collection string
+	Key        string
+	Value      []byte
+}
+
+// Read is one entry of a transaction's read set
+// Range is set for a scan, whose Key is the prefix of the keys it covers.
+type Read struct {
+	Collection string
+	Key        string
+	Range      bool
+}
+
+// Event is a chaincode event set by a transaction
+type Event struct {
+	Name    string
+	Payload []byte
+}
+
+// Stub is the stub a chaincode sees during one transaction
+// Unlike a bare shim.MockStub, it has a creator and a transient map, it records reads, it buffers
+// writes and events until the transaction succeeds, GetState never returns the transaction's own
+// writes, and key history and paginated composite-key scans work. Everything else is the embedded
+// MockStub.
+type Stub struct {
+	*shim.MockStub
+	creator   []byte
+	args      [][]byte
+	transient map[string][]byte
+	reads     []Read
+	writes    []Write
+	events    []Event
+	// failWrite is the position, from 1, of the write that fails with ErrInjectedWrite; 0 for none
+	failWrite int
+	// history holds every committed modification of each key, oldest first
+	history map[string][]*queryresult.KeyModification
+}
+
+// GetArgs returns the function name and arguments of the transaction
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+// GetStringArgs returns the function name and arguments of the transaction as strings
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+// GetFunctionAndParameters splits the arguments into the function name and its parameters
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// GetCreator returns the serialized identity of the transaction creator
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+// GetTransient returns the transient map of the proposal
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+// GetState adds `key` to the read set and returns its committed value
+func (s *Stub) GetState(key string) ([]byte, error) {
+	s.reads = append(s.reads, Read{Key: key})
+	return s.MockStub.GetState(key)
+}
+
+// GetPrivateData adds `key` of `collection` to the read set and returns its committed value
+func (s *Stub) GetPrivateData(collection string, key string) ([]byte, error) {
+	s.reads = append(s.reads, Read{Collection: collection, Key: key})
+	return s.MockStub.GetPrivateData(collection, key)
+}
+
+// GetStateByRange adds the scan of the keys from `startKey` to `endKey` to the read set
+func (s *Stub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	s.reads = append(s.reads, Read{Key: startKey + ".." + endKey, Range: true})
+	return s.MockStub.GetStateByRange(startKey, endKey)
+}
+
+// GetStateByPartialCompositeKey adds the scan of the keys starting with the partial composite key to the read set
+func (s *Stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	s.reads = append(s.reads, Read{Key: prefix, Range: true})
+	return s.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+}
+
+// GetHistoryForKey returns every committed modification of `key`, oldest first as on a Fabric 1.4 peer
+// Like a peer, it adds nothing to the read set.
+func (s *Stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: s.history[key]}, nil
+}
+
+// ErrInjectedWrite is the error of the write a transaction chose to fail, see Tx.FailWrite
+var ErrInjectedWrite = errors.New("injected write failure")
+
+// PutState adds `key` to the write set
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	return s.write(Write{Key: key, Value: value})
+}
+
+// DelState adds the deletion of `key` to the write set
+func (s *Stub) DelState(key string) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	return s.write(Write{Key: key})
+}
+
+// PutPrivateData adds `key` of `collection` to the write set
+func (s *Stub) PutPrivateData(collection string, key string, value []byte) error {
+	if collection == "" || key == "" {
+		return errors.New("collection and key must not be empty strings")
+	}
+	return s.write(Write{Collection: collection, Key: key, Value: value})
+}
+
+// write adds `write` to the write set, unless it is the write the transaction chose to fail
+// A failed write is not added, as a peer whose state database refused it would not have it.
+func (s *Stub) write(write Write) error {
+	if len(s.writes)+1 == s.failWrite {
+		s.failWrite = 0
+		return ErrInjectedWrite
+	}
+	s.writes = append(s.writes, write)
+	return nil
+}
+
+// SetEvent records an event; as on a peer, only the last one is kept with the transaction
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be nil string")
+	}
+	s.events = append(s.events, Event{Name: name, Payload: payload})
+	return nil
+}
+
+// InvokeChaincode calls a chaincode installed with Ledger.PeerChaincode
+// As on a peer, calling a chaincode that is not installed fails instead of panicking.
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) peer.Response {
+	name := chaincodeName
+	if channel != "" {
+		name = chaincodeName + "/" + channel
+	}
+	if _, installed := s.Invokables[name]; !installed {
+		return shim.Error("chaincode " + name + " is not installed")
+	}
+	return s.MockStub.InvokeChaincode(chaincodeName, args, channel)
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns one page of the keys starting with the partial
+// composite key; the bookmark is the first key of the next page
+func (s *Stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.reads = append(s.reads, Read{Key: prefix, Range: true})
+	page := &pageIterator{}
+	metadata := &peer.QueryResponseMetadata{}
+	for element := s.Keys.Front(); element != nil; element = element.Next() {
+		key := element.Value.(string)
+		if !strings.HasPrefix(key, prefix) || key < bookmark {
+			continue
+		}
+		if int32(len(page.kvs)) == pageSize {
+			metadata.Bookmark = key
+			break
+		}
+		page.kvs = append(page.kvs, &queryresult.KV{Namespace: s.Name, Key: key, Value: s.State[key]})
+	}
+	metadata.FetchedRecordsCount = int32(len(page.kvs))
+	return page, metadata, nil
+}
+
+// pageIterator iterates over one page of a paginated scan
+type pageIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (p *pageIterator) HasNext() bool {
+	return len(p.kvs) > 0
+}
+
+func (p *pageIterator) Next() (*queryresult.KV, error) {
+	if len(p.kvs) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := p.kvs[0]
+	p.kvs = p.kvs[1:]
+	return kv, nil
+}
+
+func (p *pageIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over the modifications of one key
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (h *historyIterator) HasNext() bool {
+	return len(h.modifications) > 0
+}
+
+func (h *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if len(h.modifications) == 0 {
+		return nil, errors.New("no more results")
+	}
+	modification := h.modifications[0]
+	h.modifications = h.modifications[1:]
+	return modification, nil
+}
+
+func (h *historyIterator) Close() error {
+	return nil
+}
+
+// WriteSet returns the final value of every key written, sorted by collection and key, as a peer
+// would put it in the read-write set
+func WriteSet(writes []Write) []Write {
+	last := map[[2]string]Write{}
+	for _, write := range writes {
+		last[[2]string{write.Collection, write.Key}] = write
+	}
+	set := make([]Write, 0, len(last))
+	for _, write := range last {
+		set = append(set, write)
+	}
+	sort.Slice(set, func(i, j int) bool {
+		if set[i].Collection != set[j].Collection {
+			return set[i].Collection < set[j].Collection
+		}
+		return set[i].Key < set[j].Key
+	})
+	return set
+}
+
+// ReadSet returns every distinct entry of `reads`, sorted by collection and key, as a peer would
+// put it in the read-write set
+func ReadSet(reads []Read) []Read {
+	seen := map[Read]bool{}
+	set := make([]Read, 0, len(reads))
+	for _, read := range reads {
+		if !seen[read] {
+			seen[read] = true
+			set = append(set, read)
+		}
+	}
+	sort.Slice(set, func(i, j int) bool {
+		if set[i].Collection != set[j].Collection {
+			return set[i].Collection < set[j].Collection
+		}
+		if set[i].Key != set[j].Key {
+			return set[i].Key < set[j].Key
+		}
+		return !set[i].Range && set[j].Range
+	})
+	return set
+}
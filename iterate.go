@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// errStopIteration ends an iteration early when returned by a visit function; iterate does not report it
+var errStopIteration = errors.New("stop iteration")
+
+// iterate calls `visit` with the key attributes and value of every entry stored under `objectType`
+// whose key starts with `keys`, in key order. Every scan of composite keys goes through it, so the
+// iterator is always closed and errors from the middle of a scan are never dropped.
+// A pageSize of 0 reads every entry and is meant for bounded internal scans. Otherwise pageSize must be
+// between 1 and maxPageSize, and the returned bookmark continues the scan; paginated scans are only
+// allowed in queries.
+func iterate(APIstub shim.ChaincodeStubInterface, objectType string, keys []string, pageSize int, bookmark string, visit func(attributes []string, value []byte) error) (string, error) {
+	if pageSize < 0 || pageSize > maxPageSize {
+		return "", fmt.Errorf("Invalid page size. Expecting a number between 1 and %d", maxPageSize)
+	}
+
+	name, err := resolveObjectType(APIstub, objectType)
+	if err != nil {
+		return "", err
+	}
+
+	var iterator shim.StateQueryIteratorInterface
+	var metadata *peer.QueryResponseMetadata
+	if pageSize == 0 {
+		iterator, err = APIstub.GetStateByPartialCompositeKey(name, keys)
+	} else {
+		iterator, metadata, err = APIstub.GetStateByPartialCompositeKeyWithPagination(name, keys, int32(pageSize), bookmark)
+	}
+	if err != nil {
+		return "", stateError(APIstub, "GetStateByPartialCompositeKey", objectType, err)
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return "", stateError(APIstub, "Next", objectType, err)
+		}
+		_, attributes, err := APIstub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return "", err
+		}
+		err = visit(attributes, kv.Value)
+		if err == errStopIteration {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if metadata == nil {
+		return "", nil
+	}
+	return metadata.Bookmark, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for the key schema
+const keySchemaKey = "keySchema"
+
+// keySchema maps every object type used in code to the name its keys are stored under
+// Renaming an object type in code keeps existing keys readable by mapping the new name to the
+// old one here; a deployment can map further with SetKeySchema. Key construction only accepts
+// object types listed here, see buildKey.
+var keySchema = map[string]string{
+	accountCountPrefix:              accountCountPrefix,
+	activityPrefix:                  activityPrefix,
+	allowancePrefix:                 allowancePrefix,
+	allowanceRecipientPrefix:        allowanceRecipientPrefix,
+	allowanceReferencePrefix:        allowanceReferencePrefix,
+	allowanceRequestPrefix:          allowanceRequestPrefix,
+	allowanceRequestByOwnerPrefix:   allowanceRequestByOwnerPrefix,
+	allowanceRequestBySpenderPrefix: allowanceRequestBySpenderPrefix,
+	allowedSenderPrefix:             allowedSenderPrefix,
+	allowlistEnabledPrefix:          allowlistEnabledPrefix,
+	balanceCapPrefix:                balanceCapPrefix,
+	balancePrefix:                   balancePrefix,
+	caseActionPrefix:                caseActionPrefix,
+	casePrefix:                      casePrefix,
+	categoryBudgetPrefix:            categoryBudgetPrefix,
+	categoryUsagePrefix:             categoryUsagePrefix,
+	complianceNotePrefix:            complianceNotePrefix,
+	faucetUsagePrefix:               faucetUsagePrefix,
+	frozenPrefix:                    frozenPrefix,
+	guardiansPrefix:                 guardiansPrefix,
+	holdPrefix:                      holdPrefix,
+	holdByAccountPrefix:             holdByAccountPrefix,
+	memoRequiredPrefix:              memoRequiredPrefix,
+	minterSessionPrefix:             minterSessionPrefix,
+	mspBindingPrefix:                mspBindingPrefix,
+	paymentRequestPrefix:            paymentRequestPrefix,
+	paymentRequestByPayeePrefix:     paymentRequestByPayeePrefix,
+	publicBalancePrefix:             publicBalancePrefix,
+	pendingOutgoingPrefix:           pendingOutgoingPrefix,
+	pendingOutgoingByAccountPrefix:  pendingOutgoingByAccountPrefix,
+	pendingRecoveryPrefix:           pendingRecoveryPrefix,
+	receiverPrefix:                  receiverPrefix,
+	recentPrefix:                    recentPrefix,
+	recentCountPrefix:               recentCountPrefix,
+	recoveryPrefix:                  recoveryPrefix,
+	retirementPrefix:                retirementPrefix,
+	retirementByAccountPrefix:       retirementByAccountPrefix,
+	rolePrefix:                      rolePrefix,
+	rotationOptOutPrefix:            rotationOptOutPrefix,
+	scheduledTransferPrefix:         scheduledTransferPrefix,
+	scheduledTransferDuePrefix:      scheduledTransferDuePrefix,
+	sequencePrefix:                  sequencePrefix,
+	spenderIndexPrefix:              spenderIndexPrefix,
+	spenderRotationPrefix:           spenderRotationPrefix,
+	supplyHistoryPrefix:             supplyHistoryPrefix,
+	swapPrefix:                      swapPrefix,
+	termsVersionPrefix:              termsVersionPrefix,
+	termsVersionAcceptedPrefix:      termsVersionAcceptedPrefix,
+	termsAcceptedPrefix:             termsAcceptedPrefix,
+	travelRulePrefix:                travelRulePrefix,
+	trustedPairPrefix:               trustedPairPrefix,
+	twoPersonRulePrefix:             twoPersonRulePrefix,
+	uncategorizedBlockedPrefix:      uncategorizedBlockedPrefix,
+}
+
+// SetKeySchema maps object types to the names their keys are stored under on this deployment
+// `schemaJSON` is a JSON object from object type to stored name, e.g. {"allowance":"allowances"}.
+// Only admins can set it, and only once; set it right after upgrading, before keys are written
+// under the names it replaces. Existing keys are not rewritten.
+func (s *SmartContract) SetKeySchema(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var overrides map[string]string
+	err := json.Unmarshal([]byte(args[0]), &overrides)
+	if err != nil || len(overrides) == 0 {
+		return shim.Error("Invalid key schema. Expecting a non-empty JSON object of object types to stored names")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := APIstub.GetState(keySchemaKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "GetState", keySchemaKey, err).Error())
+	}
+	if existing != nil {
+		return shim.Error("Key schema already set")
+	}
+
+	err = checkKeySchema(APIstub, overrides)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	schemaBytes, err := json.Marshal(overrides)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(keySchemaKey, schemaBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", keySchemaKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkKeySchema returns an error unless `overrides` only maps known object types to valid names
+// and no two object types end up stored under the same name
+func checkKeySchema(APIstub shim.ChaincodeStubInterface, overrides map[string]string) error {
+	for objectType, name := range overrides {
+		if _, known := keySchema[objectType]; !known {
+			return fmt.Errorf("Unknown object type %s", objectType)
+		}
+		if name == "" {
+			return fmt.Errorf("Invalid stored name for %s. Expecting a non-empty string", objectType)
+		}
+		_, err := APIstub.CreateCompositeKey(name, nil)
+		if err != nil {
+			return fmt.Errorf("Invalid stored name for %s: %s", objectType, err)
+		}
+	}
+
+	owners := make(map[string]string, len(keySchema))
+	for objectType, name := range keySchema {
+		if override, ok := overrides[objectType]; ok {
+			name = override
+		}
+		if other, taken := owners[name]; taken {
+			return fmt.Errorf("Object types %s and %s would both be stored under %s", other, objectType, name)
+		}
+		owners[name] = objectType
+	}
+	return nil
+}
+
+// buildKey returns the composite key of `attributes` under the stored name of `objectType`
+// Every composite key is built here, so keys follow the key schema.
+func buildKey(APIstub shim.ChaincodeStubInterface, objectType string, attributes []string) (string, error) {
+	name, err := resolveObjectType(APIstub, objectType)
+	if err != nil {
+		return "", err
+	}
+	return APIstub.CreateCompositeKey(name, attributes)
+}
+
+// buildAllowanceKey returns the key of the allowance of `spender` over `owner`'s account
+func buildAllowanceKey(APIstub shim.ChaincodeStubInterface, owner string, spender string) (string, error) {
+	return buildKey(APIstub, allowancePrefix, []string{owner, spender})
+}
+
+// buildLegacyAllowanceKey returns the key allowances were stored under before they became composite keys
+// It is the plain concatenation of the stored name of allowancePrefix, the owner and the spender,
+// so different pairs can share it; only MigrateLegacyAllowances and AllowanceHistory read it.
+func buildLegacyAllowanceKey(APIstub shim.ChaincodeStubInterface, owner string, spender string) (string, error) {
+	name, err := resolveObjectType(APIstub, allowancePrefix)
+	if err != nil {
+		return "", err
+	}
+	return name + owner + spender, nil
+}
+
+// resolveObjectType returns the name keys of `objectType` are stored under
+// The overrides of SetKeySchema are read once per transaction.
+func resolveObjectType(APIstub shim.ChaincodeStubInterface, objectType string) (string, error) {
+	name, known := keySchema[objectType]
+	if !known {
+		return "", fmt.Errorf("Unknown object type %s", objectType)
+	}
+
+	// Queries run on a read-only view of the transaction stub
+	if view, ok := APIstub.(readOnlyStub); ok {
+		APIstub = view.ChaincodeStubInterface
+	}
+	tx, cached := APIstub.(*txStub)
+	var overrides map[string]string
+	if cached && tx.keySchema != nil {
+		overrides = tx.keySchema
+	} else {
+		schemaBytes, err := APIstub.GetState(keySchemaKey)
+		if err != nil {
+			return "", stateError(APIstub, "GetState", keySchemaKey, err)
+		}
+		overrides = map[string]string{}
+		if schemaBytes != nil {
+			err = json.Unmarshal(schemaBytes, &overrides)
+			if err != nil {
+				return "", err
+			}
+		}
+		if cached {
+			tx.keySchema = overrides
+		}
+	}
+
+	if override, ok := overrides[objectType]; ok {
+		return override, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// storedKey returns the composite key of `attributes` under the stored name `name`
+func storedKey(name string, attributes ...string) string {
+	return "\x00" + name + "\x00" + strings.Join(attributes, "\x00") + "\x00"
+}
+
+// TestKeySchemaReadsLegacyNames seeds keys under a legacy object type name, as an earlier
+// deployment would have written them, and reads them back once SetKeySchema maps the object type
+func TestKeySchemaReadsLegacyNames(t *testing.T) {
+	pauserGrant := `{"role":"pauser","account":"` + alice.Account + `","expiresAt":0}`
+	adminGrant := `{"role":"admin","account":"` + admin.Account + `","expiresAt":0}`
+	tests := []struct {
+		objectType string
+		legacyName string
+		seed       map[string]string
+		read       []string
+		expected   string
+	}{
+		{balancePrefix, "balances", map[string]string{storedKey("balances", alice.Account): "70"},
+			[]string{"BalanceOf", alice.Account}, "70"},
+		{allowancePrefix, "allowances", map[string]string{storedKey("allowances", alice.Account, bob.Account): "30"},
+			[]string{"Allowance", alice.Account, bob.Account}, "30"},
+		// Admin's own grant moves with the schema, or nobody could act after the mapping
+		{rolePrefix, "roles", map[string]string{
+			storedKey("roles", pauserRole, alice.Account): pauserGrant,
+			storedKey("roles", adminRole, admin.Account):  adminGrant,
+		}, []string{"HasRole", pauserRole, alice.Account}, "true"},
+	}
+	for _, test := range tests {
+		t.Run(test.objectType, func(t *testing.T) {
+			ledger := newToken(t, "")
+			for key, value := range test.seed {
+				ledger.SetState(key, []byte(value))
+			}
+			if result := ledger.Invoke(admin, test.read[0], test.read[1:]...); string(result.Payload) == test.expected {
+				t.Fatalf("%s%q returned %s before the mapping, expected the legacy keys to be invisible", test.read[0], test.read[1:], result.Payload)
+			}
+
+			mustInvoke(t, ledger, admin, "SetKeySchema", `{"`+test.objectType+`":"`+test.legacyName+`"}`)
+			if got := mustInvoke(t, ledger, admin, test.read[0], test.read[1:]...); got != test.expected {
+				t.Fatalf("%s%q returned %s after the mapping, expected %s", test.read[0], test.read[1:], got, test.expected)
+			}
+		})
+	}
+}
+
+// TestKeySchemaWritesUnderMappedName checks that keys are written under the mapped name only
+func TestKeySchemaWritesUnderMappedName(t *testing.T) {
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, admin, "SetKeySchema", `{"balance":"balances"}`)
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "30")
+
+	for account, expected := range map[string]string{alice.Account: "70", bob.Account: "30"} {
+		if got := string(ledger.State(storedKey("balances", account))); got != expected {
+			t.Fatalf("balance stored under the mapped name is %q, expected %s", got, expected)
+		}
+		if ledger.State(storedBalanceKey(account)) != nil {
+			t.Fatalf("balance was written under the default name %s", balancePrefix)
+		}
+	}
+}
+
+func TestSetKeySchemaValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		caller   chaintest.Identity
+		schema   string
+		expected string
+	}{
+		{"not an object", admin, `["balance"]`, "Invalid key schema"},
+		{"empty", admin, `{}`, "Invalid key schema"},
+		{"unknown object type", admin, `{"balances":"b"}`, "Unknown object type balances"},
+		{"empty name", admin, `{"balance":""}`, "Invalid stored name for balance"},
+		{"invalid name", admin, `{"balance":"b\u0000"}`, "Invalid stored name for balance"},
+		{"name of another object type", admin, `{"balance":"allowance"}`, "would both be stored under allowance"},
+		{"shared name", admin, `{"balance":"b","allowance":"b"}`, "would both be stored under b"},
+		{"not an admin", alice, `{"balance":"balances"}`, errUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			message := mustFail(t, ledger, test.caller, "SetKeySchema", test.schema)
+			if !strings.Contains(message, test.expected) {
+				t.Fatalf("SetKeySchema of %s failed with %q, expected it to mention %q", test.schema, message, test.expected)
+			}
+			if ledger.State(keySchemaKey) != nil {
+				t.Fatalf("refused key schema was stored as %s", ledger.State(keySchemaKey))
+			}
+		})
+	}
+
+	// The schema can only be set once
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, admin, "SetKeySchema", `{"balance":"balances"}`)
+	result := ledger.Invoke(admin, "SetKeySchema", `{"allowance":"allowances"}`)
+	if result.Status == shim.OK || result.Message != "Key schema already set" {
+		t.Fatalf("second SetKeySchema returned %q, expected Key schema already set", result.Message)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// MigrateLegacyAllowances moves allowances stored under the concatenated key to the allowance key; only admins can migrate
+// `pairsJSON` is a JSON array of [owner, spender] pairs. Allowances were stored under the stored
+// name of allowancePrefix followed by the owner and the spender, so the key of one pair can be the
+// key of another, e.g. owner "ab" and spender "c" against owner "a" and spender "bc". Nothing in
+// the key tells them apart, so pairs are listed explicitly and each listed pair takes the value.
+// Every pair is checked before any is moved. The spender index is written for every moved pair so
+// RevokeSpenderAllowances and spender rotations find it.
+func (s *SmartContract) MigrateLegacyAllowances(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var pairs [][2]string
+	err := json.Unmarshal([]byte(args[0]), &pairs)
+	if err != nil || len(pairs) == 0 {
+		return shim.Error("Invalid pairs. Expecting a non-empty JSON array of [owner, spender] pairs")
+	}
+	if len(pairs) > maxLegacyMigrationSize {
+		return shim.Error(fmt.Sprintf("Too many pairs. Expecting at most %d", maxLegacyMigrationSize))
+	}
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Two pairs sharing a legacy key would both take its value
+	legacyKeys := make([]string, len(pairs))
+	values := make([][]byte, len(pairs))
+	taken := map[string]int{}
+	for i, pair := range pairs {
+		if pair[0] == "" || pair[1] == "" {
+			return shim.Error("Every pair needs an owner and a spender")
+		}
+		legacyKeys[i], err = buildLegacyAllowanceKey(APIstub, pair[0], pair[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if other, ok := taken[legacyKeys[i]]; ok {
+			return shim.Error(fmt.Sprintf("Pairs %q and %q share the legacy key %q", pairs[other], pair, legacyKeys[i]))
+		}
+		taken[legacyKeys[i]] = i
+		values[i], err = getLegacyAllowance(APIstub, legacyKeys[i], pair[0], pair[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	for i, pair := range pairs {
+		err = moveLegacyAllowance(APIstub, legacyKeys[i], pair[0], pair[1], values[i])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// getLegacyAllowance returns the allowance stored under `legacyKey` for `owner` and `spender`
+// It fails if there is none, if it is not a bare integer or if the pair already has an allowance
+// under the allowance key.
+func getLegacyAllowance(APIstub shim.ChaincodeStubInterface, legacyKey string, owner string, spender string) ([]byte, error) {
+	allowanceBytes, err := APIstub.GetState(legacyKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", allowancePrefix, err)
+	}
+	if allowanceBytes == nil {
+		return nil, fmt.Errorf("no legacy allowance stored for owner %s and spender %s", owner, spender)
+	}
+	_, err = strconv.Atoi(string(allowanceBytes))
+	if err != nil || !isDigits(string(allowanceBytes)) {
+		return nil, fmt.Errorf("ERR_STATE: invalid legacy allowance %q stored for owner %s and spender %s", allowanceBytes, owner, spender)
+	}
+
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := APIstub.GetState(allowanceKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", allowancePrefix, err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("owner %s already has an allowance for spender %s under the allowance key", owner, spender)
+	}
+	return allowanceBytes, nil
+}
+
+// moveLegacyAllowance stores `allowanceBytes` under the allowance key of the pair, indexes the spender and deletes `legacyKey`
+func moveLegacyAllowance(APIstub shim.ChaincodeStubInterface, legacyKey string, owner string, spender string, allowanceBytes []byte) error {
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(allowanceKey, allowanceBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", allowancePrefix, err)
+	}
+	err = indexSpender(APIstub, owner, spender)
+	if err != nil {
+		return err
+	}
+	err = APIstub.DelState(legacyKey)
+	if err != nil {
+		return stateError(APIstub, "DelState", allowancePrefix, err)
+	}
+	return nil
+}
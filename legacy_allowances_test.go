@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAllowanceKeysDoNotCollide(t *testing.T) {
+	ledger := newToken(t, "")
+	// Concatenated, both pairs are "ab" + "c"
+	mustInvoke(t, ledger, alice, "Approve", "c", "10")
+	owner := alice.Account[:len(alice.Account)-1]
+	spender := alice.Account[len(alice.Account)-1:] + "c"
+
+	if got := mustInvoke(t, ledger, alice, "Allowance", owner, spender); got != "0" {
+		t.Fatalf("allowance of %q from %q is %s, expected the allowance of another pair not to count", spender, owner, got)
+	}
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, "c"); got != "10" {
+		t.Fatalf("allowance is %s, expected 10", got)
+	}
+}
+
+func TestMigrateLegacyAllowances(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	legacyKey := allowancePrefix + alice.Account + bob.Account
+	ledger.SetState(legacyKey, []byte("30"))
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, bob.Account); got != "0" {
+		t.Fatalf("legacy allowance reads as %s before the migration, expected 0", got)
+	}
+
+	pairs := `[["` + alice.Account + `","` + bob.Account + `"]]`
+	mustFail(t, ledger, alice, "MigrateLegacyAllowances", pairs)
+	mustInvoke(t, ledger, admin, "MigrateLegacyAllowances", pairs)
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, bob.Account); got != "30" {
+		t.Fatalf("migrated allowance is %s, expected 30", got)
+	}
+	if ledger.State(legacyKey) != nil {
+		t.Fatalf("legacy allowance is still %q after the migration", ledger.State(legacyKey))
+	}
+	message := mustFail(t, ledger, admin, "MigrateLegacyAllowances", pairs)
+	if !strings.Contains(message, "no legacy allowance") {
+		t.Fatalf("second migration failed with %q, expected no legacy allowance", message)
+	}
+
+	// The migrated allowance is spendable and indexed by spender
+	mustInvoke(t, ledger, bob, "TransferFrom", alice.Account, carol.Account, "10")
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, bob.Account); got != "20" {
+		t.Fatalf("allowance is %s after spending 10, expected 20", got)
+	}
+	mustInvoke(t, ledger, admin, "GrantRole", complianceRole, admin.Account)
+	mustInvoke(t, ledger, admin, "OpenCase", "case-1", "offboarding")
+	mustInvoke(t, ledger, admin, "RevokeSpenderAllowances", bob.Account, "case-1")
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, bob.Account); got != "0" {
+		t.Fatalf("allowance is %s after revoking the spender, expected 0", got)
+	}
+}
+
+func TestMigrateLegacyAllowancesIsAllOrNothing(t *testing.T) {
+	ledger := newToken(t, "")
+	legacyKey := allowancePrefix + alice.Account + bob.Account
+	ledger.SetState(legacyKey, []byte("30"))
+
+	for _, pairs := range []string{
+		// carol has no legacy allowance
+		`[["` + alice.Account + `","` + bob.Account + `"],["` + carol.Account + `","` + bob.Account + `"]]`,
+		// Both pairs share the legacy key
+		`[["` + alice.Account + `","` + bob.Account + `"],["` + alice.Account + bob.Account[:1] + `","` + bob.Account[1:] + `"]]`,
+	} {
+		result := ledger.Invoke(admin, "MigrateLegacyAllowances", pairs)
+		if result.Message == "" || len(result.Writes) != 0 {
+			t.Fatalf("migration of %s returned %q after %d writes, expected a failure without writes", pairs, result.Message, len(result.Writes))
+		}
+	}
+	if got := string(ledger.State(legacyKey)); got != "30" {
+		t.Fatalf("legacy allowance is %q after refused migrations, expected 30", got)
+	}
+}
+
+func TestAllowanceHistoryFollowsMigration(t *testing.T) {
+	ledger := newToken(t, "")
+	ledger.SetState(allowancePrefix+alice.Account+bob.Account, []byte("30"))
+	mustInvoke(t, ledger, admin, "MigrateLegacyAllowances", `[["`+alice.Account+`","`+bob.Account+`"]]`)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "5")
+
+	var response allowanceHistoryResponse
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, bob, "AllowanceHistory", alice.Account, bob.Account, "10")), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []int
+	for _, entry := range response.History {
+		values = append(values, entry.Value)
+	}
+	// Set under the legacy key, moved, then approved again
+	if len(values) != 4 || values[0] != 30 || values[1] != 0 || values[2] != 30 || values[3] != 5 {
+		t.Fatalf("allowance history is %v, expected [30 0 30 5]", values)
+	}
+	if response.History[1].TxID != response.History[2].TxID {
+		t.Fatalf("migration shows as transactions %s and %s, expected one", response.History[1].TxID, response.History[2].TxID)
+	}
+
+	mustFail(t, ledger, carol, "AllowanceHistory", alice.Account, bob.Account, "10")
+	empty := mustInvoke(t, ledger, alice, "AllowanceHistory", alice.Account, carol.Account, "10")
+	if !strings.Contains(empty, `"history":[]`) {
+		t.Fatalf("history of an allowance never set is %s, expected an empty array", empty)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxLegacyMigrationSize bounds the number of accounts in a single MigrateLegacyBalances call
+const maxLegacyMigrationSize = 500
+
+// MigrateLegacyBalances moves balances stored under the raw account string to the balance key; only admins can migrate
+// `accountsJSON` is a JSON array of the accounts to move. Balances were stored under the raw account
+// until balancePrefix, where nothing tells them apart from other plain keys: allowances not yet
+// moved by MigrateLegacyAllowances are the concatenation of allowancePrefix, the owner and the
+// spender, so reading any raw key as a balance could turn an allowance into tokens. Accounts are
+// therefore listed explicitly, and the migration refuses accounts whose raw key may be something
+// else: the token-wide plain keys, composite keys and keys starting with the allowance name. A
+// moved account is counted like any new balance key, so the account cap applies. The move is not
+// a movement, so freezes and activity tracking do not apply.
+func (s *SmartContract) MigrateLegacyBalances(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var accounts []string
+	err := json.Unmarshal([]byte(args[0]), &accounts)
+	if err != nil || len(accounts) == 0 {
+		return shim.Error("Invalid accounts. Expecting a non-empty JSON array of accounts")
+	}
+	if len(accounts) > maxLegacyMigrationSize {
+		return shim.Error(fmt.Sprintf("Too many accounts. Expecting at most %d", maxLegacyMigrationSize))
+	}
+
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	allowanceName, err := resolveObjectType(APIstub, allowancePrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Every account is checked before any is moved, so a refused list moves nothing
+	balances := make(map[string][]byte, len(accounts))
+	for _, account := range accounts {
+		if account == "" || isUnprefixedKey(account) || strings.HasPrefix(account, "\x00") || strings.HasPrefix(account, allowanceName) {
+			return shim.Error(fmt.Sprintf("Account %q cannot be told apart from other plain keys", account))
+		}
+		balances[account], err = getLegacyRawBalance(APIstub, account)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	for _, account := range accounts {
+		err = moveLegacyRawBalance(APIstub, account, balances[account])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// getLegacyRawBalance returns the balance of `account` stored under its raw account key
+// It fails if there is none, if it is not a bare integer or if the account already has a balance
+// under the balance key.
+func getLegacyRawBalance(APIstub shim.ChaincodeStubInterface, account string) ([]byte, error) {
+	balanceBytes, err := APIstub.GetState(account)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", balancePrefix, err)
+	}
+	if balanceBytes == nil {
+		return nil, fmt.Errorf("no legacy balance stored for account %s", account)
+	}
+	// Balances under the raw account were only ever written as bare integers
+	_, err = strconv.Atoi(string(balanceBytes))
+	if err != nil || !isDigits(string(balanceBytes)) {
+		return nil, fmt.Errorf("ERR_STATE: invalid legacy balance %q stored for account %s", balanceBytes, account)
+	}
+
+	balanceKey, err := buildKey(APIstub, balancePrefix, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	existing, err := APIstub.GetState(balanceKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", balancePrefix, err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("account %s already has a balance under the balance key", account)
+	}
+	return balanceBytes, nil
+}
+
+// moveLegacyRawBalance stores `balanceBytes` under the balance key of `account` and deletes its raw account key
+// The balance key is new, so the account is registered in the account count.
+func moveLegacyRawBalance(APIstub shim.ChaincodeStubInterface, account string, balanceBytes []byte) error {
+	balanceKey, err := buildKey(APIstub, balancePrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	err = registerAccount(APIstub, account)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(balanceKey, balanceBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", balancePrefix, err)
+	}
+	err = APIstub.DelState(account)
+	if err != nil {
+		return stateError(APIstub, "DelState", balancePrefix, err)
+	}
+	return nil
+}
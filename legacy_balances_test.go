@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAccountNamedAfterMetadataKey(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, "name", 10)
+	fund(t, ledger, totalSupplyKey, 5)
+
+	if got := mustInvoke(t, ledger, alice, "Name"); got != "Token" {
+		t.Fatalf("token name is %q after crediting an account named name, expected Token", got)
+	}
+	if got := balanceOf(t, ledger, "name"); got != 10 {
+		t.Fatalf("balance of the account named name is %d, expected 10", got)
+	}
+	if got := balanceOf(t, ledger, totalSupplyKey); got != 5 {
+		t.Fatalf("balance of the account named %s is %d, expected 5", totalSupplyKey, got)
+	}
+	if got := mustInvoke(t, ledger, alice, "TotalSupply"); got != "15" {
+		t.Fatalf("total supply is %s, expected 15", got)
+	}
+}
+
+func TestAccountNamedAfterAllowanceKey(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "30")
+
+	// In the plain key layout this account's balance and the allowance share a key
+	account := allowancePrefix + alice.Account + bob.Account
+	mustInvoke(t, ledger, alice, "Transfer", account, "10")
+
+	if got := mustInvoke(t, ledger, alice, "Allowance", alice.Account, bob.Account); got != "30" {
+		t.Fatalf("allowance is %s after a transfer to %q, expected 30", got, account)
+	}
+	if got := balanceOf(t, ledger, account); got != 10 {
+		t.Fatalf("balance of %q is %d, expected 10", account, got)
+	}
+	mustInvoke(t, ledger, bob, "TransferFrom", alice.Account, carol.Account, "30")
+	if got := balanceOf(t, ledger, alice.Account) + balanceOf(t, ledger, account) + balanceOf(t, ledger, carol.Account); got != 100 {
+		t.Fatalf("balances add up to %d, expected the total supply of 100", got)
+	}
+	if got := mustInvoke(t, ledger, alice, "TotalSupply"); got != "100" {
+		t.Fatalf("total supply is %s, expected 100", got)
+	}
+}
+
+func TestRawKeysAreNotBalances(t *testing.T) {
+	ledger := newToken(t, "")
+	// An allowance not yet moved by MigrateLegacyAllowances
+	allowanceKey := allowancePrefix + alice.Account + bob.Account
+	ledger.SetState(allowanceKey, []byte("30"))
+
+	// An allowance read as a balance would be spendable and overwritten on the next credit
+	if got := balanceOf(t, ledger, allowanceKey); got != 0 {
+		t.Fatalf("balance of %q is %d, expected the allowance not to count", allowanceKey, got)
+	}
+	mustFail(t, ledger, admin, "MigrateLegacyBalances", `["`+allowanceKey+`"]`)
+	mustFail(t, ledger, admin, "MigrateLegacyBalances", `["`+totalSupplyKey+`"]`)
+	if got := string(ledger.State(allowanceKey)); got != "30" {
+		t.Fatalf("legacy allowance is %q after refused migrations, expected 30", got)
+	}
+}
+
+func TestMigrateLegacyBalances(t *testing.T) {
+	ledger := newToken(t, "")
+	// Balances written before balancePrefix are under the raw account string
+	ledger.SetState(alice.Account, []byte("70"))
+	ledger.SetState(bob.Account, []byte("30"))
+	ledger.SetState(totalSupplyKey, []byte("100"))
+	if got := balanceOf(t, ledger, alice.Account); got != 0 {
+		t.Fatalf("balance under the raw account reads as %d before the migration, expected 0", got)
+	}
+
+	accounts := `["` + alice.Account + `","` + bob.Account + `"]`
+	mustFail(t, ledger, alice, "MigrateLegacyBalances", accounts)
+	mustInvoke(t, ledger, admin, "MigrateLegacyBalances", accounts)
+	if got := balanceOf(t, ledger, alice.Account); got != 70 {
+		t.Fatalf("migrated balance is %d, expected 70", got)
+	}
+	if ledger.State(alice.Account) != nil {
+		t.Fatalf("raw account key is still %q after the migration", ledger.State(alice.Account))
+	}
+
+	// A second run finds nothing to move and must not overwrite the migrated balances
+	message := mustFail(t, ledger, admin, "MigrateLegacyBalances", accounts)
+	if !strings.Contains(message, "no legacy balance") {
+		t.Fatalf("second migration failed with %q, expected no legacy balance", message)
+	}
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "20")
+	if got := balanceOf(t, ledger, bob.Account); got != 50 {
+		t.Fatalf("balance after a transfer to a migrated account is %d, expected 50", got)
+	}
+}
+
+func TestMigrateLegacyBalancesCountsAccounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		accounts    int
+		maxAccounts string
+		expected    int
+		err         string
+	}{
+		{"uncapped", 2, "0", 3, ""},
+		{"within the cap", 2, "3", 3, ""},
+		{"over the cap", 2, "2", 1, "ERR_ACCOUNT_CAP"},
+		// More accounts than counter shards, so several share a shard
+		{"shared shards", 2 * accountCountShards, "0", 2*accountCountShards + 1, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			fund(t, ledger, carol.Account, 10)
+			mustInvoke(t, ledger, admin, "SetMaxAccounts", test.maxAccounts)
+			var accounts []string
+			for i := 0; i < test.accounts; i++ {
+				account := "legacy-" + strconv.Itoa(i)
+				ledger.SetState(account, []byte("10"))
+				accounts = append(accounts, account)
+			}
+			accountsJSON, _ := json.Marshal(accounts)
+
+			result := ledger.Invoke(admin, "MigrateLegacyBalances", string(accountsJSON))
+			if test.err == "" && result.Status != shim.OK {
+				t.Fatalf("migration failed with %q", result.Message)
+			}
+			if test.err != "" && !strings.HasPrefix(result.Message, test.err) {
+				t.Fatalf("migration returned %q, expected %s", result.Message, test.err)
+			}
+			if got := accountCount(t, ledger); got != test.expected {
+				t.Fatalf("account count is %d after the migration, expected %d", got, test.expected)
+			}
+		})
+	}
+}
+
+// accountCount returns the count reported by CurrentAccountCount
+func accountCount(t *testing.T, ledger *chaintest.Ledger) int {
+	t.Helper()
+	var response accountCountResponse
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "CurrentAccountCount")), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.Count
+}
+
+func TestMigrateLegacyBalancesRefusesCorruptValues(t *testing.T) {
+	ledger := newToken(t, "")
+	ledger.SetState(alice.Account, []byte(`{"owner":"someone"}`))
+
+	message := mustFail(t, ledger, admin, "MigrateLegacyBalances", `["`+alice.Account+`"]`)
+	if !strings.HasPrefix(message, "ERR_STATE") {
+		t.Fatalf("migration of a non-integer value failed with %q, expected ERR_STATE", message)
+	}
+	if got := string(ledger.State(alice.Account)); got != `{"owner":"someone"}` {
+		t.Fatalf("raw key changed to %q by a refused migration", got)
+	}
+}
+
+func TestMigrateLegacyBalancesIsAllOrNothing(t *testing.T) {
+	ledger := newToken(t, "")
+	ledger.SetState(alice.Account, []byte("70"))
+
+	// bob has no legacy balance, so alice's must not move either
+	result := ledger.Invoke(admin, "MigrateLegacyBalances", `["`+alice.Account+`","`+bob.Account+`"]`)
+	if result.Status == shim.OK {
+		t.Fatalf("migration of an account without a legacy balance succeeded")
+	}
+	if len(result.Writes) != 0 {
+		t.Fatalf("refused migration made %d writes, expected none", len(result.Writes))
+	}
+	if got := string(ledger.State(alice.Account)); got != "70" {
+		t.Fatalf("raw account key is %q after a refused migration, expected 70", got)
+	}
+}
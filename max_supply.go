@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for the supply cap
+const maxSupplyKey = "maxSupply"
+const maxSupplyDelayKey = "maxSupplyChangeDelay"
+const maxSupplyProposalKey = "maxSupplyProposal"
+
+// maxSupplyProposal is a pending change of the supply cap
+type maxSupplyProposal struct {
+	NewCap      int   `json:"newCap"`
+	EffectiveAt int64 `json:"effectiveAt"`
+}
+
+// maxSupplyEvent provides an organized struct for emitting supply cap events
+type maxSupplyEvent struct {
+	Token       string `json:"token"`
+	CurrentCap  int    `json:"currentCap"`
+	NewCap      int    `json:"newCap"`
+	EffectiveAt int64  `json:"effectiveAt"`
+}
+
+// maxSupplyResponse is the JSON document returned by MaxSupply
+type maxSupplyResponse struct {
+	Token     string             `json:"token"`
+	MaxSupply int                `json:"maxSupply"`
+	Pending   *maxSupplyProposal `json:"pending"`
+}
+
+// MaxSupply returns the supply cap, 0 meaning uncapped, and any pending change
+func (s *SmartContract) MaxSupply(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	maxSupply, err := getMaxSupply(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal, err := getMaxSupplyProposal(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(maxSupplyResponse{Token: symbol, MaxSupply: maxSupply, Pending: proposal})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// ProposeMaxSupplyChange announces a new supply cap, 0 meaning uncapped; only admins can propose
+// The change can be applied once the delay configured at Initialize has passed.
+// This function triggers a MaxSupplyChangeProposed event
+func (s *SmartContract) ProposeMaxSupplyChange(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	newCap, err := strconv.Atoi(args[0])
+	if err != nil || newCap < 0 {
+		return shim.Error("Invalid cap. Expecting a non-negative numeric string")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pending, err := getMaxSupplyProposal(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pending != nil {
+		return shim.Error("A supply cap change is already pending")
+	}
+
+	delay, err := getIntSetting(APIstub, maxSupplyDelayKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	proposal := maxSupplyProposal{NewCap: newCap, EffectiveAt: now + int64(delay)}
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(maxSupplyProposalKey, proposalBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", maxSupplyProposalKey, err).Error())
+	}
+
+	err = emitMaxSupplyEvent(APIstub, "MaxSupplyChangeProposed", proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CancelMaxSupplyChange withdraws the pending supply cap change before it takes effect
+// Only admins can cancel.
+// This function triggers a MaxSupplyChangeCancelled event
+func (s *SmartContract) CancelMaxSupplyChange(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	proposal, err := getMaxSupplyProposal(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal == nil {
+		return shim.Error("No supply cap change pending")
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if now >= proposal.EffectiveAt {
+		return shim.Error("Supply cap change has already taken effect")
+	}
+
+	err = APIstub.DelState(maxSupplyProposalKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", maxSupplyProposalKey, err).Error())
+	}
+
+	err = emitMaxSupplyEvent(APIstub, "MaxSupplyChangeCancelled", *proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ApplyMaxSupplyChange enacts the pending supply cap change; anyone can apply it once it is effective
+// This function triggers a MaxSupplyChangeApplied event
+func (s *SmartContract) ApplyMaxSupplyChange(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	proposal, err := getMaxSupplyProposal(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal == nil {
+		return shim.Error("No supply cap change pending")
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if now < proposal.EffectiveAt {
+		return shim.Error(fmt.Sprintf("Supply cap change is not effective before %d", proposal.EffectiveAt))
+	}
+
+	// Emit before writing so the event reports the cap being replaced
+	err = emitMaxSupplyEvent(APIstub, "MaxSupplyChangeApplied", *proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(maxSupplyKey, []byte(strconv.Itoa(proposal.NewCap)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", maxSupplyKey, err).Error())
+	}
+	err = APIstub.DelState(maxSupplyProposalKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", maxSupplyProposalKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkMaxSupply returns an error if minting `amount` would take the total supply over the cap
+func checkMaxSupply(APIstub shim.ChaincodeStubInterface, amount int) error {
+	maxSupply, err := getMaxSupply(APIstub)
+	if err != nil {
+		return err
+	}
+	if maxSupply == 0 {
+		return nil
+	}
+	totalSupply, err := getTotalSupply(APIstub)
+	if err != nil {
+		return err
+	}
+	if totalSupply+amount > maxSupply {
+		return fmt.Errorf("Minting %d would exceed the supply cap of %d", amount, maxSupply)
+	}
+	return nil
+}
+
+// getMaxSupply returns the supply cap, or 0 if the supply is uncapped
+func getMaxSupply(APIstub shim.ChaincodeStubInterface) (int, error) {
+	return getIntSetting(APIstub, maxSupplyKey)
+}
+
+// getMaxSupplyProposal returns the pending supply cap change, or nil if there is none
+func getMaxSupplyProposal(APIstub shim.ChaincodeStubInterface) (*maxSupplyProposal, error) {
+	proposalBytes, err := APIstub.GetState(maxSupplyProposalKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", maxSupplyProposalKey, err)
+	}
+	if proposalBytes == nil {
+		return nil, nil
+	}
+
+	var proposal maxSupplyProposal
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// emitMaxSupplyEvent emits `name` describing a supply cap change
+func emitMaxSupplyEvent(APIstub shim.ChaincodeStubInterface, name string, proposal maxSupplyProposal) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	currentCap, err := getMaxSupply(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData := maxSupplyEvent{Token: symbol, CurrentCap: currentCap, NewCap: proposal.NewCap, EffectiveAt: proposal.EffectiveAt}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for receive-side memo requirements
+const memoRequiredPrefix = "memoRequired"
+
+// SetMemoRequired makes Transfer and TransferFrom to the caller's account require a memo ("true") or not ("false")
+// Exchanges use it so deposits always carry the tag that identifies the customer.
+func (s *SmartContract) SetMemoRequired(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	required, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Invalid flag. Expecting true or false")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requiredKey, err := buildKey(APIstub, memoRequiredPrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !required {
+		err = APIstub.DelState(requiredKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", memoRequiredPrefix, err).Error())
+		}
+		return shim.Success(nil)
+	}
+	err = APIstub.PutState(requiredKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", memoRequiredPrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// MemoRequired returns "true" if transfers to `account` need a memo, otherwise "false"
+func (s *SmartContract) MemoRequired(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	required, err := isMemoRequired(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.FormatBool(required)))
+}
+
+// checkMemo returns an error if `to` requires a memo and `memo` is empty
+// Only Transfer and TransferFrom check it; mints and other credits are not deposits.
+func checkMemo(APIstub shim.ChaincodeStubInterface, to string, memo string) error {
+	if memo != "" {
+		return nil
+	}
+	required, err := isMemoRequired(APIstub, to)
+	if err != nil {
+		return err
+	}
+	if required {
+		return newCodedError(APIstub, errMemoRequired, map[string]string{"account": to}, "transfers to %s must include a memo", to)
+	}
+	return nil
+}
+
+// isMemoRequired reports whether transfers to `account` need a memo
+func isMemoRequired(APIstub shim.ChaincodeStubInterface, account string) (bool, error) {
+	requiredKey, err := buildKey(APIstub, memoRequiredPrefix, []string{account})
+	if err != nil {
+		return false, err
+	}
+	requiredBytes, err := APIstub.GetState(requiredKey)
+	if err != nil {
+		return false, stateError(APIstub, "GetState", memoRequiredPrefix, err)
+	}
+	return requiredBytes != nil, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Define key names for minter options
+const minterOrgKey = "minterOrg"
+
+// checkMinter returns ERR_UNAUTHORIZED unless the client may mint
+// With a minterOrg set at Initialize, only clients of that MSP can mint. Otherwise only holders of
+// the minter or admin role can.
+func checkMinter(APIstub shim.ChaincodeStubInterface) error {
+	minterOrg, err := getSetting(APIstub, minterOrgKey)
+	if err != nil {
+		return err
+	}
+	if minterOrg != "" {
+		callerMSP, err := getClientMSP(APIstub)
+		if err != nil {
+			return err
+		}
+		if callerMSP != minterOrg {
+			return newCodedError(APIstub, errUnauthorized, map[string]string{"minterOrg": minterOrg}, "only clients of MSP %s can mint", minterOrg)
+		}
+		return nil
+	}
+
+	return requireAnyRole(APIstub, minterRole, adminRole)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for minter sessions
+const minterSessionPrefix = "minterSession"
+
+// Define minter session statuses
+const sessionOpen = "open"
+const sessionClosed = "closed"
+const sessionExpired = "expired"
+
+// minterSession is a temporary, capped grant of minting power to one client identity
+// Sessions are independent of checkMinter: the session client needs no standing minter power.
+type minterSession struct {
+	ID        string `json:"id"`
+	Client    string `json:"client"`
+	MaxAmount int    `json:"maxAmount"`
+	Minted    int    `json:"minted"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Status    string `json:"status"`
+}
+
+// minterSessionEvent provides an organized struct for emitting minter session events
+type minterSessionEvent struct {
+	Token string `json:"token"`
+	minterSession
+}
+
+// minterSessionResponse is the JSON document returned by GetMinterSession
+type minterSessionResponse struct {
+	Token string `json:"token"`
+	minterSession
+	Remaining int `json:"remaining"`
+}
+
+// CreateMinterSession lets `client` mint up to `maxAmount` tokens for the next `expirySeconds`
+// Only admins can create sessions. The new session ID is returned as payload.
+// This function triggers a MinterSessionCreated event
+func (s *SmartContract) CreateMinterSession(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	client := args[0]
+	if client == "" {
+		return shim.Error("Client must be a non-empty string")
+	}
+	maxAmount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expirySeconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || expirySeconds <= 0 {
+		return shim.Error("Invalid expiry. Expecting a positive number of seconds")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	id, err := newDeterministicID(APIstub, minterSessionPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	session := minterSession{
+		ID:        id,
+		Client:    client,
+		MaxAmount: maxAmount,
+		ExpiresAt: now + expirySeconds,
+		Status:    sessionOpen,
+	}
+	err = putMinterSession(APIstub, session)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitMinterSessionEvent(APIstub, "MinterSessionCreated", session)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success([]byte(session.ID))
+}
+
+// MintWithSession mints `amount` tokens into `account` under an open minter session
+// Only the session client can use it, and the session's minted total cannot exceed its cap.
+// Mint pauses, the supply cap and balance caps apply as they do to Mint.
+// This function triggers a Transfer event, or a SupplyAlarm event if the mint trips the supply alarm
+func (s *SmartContract) MintWithSession(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	account := args[1]
+	amount, err := parsePositiveAmount(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	session, err := getOpenMinterSession(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != session.Client {
+		return shim.Error(newCodedError(APIstub, errUnauthorized, map[string]string{"session": session.ID}, "caller is not the client of minter session %s", session.ID).Error())
+	}
+	if remaining := session.MaxAmount - session.Minted; amount > remaining {
+		params := map[string]string{"session": session.ID, "remaining": strconv.Itoa(remaining)}
+		return shim.Error(newCodedError(APIstub, errSessionCapExceeded, params, "minter session %s can mint %d more tokens", session.ID, remaining).Error())
+	}
+
+	err = checkMintPaused(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMaxSupply(APIstub, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	credited, err := mintBalance(APIstub, account, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	session.Minted += credited
+	err = putMinterSession(APIstub, *session)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitMint(APIstub, event{To: account, Value: credited, Spillover: amount - credited, MinterSession: session.ID})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CloseMinterSession ends an open minter session before its expiry
+// Only admins can close sessions.
+// This function triggers a MinterSessionClosed event
+func (s *SmartContract) CloseMinterSession(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	session, err := getOpenMinterSession(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	session.Status = sessionClosed
+	err = putMinterSession(APIstub, *session)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitMinterSessionEvent(APIstub, "MinterSessionClosed", *session)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetMinterSession returns the minter session with the given ID and what it can still mint
+// An open session past its expiry is reported with the expired status.
+func (s *SmartContract) GetMinterSession(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	session, err := getMinterSession(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if session == nil {
+		return shim.Error("Minter session not found")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	session.Status = session.effectiveStatus(now)
+	remaining := 0
+	if session.Status == sessionOpen {
+		remaining = session.MaxAmount - session.Minted
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sessionBytes, err := json.Marshal(minterSessionResponse{Token: symbol, minterSession: *session, Remaining: remaining})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(sessionBytes)
+}
+
+// effectiveStatus returns the status of the session at `now`, taking expiry into account
+func (m minterSession) effectiveStatus(now int64) string {
+	if m.Status == sessionOpen && now >= m.ExpiresAt {
+		return sessionExpired
+	}
+	return m.Status
+}
+
+// getOpenMinterSession returns the session with the given ID if it can still be used
+func getOpenMinterSession(APIstub shim.ChaincodeStubInterface, id string) (*minterSession, error) {
+	session, err := getMinterSession(APIstub, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("Minter session not found")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	status := session.effectiveStatus(now)
+	if status != sessionOpen {
+		return nil, fmt.Errorf("Minter session is %s", status)
+	}
+	return session, nil
+}
+
+// getMinterSession returns the stored minter session, or nil if there is none
+func getMinterSession(APIstub shim.ChaincodeStubInterface, id string) (*minterSession, error) {
+	sessionKey, err := buildKey(APIstub, minterSessionPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	sessionBytes, err := APIstub.GetState(sessionKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", minterSessionPrefix, err)
+	}
+	if sessionBytes == nil {
+		return nil, nil
+	}
+
+	var session minterSession
+	err = json.Unmarshal(sessionBytes, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// putMinterSession stores `session` under its ID
+func putMinterSession(APIstub shim.ChaincodeStubInterface, session minterSession) error {
+	sessionKey, err := buildKey(APIstub, minterSessionPrefix, []string{session.ID})
+	if err != nil {
+		return err
+	}
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(sessionKey, sessionBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", minterSessionPrefix, err)
+	}
+	return nil
+}
+
+// emitMinterSessionEvent emits `name` with the state of the session, labelled with the token symbol
+func emitMinterSessionEvent(APIstub shim.ChaincodeStubInterface, name string, session minterSession) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(minterSessionEvent{Token: symbol, minterSession: session})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
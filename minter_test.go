@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestMintRequiresMinter(t *testing.T) {
+	attributeMinter := chaintest.NewIdentity("Org2MSP", "dave", chaintest.WithAttrs(map[string]string{"role": minterRole}))
+	tests := []struct {
+		name    string
+		options string
+		minter  string
+		caller  chaintest.Identity
+		allowed bool
+	}{
+		{"admin", "", "", admin, true},
+		{"minter role", "", alice.Account, alice, true},
+		{"no role", "", "", bob, false},
+		{"role=minter attribute", "", "", attributeMinter, false},
+		{"minter role of another client", "", alice.Account, bob, false},
+		{"client of the minter organization", `{"minterOrg":"Org1MSP"}`, "", alice, true},
+		{"minter role outside the minter organization", `{"minterOrg":"Org1MSP"}`, bob.Account, bob, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, test.options)
+			if test.minter != "" {
+				mustInvoke(t, ledger, admin, "GrantRole", minterRole, test.minter)
+			}
+
+			result := ledger.Invoke(test.caller, "Mint", carol.Account, "100")
+			if test.allowed != (result.Status == shim.OK) {
+				t.Fatalf("Mint returned status %d and %q, expected it to succeed: %t", result.Status, result.Message, test.allowed)
+			}
+			if !test.allowed && !strings.HasPrefix(result.Message, errUnauthorized) {
+				t.Fatalf("Mint failed with %q, expected %s", result.Message, errUnauthorized)
+			}
+			expected := 0
+			if test.allowed {
+				expected = 100
+			}
+			if got := balanceOf(t, ledger, carol.Account); got != expected {
+				t.Fatalf("balance is %d after the mint, expected %d", got, expected)
+			}
+		})
+	}
+}
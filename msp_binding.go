@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for MSP bindings
+const mspBindingPrefix = "mspBinding"
+
+// mspBindingEvent provides an organized struct for emitting MSP binding events
+type mspBindingEvent struct {
+	Token   string `json:"token"`
+	Account string `json:"account"`
+	MSPID   string `json:"mspId"`
+}
+
+// BindAccountToMSP restricts the caller's account to identities issued by `mspID`
+// The caller must itself belong to `mspID`, so an account cannot be bound out of its owner's reach.
+// This function triggers an AccountBoundToMSP event
+func (s *SmartContract) BindAccountToMSP(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	mspID := args[0]
+	if mspID == "" {
+		return shim.Error("MSP ID must be a non-empty string")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	callerMSP, err := getClientMSP(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if callerMSP != mspID {
+		return shim.Error("ERR_MSP_MISMATCH: an account can only be bound to the caller's own MSP")
+	}
+	// Rebinding is only possible from the currently bound MSP
+	err = checkMSPBinding(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bindingKey, err := buildKey(APIstub, mspBindingPrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(bindingKey, []byte(mspID))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", mspBindingPrefix, err).Error())
+	}
+
+	err = emitMSPBindingEvent(APIstub, "AccountBoundToMSP", account, mspID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// UnbindAccountFromMSP removes the MSP binding of the caller's account
+// Only an identity from the bound MSP can unbind.
+// This function triggers an AccountUnboundFromMSP event
+func (s *SmartContract) UnbindAccountFromMSP(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mspID, err := getMSPBinding(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mspID == "" {
+		return shim.Error("Account is not bound to an MSP")
+	}
+	err = checkMSPBinding(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bindingKey, err := buildKey(APIstub, mspBindingPrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(bindingKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", mspBindingPrefix, err).Error())
+	}
+
+	err = emitMSPBindingEvent(APIstub, "AccountUnboundFromMSP", account, mspID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkMSPBinding returns ERR_MSP_MISMATCH if `account` is bound to an MSP other than the caller's
+// Every path that debits an account on its owner's behalf calls this first.
+func checkMSPBinding(APIstub shim.ChaincodeStubInterface, account string) error {
+	mspID, err := getMSPBinding(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if mspID == "" {
+		return nil
+	}
+	callerMSP, err := getClientMSP(APIstub)
+	if err != nil {
+		return err
+	}
+	if callerMSP != mspID {
+		return fmt.Errorf("ERR_MSP_MISMATCH: account is bound to MSP %s", mspID)
+	}
+	return nil
+}
+
+// getMSPBinding returns the MSP `account` is bound to, or "" if it is not bound
+func getMSPBinding(APIstub shim.ChaincodeStubInterface, account string) (string, error) {
+	bindingKey, err := buildKey(APIstub, mspBindingPrefix, []string{account})
+	if err != nil {
+		return "", err
+	}
+	bindingBytes, err := APIstub.GetState(bindingKey)
+	if err != nil {
+		return "", stateError(APIstub, "GetState", mspBindingPrefix, err)
+	}
+	return string(bindingBytes), nil
+}
+
+// emitMSPBindingEvent emits `name` for a change of the MSP binding of `account`
+func emitMSPBindingEvent(APIstub shim.ChaincodeStubInterface, name string, account string, mspID string) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(mspBindingEvent{Token: symbol, Account: account, MSPID: mspID})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for pausing the contract
+const pausedKey = "paused"
+
+// pauseExemptFunctions are the invoke functions that still run while the contract is paused
+// Admins can lift the pause, replace the roles of a compromised identity and lift a mistaken
+// freeze in the meantime, so a pause never locks them out.
+var pauseExemptFunctions = map[string]bool{
+	"Pause":           true,
+	"Unpause":         true,
+	"GrantRole":       true,
+	"RevokeRole":      true,
+	"UnfreezeAccount": true,
+}
+
+// pauseEvent provides an organized struct for emitting the Paused and Unpaused events
+type pauseEvent struct {
+	Token   string `json:"token"`
+	Account string `json:"account"`
+}
+
+// Pause makes every invoke function except those in pauseExemptFunctions fail with ERR_CONTRACT_PAUSED
+// Queries keep working. Only pausers and admins can pause the contract.
+// This function triggers a Paused event
+func (s *SmartContract) Pause(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return setPaused(APIstub, args, true)
+}
+
+// Unpause lets invoke functions run again after Pause
+// Only pausers and admins can unpause the contract.
+// This function triggers an Unpaused event
+func (s *SmartContract) Unpause(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	return setPaused(APIstub, args, false)
+}
+
+// setPaused pauses or unpauses the contract for Pause and Unpause
+func setPaused(APIstub shim.ChaincodeStubInterface, args []string, pause bool) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	err := requireAnyRole(APIstub, pauserRole, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	paused, err := isPaused(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if paused == pause {
+		if pause {
+			return shim.Error("Contract is already paused")
+		}
+		return shim.Error("Contract is not paused")
+	}
+
+	eventName := "Unpaused"
+	if pause {
+		eventName = "Paused"
+		err = APIstub.PutState(pausedKey, []byte("true"))
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", pausedKey, err).Error())
+		}
+	} else {
+		err = APIstub.DelState(pausedKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", pausedKey, err).Error())
+		}
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventBytes, err := json.Marshal(pauseEvent{Token: symbol, Account: clientID})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent(eventName, eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// isPaused reports whether the contract is paused
+func isPaused(APIstub shim.ChaincodeStubInterface) (bool, error) {
+	pausedBytes, err := APIstub.GetState(pausedKey)
+	if err != nil {
+		return false, stateError(APIstub, "GetState", pausedKey, err)
+	}
+	return pausedBytes != nil, nil
+}
+
+// checkNotPaused returns ERR_CONTRACT_PAUSED if the contract is paused and `function` is not exempt
+func checkNotPaused(APIstub shim.ChaincodeStubInterface, function string) error {
+	if pauseExemptFunctions[function] {
+		return nil
+	}
+	paused, err := isPaused(APIstub)
+	if err != nil {
+		return err
+	}
+	if paused {
+		return newCodedError(APIstub, errContractPaused, map[string]string{"function": function}, "contract is paused")
+	}
+	return nil
+}
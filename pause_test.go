@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+func TestPauseStopsMovements(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "50")
+	mustInvoke(t, ledger, admin, "Pause")
+
+	tests := []struct {
+		caller chaintest.Identity
+		call   []string
+	}{
+		{alice, []string{"Transfer", bob.Account, "10"}},
+		{bob, []string{"TransferFrom", alice.Account, bob.Account, "10"}},
+		{admin, []string{"Mint", alice.Account, "10"}},
+		{alice, []string{"Burn", "10"}},
+	}
+	for _, test := range tests {
+		message := mustFail(t, ledger, test.caller, test.call[0], test.call[1:]...)
+		if !strings.HasPrefix(message, errContractPaused) {
+			t.Fatalf("%s of a paused contract failed with %q, expected %s", test.call[0], message, errContractPaused)
+		}
+	}
+	if got := balanceOf(t, ledger, alice.Account); got != 100 {
+		t.Fatalf("balance is %d after movements of a paused contract, expected 100", got)
+	}
+
+	mustInvoke(t, ledger, admin, "Unpause")
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+}
+
+func TestPauseKeepsAdminsInControl(t *testing.T) {
+	ledger := newToken(t, "")
+	mustInvoke(t, ledger, admin, "GrantRole", pauserRole, alice.Account)
+	mustInvoke(t, ledger, alice, "Pause")
+
+	// The pauser and the only admin turn out to be compromised; both are replaced during the pause
+	mustInvoke(t, ledger, admin, "GrantRole", adminRole, carol.Account)
+	mustInvoke(t, ledger, carol, "RevokeRole", pauserRole, alice.Account)
+	mustInvoke(t, ledger, carol, "RevokeRole", adminRole, admin.Account)
+	mustInvoke(t, ledger, carol, "GrantRole", pauserRole, bob.Account)
+
+	mustFail(t, ledger, admin, "Unpause")
+	mustFail(t, ledger, alice, "Unpause")
+	mustInvoke(t, ledger, bob, "Unpause")
+}
+
+func TestPauseAllowsUnfreeze(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, admin, "GrantRole", complianceRole, admin.Account)
+	mustInvoke(t, ledger, admin, "OpenCase", "case-1", "court order")
+	mustInvoke(t, ledger, admin, "FreezeAccount", alice.Account, "case-1")
+	mustInvoke(t, ledger, admin, "Pause")
+
+	// A freeze made by mistake can be lifted before the contract resumes
+	mustInvoke(t, ledger, admin, "UnfreezeAccount", alice.Account, "case-1")
+	mustInvoke(t, ledger, admin, "Unpause")
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+}
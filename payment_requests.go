@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for payment requests
+const paymentRequestPrefix = "paymentRequest"
+const paymentRequestByPayeePrefix = "paymentRequestByPayee"
+
+// Define payment request statuses
+const requestOpen = "open"
+const requestPaid = "paid"
+const requestCancelled = "cancelled"
+const requestExpired = "expired"
+
+// paymentRequest is an on-chain invoice created by a payee and fulfilled by reference
+type paymentRequest struct {
+	ID        string `json:"id"`
+	Payee     string `json:"payee"`
+	Amount    int    `json:"amount"`
+	Memo      string `json:"memo"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Status    string `json:"status"`
+	Payer     string `json:"payer,omitempty"`
+	PaidTxID  string `json:"paidTxId,omitempty"`
+}
+
+// paymentRequestEvent provides an organized struct for emitting payment request events
+type paymentRequestEvent struct {
+	Token  string `json:"token"`
+	ID     string `json:"id"`
+	Payee  string `json:"payee"`
+	Payer  string `json:"payer,omitempty"`
+	Amount int    `json:"amount"`
+	Status string `json:"status"`
+}
+
+// paymentRequestResponse is the JSON document returned by GetPaymentRequest
+type paymentRequestResponse struct {
+	Token string `json:"token"`
+	paymentRequest
+}
+
+// paymentRequestsResponse is the JSON document returned by ListMyRequests
+type paymentRequestsResponse struct {
+	Token    string           `json:"token"`
+	Requests []paymentRequest `json:"requests"`
+}
+
+// CreatePaymentRequest creates a request for the caller to be paid `amount` before `expiryTs`
+// The new request ID is returned as payload.
+// This function triggers a PaymentRequestCreated event
+func (s *SmartContract) CreatePaymentRequest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	amount, err := parsePositiveAmount(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	memo, err := sanitizeText("memo", args[1], maxMemoLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiresAt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid expiry. Expecting a unix timestamp in seconds")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiresAt <= now {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	payee, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	id, err := newDeterministicID(APIstub, paymentRequestPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	request := paymentRequest{
+		ID:        id,
+		Payee:     payee,
+		Amount:    amount,
+		Memo:      memo,
+		ExpiresAt: expiresAt,
+		Status:    requestOpen,
+	}
+
+	err = putPaymentRequest(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := buildKey(APIstub, paymentRequestByPayeePrefix, []string{payee, request.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", paymentRequestByPayeePrefix, err).Error())
+	}
+
+	err = emitPaymentRequestEvent(APIstub, "PaymentRequestCreated", request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(request.ID))
+}
+
+// PayRequest pays the exact amount of an open payment request from the caller's account to the payee
+// This function triggers a PaymentRequestPaid event
+func (s *SmartContract) PayRequest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getOpenPaymentRequest(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payer, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, payer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// A request is paid in full or not at all, so a capped payee cannot take part of it
+	credited, err := transferBalance(APIstub, payer, request.Payee, request.Amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if credited != request.Amount {
+		return shim.Error(balanceCapExceeded(APIstub, request.Payee, credited).Error())
+	}
+
+	request.Status = requestPaid
+	request.Payer = payer
+	request.PaidTxID = APIstub.GetTxID()
+	err = putPaymentRequest(APIstub, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitPaymentRequestEvent(APIstub, "PaymentRequestPaid", *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CancelRequest cancels an open payment request; only the payee can cancel
+// This function triggers a PaymentRequestCancelled event
+func (s *SmartContract) CancelRequest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getPaymentRequest(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request == nil {
+		return shim.Error("Payment request not found")
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != request.Payee {
+		return shim.Error("Only the payee can cancel a payment request")
+	}
+	if request.Status != requestOpen {
+		return shim.Error(fmt.Sprintf("Payment request is %s", request.Status))
+	}
+
+	request.Status = requestCancelled
+	err = putPaymentRequest(APIstub, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitPaymentRequestEvent(APIstub, "PaymentRequestCancelled", *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetPaymentRequest returns the payment request with the given ID
+// An open request past its expiry is reported with the expired status.
+func (s *SmartContract) GetPaymentRequest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getPaymentRequest(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request == nil {
+		return shim.Error("Payment request not found")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	request.Status = request.effectiveStatus(now)
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requestBytes, err := json.Marshal(paymentRequestResponse{Token: symbol, paymentRequest: *request})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(requestBytes)
+}
+
+// ListMyRequests returns the caller's payment requests with the given status,
+// or all of them when the status is empty
+func (s *SmartContract) ListMyRequests(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	status := args[0]
+	switch status {
+	case "", requestOpen, requestPaid, requestCancelled, requestExpired:
+	default:
+		return shim.Error("Invalid status. Expecting open, paid, cancelled, expired or empty")
+	}
+
+	payee, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requests := []paymentRequest{}
+	_, err = iterate(APIstub, paymentRequestByPayeePrefix, []string{payee}, 0, "", func(attributes []string, value []byte) error {
+		request, err := getPaymentRequest(APIstub, attributes[1])
+		if err != nil || request == nil {
+			return err
+		}
+		request.Status = request.effectiveStatus(now)
+		if status == "" || request.Status == status {
+			requests = append(requests, *request)
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	requestsBytes, err := json.Marshal(paymentRequestsResponse{Token: symbol, Requests: requests})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(requestsBytes)
+}
+
+// effectiveStatus returns the status of the request at `now`, taking expiry into account
+func (r paymentRequest) effectiveStatus(now int64) string {
+	if r.Status == requestOpen && now >= r.ExpiresAt {
+		return requestExpired
+	}
+	return r.Status
+}
+
+// getOpenPaymentRequest returns the request with the given ID if it can still be paid
+func getOpenPaymentRequest(APIstub shim.ChaincodeStubInterface, id string) (*paymentRequest, error) {
+	request, err := getPaymentRequest(APIstub, id)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, fmt.Errorf("Payment request not found")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	status := request.effectiveStatus(now)
+	if status != requestOpen {
+		return nil, fmt.Errorf("Payment request is %s", status)
+	}
+	return request, nil
+}
+
+// getPaymentRequest returns the stored payment request, or nil if there is none
+func getPaymentRequest(APIstub shim.ChaincodeStubInterface, id string) (*paymentRequest, error) {
+	requestKey, err := buildKey(APIstub, paymentRequestPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	requestBytes, err := APIstub.GetState(requestKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", paymentRequestPrefix, err)
+	}
+	if requestBytes == nil {
+		return nil, nil
+	}
+
+	var request paymentRequest
+	err = json.Unmarshal(requestBytes, &request)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// putPaymentRequest stores the payment request under its ID
+func putPaymentRequest(APIstub shim.ChaincodeStubInterface, request paymentRequest) error {
+	requestKey, err := buildKey(APIstub, paymentRequestPrefix, []string{request.ID})
+	if err != nil {
+		return err
+	}
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(requestKey, requestBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", paymentRequestPrefix, err)
+	}
+	return nil
+}
+
+// emitPaymentRequestEvent emits `name` describing the current state of the request
+func emitPaymentRequestEvent(APIstub shim.ChaincodeStubInterface, name string, request paymentRequest) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData := paymentRequestEvent{
+		Token:  symbol,
+		ID:     request.ID,
+		Payee:  request.Payee,
+		Payer:  request.Payer,
+		Amount: request.Amount,
+		Status: request.Status,
+	}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
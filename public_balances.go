@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for balance privacy options
+const privateBalancesKey = "privateBalances"
+
+// Define objectType names for public balances
+const publicBalancePrefix = "publicBalance"
+
+// publicBalance is the balance of an account that opted in to public disclosure
+type publicBalance struct {
+	Account string `json:"account"`
+	Balance int    `json:"balance"`
+}
+
+// publicBalancesResponse is the JSON document returned by ListPublicBalances
+type publicBalancesResponse struct {
+	Token    string          `json:"token"`
+	Balances []publicBalance `json:"balances"`
+	Bookmark string          `json:"bookmark"`
+}
+
+// SetPublicBalance lets anyone read the caller's balance when `public` is "true", even with privateBalances
+// Passing "false" makes the balance private again.
+func (s *SmartContract) SetPublicBalance(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	public, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Invalid flag. Expecting true or false")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	publicKey, err := buildKey(APIstub, publicBalancePrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if public {
+		err = APIstub.PutState(publicKey, []byte{0x00})
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", publicBalancePrefix, err).Error())
+		}
+	} else {
+		err = APIstub.DelState(publicKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", publicBalancePrefix, err).Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// ListPublicBalances returns the balances of the accounts that opted in with SetPublicBalance
+// Results are paginated: pass the returned bookmark to continue.
+func (s *SmartContract) ListPublicBalances(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return shim.Error(fmt.Sprintf("Invalid limit. Expecting a number between 1 and %d", maxPageSize))
+	}
+	bookmark := args[1]
+
+	balances := []publicBalance{}
+	bookmark, err = iterate(APIstub, publicBalancePrefix, []string{}, limit, bookmark, func(attributes []string, value []byte) error {
+		balance, _, err := getBalance(APIstub, attributes[0])
+		if err != nil {
+			return err
+		}
+		balances = append(balances, publicBalance{Account: attributes[0], Balance: balance})
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(publicBalancesResponse{Token: symbol, Balances: balances, Bookmark: bookmark})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// checkBalanceReadable returns ERR_UNAUTHORIZED if the token has privateBalances and the caller
+// is neither the owner of `account` nor an auditor, unless the account made its balance public
+func checkBalanceReadable(APIstub shim.ChaincodeStubInterface, account string) error {
+	private, err := getBoolSetting(APIstub, privateBalancesKey)
+	if err != nil || !private {
+		return err
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return err
+	}
+	if clientID == account {
+		return nil
+	}
+	public, err := isPublicBalance(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if public {
+		return nil
+	}
+	auditor, err := hasRole(APIstub, auditorRole, clientID)
+	if err != nil {
+		return err
+	}
+	if !auditor {
+		return newCodedError(APIstub, errUnauthorized, map[string]string{"account": account}, "the balance of %s is private", account)
+	}
+	return nil
+}
+
+// isPublicBalance reports whether `account` opted in to public balance disclosure
+func isPublicBalance(APIstub shim.ChaincodeStubInterface, account string) (bool, error) {
+	publicKey, err := buildKey(APIstub, publicBalancePrefix, []string{account})
+	if err != nil {
+		return false, err
+	}
+	publicBytes, err := APIstub.GetState(publicKey)
+	if err != nil {
+		return false, stateError(APIstub, "GetState", publicBalancePrefix, err)
+	}
+	return publicBytes != nil, nil
+}
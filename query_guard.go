@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// writeInQuery is the panic value raised when a query attempts a write
+type writeInQuery struct {
+	operation string
+	key       string
+}
+
+// readOnlyStub is handed to query functions in place of the real stub
+// Every operation that would add to the write set or emit an event panics.
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+}
+
+// PutState panics: queries must not write state
+func (r readOnlyStub) PutState(key string, value []byte) error {
+	panic(writeInQuery{operation: "PutState", key: key})
+}
+
+// DelState panics: queries must not delete state
+func (r readOnlyStub) DelState(key string) error {
+	panic(writeInQuery{operation: "DelState", key: key})
+}
+
+// PutPrivateData panics: queries must not write private data
+func (r readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	panic(writeInQuery{operation: "PutPrivateData", key: key})
+}
+
+// DelPrivateData panics: queries must not delete private data
+func (r readOnlyStub) DelPrivateData(collection string, key string) error {
+	panic(writeInQuery{operation: "DelPrivateData", key: key})
+}
+
+// SetEvent panics: queries must not emit events
+func (r readOnlyStub) SetEvent(name string, payload []byte) error {
+	panic(writeInQuery{operation: "SetEvent", key: name})
+}
+
+// queryOnly runs `query` against a read-only view of the stub
+// A write attempted by the query is converted to an ERR_WRITE_IN_QUERY error
+// instead of silently becoming part of an endorsed write set.
+func queryOnly(APIstub shim.ChaincodeStubInterface, args []string, query func(shim.ChaincodeStubInterface, []string) peer.Response) (response peer.Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			write, ok := r.(writeInQuery)
+			if !ok {
+				panic(r)
+			}
+			response = shim.Error(fmt.Sprintf("ERR_WRITE_IN_QUERY: query attempted %s on %q", write.operation, write.key))
+		}
+	}()
+
+	return query(readOnlyStub{APIstub}, args)
+}
+
+// isReadOnly reports whether the stub belongs to a query run through queryOnly
+func isReadOnly(APIstub shim.ChaincodeStubInterface) bool {
+	_, readOnly := APIstub.(readOnlyStub)
+	return readOnly
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for receiver registration
+const receiverPrefix = "receiver"
+
+// receiverAccountPrefix prefixes the account of a receiver chaincode
+const receiverAccountPrefix = "chaincode:"
+
+// Define the notifications a receiver chaincode gets
+const receiverPing = "ping"
+const receiverTransfer = "transfer"
+const receiverApproval = "approval"
+
+// receiver is a chaincode that declared it handles TransferAndCall and ApproveAndCall
+// Its tokens are held by the account "chaincode:<name>".
+type receiver struct {
+	ChaincodeName string `json:"chaincodeName"`
+	FunctionName  string `json:"functionName"`
+	RegisteredBy  string `json:"registeredBy"`
+	RegisteredAt  int64  `json:"registeredAt"`
+	TxID          string `json:"txId"`
+}
+
+// receiverEvent provides an organized struct for emitting receiver events
+type receiverEvent struct {
+	Token   string `json:"token"`
+	Account string `json:"account"`
+	receiver
+}
+
+// receiverAccount returns the account of the receiver chaincode `chaincodeName`
+func receiverAccount(chaincodeName string) string {
+	return receiverAccountPrefix + chaincodeName
+}
+
+// RegisterReceiver declares that the chaincode `chaincodeName` handles TransferAndCall and
+// ApproveAndCall through its function `functionName`; only admins can register receivers
+// The function is called with "ping" first and must succeed. It is then called with "transfer",
+// the sender, the amount and the data of every TransferAndCall to the chaincode's account, and
+// with "approval", the owner, the amount and the data of every ApproveAndCall. Registering a
+// chaincode again replaces its function.
+// This function triggers a ReceiverRegistered event
+func (s *SmartContract) RegisterReceiver(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	chaincodeName := args[0]
+	if chaincodeName == "" {
+		return shim.Error("Chaincode name must be a non-empty string")
+	}
+	functionName := args[1]
+	if functionName == "" {
+		return shim.Error("Function name must be a non-empty string")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// A chaincode that cannot answer the ping would leave the tokens sent to it stuck
+	r := receiver{
+		ChaincodeName: chaincodeName,
+		FunctionName:  functionName,
+		RegisteredBy:  clientID,
+		RegisteredAt:  now,
+		TxID:          APIstub.GetTxID(),
+	}
+	err = notifyReceiver(APIstub, r, receiverPing)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	receiverKey, err := buildKey(APIstub, receiverPrefix, []string{chaincodeName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	receiverBytes, err := json.Marshal(r)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(receiverKey, receiverBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", receiverPrefix, err).Error())
+	}
+
+	err = emitReceiverEvent(APIstub, "ReceiverRegistered", r)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// DeregisterReceiver removes the chaincode `chaincodeName` from the receivers
+// Tokens already held by its account stay there. Only admins can deregister receivers.
+// This function triggers a ReceiverDeregistered event
+func (s *SmartContract) DeregisterReceiver(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	r, err := getReceiver(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if r == nil {
+		return shim.Error("Receiver not found")
+	}
+
+	receiverKey, err := buildKey(APIstub, receiverPrefix, []string{r.ChaincodeName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(receiverKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", receiverPrefix, err).Error())
+	}
+
+	err = emitReceiverEvent(APIstub, "ReceiverDeregistered", *r)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// TransferAndCall transfers `amount` tokens to the account of the receiver chaincode `chaincodeName`
+// and notifies it with `data`; the transfer fails if the receiver is not registered or rejects it
+// This function triggers a Transfer event
+func (s *SmartContract) TransferAndCall(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	amount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var data string
+	if len(args) == 3 {
+		data = args[2]
+	}
+
+	r, err := requireReceiver(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	from, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	to := receiverAccount(r.ChaincodeName)
+	credited, err := transferBalance(APIstub, from, to, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = notifyReceiver(APIstub, *r, receiverTransfer, from, strconv.Itoa(credited), data)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitTransfer(APIstub, event{From: from, To: to, Value: credited, Spillover: amount - credited})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ApproveAndCall sets the allowance of the receiver chaincode `chaincodeName` over the caller's
+// account to `amount` and notifies it with `data`; it fails if the receiver is not registered or rejects it
+// This function triggers an Approval event
+func (s *SmartContract) ApproveAndCall(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	amount, err := parseNonNegativeAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var data string
+	if len(args) == 3 {
+		data = args[2]
+	}
+
+	r, err := requireReceiver(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	spender := receiverAccount(r.ChaincodeName)
+	err = setAllowance(APIstub, owner, spender, amount, "", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = notifyReceiver(APIstub, *r, receiverApproval, owner, strconv.Itoa(amount), data)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitApproval(APIstub, owner, spender, amount, "", "")
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getReceiver returns the registered receiver chaincode `chaincodeName`, or nil if it is not registered
+func getReceiver(APIstub shim.ChaincodeStubInterface, chaincodeName string) (*receiver, error) {
+	receiverKey, err := buildKey(APIstub, receiverPrefix, []string{chaincodeName})
+	if err != nil {
+		return nil, err
+	}
+	receiverBytes, err := APIstub.GetState(receiverKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", receiverPrefix, err)
+	}
+	if receiverBytes == nil {
+		return nil, nil
+	}
+	var r receiver
+	err = json.Unmarshal(receiverBytes, &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// requireReceiver returns the registered receiver chaincode `chaincodeName`, or an error if it is not registered
+func requireReceiver(APIstub shim.ChaincodeStubInterface, chaincodeName string) (*receiver, error) {
+	r, err := getReceiver(APIstub, chaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, fmt.Errorf("Chaincode %s is not a registered receiver", chaincodeName)
+	}
+	return r, nil
+}
+
+// getReceiverOfAccount returns the registered receiver chaincode holding `account`, or nil if there is none
+func getReceiverOfAccount(APIstub shim.ChaincodeStubInterface, account string) (*receiver, error) {
+	if !strings.HasPrefix(account, receiverAccountPrefix) {
+		return nil, nil
+	}
+	return getReceiver(APIstub, strings.TrimPrefix(account, receiverAccountPrefix))
+}
+
+// notifyReceiver calls the function of the receiver `r` on this channel with `notification` and `args`
+// An error response of the receiver is returned as an error, which fails the transaction.
+func notifyReceiver(APIstub shim.ChaincodeStubInterface, r receiver, notification string, args ...string) error {
+	callArgs := [][]byte{[]byte(r.FunctionName), []byte(notification)}
+	for _, arg := range args {
+		callArgs = append(callArgs, []byte(arg))
+	}
+	response := APIstub.InvokeChaincode(r.ChaincodeName, callArgs, "")
+	if response.Status != shim.OK {
+		return fmt.Errorf("Receiver %s rejected %s: %s", r.ChaincodeName, notification, response.Message)
+	}
+	return nil
+}
+
+// emitReceiverEvent emits the receiver event `name` for `r`
+func emitReceiverEvent(APIstub shim.ChaincodeStubInterface, name string, r receiver) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(receiverEvent{Token: symbol, Account: receiverAccount(r.ChaincodeName), receiver: r})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// mockReceiver is a receiver chaincode that records its notifications and rejects those listed in reject
+type mockReceiver struct {
+	reject        map[string]bool
+	notifications *[][]string
+}
+
+func (r mockReceiver) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (r mockReceiver) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	args := stub.GetStringArgs()
+	if len(args) < 2 || args[0] != "onToken" {
+		return shim.Error("unexpected call")
+	}
+	if r.reject[args[1]] {
+		return shim.Error("not accepted")
+	}
+	*r.notifications = append(*r.notifications, args[1:])
+	return shim.Success(nil)
+}
+
+// newReceiverToken returns a token where alice holds 100 and the chaincode "hook" is installed
+// as a mockReceiver rejecting the notifications in `reject`; it also returns its notifications
+func newReceiverToken(t *testing.T, reject ...string) (*chaintest.Ledger, *[][]string) {
+	t.Helper()
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	notifications := &[][]string{}
+	hook := mockReceiver{reject: map[string]bool{}, notifications: notifications}
+	for _, notification := range reject {
+		hook.reject[notification] = true
+	}
+	ledger.PeerChaincode("hook", hook)
+	return ledger, notifications
+}
+
+func TestRegisterReceiver(t *testing.T) {
+	tests := []struct {
+		name      string
+		caller    chaintest.Identity
+		args      []string
+		reject    []string
+		expected  string
+		pinged    bool
+		installed bool
+	}{
+		{"ping answered", admin, []string{"hook", "onToken"}, nil, "", true, true},
+		{"ping rejected", admin, []string{"hook", "onToken"}, []string{receiverPing}, "Receiver hook rejected ping: not accepted", false, false},
+		{"wrong function", admin, []string{"hook", "other"}, nil, "Receiver hook rejected ping: unexpected call", false, false},
+		{"not installed", admin, []string{"missing", "onToken"}, nil, "Receiver missing rejected ping", false, false},
+		{"not an admin", alice, []string{"hook", "onToken"}, nil, errUnauthorized, false, false},
+		{"no chaincode", admin, []string{"", "onToken"}, nil, "Chaincode name must be a non-empty string", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger, notifications := newReceiverToken(t, test.reject...)
+			result := ledger.Invoke(test.caller, "RegisterReceiver", test.args...)
+			if test.expected == "" && result.Status != shim.OK {
+				t.Fatalf("RegisterReceiver%q failed with %q", test.args, result.Message)
+			}
+			if test.expected != "" && !strings.HasPrefix(result.Message, test.expected) {
+				t.Fatalf("RegisterReceiver%q returned %q, expected %q", test.args, result.Message, test.expected)
+			}
+			if pinged := len(*notifications) == 1 && (*notifications)[0][0] == receiverPing; pinged != test.pinged {
+				t.Fatalf("receiver got %q, expected a ping: %t", *notifications, test.pinged)
+			}
+
+			// Only a registered receiver can be called
+			call := ledger.Invoke(alice, "TransferAndCall", "hook", "10")
+			if (call.Status == shim.OK) != test.installed {
+				t.Fatalf("TransferAndCall returned %q, expected it to succeed: %t", call.Message, test.installed)
+			}
+		})
+	}
+}
+
+func TestReceiverNotifications(t *testing.T) {
+	tests := []struct {
+		function     string
+		args         []string
+		reject       string
+		notification []string
+		balance      int
+		allowance    string
+	}{
+		{"TransferAndCall", []string{"hook", "30", "order 7"}, "", []string{receiverTransfer, alice.Account, "30", "order 7"}, 70, "0"},
+		{"TransferAndCall", []string{"hook", "30"}, receiverTransfer, nil, 100, "0"},
+		{"ApproveAndCall", []string{"hook", "40", "order 8"}, "", []string{receiverApproval, alice.Account, "40", "order 8"}, 100, "40"},
+		{"ApproveAndCall", []string{"hook", "40"}, receiverApproval, nil, 100, "0"},
+	}
+	for _, test := range tests {
+		ledger, notifications := newReceiverToken(t, test.reject)
+		mustInvoke(t, ledger, admin, "RegisterReceiver", "hook", "onToken")
+		*notifications = nil
+		before := ledger.Fork()
+
+		result := ledger.Invoke(alice, test.function, test.args...)
+		if test.notification == nil {
+			if result.Status == shim.OK || !strings.Contains(result.Message, "Receiver hook rejected "+test.reject) {
+				t.Fatalf("%s%q to a rejecting receiver returned %q", test.function, test.args, result.Message)
+			}
+			if !sameState(before, ledger) {
+				t.Fatalf("%s%q rejected by the receiver changed the state", test.function, test.args)
+			}
+		} else {
+			if result.Status != shim.OK {
+				t.Fatalf("%s%q failed with %q", test.function, test.args, result.Message)
+			}
+			if len(*notifications) != 1 || strings.Join((*notifications)[0], "|") != strings.Join(test.notification, "|") {
+				t.Fatalf("%s%q notified %q, expected %q", test.function, test.args, *notifications, test.notification)
+			}
+		}
+		if got := balanceOf(t, ledger, alice.Account); got != test.balance {
+			t.Fatalf("%s%q left alice with %d, expected %d", test.function, test.args, got, test.balance)
+		}
+		if got := mustInvoke(t, ledger, admin, "Allowance", alice.Account, receiverAccountPrefix+"hook"); got != test.allowance {
+			t.Fatalf("%s%q left an allowance of %s, expected %s", test.function, test.args, got, test.allowance)
+		}
+	}
+}
+
+func TestDeregisterReceiver(t *testing.T) {
+	ledger, notifications := newReceiverToken(t)
+	mustInvoke(t, ledger, admin, "RegisterReceiver", "hook", "onToken")
+	mustInvoke(t, ledger, alice, "TransferAndCall", "hook", "10")
+
+	mustFail(t, ledger, alice, "DeregisterReceiver", "hook")
+	mustInvoke(t, ledger, admin, "DeregisterReceiver", "hook")
+	if message := mustFail(t, ledger, admin, "DeregisterReceiver", "hook"); message != "Receiver not found" {
+		t.Fatalf("second DeregisterReceiver failed with %q, expected Receiver not found", message)
+	}
+	mustFail(t, ledger, alice, "TransferAndCall", "hook", "10")
+	mustFail(t, ledger, alice, "ApproveAndCall", "hook", "10")
+
+	// Tokens held by the deregistered receiver stay, and plain transfers no longer warn
+	if got := balanceOf(t, ledger, receiverAccountPrefix+"hook"); got != 10 {
+		t.Fatalf("deregistered receiver holds %d, expected 10", got)
+	}
+	result := ledger.Invoke(alice, "Transfer", receiverAccountPrefix+"hook", "5")
+	if result.Status != shim.OK || result.Message != "" {
+		t.Fatalf("Transfer to a deregistered receiver returned %q, expected success without a warning", result.Message)
+	}
+	if len(*notifications) != 2 {
+		t.Fatalf("receiver got %q, expected only the ping and the first transfer", *notifications)
+	}
+}
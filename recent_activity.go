@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for recent activity options
+const recentActivitySizeKey = "recentActivitySize"
+
+// Define objectType names for recent activity
+const recentPrefix = "recent"
+const recentCountPrefix = "recentCount"
+
+// defaultRecentActivitySize is the number of movements kept per account when no size is configured
+const defaultRecentActivitySize = 100
+
+// movement summarizes one token movement; an empty From denotes a mint and an empty To a burn
+type movement struct {
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Value     int    `json:"value"`
+}
+
+// recentActivityResponse is the JSON document returned by RecentActivity
+type recentActivityResponse struct {
+	Token     string     `json:"token"`
+	Account   string     `json:"account"`
+	Movements []movement `json:"movements"`
+}
+
+// RecentActivity returns the last movements of `account`, newest first
+// Movements are only kept when recent activity was enabled at Initialize.
+func (s *SmartContract) RecentActivity(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	size, err := getRecentActivitySize(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if size == 0 {
+		return shim.Error("Recent activity is not enabled")
+	}
+	count, err := getRecentCount(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	movements := []movement{}
+	for seq := count - 1; seq >= 0 && seq >= count-size; seq-- {
+		slotKey, err := recentSlotKey(APIstub, account, seq%size)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		movementBytes, err := APIstub.GetState(slotKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "GetState", recentPrefix, err).Error())
+		}
+		var m movement
+		err = json.Unmarshal(movementBytes, &m)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		movements = append(movements, m)
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(recentActivityResponse{Token: symbol, Account: account, Movements: movements})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// recordMovements appends the movements of this transaction to the recent activity of every account involved
+// All movements of a transaction must be recorded in one call, since each account's counter
+// can only be written once per transaction. The oldest slot is overwritten once the buffer is full.
+// The first movement also activates the token, closing the balance import window.
+func recordMovements(APIstub shim.ChaincodeStubInterface, movements ...movement) error {
+	err := markActivated(APIstub)
+	if err != nil {
+		return err
+	}
+	size, err := getRecentActivitySize(APIstub)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	txID := APIstub.GetTxID()
+
+	// Group the movements per account, keeping the order in which accounts first appear
+	var accounts []string
+	perAccount := map[string][]movement{}
+	for _, m := range movements {
+		m.TxID = txID
+		m.Timestamp = now
+		for _, account := range []string{m.From, m.To} {
+			if account == "" {
+				continue
+			}
+			if _, seen := perAccount[account]; !seen {
+				accounts = append(accounts, account)
+			}
+			perAccount[account] = append(perAccount[account], m)
+		}
+	}
+
+	for _, account := range accounts {
+		count, err := getRecentCount(APIstub, account)
+		if err != nil {
+			return err
+		}
+		for _, m := range perAccount[account] {
+			slotKey, err := recentSlotKey(APIstub, account, count%size)
+			if err != nil {
+				return err
+			}
+			movementBytes, err := encodeJSON(m)
+			if err != nil {
+				return err
+			}
+			err = APIstub.PutState(slotKey, movementBytes)
+			if err != nil {
+				return stateError(APIstub, "PutState", recentPrefix, err)
+			}
+			count++
+		}
+		countKey, err := buildKey(APIstub, recentCountPrefix, []string{account})
+		if err != nil {
+			return err
+		}
+		err = APIstub.PutState(countKey, []byte(strconv.Itoa(count)))
+		if err != nil {
+			return stateError(APIstub, "PutState", recentCountPrefix, err)
+		}
+	}
+	return nil
+}
+
+// getRecentActivitySize returns the number of movements kept per account, or 0 if recent activity is disabled
+func getRecentActivitySize(APIstub shim.ChaincodeStubInterface) (int, error) {
+	return getIntSetting(APIstub, recentActivitySizeKey)
+}
+
+// getRecentCount returns the number of movements ever recorded for `account`
+func getRecentCount(APIstub shim.ChaincodeStubInterface, account string) (int, error) {
+	countKey, err := buildKey(APIstub, recentCountPrefix, []string{account})
+	if err != nil {
+		return 0, err
+	}
+	countBytes, err := APIstub.GetState(countKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", recentCountPrefix, err)
+	}
+	count, _ := strconv.Atoi(string(countBytes))
+	return count, nil
+}
+
+// recentSlotKey returns the key of ring buffer slot `slot` of `account`
+func recentSlotKey(APIstub shim.ChaincodeStubInterface, account string, slot int) (string, error) {
+	return buildKey(APIstub, recentPrefix, []string{account, strconv.Itoa(slot)})
+}
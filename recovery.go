@@ -0,0 +1,554 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for social recovery
+const guardiansPrefix = "guardians"
+const recoveryPrefix = "recovery"
+const pendingRecoveryPrefix = "pendingRecovery"
+
+// Define recovery statuses
+const recoveryPending = "pending"
+const recoveryExecuted = "executed"
+const recoveryVetoed = "vetoed"
+
+// maxGuardians bounds the number of guardians of an account
+const maxGuardians = 10
+
+// recoveryDelay is the number of seconds a recovery waits after initiation, during which the
+// original owner can veto it by signing any transaction
+const recoveryDelay int64 = 3 * 24 * 60 * 60
+
+// guardianSet is the recovery configuration of an account
+type guardianSet struct {
+	Guardians []string `json:"guardians"`
+	Threshold int      `json:"threshold"`
+}
+
+// recovery is a request by guardians to move a lost account to a new one
+// The guardian set is copied at initiation, so later changes do not affect a running recovery.
+type recovery struct {
+	ID           string   `json:"id"`
+	LostAccount  string   `json:"lostAccount"`
+	NewAccount   string   `json:"newAccount"`
+	Guardians    []string `json:"guardians"`
+	Threshold    int      `json:"threshold"`
+	Approvals    []string `json:"approvals"`
+	InitiatedAt  int64    `json:"initiatedAt"`
+	ExecutableAt int64    `json:"executableAt"`
+	Status       string   `json:"status"`
+	Amount       int      `json:"amount,omitempty"`
+	ClosedTxID   string   `json:"closedTxId,omitempty"`
+}
+
+// guardiansEvent provides an organized struct for emitting GuardiansConfigured events
+type guardiansEvent struct {
+	Token   string `json:"token"`
+	Account string `json:"account"`
+	guardianSet
+}
+
+// recoveryEvent provides an organized struct for emitting recovery events
+type recoveryEvent struct {
+	Token string `json:"token"`
+	Actor string `json:"actor"`
+	recovery
+}
+
+// recoveryResponse is the JSON document returned by GetRecovery
+type recoveryResponse struct {
+	Token string `json:"token"`
+	recovery
+}
+
+// ConfigureGuardians sets the guardians, as a JSON array of account IDs, that can recover the caller's account
+// `threshold` guardians must approve a recovery. An empty array with a threshold of 0 removes the guardians.
+// This function triggers a GuardiansConfigured event
+func (s *SmartContract) ConfigureGuardians(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var guardians []string
+	err := json.Unmarshal([]byte(args[0]), &guardians)
+	if err != nil {
+		return shim.Error("Invalid guardians. Expecting a JSON array of account IDs")
+	}
+	if len(guardians) > maxGuardians {
+		return shim.Error(fmt.Sprintf("Too many guardians. Expecting at most %d", maxGuardians))
+	}
+	threshold, err := strconv.Atoi(args[1])
+	if err != nil || threshold < 0 || threshold > len(guardians) || (threshold == 0 && len(guardians) > 0) {
+		return shim.Error("Invalid threshold. Expecting a number between 1 and the number of guardians")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	seen := map[string]bool{}
+	for _, guardian := range guardians {
+		if guardian == "" || guardian == account || seen[guardian] {
+			return shim.Error("Guardians must be distinct accounts other than the caller's")
+		}
+		seen[guardian] = true
+	}
+
+	set := guardianSet{Guardians: guardians, Threshold: threshold}
+	err = putGuardianSet(APIstub, account, set)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventBytes, err := json.Marshal(guardiansEvent{Token: symbol, Account: account, guardianSet: set})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.SetEvent("GuardiansConfigured", eventBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// InitiateRecovery starts moving `lostAccount` to `newAccount`; only a guardian of `lostAccount` can initiate
+// The initiating guardian's approval is counted. The recovery ID is returned as payload.
+// This function triggers a RecoveryInitiated event
+func (s *SmartContract) InitiateRecovery(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	lostAccount := args[0]
+	newAccount := args[1]
+	if lostAccount == "" || newAccount == "" || lostAccount == newAccount {
+		return shim.Error("Lost and new account must be distinct non-empty strings")
+	}
+
+	guardian, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	set, err := getGuardianSet(APIstub, lostAccount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsAccount(set.Guardians, guardian) {
+		return shim.Error("Caller is not a guardian of the lost account")
+	}
+	pendingID, err := getPendingRecoveryID(APIstub, lostAccount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pendingID != "" {
+		return shim.Error("A recovery of this account is already pending")
+	}
+
+	id, err := newDeterministicID(APIstub, recoveryPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	request := recovery{
+		ID:           id,
+		LostAccount:  lostAccount,
+		NewAccount:   newAccount,
+		Guardians:    set.Guardians,
+		Threshold:    set.Threshold,
+		Approvals:    []string{guardian},
+		InitiatedAt:  now,
+		ExecutableAt: now + recoveryDelay,
+		Status:       recoveryPending,
+	}
+	err = putRecovery(APIstub, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putPendingRecoveryID(APIstub, lostAccount, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitRecoveryEvent(APIstub, "RecoveryInitiated", guardian, request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(id))
+}
+
+// ApproveRecovery adds the caller's approval to a pending recovery; only its guardians can approve
+// This function triggers a RecoveryApproved event
+func (s *SmartContract) ApproveRecovery(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getPendingRecovery(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	guardian, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsAccount(request.Guardians, guardian) {
+		return shim.Error("Caller is not a guardian of this recovery")
+	}
+	if containsAccount(request.Approvals, guardian) {
+		return shim.Error("Caller has already approved this recovery")
+	}
+
+	request.Approvals = append(request.Approvals, guardian)
+	err = putRecovery(APIstub, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitRecoveryEvent(APIstub, "RecoveryApproved", guardian, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ExecuteRecovery moves the whole balance of the lost account to the new account and passes
+// the guardians on to it. Anyone can execute once the threshold is met and the delay has passed.
+// This function triggers a RecoveryExecuted event
+func (s *SmartContract) ExecuteRecovery(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getPendingRecovery(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	executor, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if executor == request.LostAccount {
+		return shim.Error("The owner of the lost account vetoes the recovery by signing; it cannot execute it")
+	}
+	if len(request.Approvals) < request.Threshold {
+		return shim.Error(fmt.Sprintf("Recovery has %d of %d required approvals", len(request.Approvals), request.Threshold))
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if now < request.ExecutableAt {
+		return shim.Error(fmt.Sprintf("Recovery cannot be executed before %d", request.ExecutableAt))
+	}
+
+	balance, _, err := getBalance(APIstub, request.LostAccount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if balance > 0 {
+		err = putBalance(APIstub, request.LostAccount, 0)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		err = creditBalance(APIstub, request.NewAccount, balance)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		err = recordMovements(APIstub, movement{From: request.LostAccount, To: request.NewAccount, Value: balance})
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+	}
+
+	set, err := getGuardianSet(APIstub, request.LostAccount)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = putGuardianSet(APIstub, request.NewAccount, set)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = putGuardianSet(APIstub, request.LostAccount, guardianSet{})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	request.Status = recoveryExecuted
+	request.Amount = balance
+	err = closeRecovery(APIstub, *request)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitRecoveryEvent(APIstub, "RecoveryExecuted", executor, *request)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// VetoRecovery cancels a pending recovery of the caller's account
+// Any other transaction signed by the owner vetoes it as well, see vetoRecoveryOnActivity.
+// This function triggers a RecoveryVetoed event
+func (s *SmartContract) VetoRecovery(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getPendingRecovery(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner != request.LostAccount {
+		return shim.Error("Only the owner of the account under recovery can veto")
+	}
+
+	request.Status = recoveryVetoed
+	err = closeRecovery(APIstub, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitRecoveryEvent(APIstub, "RecoveryVetoed", owner, *request)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetRecovery returns the recovery `recoveryID`
+func (s *SmartContract) GetRecovery(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	request, err := getRecovery(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request == nil {
+		return shim.Error("Recovery not found")
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(recoveryResponse{Token: symbol, recovery: *request})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// vetoRecoveryOnActivity vetoes a pending recovery of the caller's account
+// Invoke calls it before every function, so an owner who still holds the key stops a recovery
+// just by signing any successful transaction. No event is emitted, since it would replace or be
+// replaced by the event of the function itself; VetoRecovery is skipped so it can emit its own.
+// Callers whose account cannot be resolved are left alone.
+func vetoRecoveryOnActivity(APIstub shim.ChaincodeStubInterface, function string) error {
+	if function == "VetoRecovery" {
+		return nil
+	}
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return nil
+	}
+	id, err := getPendingRecoveryID(APIstub, account)
+	if err != nil || id == "" {
+		return err
+	}
+	request, err := getRecovery(APIstub, id)
+	if err != nil || request == nil {
+		return err
+	}
+	request.Status = recoveryVetoed
+	return closeRecovery(APIstub, *request)
+}
+
+// getPendingRecovery returns the recovery `recoveryID`, or an error unless it is pending
+func getPendingRecovery(APIstub shim.ChaincodeStubInterface, recoveryID string) (*recovery, error) {
+	request, err := getRecovery(APIstub, recoveryID)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, fmt.Errorf("Recovery not found")
+	}
+	if request.Status != recoveryPending {
+		return nil, fmt.Errorf("Recovery is %s", request.Status)
+	}
+	return request, nil
+}
+
+// closeRecovery stores the final status of a recovery and clears the pending marker of its account
+// The closing transaction is recorded, so a recovery vetoed without an event can still be traced.
+func closeRecovery(APIstub shim.ChaincodeStubInterface, request recovery) error {
+	request.ClosedTxID = APIstub.GetTxID()
+	err := putRecovery(APIstub, request)
+	if err != nil {
+		return err
+	}
+	return putPendingRecoveryID(APIstub, request.LostAccount, "")
+}
+
+// getRecovery returns the recovery `recoveryID`, or nil if it does not exist
+func getRecovery(APIstub shim.ChaincodeStubInterface, recoveryID string) (*recovery, error) {
+	recoveryKey, err := buildKey(APIstub, recoveryPrefix, []string{recoveryID})
+	if err != nil {
+		return nil, err
+	}
+	recoveryBytes, err := APIstub.GetState(recoveryKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", recoveryPrefix, err)
+	}
+	if recoveryBytes == nil {
+		return nil, nil
+	}
+
+	var request recovery
+	err = json.Unmarshal(recoveryBytes, &request)
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// putRecovery stores the recovery under its ID
+func putRecovery(APIstub shim.ChaincodeStubInterface, request recovery) error {
+	recoveryKey, err := buildKey(APIstub, recoveryPrefix, []string{request.ID})
+	if err != nil {
+		return err
+	}
+	recoveryBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(recoveryKey, recoveryBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", recoveryPrefix, err)
+	}
+	return nil
+}
+
+// getPendingRecoveryID returns the ID of the pending recovery of `account`, or "" if there is none
+func getPendingRecoveryID(APIstub shim.ChaincodeStubInterface, account string) (string, error) {
+	pendingKey, err := buildKey(APIstub, pendingRecoveryPrefix, []string{account})
+	if err != nil {
+		return "", err
+	}
+	idBytes, err := APIstub.GetState(pendingKey)
+	if err != nil {
+		return "", stateError(APIstub, "GetState", pendingRecoveryPrefix, err)
+	}
+	return string(idBytes), nil
+}
+
+// putPendingRecoveryID marks `id` as the pending recovery of `account`; an empty ID clears the marker
+func putPendingRecoveryID(APIstub shim.ChaincodeStubInterface, account string, id string) error {
+	pendingKey, err := buildKey(APIstub, pendingRecoveryPrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		err = APIstub.DelState(pendingKey)
+		if err != nil {
+			return stateError(APIstub, "DelState", pendingRecoveryPrefix, err)
+		}
+		return nil
+	}
+	err = APIstub.PutState(pendingKey, []byte(id))
+	if err != nil {
+		return stateError(APIstub, "PutState", pendingRecoveryPrefix, err)
+	}
+	return nil
+}
+
+// getGuardianSet returns the guardians of `account`; an account without guardians has an empty set
+func getGuardianSet(APIstub shim.ChaincodeStubInterface, account string) (guardianSet, error) {
+	var set guardianSet
+	guardiansKey, err := buildKey(APIstub, guardiansPrefix, []string{account})
+	if err != nil {
+		return set, err
+	}
+	setBytes, err := APIstub.GetState(guardiansKey)
+	if err != nil {
+		return set, stateError(APIstub, "GetState", guardiansPrefix, err)
+	}
+	if setBytes == nil {
+		return set, nil
+	}
+	err = json.Unmarshal(setBytes, &set)
+	return set, err
+}
+
+// putGuardianSet stores the guardians of `account`; an empty set removes them
+func putGuardianSet(APIstub shim.ChaincodeStubInterface, account string, set guardianSet) error {
+	guardiansKey, err := buildKey(APIstub, guardiansPrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	if len(set.Guardians) == 0 {
+		err = APIstub.DelState(guardiansKey)
+		if err != nil {
+			return stateError(APIstub, "DelState", guardiansPrefix, err)
+		}
+		return nil
+	}
+	setBytes, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(guardiansKey, setBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", guardiansPrefix, err)
+	}
+	return nil
+}
+
+// containsAccount reports whether `account` is in `accounts`
+func containsAccount(accounts []string, account string) bool {
+	for _, a := range accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+// emitRecoveryEvent emits `name` for a step of a recovery performed by `actor`
+func emitRecoveryEvent(APIstub shim.ChaincodeStubInterface, name string, actor string, request recovery) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(recoveryEvent{Token: symbol, Actor: actor, recovery: request})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// strictQueriesKey marks a token that flags query functions that are submitted as transactions
+const strictQueriesKey = "strictQueries"
+
+// Define function kinds
+// A query only reads state and should be evaluated; an invoke changes state and must be submitted.
+const queryFunction = "query"
+const invokeFunction = "invoke"
+
+// contractFunction is a function callable through Invoke
+type contractFunction struct {
+	kind    string
+	handler func(*SmartContract, shim.ChaincodeStubInterface, []string) peer.Response
+}
+
+// contractFunctionInfo describes a function in GetContractMetadata
+type contractFunctionInfo struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// contractMetadataResponse is the JSON document returned by GetContractMetadata
+type contractMetadataResponse struct {
+	StrictQueries bool                   `json:"strictQueries"`
+	Functions     []contractFunctionInfo `json:"functions"`
+}
+
+// contractFunctions is the registry of every function callable through Invoke
+// It is the single source of truth for dispatching and for running queries through queryOnly.
+// It is filled in init, since GetContractMetadata reads the registry it is listed in.
+var contractFunctions map[string]contractFunction
+
+// preInitFunctions can be called before the token is initialized; every other function fails with
+// ERR_NOT_INITIALIZED until Initialize has run
+var preInitFunctions = map[string]bool{
+	"Initialize":          true,
+	"CheckInitialized":    true,
+	"GetContractMetadata": true,
+}
+
+func init() {
+	contractFunctions = map[string]contractFunction{
+		"Mint":                      {invokeFunction, (*SmartContract).Mint},
+		"Burn":                      {invokeFunction, (*SmartContract).Burn},
+		"Transfer":                  {invokeFunction, (*SmartContract).Transfer},
+		"BalanceOf":                 {queryFunction, (*SmartContract).BalanceOf},
+		"ClientAccountBalance":      {queryFunction, (*SmartContract).ClientAccountBalance},
+		"ClientAccountID":           {queryFunction, (*SmartContract).ClientAccountID},
+		"TotalSupply":               {queryFunction, (*SmartContract).TotalSupply},
+		"Approve":                   {invokeFunction, (*SmartContract).Approve},
+		"Allowance":                 {queryFunction, (*SmartContract).Allowance},
+		"TransferFrom":              {invokeFunction, (*SmartContract).TransferFrom},
+		"Name":                      {queryFunction, (*SmartContract).Name},
+		"Symbol":                    {queryFunction, (*SmartContract).Symbol},
+		"Initialize":                {invokeFunction, (*SmartContract).Initialize},
+		"CheckInitialized":          {queryFunction, (*SmartContract).CheckInitialized},
+		"SetIncomingAllowlist":      {invokeFunction, (*SmartContract).SetIncomingAllowlist},
+		"AddAllowedSender":          {invokeFunction, (*SmartContract).AddAllowedSender},
+		"RemoveAllowedSender":       {invokeFunction, (*SmartContract).RemoveAllowedSender},
+		"ListAllowedSenders":        {queryFunction, (*SmartContract).ListAllowedSenders},
+		"GrantRole":                 {invokeFunction, (*SmartContract).GrantRole},
+		"RevokeRole":                {invokeFunction, (*SmartContract).RevokeRole},
+		"HasRole":                   {queryFunction, (*SmartContract).HasRole},
+		"ListRoleMembers":           {queryFunction, (*SmartContract).ListRoleMembers},
+		"RotateSpender":             {invokeFunction, (*SmartContract).RotateSpender},
+		"ClaimSpenderRole":          {invokeFunction, (*SmartContract).ClaimSpenderRole},
+		"SetSpenderRotationOptOut":  {invokeFunction, (*SmartContract).SetSpenderRotationOptOut},
+		"CreatePaymentRequest":      {invokeFunction, (*SmartContract).CreatePaymentRequest},
+		"PayRequest":                {invokeFunction, (*SmartContract).PayRequest},
+		"CancelRequest":             {invokeFunction, (*SmartContract).CancelRequest},
+		"GetPaymentRequest":         {queryFunction, (*SmartContract).GetPaymentRequest},
+		"ListMyRequests":            {queryFunction, (*SmartContract).ListMyRequests},
+		"SetDormancyThreshold":      {invokeFunction, (*SmartContract).SetDormancyThreshold},
+		"IsDormant":                 {queryFunction, (*SmartContract).IsDormant},
+		"ListDormantAccounts":       {queryFunction, (*SmartContract).ListDormantAccounts},
+		"ProposeSwap":               {invokeFunction, (*SmartContract).ProposeSwap},
+		"AcceptSwap":                {invokeFunction, (*SmartContract).AcceptSwap},
+		"DeclineSwap":               {invokeFunction, (*SmartContract).DeclineSwap},
+		"CancelSwap":                {invokeFunction, (*SmartContract).CancelSwap},
+		"GetSwap":                   {queryFunction, (*SmartContract).GetSwap},
+		"SetMaxAccounts":            {invokeFunction, (*SmartContract).SetMaxAccounts},
+		"CurrentAccountCount":       {queryFunction, (*SmartContract).CurrentAccountCount},
+		"AcceptTerms":               {invokeFunction, (*SmartContract).AcceptTerms},
+		"SetDocumentHash":           {invokeFunction, (*SmartContract).SetDocumentHash},
+		"HasAcceptedTerms":          {queryFunction, (*SmartContract).HasAcceptedTerms},
+		"ScheduleTransfer":          {invokeFunction, (*SmartContract).ScheduleTransfer},
+		"ExecuteScheduled":          {invokeFunction, (*SmartContract).ExecuteScheduled},
+		"CancelScheduled":           {invokeFunction, (*SmartContract).CancelScheduled},
+		"RecentActivity":            {queryFunction, (*SmartContract).RecentActivity},
+		"ApproveBulkByOwner":        {invokeFunction, (*SmartContract).ApproveBulkByOwner},
+		"RequestAllowance":          {invokeFunction, (*SmartContract).RequestAllowance},
+		"ConfirmAllowanceRequest":   {invokeFunction, (*SmartContract).ConfirmAllowanceRequest},
+		"ListAllowanceRequests":     {queryFunction, (*SmartContract).ListAllowanceRequests},
+		"BindAccountToMSP":          {invokeFunction, (*SmartContract).BindAccountToMSP},
+		"UnbindAccountFromMSP":      {invokeFunction, (*SmartContract).UnbindAccountFromMSP},
+		"AccountDashboard":          {queryFunction, (*SmartContract).AccountDashboard},
+		"MaxSupply":                 {queryFunction, (*SmartContract).MaxSupply},
+		"ProposeMaxSupplyChange":    {invokeFunction, (*SmartContract).ProposeMaxSupplyChange},
+		"CancelMaxSupplyChange":     {invokeFunction, (*SmartContract).CancelMaxSupplyChange},
+		"ApplyMaxSupplyChange":      {invokeFunction, (*SmartContract).ApplyMaxSupplyChange},
+		"AllowanceHistory":          {queryFunction, (*SmartContract).AllowanceHistory},
+		"SetSupplyAlarm":            {invokeFunction, (*SmartContract).SetSupplyAlarm},
+		"ClearSupplyAlarm":          {invokeFunction, (*SmartContract).ClearSupplyAlarm},
+		"SetComplianceNote":         {invokeFunction, (*SmartContract).SetComplianceNote},
+		"GetComplianceNotes":        {queryFunction, (*SmartContract).GetComplianceNotes},
+		"GetRetirement":             {queryFunction, (*SmartContract).GetRetirement},
+		"ListRetirements":           {queryFunction, (*SmartContract).ListRetirements},
+		"ConfigureGuardians":        {invokeFunction, (*SmartContract).ConfigureGuardians},
+		"InitiateRecovery":          {invokeFunction, (*SmartContract).InitiateRecovery},
+		"ApproveRecovery":           {invokeFunction, (*SmartContract).ApproveRecovery},
+		"ExecuteRecovery":           {invokeFunction, (*SmartContract).ExecuteRecovery},
+		"VetoRecovery":              {invokeFunction, (*SmartContract).VetoRecovery},
+		"GetRecovery":               {queryFunction, (*SmartContract).GetRecovery},
+		"ImportBalances":            {invokeFunction, (*SmartContract).ImportBalances},
+		"FinalizeImport":            {invokeFunction, (*SmartContract).FinalizeImport},
+		"SetCategoryBudget":         {invokeFunction, (*SmartContract).SetCategoryBudget},
+		"SetUncategorizedSpend":     {invokeFunction, (*SmartContract).SetUncategorizedSpend},
+		"CategoryUsage":             {queryFunction, (*SmartContract).CategoryUsage},
+		"SetMemoRequired":           {invokeFunction, (*SmartContract).SetMemoRequired},
+		"MemoRequired":              {queryFunction, (*SmartContract).MemoRequired},
+		"OpenCase":                  {invokeFunction, (*SmartContract).OpenCase},
+		"CloseCase":                 {invokeFunction, (*SmartContract).CloseCase},
+		"GetCase":                   {queryFunction, (*SmartContract).GetCase},
+		"EnableSequencing":          {invokeFunction, (*SmartContract).EnableSequencing},
+		"DisableSequencing":         {invokeFunction, (*SmartContract).DisableSequencing},
+		"GetNextSequence":           {queryFunction, (*SmartContract).GetNextSequence},
+		"ForceTransfer":             {invokeFunction, (*SmartContract).ForceTransfer},
+		"SetBalanceCap":             {invokeFunction, (*SmartContract).SetBalanceCap},
+		"BalanceCap":                {queryFunction, (*SmartContract).BalanceCap},
+		"ForceBurn":                 {invokeFunction, (*SmartContract).ForceBurn},
+		"SetTravelRuleThreshold":    {invokeFunction, (*SmartContract).SetTravelRuleThreshold},
+		"GetTravelRuleData":         {queryFunction, (*SmartContract).GetTravelRuleData},
+		"GetConfiguration":          {queryFunction, (*SmartContract).GetConfiguration},
+		"GetDefaultSettings":        {queryFunction, (*SmartContract).GetDefaultSettings},
+		"ExportConfigurationDigest": {queryFunction, (*SmartContract).ExportConfigurationDigest},
+		"TransferFromSubaccounts":   {invokeFunction, (*SmartContract).TransferFromSubaccounts},
+		"CreateMinterSession":       {invokeFunction, (*SmartContract).CreateMinterSession},
+		"MintWithSession":           {invokeFunction, (*SmartContract).MintWithSession},
+		"CloseMinterSession":        {invokeFunction, (*SmartContract).CloseMinterSession},
+		"GetMinterSession":          {queryFunction, (*SmartContract).GetMinterSession},
+		"SetKeySchema":              {invokeFunction, (*SmartContract).SetKeySchema},
+		"SetPublicBalance":          {invokeFunction, (*SmartContract).SetPublicBalance},
+		"ListPublicBalances":        {queryFunction, (*SmartContract).ListPublicBalances},
+		"TransferBatch":             {invokeFunction, (*SmartContract).TransferBatch},
+		"SetTrustedPair":            {invokeFunction, (*SmartContract).SetTrustedPair},
+		"RemoveTrustedPair":         {invokeFunction, (*SmartContract).RemoveTrustedPair},
+		"GetTrustedPair":            {queryFunction, (*SmartContract).GetTrustedPair},
+		"SetExperimentalEnabled":    {invokeFunction, (*SmartContract).SetExperimentalEnabled},
+		"RevokeSpenderAllowances":   {invokeFunction, (*SmartContract).RevokeSpenderAllowances},
+		"SetMaintenanceWindow":      {invokeFunction, (*SmartContract).SetMaintenanceWindow},
+		"SelfTest":                  {queryFunction, (*SmartContract).SelfTest},
+		"StatementSummary":          {queryFunction, (*SmartContract).StatementSummary},
+		"RegisterReceiver":          {invokeFunction, (*SmartContract).RegisterReceiver},
+		"DeregisterReceiver":        {invokeFunction, (*SmartContract).DeregisterReceiver},
+		"TransferAndCall":           {invokeFunction, (*SmartContract).TransferAndCall},
+		"ApproveAndCall":            {invokeFunction, (*SmartContract).ApproveAndCall},
+		"SetStrictMode":             {invokeFunction, (*SmartContract).SetStrictMode},
+		"Pause":                     {invokeFunction, (*SmartContract).Pause},
+		"Unpause":                   {invokeFunction, (*SmartContract).Unpause},
+		"PublishTermsVersion":       {invokeFunction, (*SmartContract).PublishTermsVersion},
+		"ListTermsVersions":         {queryFunction, (*SmartContract).ListTermsVersions},
+		"HasAcceptedCurrentTerms":   {queryFunction, (*SmartContract).HasAcceptedCurrentTerms},
+		"GetTermsAcceptanceHistory": {queryFunction, (*SmartContract).GetTermsAcceptanceHistory},
+		"SetTwoPersonRule":          {invokeFunction, (*SmartContract).SetTwoPersonRule},
+		"ApproveOutgoing":           {invokeFunction, (*SmartContract).ApproveOutgoing},
+		"CancelOutgoing":            {invokeFunction, (*SmartContract).CancelOutgoing},
+		"ListPendingOutgoing":       {queryFunction, (*SmartContract).ListPendingOutgoing},
+		"FreezeAccount":             {invokeFunction, (*SmartContract).FreezeAccount},
+		"UnfreezeAccount":           {invokeFunction, (*SmartContract).UnfreezeAccount},
+		"IsFrozen":                  {queryFunction, (*SmartContract).IsFrozen},
+		"Hold":                      {invokeFunction, (*SmartContract).Hold},
+		"CaptureHold":               {invokeFunction, (*SmartContract).CaptureHold},
+		"ReleaseHold":               {invokeFunction, (*SmartContract).ReleaseHold},
+		"GetHold":                   {queryFunction, (*SmartContract).GetHold},
+		"GetContractMetadata":       {queryFunction, (*SmartContract).GetContractMetadata},
+		"UpgradeStateFormat":        {invokeFunction, (*SmartContract).UpgradeStateFormat},
+		"StateFormat":               {queryFunction, (*SmartContract).StateFormat},
+		"MigrateLegacyBalances":     {invokeFunction, (*SmartContract).MigrateLegacyBalances},
+		"MigrateLegacyAllowances":   {invokeFunction, (*SmartContract).MigrateLegacyAllowances},
+	}
+}
+
+// dispatch runs `function` from the registry; queries run against a read-only stub
+// While the contract is paused, invoke functions fail unless they are exempt, see Pause.
+// When the token was initialized with strictQueries, a query's response carries a warning
+// in its message. The peer cannot tell an evaluation from a submission, so the warning is
+// attached either way and only matters to clients that submit the query. Strict mode turns it
+// into an error like any other warning, so with both options set every query fails.
+func dispatch(s *SmartContract, APIstub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
+	fn, found := contractFunctions[function]
+	if !found {
+		fn, found = devFunctions[function]
+		if found {
+			devMode, err := isDevMode(APIstub)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			found = devMode
+		}
+	}
+	if !found {
+		fn, found = experimentalFunctions[function]
+		if found {
+			err := checkExperimentalEnabled(APIstub, function)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+	if !found {
+		return shim.Error("Invalid function name")
+	}
+	if !preInitFunctions[function] {
+		err := checkInitialized(APIstub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if fn.kind == invokeFunction {
+		err := checkNotPaused(APIstub, function)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkAdminOU(APIstub, function)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = checkTwoPersonGated(APIstub, function)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return fn.handler(s, APIstub, args)
+	}
+
+	response := queryOnly(APIstub, args, func(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+		return fn.handler(s, APIstub, args)
+	})
+	if response.Status != shim.OK {
+		return response
+	}
+	strict, err := getBoolSetting(APIstub, strictQueriesKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if strict {
+		return withWarning(APIstub, response, warnQuerySubmitted, "%s is a query; evaluate it instead of submitting a transaction", function)
+	}
+	return response
+}
+
+// GetContractMetadata lists every function with its kind, "query" or "invoke", sorted by name
+func (s *SmartContract) GetContractMetadata(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	strict, err := getBoolSetting(APIstub, strictQueriesKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	devMode, err := isDevMode(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	experimental, err := getBoolSetting(APIstub, experimentalEnabledKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	functions := []contractFunctionInfo{}
+	for name, fn := range contractFunctions {
+		functions = append(functions, contractFunctionInfo{Name: name, Kind: fn.kind})
+	}
+	if devMode {
+		for name, fn := range devFunctions {
+			functions = append(functions, contractFunctionInfo{Name: name, Kind: fn.kind})
+		}
+	}
+	if experimental {
+		for name, fn := range experimentalFunctions {
+			functions = append(functions, contractFunctionInfo{Name: name, Kind: fn.kind})
+		}
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+
+	responseBytes, err := json.Marshal(contractMetadataResponse{StrictQueries: strict, Functions: functions})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
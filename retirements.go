@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for retirement certificates
+const retirementPrefix = "retirement"
+const retirementByAccountPrefix = "retirementByAccount"
+
+// retirement is the certificate recorded for every burn
+// Certificates are written once and never changed or reassigned.
+type retirement struct {
+	CertificateID string `json:"certificateId"`
+	Owner         string `json:"owner"`
+	Amount        int    `json:"amount"`
+	Reason        string `json:"reason,omitempty"`
+	Beneficiary   string `json:"beneficiary,omitempty"`
+	TxID          string `json:"txId"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// retirementEvent describes a burn and the certificate it produced
+// It carries the Transfer fields as well, since Fabric only keeps one event per transaction.
+type retirementEvent struct {
+	event
+	retirement
+}
+
+// retirementResponse is the JSON document returned by GetRetirement
+type retirementResponse struct {
+	Token string `json:"token"`
+	retirement
+}
+
+// retirementsResponse is the JSON document returned by ListRetirements
+type retirementsResponse struct {
+	Token       string       `json:"token"`
+	Account     string       `json:"account"`
+	Retirements []retirement `json:"retirements"`
+}
+
+// GetRetirement returns the retirement certificate `certificateID`
+func (s *SmartContract) GetRetirement(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	certificate, err := getRetirement(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificate == nil {
+		return shim.Error("Retirement not found")
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(retirementResponse{Token: symbol, retirement: *certificate})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// ListRetirements returns the retirement certificates of tokens burned from `account`
+func (s *SmartContract) ListRetirements(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	retirements := []retirement{}
+	_, err := iterate(APIstub, retirementByAccountPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		certificate, err := getRetirement(APIstub, attributes[1])
+		if err != nil || certificate == nil {
+			return err
+		}
+		retirements = append(retirements, *certificate)
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(retirementsResponse{Token: symbol, Account: account, Retirements: retirements})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// createRetirement records the certificate for burning `amount` tokens of `owner`
+// `beneficiary` names on whose behalf the tokens were retired and may be empty.
+func createRetirement(APIstub shim.ChaincodeStubInterface, owner string, amount int, reason string, beneficiary string) (*retirement, error) {
+	certificateID, err := newDeterministicID(APIstub, retirementPrefix)
+	if err != nil {
+		return nil, err
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	certificate := retirement{
+		CertificateID: certificateID,
+		Owner:         owner,
+		Amount:        amount,
+		Reason:        reason,
+		Beneficiary:   beneficiary,
+		TxID:          APIstub.GetTxID(),
+		Timestamp:     now,
+	}
+
+	certificateKey, err := buildKey(APIstub, retirementPrefix, []string{certificateID})
+	if err != nil {
+		return nil, err
+	}
+	certificateBytes, err := json.Marshal(certificate)
+	if err != nil {
+		return nil, err
+	}
+	err = APIstub.PutState(certificateKey, certificateBytes)
+	if err != nil {
+		return nil, stateError(APIstub, "PutState", retirementPrefix, err)
+	}
+
+	// Index the certificate by owner so ListRetirements can find it
+	indexKey, err := buildKey(APIstub, retirementByAccountPrefix, []string{owner, certificateID})
+	if err != nil {
+		return nil, err
+	}
+	err = APIstub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return nil, stateError(APIstub, "PutState", retirementByAccountPrefix, err)
+	}
+
+	return &certificate, nil
+}
+
+// getRetirement returns the certificate `certificateID`, or nil if it does not exist
+func getRetirement(APIstub shim.ChaincodeStubInterface, certificateID string) (*retirement, error) {
+	certificateKey, err := buildKey(APIstub, retirementPrefix, []string{certificateID})
+	if err != nil {
+		return nil, err
+	}
+	certificateBytes, err := APIstub.GetState(certificateKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", retirementPrefix, err)
+	}
+	if certificateBytes == nil {
+		return nil, nil
+	}
+
+	var certificate retirement
+	err = json.Unmarshal(certificateBytes, &certificate)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+// emitRetirement emits the Retirement event of a burn
+func emitRetirement(APIstub shim.ChaincodeStubInterface, certificate retirement) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData := retirementEvent{
+		event:      event{Token: symbol, From: certificate.Owner, To: "", Value: certificate.Amount},
+		retirement: certificate,
+	}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent("Retirement", eventBytes)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for role records
+const rolePrefix = "role"
+
+// Define role names
+const adminRole = "admin"
+const auditorRole = "auditor"
+const keeperRole = "keeper"
+const complianceRole = "compliance"
+const burnerRole = "burner"
+const minterRole = "minter"
+const pauserRole = "pauser"
+
+// roleGrant is the record stored for every role member
+// ExpiresAt is a unix timestamp in seconds; 0 means the grant never expires
+type roleGrant struct {
+	Role      string `json:"role"`
+	Account   string `json:"account"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// roleEvent provides an organized struct for emitting role events
+type roleEvent struct {
+	Token     string `json:"token"`
+	Role      string `json:"role"`
+	Account   string `json:"account"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// roleMembersResponse is the JSON document returned by ListRoleMembers
+type roleMembersResponse struct {
+	Token   string      `json:"token"`
+	Role    string      `json:"role"`
+	Members []roleGrant `json:"members"`
+}
+
+// expired reports whether the grant is no longer in force at `now`.
+// A grant stops being in force at the exact expiry instant.
+func (g roleGrant) expired(now int64) bool {
+	return g.ExpiresAt != 0 && now >= g.ExpiresAt
+}
+
+// GrantRole grants `role` to `account`, optionally until the unix timestamp `expiresAt`
+// Only admins can grant roles. Granting again overwrites the previous expiry. At least one admin
+// must keep a grant that never expires.
+func (s *SmartContract) GrantRole(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	role := args[0]
+	account := args[1]
+	if role == "" || account == "" {
+		return shim.Error("Role and account must be non-empty strings")
+	}
+	var expiresAt int64
+	if len(args) == 3 {
+		var err error
+		expiresAt, err = strconv.ParseInt(args[2], 10, 64)
+		if err != nil || expiresAt < 0 {
+			return shim.Error("Invalid expiry. Expecting a unix timestamp in seconds")
+		}
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiresAt != 0 && expiresAt <= now {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	// An expiring admin grant must not replace the last permanent one, or the contract would
+	// lose its admins when it expires
+	if role == adminRole && expiresAt != 0 {
+		err = checkAdminRemains(APIstub, account, "put an expiry on")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = putRoleGrant(APIstub, roleGrant{Role: role, Account: account, ExpiresAt: expiresAt})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// RevokeRole removes `role` from `account`
+// Only admins can revoke roles. The last admin whose grant never expires cannot be revoked.
+func (s *SmartContract) RevokeRole(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	role := args[0]
+	account := args[1]
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Never leave the contract without an admin able to recover it
+	if role == adminRole {
+		err = checkAdminRemains(APIstub, account, "revoke")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	roleKey, err := buildKey(APIstub, rolePrefix, []string{role, account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(roleKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", rolePrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// HasRole returns "true" if `account` currently holds `role`, otherwise "false"
+// Expired grants are reported as absent.
+func (s *SmartContract) HasRole(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	grant, err := getRoleGrant(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.FormatBool(grant != nil && !grant.expired(now))))
+}
+
+// ListRoleMembers returns every grant of `role` with its expiry
+// Grants that have expired but were not cleaned up yet are omitted.
+func (s *SmartContract) ListRoleMembers(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	role := args[0]
+
+	members, err := activeMembers(APIstub, role)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	membersBytes, err := json.Marshal(roleMembersResponse{Token: symbol, Role: role, Members: members})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(membersBytes)
+}
+
+// requireRole returns an error unless the requesting client currently holds `role`
+func requireRole(APIstub shim.ChaincodeStubInterface, role string) error {
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return err
+	}
+	granted, err := hasRole(APIstub, role, clientID)
+	if err != nil {
+		return err
+	}
+	if !granted {
+		return newCodedError(APIstub, errUnauthorized, map[string]string{"role": role}, "caller does not have the %s role", role)
+	}
+	return nil
+}
+
+// requireAnyRole returns an error unless the requesting client currently holds one of `roles`
+func requireAnyRole(APIstub shim.ChaincodeStubInterface, roles ...string) error {
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		granted, err := hasRole(APIstub, role, clientID)
+		if err != nil {
+			return err
+		}
+		if granted {
+			return nil
+		}
+	}
+	names := strings.Join(roles, " or ")
+	return newCodedError(APIstub, errUnauthorized, map[string]string{"role": names}, "caller does not have the %s role", names)
+}
+
+// hasRole reports whether `account` currently holds `role`.
+// An expired grant found here is deleted and a RoleExpired event is emitted.
+func hasRole(APIstub shim.ChaincodeStubInterface, role string, account string) (bool, error) {
+	grant, err := getRoleGrant(APIstub, role, account)
+	if err != nil {
+		return false, err
+	}
+	if grant == nil {
+		return false, nil
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return false, err
+	}
+	if !grant.expired(now) {
+		return true, nil
+	}
+
+	// Lazily clean up the expired grant; queries leave that to the next transaction
+	if isReadOnly(APIstub) {
+		return false, nil
+	}
+	roleKey, err := buildKey(APIstub, rolePrefix, []string{role, account})
+	if err != nil {
+		return false, err
+	}
+	err = APIstub.DelState(roleKey)
+	if err != nil {
+		return false, stateError(APIstub, "DelState", rolePrefix, err)
+	}
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return false, err
+	}
+	eventBytes, err := json.Marshal(roleEvent{Token: symbol, Role: role, Account: account, ExpiresAt: grant.ExpiresAt})
+	if err != nil {
+		return false, err
+	}
+	err = APIstub.SetEvent("RoleExpired", eventBytes)
+	if err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// checkAdminRemains returns ERR_LAST_ADMIN unless an admin other than `account` holds a grant
+// that never expires; `action` names what would be done to the admin grant of `account`
+func checkAdminRemains(APIstub shim.ChaincodeStubInterface, account string, action string) error {
+	admins, err := activeMembers(APIstub, adminRole)
+	if err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		if admin.Account != account && admin.ExpiresAt == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("ERR_LAST_ADMIN: cannot %s the last permanent admin", action)
+}
+
+// getRoleGrant returns the stored grant of `role` to `account`, or nil if there is none
+func getRoleGrant(APIstub shim.ChaincodeStubInterface, role string, account string) (*roleGrant, error) {
+	roleKey, err := buildKey(APIstub, rolePrefix, []string{role, account})
+	if err != nil {
+		return nil, err
+	}
+	grantBytes, err := APIstub.GetState(roleKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", rolePrefix, err)
+	}
+	if grantBytes == nil {
+		return nil, nil
+	}
+
+	var grant roleGrant
+	err = json.Unmarshal(grantBytes, &grant)
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// putRoleGrant stores `grant` under its role and account
+func putRoleGrant(APIstub shim.ChaincodeStubInterface, grant roleGrant) error {
+	roleKey, err := buildKey(APIstub, rolePrefix, []string{grant.Role, grant.Account})
+	if err != nil {
+		return err
+	}
+	grantBytes, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(roleKey, grantBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", rolePrefix, err)
+	}
+	return nil
+}
+
+// activeMembers returns every grant of `role` that is in force at the transaction time
+func activeMembers(APIstub shim.ChaincodeStubInterface, role string) ([]roleGrant, error) {
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []roleGrant{}
+	_, err = iterate(APIstub, rolePrefix, []string{role}, 0, "", func(attributes []string, value []byte) error {
+		var grant roleGrant
+		err := json.Unmarshal(value, &grant)
+		if err != nil {
+			return err
+		}
+		if !grant.expired(now) {
+			members = append(members, grant)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// hasAnyMember reports whether at least one grant of `role` is stored, expired or not
+func hasAnyMember(APIstub shim.ChaincodeStubInterface, role string) (bool, error) {
+	found := false
+	_, err := iterate(APIstub, rolePrefix, []string{role}, 0, "", func(attributes []string, value []byte) error {
+		found = true
+		return errStopIteration
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
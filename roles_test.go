@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestRoleExpiryBoundary checks a pauser grant to alice just before, at and after its expiry
+// A grant is in force up to the second before the expiry and absent from the expiry instant on;
+// a permanent grant never expires.
+func TestRoleExpiryBoundary(t *testing.T) {
+	tests := []struct {
+		name    string
+		expiry  int64
+		elapsed int64
+		holds   bool
+	}{
+		{"before the expiry", 100, 99, true},
+		{"at the expiry", 100, 100, false},
+		{"after the expiry", 100, 101, false},
+		{"permanent", 0, 100 * 365 * 24 * 3600, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			args := []string{pauserRole, alice.Account}
+			if test.expiry != 0 {
+				args = append(args, strconv.FormatInt(ledger.Now+test.expiry, 10))
+			}
+			mustInvoke(t, ledger, admin, "GrantRole", args...)
+			ledger.Now += test.elapsed
+
+			if got := mustInvoke(t, ledger, admin, "HasRole", pauserRole, alice.Account); got != strconv.FormatBool(test.holds) {
+				t.Fatalf("HasRole is %s, expected %t", got, test.holds)
+			}
+			members := mustInvoke(t, ledger, admin, "ListRoleMembers", pauserRole)
+			if listed := strings.Contains(members, `"account":"`+alice.Account+`"`); listed != test.holds {
+				t.Fatalf("ListRoleMembers returned %s, expected alice listed: %t", members, test.holds)
+			}
+
+			result := ledger.Invoke(alice, "Pause")
+			if test.holds {
+				if result.Status != shim.OK {
+					t.Fatalf("Pause by a pauser failed with %q", result.Message)
+				}
+				return
+			}
+			if !strings.HasPrefix(result.Message, errUnauthorized) {
+				t.Fatalf("Pause with an expired grant returned %q, expected %s", result.Message, errUnauthorized)
+			}
+
+			// The failed Pause is not committed; a committed transaction touching the grant deletes it
+			mustInvoke(t, ledger, admin, "GrantRole", adminRole, alice.Account)
+			touch := ledger.Invoke(alice, "Pause")
+			if touch.Status != shim.OK || len(touch.Events) == 0 || touch.Events[0].Name != "RoleExpired" {
+				t.Fatalf("Pause by an admin with an expired pauser grant returned %q with events %v, expected RoleExpired", touch.Message, touch.Events)
+			}
+			if got := mustInvoke(t, ledger, admin, "ListRoleMembers", pauserRole); strings.Contains(got, alice.Account) {
+				t.Fatalf("ListRoleMembers returned %s after RoleExpired", got)
+			}
+			for _, key := range ledger.Keys() {
+				if strings.Contains(key, rolePrefix) && strings.Contains(key, pauserRole) {
+					t.Fatalf("the expired grant is still stored under %q after RoleExpired", key)
+				}
+			}
+		})
+	}
+}
+
+// TestGrantRoleExpiryInFuture checks that an expiry must be after the transaction time
+func TestGrantRoleExpiryInFuture(t *testing.T) {
+	tests := []struct {
+		offset int64
+		valid  bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+	}
+	for _, test := range tests {
+		ledger := newToken(t, "")
+		result := ledger.Invoke(admin, "GrantRole", pauserRole, alice.Account, strconv.FormatInt(ledger.Now+test.offset, 10))
+		if (result.Status == shim.OK) != test.valid {
+			t.Fatalf("GrantRole with an expiry %d seconds from now returned %q, expected it to succeed: %t", test.offset, result.Message, test.valid)
+		}
+	}
+}
+
+// TestLastPermanentAdmin checks that an admin whose grant never expires always remains
+func TestLastPermanentAdmin(t *testing.T) {
+	expiry := strconv.FormatInt(chaintest.StartTime+100, 10)
+	tests := []struct {
+		name     string
+		bobAdmin []string
+		call     []string
+		rejected bool
+	}{
+		{"expiry on the only admin", nil, []string{"GrantRole", adminRole, admin.Account, expiry}, true},
+		{"expiry on the last permanent admin", []string{expiry}, []string{"GrantRole", adminRole, admin.Account, expiry}, true},
+		{"expiry with another permanent admin", []string{}, []string{"GrantRole", adminRole, admin.Account, expiry}, false},
+		{"expiring admin added", nil, []string{"GrantRole", adminRole, bob.Account, expiry}, false},
+		{"expiry on another role", nil, []string{"GrantRole", pauserRole, admin.Account, expiry}, false},
+		{"revoke the last permanent admin", []string{expiry}, []string{"RevokeRole", adminRole, admin.Account}, true},
+		{"revoke with another permanent admin", []string{}, []string{"RevokeRole", adminRole, admin.Account}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			if test.bobAdmin != nil {
+				mustInvoke(t, ledger, admin, "GrantRole", append([]string{adminRole, bob.Account}, test.bobAdmin...)...)
+			}
+			result := ledger.Invoke(admin, test.call[0], test.call[1:]...)
+			if !test.rejected {
+				if result.Status != shim.OK {
+					t.Fatalf("%s%q failed with %q", test.call[0], test.call[1:], result.Message)
+				}
+				return
+			}
+			if !strings.HasPrefix(result.Message, "ERR_LAST_ADMIN") {
+				t.Fatalf("%s%q returned %q, expected ERR_LAST_ADMIN", test.call[0], test.call[1:], result.Message)
+			}
+			// admin keeps its permanent grant past every expiry
+			ledger.Now += 3600
+			mustInvoke(t, ledger, admin, "GrantRole", auditorRole, carol.Account)
+		})
+	}
+}
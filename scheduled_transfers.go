@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for scheduled transfers
+const scheduledTransferPrefix = "scheduledTransfer"
+const scheduledTransferDuePrefix = "scheduledTransferDue"
+
+// Define scheduled transfer statuses
+const schedulePending = "scheduled"
+const scheduleExecuted = "executed"
+const scheduleCancelled = "cancelled"
+
+// scheduledTransfer is a transfer escrowed from the sender until it is due
+type scheduledTransfer struct {
+	ID           string `json:"id"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Amount       int    `json:"amount"`
+	ExecuteAfter int64  `json:"executeAfter"`
+	Status       string `json:"status"`
+}
+
+// scheduledTransferEvent provides an organized struct for emitting scheduled transfer events
+type scheduledTransferEvent struct {
+	Token string `json:"token"`
+	scheduledTransfer
+}
+
+// executedTransfer is a Transfer event entry annotated with the schedule it came from
+type executedTransfer struct {
+	event
+	ScheduleID string `json:"scheduleId"`
+}
+
+// scheduledTransfersExecutedEvent lists every transfer executed by one ExecuteScheduled call
+type scheduledTransfersExecutedEvent struct {
+	Token     string             `json:"token"`
+	Transfers []executedTransfer `json:"transfers"`
+}
+
+// ScheduleTransfer escrows `amount` from the caller to be transferred to `to` once `executeAfterTs` is reached
+// The schedule ID is returned as payload.
+// This function triggers a TransferScheduled event
+func (s *SmartContract) ScheduleTransfer(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	to := args[0]
+	amount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	executeAfter, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || executeAfter < 0 {
+		return shim.Error("Invalid execution time. Expecting a unix timestamp in seconds")
+	}
+
+	from, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if to == "" || to == from {
+		return shim.Error("Recipient must be a different account")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if executeAfter <= now {
+		return shim.Error("Execution time must be in the future")
+	}
+
+	id, err := newDeterministicID(APIstub, scheduledTransferPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scheduled := scheduledTransfer{
+		ID:           id,
+		From:         from,
+		To:           to,
+		Amount:       amount,
+		ExecuteAfter: executeAfter,
+		Status:       schedulePending,
+	}
+
+	// Escrow the amount until the transfer is executed or cancelled
+	err = debitBalance(APIstub, from, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putScheduledTransfer(APIstub, scheduled)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dueKey, err := scheduledTransferDueKey(APIstub, scheduled)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(dueKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", scheduledTransferDuePrefix, err).Error())
+	}
+
+	err = emitScheduledTransferEvent(APIstub, "TransferScheduled", scheduled)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(scheduled.ID))
+}
+
+// ExecuteScheduled executes up to `limit` due scheduled transfers, oldest first
+// Keepers execute any due transfer; other callers only execute their own.
+// A due transfer whose recipient cannot currently receive it is left pending.
+// The IDs of the executed transfers are returned as a JSON array.
+// This function triggers a ScheduledTransfersExecuted event
+func (s *SmartContract) ExecuteScheduled(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return shim.Error(fmt.Sprintf("Invalid limit. Expecting a number between 1 and %d", maxPageSize))
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	isKeeper, err := hasRole(APIstub, keeperRole, clientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	due, err := getDueScheduledTransfers(APIstub, now, limit, clientID, isKeeper)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Sum the credits per recipient, since a balance can only be written once per transaction
+	credits := map[string]int{}
+	executed := []string{}
+	transfers := []executedTransfer{}
+	movements := []movement{}
+	for _, scheduled := range due {
+		if checkIncomingAllowed(APIstub, scheduled.From, scheduled.To) != nil || checkTermsAccepted(APIstub, scheduled.To) != nil {
+			continue
+		}
+		credits[scheduled.To] += scheduled.Amount
+
+		scheduled.Status = scheduleExecuted
+		err = closeScheduledTransfer(APIstub, scheduled)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		executed = append(executed, scheduled.ID)
+		movements = append(movements, movement{From: scheduled.From, To: scheduled.To, Value: scheduled.Amount})
+		transfers = append(transfers, executedTransfer{
+			event:      event{Token: symbol, From: scheduled.From, To: scheduled.To, Value: scheduled.Amount},
+			ScheduleID: scheduled.ID,
+		})
+	}
+
+	recipients := make([]string, 0, len(credits))
+	for to := range credits {
+		recipients = append(recipients, to)
+	}
+	sort.Strings(recipients)
+	for _, to := range recipients {
+		err = creditBalance(APIstub, to, credits[to])
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+	}
+	err = recordMovements(APIstub, movements...)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	if len(transfers) > 0 {
+		eventBytes, err := json.Marshal(scheduledTransfersExecutedEvent{Token: symbol, Transfers: transfers})
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		err = APIstub.SetEvent("ScheduledTransfersExecuted", eventBytes)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+	}
+
+	executedBytes, err := json.Marshal(executed)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	return shim.Success(executedBytes)
+}
+
+// CancelScheduled cancels a pending scheduled transfer and refunds the escrow to the sender
+// Only the sender can cancel, at any time before the transfer is executed.
+// This function triggers a TransferScheduleCancelled event
+func (s *SmartContract) CancelScheduled(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	scheduled, err := getScheduledTransfer(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if scheduled == nil {
+		return shim.Error("Scheduled transfer not found")
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != scheduled.From {
+		return shim.Error("Only the sender can cancel a scheduled transfer")
+	}
+	if scheduled.Status != schedulePending {
+		return shim.Error(fmt.Sprintf("Scheduled transfer is %s", scheduled.Status))
+	}
+
+	err = creditBalance(APIstub, scheduled.From, scheduled.Amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	scheduled.Status = scheduleCancelled
+	err = closeScheduledTransfer(APIstub, *scheduled)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitScheduledTransferEvent(APIstub, "TransferScheduleCancelled", *scheduled)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getDueScheduledTransfers returns up to `limit` pending transfers due at `now`, oldest first
+// Unless `isKeeper` is set, only transfers sent by `clientID` are returned.
+func getDueScheduledTransfers(APIstub shim.ChaincodeStubInterface, now int64, limit int, clientID string, isKeeper bool) ([]scheduledTransfer, error) {
+	due := []scheduledTransfer{}
+	_, err := iterate(APIstub, scheduledTransferDuePrefix, []string{}, 0, "", func(attributes []string, value []byte) error {
+		executeAfter, _ := strconv.ParseInt(attributes[0], 10, 64)
+		if executeAfter > now {
+			// The index is ordered by execution time, nothing further is due
+			return errStopIteration
+		}
+		scheduled, err := getScheduledTransfer(APIstub, attributes[1])
+		if err != nil {
+			return err
+		}
+		if scheduled == nil || scheduled.Status != schedulePending {
+			return nil
+		}
+		if !isKeeper && scheduled.From != clientID {
+			return nil
+		}
+		due = append(due, *scheduled)
+		if len(due) == limit {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// closeScheduledTransfer stores the final status of a scheduled transfer and removes it from the due index
+func closeScheduledTransfer(APIstub shim.ChaincodeStubInterface, scheduled scheduledTransfer) error {
+	err := putScheduledTransfer(APIstub, scheduled)
+	if err != nil {
+		return err
+	}
+	dueKey, err := scheduledTransferDueKey(APIstub, scheduled)
+	if err != nil {
+		return err
+	}
+	err = APIstub.DelState(dueKey)
+	if err != nil {
+		return stateError(APIstub, "DelState", scheduledTransferDuePrefix, err)
+	}
+	return nil
+}
+
+// scheduledTransferDueKey returns the due index key of a scheduled transfer
+// The execution time is zero-padded so the index sorts chronologically.
+func scheduledTransferDueKey(APIstub shim.ChaincodeStubInterface, scheduled scheduledTransfer) (string, error) {
+	return buildKey(APIstub, scheduledTransferDuePrefix, []string{fmt.Sprintf("%020d", scheduled.ExecuteAfter), scheduled.ID})
+}
+
+// getScheduledTransfer returns the stored scheduled transfer, or nil if there is none
+func getScheduledTransfer(APIstub shim.ChaincodeStubInterface, id string) (*scheduledTransfer, error) {
+	scheduledKey, err := buildKey(APIstub, scheduledTransferPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	scheduledBytes, err := APIstub.GetState(scheduledKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", scheduledTransferPrefix, err)
+	}
+	if scheduledBytes == nil {
+		return nil, nil
+	}
+
+	var scheduled scheduledTransfer
+	err = json.Unmarshal(scheduledBytes, &scheduled)
+	if err != nil {
+		return nil, err
+	}
+	return &scheduled, nil
+}
+
+// putScheduledTransfer stores the scheduled transfer under its ID
+func putScheduledTransfer(APIstub shim.ChaincodeStubInterface, scheduled scheduledTransfer) error {
+	scheduledKey, err := buildKey(APIstub, scheduledTransferPrefix, []string{scheduled.ID})
+	if err != nil {
+		return err
+	}
+	scheduledBytes, err := json.Marshal(scheduled)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(scheduledKey, scheduledBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", scheduledTransferPrefix, err)
+	}
+	return nil
+}
+
+// emitScheduledTransferEvent emits `name` describing the current state of the scheduled transfer
+func emitScheduledTransferEvent(APIstub shim.ChaincodeStubInterface, name string, scheduled scheduledTransfer) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(scheduledTransferEvent{Token: symbol, scheduledTransfer: scheduled})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
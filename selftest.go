@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for the maintenance window
+const maintenanceUntilKey = "maintenanceUntil"
+
+// Define the reserved accounts of the self-test
+const selfTestOwner = "selfTest:owner"
+const selfTestRecipient = "selfTest:recipient"
+const selfTestSpender = "selfTest:spender"
+
+// selfTestAmount is the amount the self-test mints and moves around
+const selfTestAmount = 100
+
+// selfTestHooks are the recipient hooks the self-test skips
+// They enforce channel policy, such as terms or allowlists, that the reserved accounts do not meet.
+var selfTestHooks = hookSet{hookAllowlist: true, hookTerms: true, hookBalanceCap: true}
+
+// selfTestStep is the outcome of one step of the self-test
+type selfTestStep struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// selfTestReport is the JSON document returned by SelfTest
+type selfTestReport struct {
+	Token           string         `json:"token"`
+	Passed          bool           `json:"passed"`
+	Steps           []selfTestStep `json:"steps"`
+	DiscardedWrites int            `json:"discardedWrites"`
+}
+
+// selfTestStub runs the self-test against the ledger without writing to it
+// Writes and deletions stay in memory and later reads see them, unlike in a real transaction.
+// Events are dropped. Range queries only see the ledger, which no self-test step relies on.
+type selfTestStub struct {
+	shim.ChaincodeStubInterface
+	writes map[string][]byte
+}
+
+// GetState returns the value written by the self-test, or else the ledger value
+func (o *selfTestStub) GetState(key string) ([]byte, error) {
+	if value, written := o.writes[key]; written {
+		return value, nil
+	}
+	return o.ChaincodeStubInterface.GetState(key)
+}
+
+// PutState keeps `value` in memory
+func (o *selfTestStub) PutState(key string, value []byte) error {
+	if len(value) == 0 {
+		return fmt.Errorf("PutState of %s without a value", key)
+	}
+	o.writes[key] = value
+	return nil
+}
+
+// DelState keeps the deletion in memory
+func (o *selfTestStub) DelState(key string) error {
+	o.writes[key] = nil
+	return nil
+}
+
+// SetEvent drops the event
+func (o *selfTestStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
+
+// SetMaintenanceWindow opens a maintenance window until the Unix time `until`; "0" closes it
+// Only admins can set it. SelfTest is available during the window.
+func (s *SmartContract) SetMaintenanceWindow(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	until, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || until < 0 {
+		return shim.Error("Invalid end of maintenance window. Expecting a Unix time in seconds or 0")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(maintenanceUntilKey, []byte(strconv.FormatInt(until, 10)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", maintenanceUntilKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// SelfTest mints, transfers, approves, spends an allowance and burns on reserved accounts and
+// checks every intermediate balance, allowance and the total supply
+// Only admins can run it, and only in devMode or during a maintenance window. The steps run on
+// the real ledger code against an in-memory copy of their writes, which is discarded: the
+// self-test never writes to the ledger, and it runs as a query so a write would fail it.
+// A failed step fails with ERR_SELF_TEST_FAILED; otherwise the report of the steps is returned.
+func (s *SmartContract) SelfTest(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkSelfTestAllowed(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, account := range []string{selfTestOwner, selfTestRecipient, selfTestSpender} {
+		_, exists, err := getBalance(APIstub, account)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if exists {
+			return shim.Error(fmt.Sprintf("Reserved self-test account %s has a balance on the ledger", account))
+		}
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	overlay := &selfTestStub{ChaincodeStubInterface: APIstub, writes: make(map[string][]byte)}
+	report := selfTestReport{Token: symbol, Steps: []selfTestStep{}}
+	for _, step := range selfTestSteps(&txStub{ChaincodeStubInterface: overlay}) {
+		err = step.run()
+		if err != nil {
+			params := map[string]string{"step": step.name, "detail": err.Error()}
+			return shim.Error(newCodedError(APIstub, errSelfTestFailed, params, "self-test step %s failed: %s", step.name, err).Error())
+		}
+		report.Steps = append(report.Steps, selfTestStep{Name: step.name, Passed: true})
+	}
+	report.Passed = true
+	report.DiscardedWrites = len(overlay.writes)
+
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(reportBytes)
+}
+
+// selfTestScript is one step of the self-test
+type selfTestScript struct {
+	name string
+	run  func() error
+}
+
+// selfTestSteps returns the steps of the self-test run against `tx`
+func selfTestSteps(tx shim.ChaincodeStubInterface) []selfTestScript {
+	var supply int
+	return []selfTestScript{
+		{"mint", func() error {
+			var err error
+			supply, err = getTotalSupply(tx)
+			if err != nil {
+				return err
+			}
+			credited, err := mintBalance(tx, selfTestOwner, selfTestAmount)
+			if err != nil {
+				return err
+			}
+			if credited != selfTestAmount {
+				return fmt.Errorf("minted %d instead of %d", credited, selfTestAmount)
+			}
+			err = expectSupply(tx, supply+selfTestAmount)
+			if err != nil {
+				return err
+			}
+			return expectBalances(tx, selfTestAmount, 0)
+		}},
+		{"transfer", func() error {
+			credited, err := transferBalanceSkipping(tx, selfTestOwner, selfTestRecipient, 40, selfTestHooks)
+			if err != nil {
+				return err
+			}
+			if credited != 40 {
+				return fmt.Errorf("transferred %d instead of 40", credited)
+			}
+			return expectBalances(tx, 60, 40)
+		}},
+		{"approve", func() error {
+			err := setAllowance(tx, selfTestOwner, selfTestSpender, 30, "", "")
+			if err != nil {
+				return err
+			}
+			return expectAllowance(tx, 30)
+		}},
+		{"transferFrom", func() error {
+			credited, remaining, err := spendAllowance(tx, selfTestOwner, selfTestSpender, selfTestRecipient, 25, selfTestHooks)
+			if err != nil {
+				return err
+			}
+			if credited != 25 || remaining != 5 {
+				return fmt.Errorf("spent %d leaving %d instead of 25 leaving 5", credited, remaining)
+			}
+			err = expectAllowance(tx, 5)
+			if err != nil {
+				return err
+			}
+			return expectBalances(tx, 35, 65)
+		}},
+		{"burn", func() error {
+			_, err := burnBalance(tx, selfTestRecipient, 65, "self-test", "")
+			if err != nil {
+				return err
+			}
+			_, err = burnBalance(tx, selfTestOwner, 35, "self-test", "")
+			if err != nil {
+				return err
+			}
+			err = expectBalances(tx, 0, 0)
+			if err != nil {
+				return err
+			}
+			return expectSupply(tx, supply)
+		}},
+	}
+}
+
+// expectBalances returns an error unless the reserved owner and recipient hold the given balances
+func expectBalances(APIstub shim.ChaincodeStubInterface, owner int, recipient int) error {
+	for account, expected := range map[string]int{selfTestOwner: owner, selfTestRecipient: recipient} {
+		balance, _, err := getBalance(APIstub, account)
+		if err != nil {
+			return err
+		}
+		if balance != expected {
+			return fmt.Errorf("balance of %s is %d instead of %d", account, balance, expected)
+		}
+	}
+	return nil
+}
+
+// expectAllowance returns an error unless the reserved spender's allowance from the owner is `expected`
+func expectAllowance(APIstub shim.ChaincodeStubInterface, expected int) error {
+	allowanceKey, err := buildAllowanceKey(APIstub, selfTestOwner, selfTestSpender)
+	if err != nil {
+		return err
+	}
+	allowanceBytes, err := APIstub.GetState(allowanceKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", allowancePrefix, err)
+	}
+	allowance, _ := strconv.Atoi(string(allowanceBytes))
+	if allowance != expected {
+		return fmt.Errorf("allowance is %d instead of %d", allowance, expected)
+	}
+	return nil
+}
+
+// expectSupply returns an error unless the total supply is `expected`
+func expectSupply(APIstub shim.ChaincodeStubInterface, expected int) error {
+	supply, err := getTotalSupply(APIstub)
+	if err != nil {
+		return err
+	}
+	if supply != expected {
+		return fmt.Errorf("total supply is %d instead of %d", supply, expected)
+	}
+	return nil
+}
+
+// checkSelfTestAllowed returns an error unless the token is in devMode or a maintenance window is open
+func checkSelfTestAllowed(APIstub shim.ChaincodeStubInterface) error {
+	devMode, err := isDevMode(APIstub)
+	if err != nil || devMode {
+		return err
+	}
+	until, err := getIntSetting(APIstub, maintenanceUntilKey)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	if now >= int64(until) {
+		return fmt.Errorf("SelfTest is only available in devMode or during a maintenance window")
+	}
+	return nil
+}
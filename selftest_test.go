@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestSelfTestLeavesNoResidue runs SelfTest in devMode and in a maintenance window; it must pass
+// every step without writing, deleting or emitting anything
+func TestSelfTestLeavesNoResidue(t *testing.T) {
+	tests := map[string]func(t *testing.T) *chaintest.Ledger{
+		"devMode": func(t *testing.T) *chaintest.Ledger {
+			return newToken(t, `{"devMode":true}`)
+		},
+		"maintenance window": func(t *testing.T) *chaintest.Ledger {
+			ledger := newToken(t, "")
+			mustInvoke(t, ledger, admin, "SetMaintenanceWindow", strconv.FormatInt(ledger.Now+3600, 10))
+			return ledger
+		},
+	}
+	for name, build := range tests {
+		t.Run(name, func(t *testing.T) {
+			ledger := build(t)
+			fund(t, ledger, alice.Account, 100)
+			before := ledger.Fork()
+
+			result := ledger.Invoke(admin, "SelfTest")
+			if result.Status != shim.OK {
+				t.Fatalf("SelfTest failed with %q", result.Message)
+			}
+			if len(result.Writes) != 0 || len(result.Events) != 0 {
+				t.Fatalf("SelfTest made %d writes and %d events, expected none", len(result.Writes), len(result.Events))
+			}
+			if !sameState(before, ledger) {
+				t.Fatalf("SelfTest changed the state")
+			}
+
+			var report selfTestReport
+			err := json.Unmarshal(result.Payload, &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.Passed || len(report.Steps) != 5 || report.DiscardedWrites == 0 {
+				t.Fatalf("SelfTest returned %s, expected 5 passed steps and discarded writes", result.Payload)
+			}
+			for _, step := range report.Steps {
+				if !step.Passed {
+					t.Fatalf("SelfTest reported step %s as failed in a passing report", step.Name)
+				}
+			}
+		})
+	}
+}
+
+// TestSelfTestReportsFailure breaks a step of SelfTest; it must fail with ERR_SELF_TEST_FAILED
+// naming the step and its cause, still without writing anything
+func TestSelfTestReportsFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		capArgs  []string
+		expected string
+	}{
+		{"owner capped", []string{selfTestOwner, "50"}, "self-test step mint failed: " + errBalanceCap},
+		{"owner capped with spillover", []string{selfTestOwner, "50", "true"}, "self-test step mint failed: minted 50 instead of 100"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newComplianceToken(t)
+			mustInvoke(t, ledger, admin, "SetMaintenanceWindow", strconv.FormatInt(ledger.Now+3600, 10))
+			mustInvoke(t, ledger, admin, "SetBalanceCap", test.capArgs...)
+			before := ledger.Fork()
+
+			result := ledger.Invoke(admin, "SelfTest")
+			if result.Status == shim.OK {
+				t.Fatalf("SelfTest passed with %s, expected it to fail", result.Payload)
+			}
+			if !strings.HasPrefix(result.Message, errSelfTestFailed) || !strings.Contains(result.Message, test.expected) {
+				t.Fatalf("SelfTest failed with %q, expected %s mentioning %q", result.Message, errSelfTestFailed, test.expected)
+			}
+			if len(result.Writes) != 0 || !sameState(before, ledger) {
+				t.Fatalf("the failed SelfTest changed the state")
+			}
+		})
+	}
+}
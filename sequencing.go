@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for strict transfer sequencing
+const sequencePrefix = "sequence"
+
+// EnableSequencing makes every Transfer from the caller's account carry the next sequence number
+// The first transfer after enabling uses 1. Gateways that resubmit after an MVCC conflict can
+// then never apply a transfer twice or out of order. The tradeoff is throughput: every transfer
+// reads and writes the sequence key, so only one transfer from the account can be in flight per
+// block, and any other that was endorsed against the same sequence fails validation.
+func (s *SmartContract) EnableSequencing(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, enabled, err := getLastSequence(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if enabled {
+		return shim.Error("Sequencing is already enabled for this account")
+	}
+
+	err = putLastSequence(APIstub, account, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// DisableSequencing stops requiring sequence numbers on transfers from the caller's account
+// Enabling it again starts over at 1.
+func (s *SmartContract) DisableSequencing(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	account, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sequenceKey, err := buildKey(APIstub, sequencePrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(sequenceKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", sequencePrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetNextSequence returns the sequence number the next Transfer from `account` must carry
+func (s *SmartContract) GetNextSequence(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	last, enabled, err := getLastSequence(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !enabled {
+		return shim.Error("Sequencing is not enabled for this account")
+	}
+	return shim.Success([]byte(strconv.FormatInt(last+1, 10)))
+}
+
+// applySequence checks that `sequence` is the next sequence number of `account` and records it
+// An empty sequence is only accepted from accounts without sequencing, and a sequence on such
+// an account is rejected so the client does not believe it is protected.
+func applySequence(APIstub shim.ChaincodeStubInterface, account string, sequence string) error {
+	last, enabled, err := getLastSequence(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if sequence != "" {
+			return fmt.Errorf("ERR_SEQUENCE: sequencing is not enabled for %s", account)
+		}
+		return nil
+	}
+
+	next, err := strconv.ParseInt(sequence, 10, 64)
+	if err != nil || next != last+1 {
+		return fmt.Errorf("ERR_SEQUENCE: expected sequence %d", last+1)
+	}
+	return putLastSequence(APIstub, account, next)
+}
+
+// getLastSequence returns the last applied sequence number of `account` and whether sequencing is enabled
+func getLastSequence(APIstub shim.ChaincodeStubInterface, account string) (int64, bool, error) {
+	sequenceKey, err := buildKey(APIstub, sequencePrefix, []string{account})
+	if err != nil {
+		return 0, false, err
+	}
+	sequenceBytes, err := APIstub.GetState(sequenceKey)
+	if err != nil {
+		return 0, false, stateError(APIstub, "GetState", sequencePrefix, err)
+	}
+	if sequenceBytes == nil {
+		return 0, false, nil
+	}
+	last, _ := strconv.ParseInt(string(sequenceBytes), 10, 64)
+	return last, true, nil
+}
+
+// putLastSequence records `sequence` as the last applied sequence number of `account`
+func putLastSequence(APIstub shim.ChaincodeStubInterface, account string, sequence int64) error {
+	sequenceKey, err := buildKey(APIstub, sequencePrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(sequenceKey, []byte(strconv.FormatInt(sequence, 10)))
+	if err != nil {
+		return stateError(APIstub, "PutState", sequencePrefix, err)
+	}
+	return nil
+}
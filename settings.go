@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// settingDefaults holds the default of every optional token-wide setting
+// A token initialized or upgraded before a feature existed has none of its keys, so settings are
+// only read through getSetting and its typed variants, which fall back to these defaults: the
+// feature is then off or unlimited, as it was before the upgrade.
+var settingDefaults = map[string]string{
+	identityModeKey:        identityModeCreator,
+	termsRequiredKey:       "false",
+	documentHashKey:        "",
+	humanAmountsKey:        "false",
+	strictQueriesKey:       "false",
+	minterOrgKey:           "",
+	requireAdminOUKey:      "false",
+	devModeKey:             "false",
+	faucetCapKey:           strconv.Itoa(defaultFaucetCap),
+	recentActivitySizeKey:  "0",
+	privateBalancesKey:     "false",
+	experimentalEnabledKey: "false",
+	maxSupplyKey:           "0",
+	maxSupplyDelayKey:      "0",
+	maxAccountsKey:         "0",
+	dormancyThresholdKey:   "0",
+	travelRuleThresholdKey: "0",
+	maintenanceUntilKey:    "0",
+	strictModeKey:          "false",
+}
+
+// defaultSetting is an entry of GetDefaultSettings
+type defaultSetting struct {
+	Key     string `json:"key"`
+	Default string `json:"default"`
+}
+
+// getSetting returns the value of the setting stored under `key`, or its default if it was never set
+func getSetting(APIstub shim.ChaincodeStubInterface, key string) (string, error) {
+	defaultValue, known := settingDefaults[key]
+	if !known {
+		return "", fmt.Errorf("Unknown setting %s", key)
+	}
+	valueBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return "", stateError(APIstub, "GetState", key, err)
+	}
+	if valueBytes == nil {
+		return defaultValue, nil
+	}
+	return string(valueBytes), nil
+}
+
+// getBoolSetting reports whether the setting stored under `key` is "true"
+func getBoolSetting(APIstub shim.ChaincodeStubInterface, key string) (bool, error) {
+	value, err := getSetting(APIstub, key)
+	if err != nil {
+		return false, err
+	}
+	return value == "true", nil
+}
+
+// getIntSetting returns the numeric setting stored under `key`
+func getIntSetting(APIstub shim.ChaincodeStubInterface, key string) (int, error) {
+	value, err := getSetting(APIstub, key)
+	if err != nil {
+		return 0, err
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value %q of setting %s", value, key)
+	}
+	return number, nil
+}
+
+// GetDefaultSettings lists every setting that was never set and so uses its default, sorted by key
+// After an upgrade it shows the features the new build added but nobody configured yet. Each
+// entry is the setting's key and the default it falls back to.
+func (s *SmartContract) GetDefaultSettings(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	keys := make([]string, 0, len(settingDefaults))
+	for key := range settingDefaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	defaults := []defaultSetting{}
+	for _, key := range keys {
+		valueBytes, err := APIstub.GetState(key)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "GetState", key, err).Error())
+		}
+		if valueBytes == nil {
+			defaults = append(defaults, defaultSetting{Key: key, Default: settingDefaults[key]})
+		}
+	}
+
+	responseBytes, err := json.Marshal(defaults)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+)
+
+// defaultSettingKeys returns the settings listed in the GetDefaultSettings `payload`, by key
+func defaultSettingKeys(t *testing.T, payload string) map[string]string {
+	t.Helper()
+	var defaults []defaultSetting
+	err := json.Unmarshal([]byte(payload), &defaults)
+	if err != nil {
+		t.Fatalf("GetDefaultSettings returned %q: %s", payload, err)
+	}
+	keys := make(map[string]string, len(defaults))
+	for _, setting := range defaults {
+		keys[setting.Key] = setting.Default
+	}
+	return keys
+}
+
+func TestGetDefaultSettingsListsUnsetSettings(t *testing.T) {
+	ledger := newToken(t, "")
+	defaults := defaultSettingKeys(t, mustInvoke(t, ledger, alice, "GetDefaultSettings"))
+	if value, listed := defaults[strictModeKey]; !listed || value != "false" {
+		t.Fatalf("GetDefaultSettings lists %s as %q, %t; expected its default", strictModeKey, value, listed)
+	}
+
+	mustInvoke(t, ledger, admin, "SetStrictMode", "true")
+	defaults = defaultSettingKeys(t, mustInvoke(t, ledger, alice, "GetDefaultSettings"))
+	if _, listed := defaults[strictModeKey]; listed {
+		t.Fatalf("GetDefaultSettings lists %s after it was set", strictModeKey)
+	}
+}
+
+func TestV1LedgerUsesEveryDefault(t *testing.T) {
+	// The first version stored only the token metadata under plain keys
+	ledger := chaintest.NewLedger(new(SmartContract))
+	ledger.SetState(nameKey, []byte("Token"))
+	ledger.SetState(symbolKey, []byte("TKN"))
+	ledger.SetState(decimalsKey, []byte("0"))
+	ledger.SetState(totalSupplyKey, []byte("0"))
+
+	defaults := defaultSettingKeys(t, mustInvoke(t, ledger, alice, "GetDefaultSettings"))
+	if len(defaults) != len(settingDefaults) {
+		t.Fatalf("GetDefaultSettings lists %d settings of a v1 ledger, expected all %d", len(defaults), len(settingDefaults))
+	}
+	for key, value := range settingDefaults {
+		if defaults[key] != value {
+			t.Errorf("GetDefaultSettings lists %s as %q, expected %q", key, defaults[key], value)
+		}
+	}
+
+	if got := mustInvoke(t, ledger, alice, "TotalSupply"); got != "0" {
+		t.Fatalf("TotalSupply of a v1 ledger is %q, expected 0", got)
+	}
+	if got := balanceOf(t, ledger, alice.Account); got != 0 {
+		t.Fatalf("balance of a new account of a v1 ledger is %d, expected 0", got)
+	}
+}
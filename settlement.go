@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// settlementPlan lists every debit and credit of a transfer before any balance is written
+// Balances are read once and changes are netted per account, so an account that is both debited
+// and credited is written once with the right balance. apply refuses a plan whose debits and
+// credits differ or that would leave a balance negative.
+type settlementPlan struct {
+	accounts  []string
+	balances  map[string]int
+	exists    map[string]bool
+	changes   map[string]int
+	movements []movement
+}
+
+// newSettlementPlan returns an empty settlement plan
+func newSettlementPlan() *settlementPlan {
+	return &settlementPlan{
+		balances: make(map[string]int),
+		exists:   make(map[string]bool),
+		changes:  make(map[string]int),
+	}
+}
+
+// balance returns the balance of `account` before the plan and whether the account exists
+func (p *settlementPlan) balance(APIstub shim.ChaincodeStubInterface, account string) (int, bool, error) {
+	if balance, read := p.balances[account]; read {
+		return balance, p.exists[account], nil
+	}
+	balance, exists, err := getBalance(APIstub, account)
+	if err != nil {
+		return 0, false, err
+	}
+	p.accounts = append(p.accounts, account)
+	p.balances[account] = balance
+	p.exists[account] = exists
+	return balance, exists, nil
+}
+
+// transfer plans the move of `amount` tokens from `from` to `to`; a zero amount plans nothing
+func (p *settlementPlan) transfer(APIstub shim.ChaincodeStubInterface, from string, to string, amount int) error {
+	if amount < 0 {
+		return fmt.Errorf("settlement plan: invalid amount %d from %s to %s", amount, from, to)
+	}
+	if amount == 0 {
+		return nil
+	}
+	for _, account := range []string{from, to} {
+		_, _, err := p.balance(APIstub, account)
+		if err != nil {
+			return err
+		}
+	}
+	fromChange, err := checkedSub(APIstub, p.changes[from], amount)
+	if err != nil {
+		return err
+	}
+	p.changes[from] = fromChange
+	toChange, err := checkedAdd(APIstub, p.changes[to], amount)
+	if err != nil {
+		return err
+	}
+	// The credited balance must fit as well, check reports the other plan errors
+	_, err = checkedAdd(APIstub, p.balances[to], toChange)
+	if err != nil {
+		return err
+	}
+	p.changes[to] = toChange
+	p.movements = append(p.movements, movement{From: from, To: to, Value: amount})
+	return nil
+}
+
+// check returns an error unless the debits of the plan equal its credits and no account is overdrawn
+func (p *settlementPlan) check() error {
+	net := 0
+	for _, account := range p.accounts {
+		change := p.changes[account]
+		if p.balances[account]+change < 0 {
+			return fmt.Errorf("settlement plan: balance of %s would be negative", account)
+		}
+		net += change
+	}
+	if net != 0 {
+		return fmt.Errorf("settlement plan: debits and credits differ by %d", net)
+	}
+	return nil
+}
+
+// apply checks the plan, then writes every changed balance and records the movements once
+func (p *settlementPlan) apply(APIstub shim.ChaincodeStubInterface) error {
+	err := p.check()
+	if err != nil {
+		return err
+	}
+	for _, account := range p.accounts {
+		change := p.changes[account]
+		if change == 0 {
+			continue
+		}
+		err = putBalance(APIstub, account, p.balances[account]+change)
+		if err != nil {
+			return notCommitted(err)
+		}
+	}
+	err = recordMovements(APIstub, p.movements...)
+	if err != nil {
+		return notCommitted(err)
+	}
+	return nil
+}
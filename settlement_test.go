@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Accounts the fee and dust postings of settleWithFees go to
+const feeCollector = "fees"
+const dustSweep = "dust"
+
+// settlingContract is the token with one more function, Settle, which settles a transfer with a
+// fee and dust sweeping through a settlement plan the way those features would contribute to it
+type settlingContract struct {
+	SmartContract
+}
+
+func (s *settlingContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Response {
+	function, args := APIstub.GetFunctionAndParameters()
+	if function != "Settle" {
+		return s.SmartContract.Invoke(APIstub)
+	}
+	err := settleWithFees(&txStub{ChaincodeStubInterface: APIstub}, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// settleWithFees moves `amount` from `from` to `to` in one settlement plan: a fee of `feeBps`
+// basis points goes to feeCollector, the recipient's balance cap applies to the rest, and a sender
+// left with less than `dust` tokens is swept to dustSweep
+func settleWithFees(APIstub shim.ChaincodeStubInterface, args []string) error {
+	from, to := args[0], args[1]
+	amount, _ := strconv.Atoi(args[2])
+	feeBps, _ := strconv.Atoi(args[3])
+	dust, _ := strconv.Atoi(args[4])
+
+	plan := newSettlementPlan()
+	fromBalance, _, err := plan.balance(APIstub, from)
+	if err != nil {
+		return err
+	}
+	if fromBalance < amount {
+		return insufficientBalance(APIstub, fromBalance, amount)
+	}
+	toBalance, _, err := plan.balance(APIstub, to)
+	if err != nil {
+		return err
+	}
+	fee := amount * feeBps / 10000
+	credited, err := applyBalanceCap(APIstub, to, toBalance, amount-fee)
+	if err != nil {
+		return err
+	}
+	err = plan.transfer(APIstub, from, feeCollector, fee)
+	if err != nil {
+		return err
+	}
+	err = plan.transfer(APIstub, from, to, credited)
+	if err != nil {
+		return err
+	}
+	if left := fromBalance - fee - credited; left < dust {
+		err = plan.transfer(APIstub, from, dustSweep, left)
+		if err != nil {
+			return err
+		}
+	}
+	return plan.apply(APIstub)
+}
+
+// TestSettlementConservesWithFeesDustAndCaps settles random transfers under random fee rates,
+// dust thresholds and recipient caps, and requires every token debited from the sender to be
+// credited exactly once: to the recipient, as a fee or as swept dust. Spillover stays with the
+// sender and a refused settlement writes nothing. The seed is fixed so a failure reproduces.
+func TestSettlementConservesWithFeesDustAndCaps(t *testing.T) {
+	rng := rand.New(rand.NewSource(2014))
+	accounts := []string{alice.Account, bob.Account, feeCollector, dustSweep}
+	runs := 300
+	if testing.Short() {
+		runs = 50
+	}
+	for run := 0; run < runs; run++ {
+		ledger := chaintest.NewLedger(new(settlingContract))
+		mustInvoke(t, ledger, admin, "Initialize", "Token", "TKN", "0", "0")
+		mustInvoke(t, ledger, admin, "GrantRole", complianceRole, admin.Account)
+
+		senderBalance := 1 + rng.Intn(1000)
+		recipientBalance := rng.Intn(500)
+		amount := 1 + rng.Intn(senderBalance)
+		feeBps := []int{0, 1, 10000, rng.Intn(10001)}[rng.Intn(4)]
+		dust := rng.Intn(50)
+		maxBalance, spillover := 0, false
+		if rng.Intn(3) != 0 {
+			maxBalance, spillover = 1+rng.Intn(1000), rng.Intn(2) == 0
+		}
+		setting := fmt.Sprintf("run %d: %d of %d to a balance of %d, fee %d bps, dust %d, cap %d, spillover %t",
+			run, amount, senderBalance, recipientBalance, feeBps, dust, maxBalance, spillover)
+
+		fund(t, ledger, alice.Account, senderBalance)
+		if recipientBalance > 0 {
+			fund(t, ledger, bob.Account, recipientBalance)
+		}
+		if maxBalance > 0 {
+			mustInvoke(t, ledger, admin, "SetBalanceCap", bob.Account, strconv.Itoa(maxBalance), strconv.FormatBool(spillover))
+		}
+		before := ledger.Fork()
+
+		// The expected settlement, worked out independently of the plan
+		fee := amount * feeBps / 10000
+		credited := amount - fee
+		refused := false
+		if maxBalance > 0 && recipientBalance+credited > maxBalance {
+			headroom := 0
+			if maxBalance > recipientBalance {
+				headroom = maxBalance - recipientBalance
+			}
+			credited, refused = headroom, !spillover
+		}
+		swept := 0
+		if left := senderBalance - fee - credited; left < dust {
+			swept = left
+		}
+
+		result := ledger.Invoke(alice, "Settle", alice.Account, bob.Account, strconv.Itoa(amount), strconv.Itoa(feeBps), strconv.Itoa(dust))
+		if refused {
+			if result.Status == shim.OK {
+				t.Fatalf("%s: Settle succeeded over the cap without spillover", setting)
+			}
+			if !sameState(before, ledger) {
+				t.Fatalf("%s: refused Settle changed the state", setting)
+			}
+			continue
+		}
+		if result.Status != shim.OK {
+			t.Fatalf("%s: Settle failed with %q", setting, result.Message)
+		}
+
+		expected := map[string]int{
+			alice.Account: senderBalance - fee - credited - swept,
+			bob.Account:   recipientBalance + credited,
+			feeCollector:  fee,
+			dustSweep:     swept,
+		}
+		sum := 0
+		for _, account := range accounts {
+			got := balanceOf(t, ledger, account)
+			if got != expected[account] {
+				t.Fatalf("%s: %s holds %d, expected %d", setting, account, got, expected[account])
+			}
+			sum += got
+		}
+		supply, err := strconv.Atoi(mustInvoke(t, ledger, admin, "TotalSupply"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != senderBalance+recipientBalance || supply != sum {
+			t.Fatalf("%s: balances add up to %d with a supply of %d, expected %d", setting, sum, supply, senderBalance+recipientBalance)
+		}
+	}
+}
+
+// TestSettlementPlanRefusesImbalance checks that a plan whose postings were not made in balanced
+// pairs, or that overdraws an account, fails the check apply runs before writing anything
+func TestSettlementPlanRefusesImbalance(t *testing.T) {
+	tests := []struct {
+		name     string
+		changes  map[string]int
+		expected string
+	}{
+		{"balanced", map[string]int{alice.Account: -100, bob.Account: 100}, ""},
+		{"credit without a debit", map[string]int{bob.Account: 10}, "settlement plan: debits and credits differ by 10"},
+		{"debit without a credit", map[string]int{alice.Account: -10}, "settlement plan: debits and credits differ by -10"},
+		{"fee lost", map[string]int{alice.Account: -10, bob.Account: 9}, "settlement plan: debits and credits differ by -1"},
+		{"overdraft", map[string]int{alice.Account: -101, bob.Account: 101}, "settlement plan: balance of " + alice.Account + " would be negative"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := newSettlementPlan()
+			plan.accounts = []string{alice.Account, bob.Account}
+			plan.balances[alice.Account] = 100
+			for account, change := range test.changes {
+				plan.changes[account] = change
+			}
+
+			err := plan.check()
+			if test.expected == "" && err != nil {
+				t.Fatalf("check failed with %q", err)
+			}
+			if test.expected != "" && (err == nil || err.Error() != test.expected) {
+				t.Fatalf("check returned %v, expected %q", err, test.expected)
+			}
+		})
+	}
+}
+
+// TestTransfersConserveWithCaps runs random transfers and batches between accounts whose caps
+// change at random, with and without spillover. After every step the balances must add up to the
+// total supply; a refused step changes no balance, and a settled one only moves tokens from its caller.
+func TestTransfersConserveWithCaps(t *testing.T) {
+	rng := rand.New(rand.NewSource(2015))
+	runs := 30
+	if testing.Short() {
+		runs = 5
+	}
+	account := func() chaintest.Identity {
+		return conservationCallers[rng.Intn(len(conservationCallers))]
+	}
+	for run := 0; run < runs; run++ {
+		ledger := newComplianceToken(t)
+		for _, holder := range conservationCallers {
+			fund(t, ledger, holder.Account, 100)
+		}
+		balances := func() map[string]int {
+			current := map[string]int{}
+			for _, holder := range conservationCallers {
+				current[holder.Account] = balanceOf(t, ledger, holder.Account)
+			}
+			return current
+		}
+
+		for step := 0; step < 40; step++ {
+			caller, function, args := account(), "", []string{}
+			switch rng.Intn(4) {
+			case 0:
+				caller, function = admin, "SetBalanceCap"
+				args = []string{account().Account, strconv.Itoa(rng.Intn(250)), strconv.FormatBool(rng.Intn(2) == 0)}
+			case 1, 2:
+				function, args = "Transfer", []string{account().Account, strconv.Itoa(1 + rng.Intn(60))}
+			default:
+				function = "TransferBatch"
+				args = []string{fmt.Sprintf(`[{"to":%q,"amount":%d},{"to":%q,"amount":%d}]`, account().Account, 1+rng.Intn(40), account().Account, 1+rng.Intn(40))}
+			}
+
+			before := balances()
+			result := ledger.Invoke(caller, function, args...)
+			after := balances()
+			net := 0
+			for holder, balance := range after {
+				change := balance - before[holder]
+				net += change
+				if change != 0 && (result.Status != shim.OK || function == "SetBalanceCap") {
+					t.Fatalf("run %d step %d: %s%q changed the balance of %s by %d", run, step+1, function, args, holder, change)
+				}
+				if change < 0 && holder != caller.Account {
+					t.Fatalf("run %d step %d: %s%q by %s debited %s", run, step+1, function, args, caller.Account, holder)
+				}
+			}
+			supply, err := strconv.Atoi(mustInvoke(t, ledger, admin, "TotalSupply"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if net != 0 || supply != 100*len(conservationCallers) {
+				t.Fatalf("run %d step %d: %s%q changed the balances by %d and left a supply of %d", run, step+1, function, args, net, supply)
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for spender rotation
+const spenderIndexPrefix = "spenderAllowance"
+const spenderRotationPrefix = "spenderRotation"
+const rotationOptOutPrefix = "spenderRotationOptOut"
+
+// rotatedAllowance describes one allowance re-pointed to the new spender identity
+type rotatedAllowance struct {
+	Owner   string `json:"owner"`
+	Spender string `json:"spender"`
+	Value   int    `json:"value"`
+}
+
+// spenderRotatedEvent provides an organized struct for emitting the SpenderRotated event
+// Fabric keeps a single event per transaction, so the event lists the resulting
+// allowance of every owner whose approval moved to the new spender.
+type spenderRotatedEvent struct {
+	Token      string             `json:"token"`
+	OldSpender string             `json:"oldSpender"`
+	NewSpender string             `json:"newSpender"`
+	Approvals  []rotatedAllowance `json:"approvals"`
+}
+
+// RotateSpender announces that the caller's allowances as spender should move to `newClientID`.
+// The allowances only move once the new identity calls ClaimSpenderRole.
+func (s *SmartContract) RotateSpender(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	newSpender := args[0]
+	if newSpender == "" {
+		return shim.Error("New client ID must be a non-empty string")
+	}
+
+	oldSpender, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if oldSpender == newSpender {
+		return shim.Error("New client ID must differ from the caller")
+	}
+
+	rotationKey, err := buildKey(APIstub, spenderRotationPrefix, []string{newSpender})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(rotationKey, []byte(oldSpender))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", spenderRotationPrefix, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ClaimSpenderRole moves every allowance held by the rotating spender to the caller.
+// Allowances are moved with their value at claim time, so anything spent between
+// RotateSpender and the claim is already deducted. Owners that opted out keep
+// their allowance on the old identity.
+func (s *SmartContract) ClaimSpenderRole(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	newSpender, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	rotationKey, err := buildKey(APIstub, spenderRotationPrefix, []string{newSpender})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	oldSpenderBytes, err := APIstub.GetState(rotationKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "GetState", spenderRotationPrefix, err).Error())
+	}
+	if oldSpenderBytes == nil {
+		return shim.Error("No spender rotation pending for caller")
+	}
+	oldSpender := string(oldSpenderBytes)
+
+	owners, err := getIndexedOwners(APIstub, oldSpender)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approvals := []rotatedAllowance{}
+	for _, owner := range owners {
+		optOutKey, err := buildKey(APIstub, rotationOptOutPrefix, []string{owner, oldSpender})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		optOutBytes, err := APIstub.GetState(optOutKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "GetState", rotationOptOutPrefix, err).Error())
+		}
+		if optOutBytes != nil {
+			continue
+		}
+
+		value, err := moveAllowance(APIstub, owner, oldSpender, newSpender)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		approvals = append(approvals, rotatedAllowance{Owner: owner, Spender: newSpender, Value: value})
+	}
+
+	err = APIstub.DelState(rotationKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", spenderRotationPrefix, err).Error())
+	}
+
+	// Emit SpenderRotated event
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventData := spenderRotatedEvent{Token: symbol, OldSpender: oldSpender, NewSpender: newSpender, Approvals: approvals}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.SetEvent("SpenderRotated", eventBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// SetSpenderRotationOptOut lets the caller, as owner, keep its allowance to `spender`
+// on that identity when the spender rotates
+func (s *SmartContract) SetSpenderRotationOptOut(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	spender := args[0]
+	optOut, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return shim.Error("Invalid opt-out flag. Expecting true or false")
+	}
+
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	optOutKey, err := buildKey(APIstub, rotationOptOutPrefix, []string{owner, spender})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if optOut {
+		err = APIstub.PutState(optOutKey, []byte{0x00})
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", rotationOptOutPrefix, err).Error())
+		}
+	} else {
+		err = APIstub.DelState(optOutKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", rotationOptOutPrefix, err).Error())
+		}
+	}
+
+	return shim.Success(nil)
+}
+
+// indexSpender records that `owner` has approved `spender` so allowances can be found by spender
+func indexSpender(APIstub shim.ChaincodeStubInterface, owner string, spender string) error {
+	indexKey, err := buildKey(APIstub, spenderIndexPrefix, []string{spender, owner})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return stateError(APIstub, "PutState", spenderIndexPrefix, err)
+	}
+	return nil
+}
+
+// getIndexedOwners returns every owner that has approved `spender`
+func getIndexedOwners(APIstub shim.ChaincodeStubInterface, spender string) ([]string, error) {
+	var owners []string
+	_, err := iterate(APIstub, spenderIndexPrefix, []string{spender}, 0, "", func(attributes []string, value []byte) error {
+		owners = append(owners, attributes[1])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return owners, nil
+}
+
+// moveAllowance adds the allowance of `oldSpender` from `owner` to the one of `newSpender`,
+// removes the old allowance and its index entry, and returns the new allowance
+func moveAllowance(APIstub shim.ChaincodeStubInterface, owner string, oldSpender string, newSpender string) (int, error) {
+	oldKey, err := buildAllowanceKey(APIstub, owner, oldSpender)
+	if err != nil {
+		return 0, err
+	}
+	oldBytes, err := APIstub.GetState(oldKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", allowancePrefix, err)
+	}
+	oldAllowance, _ := strconv.Atoi(string(oldBytes))
+
+	newKey, err := buildAllowanceKey(APIstub, owner, newSpender)
+	if err != nil {
+		return 0, err
+	}
+	newBytes, err := APIstub.GetState(newKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", allowancePrefix, err)
+	}
+	newAllowance, _ := strconv.Atoi(string(newBytes))
+	newAllowance += oldAllowance
+
+	// Merging two allowances must not lift a recipient restriction from either of them
+	oldRecipient, err := getAllowedRecipient(APIstub, owner, oldSpender)
+	if err != nil {
+		return 0, err
+	}
+	newRecipient, err := getAllowedRecipient(APIstub, owner, newSpender)
+	if err != nil {
+		return 0, err
+	}
+	if newBytes != nil && oldRecipient != newRecipient {
+		return 0, fmt.Errorf("ERR_RECIPIENT_RESTRICTED: allowances of %s and %s have different recipient restrictions", oldSpender, newSpender)
+	}
+
+	err = APIstub.PutState(newKey, []byte(strconv.Itoa(newAllowance)))
+	if err != nil {
+		return 0, stateError(APIstub, "PutState", allowancePrefix, err)
+	}
+	err = indexSpender(APIstub, owner, newSpender)
+	if err != nil {
+		return 0, err
+	}
+
+	// Carry the reference over unless the new spender already has its own
+	oldReference, err := getAllowanceReference(APIstub, owner, oldSpender)
+	if err != nil {
+		return 0, err
+	}
+	newReference, err := getAllowanceReference(APIstub, owner, newSpender)
+	if err != nil {
+		return 0, err
+	}
+	if newReference == "" && oldReference != "" {
+		err = putAllowanceReference(APIstub, owner, newSpender, oldReference)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = putAllowanceReference(APIstub, owner, oldSpender, "")
+	if err != nil {
+		return 0, err
+	}
+	if newBytes == nil && oldRecipient != "" {
+		err = putAllowedRecipient(APIstub, owner, newSpender, oldRecipient)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = putAllowedRecipient(APIstub, owner, oldSpender, "")
+	if err != nil {
+		return 0, err
+	}
+
+	err = APIstub.DelState(oldKey)
+	if err != nil {
+		return 0, stateError(APIstub, "DelState", allowancePrefix, err)
+	}
+	indexKey, err := buildKey(APIstub, spenderIndexPrefix, []string{oldSpender, owner})
+	if err != nil {
+		return 0, err
+	}
+	err = APIstub.DelState(indexKey)
+	if err != nil {
+		return 0, stateError(APIstub, "DelState", spenderIndexPrefix, err)
+	}
+
+	return newAllowance, nil
+}
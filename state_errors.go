@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// stateError wraps the error of a state operation with the operation, the key namespace
+// and the invoked function, so a failing state database is not mistaken for a token error
+func stateError(APIstub shim.ChaincodeStubInterface, operation string, namespace string, err error) error {
+	function, _ := APIstub.GetFunctionAndParameters()
+	return fmt.Errorf("ERR_STATE: %s on %s failed in %s: %v", operation, namespace, function, err)
+}
+
+// notCommitted states on the error of a function that writes several keys that none of its writes took effect
+// Fabric discards the whole write set of a failed invocation, including writes made before the error.
+func notCommitted(err error) error {
+	return fmt.Errorf("%s, no changes were committed", err)
+}
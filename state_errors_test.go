@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// TestMovementWriteFailures fails each write of Transfer, TransferFrom and TransferBatch in turn
+// The failure must name the function and say that no changes were committed, and the state must
+// be exactly as before.
+func TestMovementWriteFailures(t *testing.T) {
+	tests := []struct {
+		caller   chaintest.Identity
+		function string
+		args     []string
+	}{
+		{alice, "Transfer", []string{carol.Account, "10", "", "invoice 7"}},
+		{bob, "TransferFrom", []string{alice.Account, carol.Account, "10"}},
+		{alice, "TransferBatch", []string{`[{"to":"` + bob.Account + `","amount":10},{"to":"` + carol.Account + `","amount":5}]`}},
+	}
+	for _, test := range tests {
+		ledger := newToken(t, `{"recentActivity":true}`)
+		fund(t, ledger, alice.Account, 100)
+		mustInvoke(t, ledger, alice, "Approve", bob.Account, "50")
+
+		baseline := ledger.Fork().Invoke(test.caller, test.function, test.args...)
+		if baseline.Status != shim.OK {
+			t.Fatalf("%s: %s", test.function, baseline.Message)
+		}
+		for position := 1; position <= len(baseline.Writes); position++ {
+			fork := ledger.Fork()
+			result := fork.Submit(chaintest.Tx{Caller: test.caller, Function: test.function, Args: test.args, FailWrite: position})
+
+			if result.Status == shim.OK {
+				t.Fatalf("%s succeeded with write %d of %d failing", test.function, position, len(baseline.Writes))
+			}
+			for _, part := range []string{"ERR_STATE", "failed in " + test.function, chaintest.ErrInjectedWrite.Error(), "no changes were committed"} {
+				if !strings.Contains(result.Message, part) {
+					t.Fatalf("%s with write %d of %d failing failed with %q, expected it to mention %q", test.function, position, len(baseline.Writes), result.Message, part)
+				}
+			}
+			if !sameState(ledger, fork) {
+				t.Fatalf("%s with write %d of %d failing changed the state", test.function, position, len(baseline.Writes))
+			}
+		}
+	}
+}
+
+// sameState reports whether both ledgers hold the same keys with the same values
+func sameState(a *chaintest.Ledger, b *chaintest.Ledger) bool {
+	aKeys, bKeys := a.Keys(), b.Keys()
+	if len(aKeys) != len(bKeys) {
+		return false
+	}
+	for i, key := range aKeys {
+		if key != bKeys[i] || !bytes.Equal(a.State(key), b.State(key)) {
+			return false
+		}
+	}
+	return true
+}
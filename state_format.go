@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for the state format marker
+const stateFormatKey = "stateFormat"
+
+// Define the state formats of balances
+// In the integer format a balance is a bare decimal integer, as every version wrote it before
+// balance records. In the record format it is a balanceRecord JSON object. Reads accept both
+// whatever the marker says; writes only use the record format once UpgradeStateFormat recorded it.
+const stateFormatInteger = 1
+const stateFormatRecord = 2
+
+// latestStateFormat is the newest state format this build can write
+const latestStateFormat = stateFormatRecord
+
+// UpgradeStateFormat lets balances be written in state format `version`; only admins can upgrade
+// Call it once every peer runs a build that reads the new format: until then peers still on an
+// older build would fail to read the balances written in it and endorsements would diverge. The
+// format can only move forward, since older builds cannot read what the new format wrote.
+func (s *SmartContract) UpgradeStateFormat(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	version, err := strconv.Atoi(args[0])
+	if err != nil || version > latestStateFormat {
+		return shim.Error(fmt.Sprintf("Invalid state format. Expecting at most %d", latestStateFormat))
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	current, err := getStateFormat(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if version <= current {
+		return shim.Error(fmt.Sprintf("State format is already %d", current))
+	}
+
+	err = APIstub.PutState(stateFormatKey, []byte(strconv.Itoa(version)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", stateFormatKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// StateFormat returns the state format balances are written in
+func (s *SmartContract) StateFormat(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	format, err := getStateFormat(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.Itoa(format)))
+}
+
+// getStateFormat returns the state format balances are written in, the integer format until upgraded
+func getStateFormat(APIstub shim.ChaincodeStubInterface) (int, error) {
+	formatBytes, err := APIstub.GetState(stateFormatKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", stateFormatKey, err)
+	}
+	if formatBytes == nil {
+		return stateFormatInteger, nil
+	}
+	format, err := strconv.Atoi(string(formatBytes))
+	if err != nil || format < stateFormatInteger || format > latestStateFormat {
+		return 0, fmt.Errorf("ERR_STATE: unsupported state format %q", formatBytes)
+	}
+	return format, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// storedBalanceKey returns the composite key the balance of `account` is stored under
+func storedBalanceKey(account string) string {
+	return "\x00" + balancePrefix + "\x00" + account + "\x00"
+}
+
+func TestBalancesStayIntegersUntilUpgrade(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	if got := string(ledger.State(storedBalanceKey(alice.Account))); got != "100" {
+		t.Fatalf("balance is stored as %q before the upgrade, expected a bare integer", got)
+	}
+	if got := mustInvoke(t, ledger, alice, "StateFormat"); got != "1" {
+		t.Fatalf("StateFormat is %q before the upgrade, expected 1", got)
+	}
+
+	mustInvoke(t, ledger, admin, "UpgradeStateFormat", "2")
+	// Balances written before the upgrade keep their format until their next write
+	if got := string(ledger.State(storedBalanceKey(alice.Account))); got != "100" {
+		t.Fatalf("balance is stored as %q after the upgrade, expected it unchanged", got)
+	}
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+	if got := string(ledger.State(storedBalanceKey(alice.Account))); got != `{"balance":90,"counter":1}` {
+		t.Fatalf("balance is stored as %q after a write, expected a balance record", got)
+	}
+	if got := string(ledger.State(storedBalanceKey(bob.Account))); got != `{"balance":10,"counter":1}` {
+		t.Fatalf("new balance is stored as %q, expected a balance record", got)
+	}
+}
+
+func TestMixedFormatBalances(t *testing.T) {
+	ledger := newToken(t, "")
+	ledger.SetState(storedBalanceKey(alice.Account), []byte("70"))
+	ledger.SetState(storedBalanceKey(bob.Account), []byte(`{"balance":30,"counter":4}`))
+	ledger.SetState(totalSupplyKey, []byte("100"))
+
+	if got := balanceOf(t, ledger, alice.Account); got != 70 {
+		t.Fatalf("integer balance reads as %d, expected 70", got)
+	}
+	if got := balanceOf(t, ledger, bob.Account); got != 30 {
+		t.Fatalf("balance record reads as %d, expected 30", got)
+	}
+
+	// Until the upgrade every write uses the integer format, which older builds can read
+	mustInvoke(t, ledger, bob, "Transfer", alice.Account, "5")
+	if got := string(ledger.State(storedBalanceKey(bob.Account))); got != "25" {
+		t.Fatalf("balance is stored as %q before the upgrade, expected a bare integer", got)
+	}
+	if got := balanceOf(t, ledger, alice.Account) + balanceOf(t, ledger, bob.Account); got != 100 {
+		t.Fatalf("balances add up to %d after a transfer between formats, expected 100", got)
+	}
+}
+
+func TestCorruptBalanceFails(t *testing.T) {
+	for _, stored := range []string{"12abc", "-5", "+5", " 5", "1e3", "{", `{"balance":-1}`, `{"balance":"5"}`, "[5]"} {
+		ledger := newToken(t, "")
+		ledger.SetState(storedBalanceKey(alice.Account), []byte(stored))
+
+		message := mustFail(t, ledger, alice, "BalanceOf", alice.Account)
+		if !strings.HasPrefix(message, "ERR_STATE") {
+			t.Errorf("BalanceOf of a balance stored as %q failed with %q, expected ERR_STATE", stored, message)
+		}
+		mustFail(t, ledger, admin, "Mint", alice.Account, "1")
+		if got := string(ledger.State(storedBalanceKey(alice.Account))); got != stored {
+			t.Errorf("balance stored as %q was overwritten with %q", stored, got)
+		}
+	}
+}
+
+func TestStateFormatOnlyMovesForward(t *testing.T) {
+	ledger := newToken(t, "")
+	mustFail(t, ledger, alice, "UpgradeStateFormat", "2")
+	mustFail(t, ledger, admin, "UpgradeStateFormat", "1")
+	mustFail(t, ledger, admin, "UpgradeStateFormat", "3")
+
+	mustInvoke(t, ledger, admin, "UpgradeStateFormat", "2")
+	mustFail(t, ledger, admin, "UpgradeStateFormat", "1")
+	mustFail(t, ledger, admin, "UpgradeStateFormat", "2")
+	if got := mustInvoke(t, ledger, alice, "StateFormat"); got != "2" {
+		t.Fatalf("StateFormat is %q after the upgrade, expected 2", got)
+	}
+}
+
+func TestUnknownStateFormatRefusesWrites(t *testing.T) {
+	// A marker written by a newer build means this build does not know how to write balances
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	ledger.SetState(stateFormatKey, []byte("3"))
+
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "10")
+	if !strings.HasPrefix(message, "ERR_STATE") {
+		t.Fatalf("transfer under an unknown state format failed with %q, expected ERR_STATE", message)
+	}
+}
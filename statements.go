@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// statementBucket aggregates the movements of an account over one period
+// Periods start at From and end before To.
+type statementBucket struct {
+	From           int64 `json:"from"`
+	To             int64 `json:"to"`
+	OpeningBalance int   `json:"openingBalance"`
+	TotalIn        int   `json:"totalIn"`
+	TotalOut       int   `json:"totalOut"`
+	ClosingBalance int   `json:"closingBalance"`
+}
+
+// statementSummaryResponse is the JSON document returned by StatementSummary
+type statementSummaryResponse struct {
+	Token    string            `json:"token"`
+	Account  string            `json:"account"`
+	Buckets  []statementBucket `json:"buckets"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// StatementSummary returns the opening balance, total in, total out and closing balance of
+// `account` for each period of `periodSeconds` from `fromTs` up to `toTs`
+// Up to `limit` periods are returned, 100 by default; pass the returned bookmark to continue.
+// Balances are derived from the recent activity of the account, so the range must start after
+// the oldest movement it still keeps. Only the account holder and auditors can read it.
+func (s *SmartContract) StatementSummary(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 4 && len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 or 6")
+	}
+
+	account := args[0]
+	period, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || period <= 0 {
+		return shim.Error("Invalid period. Expecting a positive number of seconds")
+	}
+	from, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || from < 0 {
+		return shim.Error("Invalid start. Expecting a Unix time in seconds")
+	}
+	to, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || to <= from {
+		return shim.Error("Invalid end. Expecting a Unix time in seconds after the start")
+	}
+	limit := maxPageSize
+	if len(args) == 6 {
+		limit, err = strconv.Atoi(args[4])
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return shim.Error(fmt.Sprintf("Invalid limit. Expecting a number between 1 and %d", maxPageSize))
+		}
+		// The bookmark is the start of the next period
+		if args[5] != "" {
+			next, err := strconv.ParseInt(args[5], 10, 64)
+			if err != nil || next < from || next >= to || (next-from)%period != 0 {
+				return shim.Error("Invalid bookmark")
+			}
+			from = next
+		}
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != account {
+		err = requireRole(APIstub, auditorRole)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	movements, err := getStatementMovements(APIstub, account, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balance, _, err := getBalance(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	buckets := []statementBucket{}
+	bookmark := ""
+	for start := from; start < to; start += period {
+		if len(buckets) == limit {
+			bookmark = strconv.FormatInt(start, 10)
+			break
+		}
+		end := start + period
+		if end > to || end < start {
+			end = to
+		}
+		bucket, err := summarizeBucket(APIstub, account, balance, movements, start, end)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// Each period opens with the closing balance of the one before
+		if len(buckets) > 0 && buckets[len(buckets)-1].ClosingBalance != bucket.OpeningBalance {
+			return shim.Error(fmt.Sprintf("Statement does not reconcile at %d", start))
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(statementSummaryResponse{Token: symbol, Account: account, Buckets: buckets, Bookmark: bookmark})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// getStatementMovements returns the movements of `account` recorded at or after `from`
+// It fails if recent activity is disabled or no longer keeps every movement since `from`.
+func getStatementMovements(APIstub shim.ChaincodeStubInterface, account string, from int64) ([]movement, error) {
+	size, err := getRecentActivitySize(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("Recent activity is not enabled")
+	}
+	count, err := getRecentCount(APIstub, account)
+	if err != nil {
+		return nil, err
+	}
+
+	var movements []movement
+	complete := count <= size
+	for seq := count - 1; seq >= 0 && seq >= count-size; seq-- {
+		slotKey, err := recentSlotKey(APIstub, account, seq%size)
+		if err != nil {
+			return nil, err
+		}
+		movementBytes, err := APIstub.GetState(slotKey)
+		if err != nil {
+			return nil, stateError(APIstub, "GetState", recentPrefix, err)
+		}
+		var m movement
+		err = json.Unmarshal(movementBytes, &m)
+		if err != nil {
+			return nil, err
+		}
+		// Movements are read newest first; once one is older than the range, so are the dropped ones
+		if m.Timestamp < from {
+			complete = true
+			break
+		}
+		movements = append(movements, m)
+	}
+	if !complete {
+		return nil, fmt.Errorf("Recent activity of %s no longer covers %d", account, from)
+	}
+	return movements, nil
+}
+
+// summarizeBucket returns the summary of the period from `start` to `end` of `account`
+// `balance` is the current balance and `movements` every movement since the statement start.
+// The opening balance is the current balance less the net of every later movement, and the
+// closing balance is checked against the one derived the same way from `end`.
+func summarizeBucket(APIstub shim.ChaincodeStubInterface, account string, balance int, movements []movement, start int64, end int64) (statementBucket, error) {
+	bucket := statementBucket{From: start, To: end}
+	opening := balance
+	closing := balance
+	var err error
+	for _, m := range movements {
+		if m.Timestamp < start {
+			continue
+		}
+		in, out := 0, 0
+		if m.To == account {
+			in = m.Value
+		}
+		if m.From == account {
+			out = m.Value
+		}
+		opening, err = checkedSub(APIstub, opening, in)
+		if err != nil {
+			return bucket, err
+		}
+		opening, err = checkedAdd(APIstub, opening, out)
+		if err != nil {
+			return bucket, err
+		}
+		if m.Timestamp >= end {
+			closing, err = checkedSub(APIstub, closing, in)
+			if err != nil {
+				return bucket, err
+			}
+			closing, err = checkedAdd(APIstub, closing, out)
+			if err != nil {
+				return bucket, err
+			}
+			continue
+		}
+		bucket.TotalIn, err = checkedAdd(APIstub, bucket.TotalIn, in)
+		if err != nil {
+			return bucket, err
+		}
+		bucket.TotalOut, err = checkedAdd(APIstub, bucket.TotalOut, out)
+		if err != nil {
+			return bucket, err
+		}
+	}
+	if opening < 0 {
+		return bucket, fmt.Errorf("Recent activity of %s is incomplete before %d", account, start)
+	}
+
+	bucket.OpeningBalance = opening
+	bucket.ClosingBalance, err = checkedAdd(APIstub, opening, bucket.TotalIn)
+	if err == nil {
+		bucket.ClosingBalance, err = checkedSub(APIstub, bucket.ClosingBalance, bucket.TotalOut)
+	}
+	if err != nil {
+		return bucket, err
+	}
+	if bucket.ClosingBalance != closing {
+		return bucket, fmt.Errorf("Statement does not reconcile at %d", end)
+	}
+	return bucket, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// subaccountSeparator joins an account and a department into a sub-account, e.g. "<account>/treasury"
+// Sub-accounts are ordinary balances; only their owner can spend them through TransferFromSubaccounts.
+const subaccountSeparator = "/"
+
+// maxSubaccountOrder bounds the number of sub-accounts a single transfer can draw from
+const maxSubaccountOrder = 20
+
+// subaccountDebit is the part of a transfer taken from one sub-account
+type subaccountDebit struct {
+	Account string `json:"account"`
+	Value   int    `json:"value"`
+}
+
+// subaccountTransferEvent describes a transfer funded by several sub-accounts
+// From is the owner of the sub-accounts and Debits lists what each of them paid, in order.
+type subaccountTransferEvent struct {
+	event
+	Debits []subaccountDebit `json:"debits"`
+}
+
+// TransferFromSubaccounts sends `amount` tokens to `to` from the caller's sub-accounts
+// `subaccountOrderJSON` is a JSON array of sub-accounts of the caller, e.g. ["<account>/ops","<account>/sales"].
+// They are debited in that order until the amount is covered; sub-accounts that are not needed are left
+// untouched. The transfer fails if their combined balance is insufficient.
+// Travel rule data is read from the transient field "travelRule" as in Transfer. Recipients that
+// require a memo must be paid with Transfer.
+// This function triggers a Transfer event
+func (s *SmartContract) TransferFromSubaccounts(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	to := args[0]
+	amount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var order []string
+	err = json.Unmarshal([]byte(args[2]), &order)
+	if err != nil || len(order) == 0 {
+		return shim.Error("Invalid sub-account order. Expecting a non-empty JSON array of sub-accounts")
+	}
+	if len(order) > maxSubaccountOrder {
+		return shim.Error("Too many sub-accounts. Expecting at most 20")
+	}
+
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkSubaccountOrder(APIstub, owner, to, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMemo(APIstub, to, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = spendCategory(APIstub, owner, "", amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	travelRule, err := prepareTravelRule(APIstub, owner, to, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkIncomingAllowed(APIstub, owner, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkTermsAccepted(APIstub, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	plan := newSettlementPlan()
+	toBalance, _, err := plan.balance(APIstub, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	credited, err := applyBalanceCap(APIstub, to, toBalance, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Plan the debits before writing, so an insufficient total fails without partial writes
+	var debits []subaccountDebit
+	available := 0
+	remaining := credited
+	for _, subaccount := range order {
+		if remaining == 0 {
+			break
+		}
+		balance, _, err := plan.balance(APIstub, subaccount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		available += balance
+		if balance == 0 {
+			continue
+		}
+		value := balance
+		if value > remaining {
+			value = remaining
+		}
+		debits = append(debits, subaccountDebit{Account: subaccount, Value: value})
+		remaining -= value
+	}
+	if remaining > 0 {
+		return shim.Error(insufficientBalance(APIstub, available, credited).Error())
+	}
+
+	for _, debit := range debits {
+		err = plan.transfer(APIstub, debit.Account, to, debit.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = plan.apply(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var travelRuleHash string
+	if travelRule != nil {
+		err = putTravelRule(APIstub, *travelRule)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		travelRuleHash = travelRule.record.Hash
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventData := subaccountTransferEvent{
+		event:  event{Token: symbol, From: owner, To: to, Value: credited, Spillover: amount - credited, TravelRuleHash: travelRuleHash},
+		Debits: debits,
+	}
+	eventBytes, err := encodeJSON(eventData)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent("Transfer", eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkSubaccountOrder returns ERR_UNAUTHORIZED unless every entry of `order` is a distinct sub-account of `owner`
+// The recipient cannot be in the order, since a balance cannot be read back after it was written in the same transaction.
+func checkSubaccountOrder(APIstub shim.ChaincodeStubInterface, owner string, to string, order []string) error {
+	seen := make(map[string]bool, len(order))
+	for _, subaccount := range order {
+		department := strings.TrimPrefix(subaccount, owner+subaccountSeparator)
+		if department == subaccount || department == "" {
+			return newCodedError(APIstub, errUnauthorized, map[string]string{"account": subaccount}, "%s is not a sub-account of the caller", subaccount)
+		}
+		if seen[subaccount] {
+			return newCodedError(APIstub, errInvalidAccount, map[string]string{"account": subaccount}, "sub-account %s is listed twice", subaccount)
+		}
+		if subaccount == to {
+			return newCodedError(APIstub, errInvalidAccount, map[string]string{"account": subaccount}, "sub-account %s is also the recipient", subaccount)
+		}
+		seen[subaccount] = true
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Define objectType names for supply history
+const supplyHistoryPrefix = "supplyHistory"
+
+// Define reasons for supply changes
+const supplyReasonInitialize = "initialize"
+const supplyReasonMint = "mint"
+const supplyReasonBurn = "burn"
+const supplyReasonImport = "import"
+
+// supplyChange is the history entry recorded for every change of the total supply
+type supplyChange struct {
+	TxID        string `json:"txId"`
+	Timestamp   int64  `json:"timestamp"`
+	Delta       int    `json:"delta"`
+	Reason      string `json:"reason"`
+	TotalSupply int    `json:"totalSupply"`
+}
+
+// adjustSupply changes the total supply by `delta` and records the change with `reason`
+// This is the only function allowed to write totalSupplyKey, so every supply change is
+// validated and leaves a history entry. It can be called at most once per transaction.
+func adjustSupply(APIstub shim.ChaincodeStubInterface, delta int, reason string) error {
+	totalSupply, err := getTotalSupply(APIstub)
+	if err != nil {
+		return err
+	}
+	// Every balance is at most the total supply, so this also keeps balances from overflowing
+	newSupply, err := checkedAdd(APIstub, totalSupply, delta)
+	if err != nil {
+		return err
+	}
+	if reason == supplyReasonMint {
+		err = trackSupplyIncrease(APIstub, totalSupply, delta)
+		if err != nil {
+			return err
+		}
+	}
+	totalSupply = newSupply
+	if totalSupply < 0 {
+		return fmt.Errorf("Total supply cannot become negative")
+	}
+
+	err = APIstub.PutState(totalSupplyKey, []byte(strconv.Itoa(totalSupply)))
+	if err != nil {
+		return stateError(APIstub, "PutState", totalSupplyKey, err)
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	change := supplyChange{
+		TxID:        APIstub.GetTxID(),
+		Timestamp:   now,
+		Delta:       delta,
+		Reason:      reason,
+		TotalSupply: totalSupply,
+	}
+	historyKey, err := buildKey(APIstub, supplyHistoryPrefix, []string{change.TxID})
+	if err != nil {
+		return err
+	}
+	changeBytes, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(historyKey, changeBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", supplyHistoryPrefix, err)
+	}
+	return nil
+}
+
+// getTotalSupply returns the total supply, or 0 if it was never set
+func getTotalSupply(APIstub shim.ChaincodeStubInterface) (int, error) {
+	totalSupplyBytes, err := APIstub.GetState(totalSupplyKey)
+	if err != nil {
+		return 0, stateError(APIstub, "GetState", totalSupplyKey, err)
+	}
+	totalSupply, _ := strconv.Atoi(string(totalSupplyBytes))
+	return totalSupply, nil
+}
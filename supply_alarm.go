@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for the supply alarm
+const supplyAlarmKey = "supplyAlarm"
+const supplyAlarmWindowKey = "supplyAlarmWindow"
+const mintPausedKey = "mintPaused"
+
+// supplyAlarm is the configured limit on how fast the supply may grow
+type supplyAlarm struct {
+	MaxIncreaseBps int   `json:"maxIncreaseBps"`
+	WindowSeconds  int64 `json:"windowSeconds"`
+}
+
+// supplyAlarmWindow tracks the minting of the current alarm window
+type supplyAlarmWindow struct {
+	Start      int64 `json:"start"`
+	BaseSupply int   `json:"baseSupply"`
+	Increase   int   `json:"increase"`
+}
+
+// supplyAlarmEvent describes a mint that tripped the supply alarm
+// It carries the Transfer fields as well, since Fabric only keeps one event per transaction.
+type supplyAlarmEvent struct {
+	event
+	MaxIncreaseBps int   `json:"maxIncreaseBps"`
+	WindowStart    int64 `json:"windowStart"`
+	WindowSeconds  int64 `json:"windowSeconds"`
+	BaseSupply     int   `json:"baseSupply"`
+	Increase       int   `json:"increase"`
+}
+
+// SetSupplyAlarm pauses minting once the supply grows by more than `maxIncreaseBps` basis points
+// within `windowSeconds`; a limit of 0 removes the alarm. Only admins can change it.
+// The window starts at the first mint after the previous window ended, and the increase is measured
+// against the supply at that time, so the alarm only applies once some supply exists.
+func (s *SmartContract) SetSupplyAlarm(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	maxIncreaseBps, err := strconv.Atoi(args[0])
+	if err != nil || maxIncreaseBps < 0 {
+		return shim.Error("Invalid limit. Expecting a non-negative number of basis points")
+	}
+	windowSeconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || windowSeconds <= 0 {
+		return shim.Error("Invalid window. Expecting a positive number of seconds")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if maxIncreaseBps == 0 {
+		err = APIstub.DelState(supplyAlarmKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", supplyAlarmKey, err).Error())
+		}
+	} else {
+		alarmBytes, err := json.Marshal(supplyAlarm{MaxIncreaseBps: maxIncreaseBps, WindowSeconds: windowSeconds})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = APIstub.PutState(supplyAlarmKey, alarmBytes)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "PutState", supplyAlarmKey, err).Error())
+		}
+	}
+
+	// Start measuring afresh under the new limit
+	err = APIstub.DelState(supplyAlarmWindowKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", supplyAlarmWindowKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ClearSupplyAlarm resumes minting after a tripped supply alarm has been investigated
+// Only admins can clear it. The current window is reset.
+// This function triggers a SupplyAlarmCleared event
+func (s *SmartContract) ClearSupplyAlarm(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pausedBytes, err := APIstub.GetState(mintPausedKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "GetState", mintPausedKey, err).Error())
+	}
+	if pausedBytes == nil {
+		return shim.Error("Minting is not paused")
+	}
+
+	err = APIstub.DelState(mintPausedKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", mintPausedKey, err).Error())
+	}
+	err = APIstub.DelState(supplyAlarmWindowKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", supplyAlarmWindowKey, err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventBytes, err := json.Marshal(struct {
+		Token string `json:"token"`
+	}{Token: symbol})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.SetEvent("SupplyAlarmCleared", eventBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// checkMintPaused returns ERR_MINT_PAUSED while a tripped supply alarm has not been cleared
+func checkMintPaused(APIstub shim.ChaincodeStubInterface) error {
+	pausedBytes, err := APIstub.GetState(mintPausedKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", mintPausedKey, err)
+	}
+	if pausedBytes != nil {
+		return newCodedError(APIstub, errMintPaused, nil, "minting is paused by the supply alarm")
+	}
+	return nil
+}
+
+// trackSupplyIncrease adds a minted `delta` to the current alarm window and pauses minting
+// when the window's increase exceeds the limit. The mint that trips the alarm still succeeds;
+// it is remembered on the transaction so Mint reports it with a SupplyAlarm event.
+func trackSupplyIncrease(APIstub shim.ChaincodeStubInterface, supplyBefore int, delta int) error {
+	alarmBytes, err := APIstub.GetState(supplyAlarmKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", supplyAlarmKey, err)
+	}
+	if alarmBytes == nil {
+		return nil
+	}
+	var alarm supplyAlarm
+	err = json.Unmarshal(alarmBytes, &alarm)
+	if err != nil {
+		return err
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return err
+	}
+	windowBytes, err := APIstub.GetState(supplyAlarmWindowKey)
+	if err != nil {
+		return stateError(APIstub, "GetState", supplyAlarmWindowKey, err)
+	}
+	var window supplyAlarmWindow
+	if windowBytes != nil {
+		err = json.Unmarshal(windowBytes, &window)
+		if err != nil {
+			return err
+		}
+	}
+	if windowBytes == nil || now >= window.Start+alarm.WindowSeconds {
+		window = supplyAlarmWindow{Start: now, BaseSupply: supplyBefore}
+	}
+	window.Increase += delta
+
+	windowBytes, err = json.Marshal(window)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(supplyAlarmWindowKey, windowBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", supplyAlarmWindowKey, err)
+	}
+
+	if window.BaseSupply == 0 || int64(window.Increase)*10000 <= int64(window.BaseSupply)*int64(alarm.MaxIncreaseBps) {
+		return nil
+	}
+
+	err = APIstub.PutState(mintPausedKey, []byte("true"))
+	if err != nil {
+		return stateError(APIstub, "PutState", mintPausedKey, err)
+	}
+	if tx, ok := APIstub.(*txStub); ok {
+		tx.supplyAlarm = &supplyAlarmEvent{
+			MaxIncreaseBps: alarm.MaxIncreaseBps,
+			WindowStart:    window.Start,
+			WindowSeconds:  alarm.WindowSeconds,
+			BaseSupply:     window.BaseSupply,
+			Increase:       window.Increase,
+		}
+	}
+	return nil
+}
+
+// emitMint emits the Transfer event of a mint, or a SupplyAlarm event if the mint tripped the alarm
+func emitMint(APIstub shim.ChaincodeStubInterface, eventData event) error {
+	tx, ok := APIstub.(*txStub)
+	if !ok || tx.supplyAlarm == nil {
+		return emitTransfer(APIstub, eventData)
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData.Token = symbol
+	alarmData := *tx.supplyAlarm
+	alarmData.event = eventData
+	eventBytes, err := json.Marshal(alarmData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent("SupplyAlarm", eventBytes)
+}
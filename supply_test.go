@@ -0,0 +1,134 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// supplyGuard runs SmartContract on a stub that records every write of totalSupplyKey not made by adjustSupply
+type supplyGuard struct {
+	*SmartContract
+	violations *[]string
+}
+
+func (g supplyGuard) Invoke(APIstub shim.ChaincodeStubInterface) peer.Response {
+	return g.SmartContract.Invoke(supplyGuardStub{APIstub, g.violations})
+}
+
+// supplyGuardStub checks the callers of every write of totalSupplyKey
+type supplyGuardStub struct {
+	shim.ChaincodeStubInterface
+	violations *[]string
+}
+
+func (s supplyGuardStub) PutState(key string, value []byte) error {
+	s.check(key)
+	return s.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (s supplyGuardStub) DelState(key string) error {
+	s.check(key)
+	return s.ChaincodeStubInterface.DelState(key)
+}
+
+// check records the function and call stack of a write of totalSupplyKey outside adjustSupply
+func (s supplyGuardStub) check(key string) {
+	if key != totalSupplyKey {
+		return
+	}
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	var stack []string
+	for {
+		frame, more := frames.Next()
+		if strings.HasSuffix(frame.Function, ".adjustSupply") {
+			return
+		}
+		stack = append(stack, frame.Function)
+		if !more {
+			break
+		}
+	}
+	function, _ := s.GetFunctionAndParameters()
+	*s.violations = append(*s.violations, function+" wrote totalSupplyKey through "+strings.Join(stack, " < "))
+}
+
+// TestOnlyAdjustSupplyWritesSupply runs every function with the fuzz seed arguments, as a client
+// and as an admin, and fails if any of them writes totalSupplyKey other than through adjustSupply
+func TestOnlyAdjustSupplyWritesSupply(t *testing.T) {
+	var violations []string
+	base := chaintest.NewLedger(supplyGuard{new(SmartContract), &violations})
+	mustInvoke(t, base, admin, "Initialize", "Token", "TKN", "100", "0", `{"devMode":true,"experimentalEnabled":true}`)
+	fund(t, base, alice.Account, 1000)
+	mustInvoke(t, base, alice, "Approve", bob.Account, "100")
+
+	seeds := append(append([][]string{}, chaintest.NastyArgs...), fuzzSeedArgs...)
+	for _, registry := range []map[string]contractFunction{contractFunctions, devFunctions, experimentalFunctions} {
+		for function := range registry {
+			for _, args := range seeds {
+				for _, caller := range []chaintest.Identity{alice, admin} {
+					base.Fork().Invoke(caller, function, args...)
+				}
+			}
+		}
+	}
+	for _, violation := range violations {
+		t.Error(violation)
+	}
+}
+
+// TestOnlyAdjustSupplyWritesSupplyKey checks the sources for writes whose key is totalSupplyKey
+// outside adjustSupply, including writes no test reaches
+func TestOnlyAdjustSupplyWritesSupplyKey(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		fset := token.NewFileSet()
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range parsed.Decls {
+			function, ok := decl.(*ast.FuncDecl)
+			if !ok || function.Body == nil || function.Name.Name == "adjustSupply" {
+				continue
+			}
+			ast.Inspect(function.Body, func(node ast.Node) bool {
+				call, ok := node.(*ast.CallExpr)
+				if !ok || len(call.Args) == 0 {
+					return true
+				}
+				selector, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || (selector.Sel.Name != "PutState" && selector.Sel.Name != "DelState") {
+					return true
+				}
+				switch key := call.Args[0].(type) {
+				case *ast.Ident:
+					if key.Name == "totalSupplyKey" {
+						t.Errorf("%s: %s writes totalSupplyKey, only adjustSupply may", fset.Position(call.Pos()), function.Name.Name)
+					}
+				case *ast.BasicLit:
+					if value, _ := strconv.Unquote(key.Value); value == totalSupplyKey {
+						t.Errorf("%s: %s writes %q, only adjustSupply may", fset.Position(call.Pos()), function.Name.Name, value)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
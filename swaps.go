@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for swaps
+const swapPrefix = "swap"
+
+// Define swap statuses
+const swapProposed = "proposed"
+const swapAccepted = "accepted"
+const swapDeclined = "declined"
+const swapCancelled = "cancelled"
+const swapExpired = "expired"
+
+// swap is a two-legged exchange between two holders of this token
+// The proposer's leg is held in escrow from ProposeSwap until the swap is resolved.
+type swap struct {
+	ID                 string `json:"id"`
+	Proposer           string `json:"proposer"`
+	Counterparty       string `json:"counterparty"`
+	ProposerAmount     int    `json:"proposerAmount"`
+	CounterpartyAmount int    `json:"counterpartyAmount"`
+	ExpiresAt          int64  `json:"expiresAt"`
+	Status             string `json:"status"`
+}
+
+// swapEvent provides an organized struct for emitting swap events
+type swapEvent struct {
+	Token string `json:"token"`
+	swap
+}
+
+// swapResponse is the JSON document returned by GetSwap
+type swapResponse struct {
+	Token string `json:"token"`
+	swap
+}
+
+// ProposeSwap offers `myAmount` of the caller's tokens for `theirAmount` of the counterparty's
+// The caller's leg is escrowed immediately; the swap ID is returned as payload.
+// This function triggers a SwapProposed event
+func (s *SmartContract) ProposeSwap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	counterparty := args[0]
+	myAmount, err := parsePositiveAmount(APIstub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	theirAmount, err := parsePositiveAmount(APIstub, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiresAt, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return shim.Error("Invalid expiry. Expecting a unix timestamp in seconds")
+	}
+
+	proposer, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, proposer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if counterparty == "" || counterparty == proposer {
+		return shim.Error("Counterparty must be a different account")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiresAt <= now {
+		return shim.Error("Expiry must be in the future")
+	}
+
+	id, err := newDeterministicID(APIstub, swapPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal := swap{
+		ID:                 id,
+		Proposer:           proposer,
+		Counterparty:       counterparty,
+		ProposerAmount:     myAmount,
+		CounterpartyAmount: theirAmount,
+		ExpiresAt:          expiresAt,
+		Status:             swapProposed,
+	}
+
+	// Escrow the proposer's leg
+	err = debitBalance(APIstub, proposer, myAmount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putSwap(APIstub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emitSwapEvent(APIstub, "SwapProposed", proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(proposal.ID))
+}
+
+// AcceptSwap executes both legs of a pending swap; only the counterparty can accept
+// This function triggers a SwapAccepted event
+func (s *SmartContract) AcceptSwap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getPendingSwap(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != proposal.Counterparty {
+		return shim.Error("Only the counterparty can accept a swap")
+	}
+	err = checkMSPBinding(APIstub, clientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal.effectiveStatus(now) != swapProposed {
+		return shim.Error("Swap has expired")
+	}
+
+	// Both parties receive tokens from each other
+	err = checkIncomingAllowed(APIstub, proposal.Proposer, proposal.Counterparty)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkIncomingAllowed(APIstub, proposal.Counterparty, proposal.Proposer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkTermsAccepted(APIstub, proposal.Proposer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkTermsAccepted(APIstub, proposal.Counterparty)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// The counterparty pays its leg and receives the escrowed leg in a single balance update
+	counterpartyBalance, exists, err := getBalance(APIstub, proposal.Counterparty)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !exists || counterpartyBalance < proposal.CounterpartyAmount {
+		return shim.Error(insufficientBalance(APIstub, counterpartyBalance, proposal.CounterpartyAmount).Error())
+	}
+	counterpartyBalance = counterpartyBalance - proposal.CounterpartyAmount + proposal.ProposerAmount
+	err = putBalance(APIstub, proposal.Counterparty, counterpartyBalance)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = creditBalance(APIstub, proposal.Proposer, proposal.CounterpartyAmount)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = recordMovements(APIstub,
+		movement{From: proposal.Proposer, To: proposal.Counterparty, Value: proposal.ProposerAmount},
+		movement{From: proposal.Counterparty, To: proposal.Proposer, Value: proposal.CounterpartyAmount},
+	)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	proposal.Status = swapAccepted
+	err = putSwap(APIstub, *proposal)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitSwapEvent(APIstub, "SwapAccepted", *proposal)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// DeclineSwap rejects a pending swap and returns the escrowed leg to the proposer
+// Only the counterparty can decline.
+// This function triggers a SwapDeclined event
+func (s *SmartContract) DeclineSwap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getPendingSwap(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != proposal.Counterparty {
+		return shim.Error("Only the counterparty can decline a swap")
+	}
+
+	err = refundSwap(APIstub, *proposal, swapDeclined, "SwapDeclined")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CancelSwap withdraws a pending or expired swap and returns the escrowed leg to the proposer
+// Only the proposer can cancel.
+// This function triggers a SwapCancelled event
+func (s *SmartContract) CancelSwap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getPendingSwap(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != proposal.Proposer {
+		return shim.Error("Only the proposer can cancel a swap")
+	}
+
+	err = refundSwap(APIstub, *proposal, swapCancelled, "SwapCancelled")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetSwap returns the swap with the given ID
+// A pending swap past its expiry is reported with the expired status.
+func (s *SmartContract) GetSwap(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := getSwap(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal == nil {
+		return shim.Error("Swap not found")
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal.Status = proposal.effectiveStatus(now)
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	swapBytes, err := json.Marshal(swapResponse{Token: symbol, swap: *proposal})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(swapBytes)
+}
+
+// effectiveStatus returns the status of the swap at `now`, taking expiry into account
+// A swap can no longer be accepted at the exact expiry instant.
+func (p swap) effectiveStatus(now int64) string {
+	if p.Status == swapProposed && now >= p.ExpiresAt {
+		return swapExpired
+	}
+	return p.Status
+}
+
+// refundSwap returns the escrowed leg to the proposer and closes the swap with `status`
+func refundSwap(APIstub shim.ChaincodeStubInterface, proposal swap, status string, eventName string) error {
+	err := creditBalance(APIstub, proposal.Proposer, proposal.ProposerAmount)
+	if err != nil {
+		return err
+	}
+
+	proposal.Status = status
+	err = putSwap(APIstub, proposal)
+	if err != nil {
+		return err
+	}
+
+	return emitSwapEvent(APIstub, eventName, proposal)
+}
+
+// getPendingSwap returns the swap with the given ID if it has not been resolved yet
+func getPendingSwap(APIstub shim.ChaincodeStubInterface, id string) (*swap, error) {
+	proposal, err := getSwap(APIstub, id)
+	if err != nil {
+		return nil, err
+	}
+	if proposal == nil {
+		return nil, fmt.Errorf("Swap not found")
+	}
+	if proposal.Status != swapProposed {
+		return nil, fmt.Errorf("Swap is %s", proposal.Status)
+	}
+	return proposal, nil
+}
+
+// getSwap returns the stored swap, or nil if there is none
+func getSwap(APIstub shim.ChaincodeStubInterface, id string) (*swap, error) {
+	swapKey, err := buildKey(APIstub, swapPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	swapBytes, err := APIstub.GetState(swapKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", swapPrefix, err)
+	}
+	if swapBytes == nil {
+		return nil, nil
+	}
+
+	var proposal swap
+	err = json.Unmarshal(swapBytes, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// putSwap stores the swap under its ID
+func putSwap(APIstub shim.ChaincodeStubInterface, proposal swap) error {
+	swapKey, err := buildKey(APIstub, swapPrefix, []string{proposal.ID})
+	if err != nil {
+		return err
+	}
+	swapBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(swapKey, swapBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", swapPrefix, err)
+	}
+	return nil
+}
+
+// emitSwapEvent emits `name` describing the current state of the swap
+func emitSwapEvent(APIstub shim.ChaincodeStubInterface, name string, proposal swap) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(swapEvent{Token: symbol, swap: proposal})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
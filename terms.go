@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for terms options
+const termsRequiredKey = "termsRequired"
+const documentHashKey = "documentHash"
+
+// Define objectType names for terms acceptance
+const termsAcceptedPrefix = "termsAccepted"
+
+// termsEvent provides an organized struct for emitting terms events
+type termsEvent struct {
+	Token        string `json:"token"`
+	Account      string `json:"account,omitempty"`
+	DocumentHash string `json:"documentHash"`
+	// Version is the accepted terms version, see PublishTermsVersion
+	Version int `json:"version,omitempty"`
+}
+
+// termsAcceptanceResponse is the JSON document returned by HasAcceptedTerms
+type termsAcceptanceResponse struct {
+	Token        string `json:"token"`
+	Account      string `json:"account"`
+	DocumentHash string `json:"documentHash"`
+	Accepted     bool   `json:"accepted"`
+}
+
+// AcceptTerms records that the caller accepts the terms version `version` published with
+// PublishTermsVersion, or else the terms document identified by `documentHash`
+// Only the current document can be accepted by hash.
+// This function triggers a TermsAccepted event
+func (s *SmartContract) AcceptTerms(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	published, err := getTermsVersion(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if published != nil {
+		return acceptTermsVersion(APIstub, *published)
+	}
+	documentHash := args[0]
+
+	currentHash, err := getDocumentHash(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if currentHash == "" {
+		return shim.Error("Terms document not set")
+	}
+	if documentHash != currentHash {
+		return shim.Error("Document hash does not match the current terms")
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	acceptedKey, err := buildKey(APIstub, termsAcceptedPrefix, []string{clientID, documentHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(acceptedKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", termsAcceptedPrefix, err).Error())
+	}
+
+	err = emitTermsEvent(APIstub, "TermsAccepted", termsEvent{Account: clientID, DocumentHash: documentHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// SetDocumentHash replaces the current terms document; only admins can change it
+// Accounts must accept the new document before their next credit. Debits are never blocked.
+// This function triggers a DocumentHashUpdated event
+func (s *SmartContract) SetDocumentHash(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	documentHash := args[0]
+	if documentHash == "" {
+		return shim.Error("Document hash must be a non-empty string")
+	}
+
+	err := requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(documentHashKey, []byte(documentHash))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", documentHashKey, err).Error())
+	}
+
+	err = emitTermsEvent(APIstub, "DocumentHashUpdated", termsEvent{DocumentHash: documentHash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// HasAcceptedTerms reports whether `account` has accepted the current terms document
+func (s *SmartContract) HasAcceptedTerms(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	documentHash, err := getDocumentHash(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if documentHash == "" {
+		return shim.Error("Terms document not set")
+	}
+	accepted, err := hasAcceptedTerms(APIstub, account, documentHash)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	responseBytes, err := json.Marshal(termsAcceptanceResponse{Token: symbol, Account: account, DocumentHash: documentHash, Accepted: accepted})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// checkTermsAccepted returns ERR_TERMS_NOT_ACCEPTED if terms are required and
+// `account` has not accepted the terms version in effect, or else the current document
+func checkTermsAccepted(APIstub shim.ChaincodeStubInterface, account string) error {
+	required, err := getBoolSetting(APIstub, termsRequiredKey)
+	if err != nil || !required {
+		return err
+	}
+
+	versions, err := getTermsVersions(APIstub)
+	if err != nil {
+		return err
+	}
+	current, err := currentTermsVersion(APIstub, versions)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		acceptance, err := getTermsVersionAcceptance(APIstub, account, current.Version)
+		if err != nil {
+			return err
+		}
+		if acceptance == nil {
+			return fmt.Errorf("ERR_TERMS_NOT_ACCEPTED: recipient has not accepted terms version %d", current.Version)
+		}
+		return nil
+	}
+
+	documentHash, err := getDocumentHash(APIstub)
+	if err != nil {
+		return err
+	}
+	accepted, err := hasAcceptedTerms(APIstub, account, documentHash)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return fmt.Errorf("ERR_TERMS_NOT_ACCEPTED: recipient has not accepted the current terms")
+	}
+	return nil
+}
+
+// hasAcceptedTerms reports whether `account` accepted the document identified by `documentHash`
+func hasAcceptedTerms(APIstub shim.ChaincodeStubInterface, account string, documentHash string) (bool, error) {
+	if documentHash == "" {
+		return false, nil
+	}
+	acceptedKey, err := buildKey(APIstub, termsAcceptedPrefix, []string{account, documentHash})
+	if err != nil {
+		return false, err
+	}
+	acceptedBytes, err := APIstub.GetState(acceptedKey)
+	if err != nil {
+		return false, stateError(APIstub, "GetState", termsAcceptedPrefix, err)
+	}
+	return acceptedBytes != nil, nil
+}
+
+// getDocumentHash returns the current terms document hash, or "" if none is set
+func getDocumentHash(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return getSetting(APIstub, documentHashKey)
+}
+
+// emitTermsEvent emits `name` with the token symbol filled in
+func emitTermsEvent(APIstub shim.ChaincodeStubInterface, name string, eventData termsEvent) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData.Token = symbol
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for terms versions
+const termsVersionPrefix = "termsVersion"
+const termsVersionAcceptedPrefix = "termsVersionAccepted"
+
+// termsVersion is a published version of the terms document
+// A version is in effect from EffectiveAt until a later version is.
+type termsVersion struct {
+	Version      int    `json:"version"`
+	DocumentHash string `json:"documentHash"`
+	EffectiveAt  int64  `json:"effectiveAt"`
+	PublishedBy  string `json:"publishedBy"`
+	PublishedAt  int64  `json:"publishedAt"`
+	TxID         string `json:"txId"`
+}
+
+// termsVersionAcceptance records that an account accepted a terms version
+type termsVersionAcceptance struct {
+	Version      int    `json:"version"`
+	DocumentHash string `json:"documentHash"`
+	AcceptedAt   int64  `json:"acceptedAt"`
+	TxID         string `json:"txId"`
+}
+
+// termsVersionsResponse is the JSON document returned by ListTermsVersions
+// Current is the version in effect, 0 if none is yet.
+type termsVersionsResponse struct {
+	Token    string         `json:"token"`
+	Current  int            `json:"current"`
+	Versions []termsVersion `json:"versions"`
+}
+
+// currentTermsResponse is the JSON document returned by HasAcceptedCurrentTerms
+type currentTermsResponse struct {
+	Token        string `json:"token"`
+	Account      string `json:"account"`
+	Version      int    `json:"version"`
+	DocumentHash string `json:"documentHash"`
+	Accepted     bool   `json:"accepted"`
+}
+
+// termsAcceptanceHistoryResponse is the JSON document returned by GetTermsAcceptanceHistory
+type termsAcceptanceHistoryResponse struct {
+	Token       string                   `json:"token"`
+	Account     string                   `json:"account"`
+	Acceptances []termsVersionAcceptance `json:"acceptances"`
+}
+
+// PublishTermsVersion publishes version `version` of the terms document `documentHash`, in effect
+// from the Unix time `effectiveTs`; only admins can publish
+// Versions must be published in increasing order and take effect no earlier than the version
+// before. Once a version is in effect, the credit gate of termsRequired checks it instead of the
+// document set with SetDocumentHash. Accounts can accept a version before it takes effect.
+// This function triggers a TermsVersionPublished event
+func (s *SmartContract) PublishTermsVersion(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	version, err := strconv.Atoi(args[0])
+	if err != nil || version <= 0 {
+		return shim.Error("Invalid version. Expecting a positive integer")
+	}
+	documentHash := args[1]
+	if documentHash == "" {
+		return shim.Error("Document hash must be a non-empty string")
+	}
+	effectiveAt, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || effectiveAt < 0 {
+		return shim.Error("Invalid effective time. Expecting a Unix time in seconds")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	versions, err := getTermsVersions(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		if version <= latest.Version {
+			return shim.Error(fmt.Sprintf("Invalid version. Expecting a version above %d", latest.Version))
+		}
+		if effectiveAt < latest.EffectiveAt {
+			return shim.Error(fmt.Sprintf("Invalid effective time. Expecting %d or later, when version %d takes effect", latest.EffectiveAt, latest.Version))
+		}
+	}
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	published := termsVersion{
+		Version:      version,
+		DocumentHash: documentHash,
+		EffectiveAt:  effectiveAt,
+		PublishedBy:  clientID,
+		PublishedAt:  now,
+		TxID:         APIstub.GetTxID(),
+	}
+	versionKey, err := buildKey(APIstub, termsVersionPrefix, []string{fmt.Sprintf("%020d", version)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	versionBytes, err := json.Marshal(published)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(versionKey, versionBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", termsVersionPrefix, err).Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventBytes, err := json.Marshal(struct {
+		Token string `json:"token"`
+		termsVersion
+	}{Token: symbol, termsVersion: published})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent("TermsVersionPublished", eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ListTermsVersions returns every published terms version, oldest first, and the version in effect
+func (s *SmartContract) ListTermsVersions(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	versions, err := getTermsVersions(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	current, err := currentTermsVersion(APIstub, versions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	response := termsVersionsResponse{Token: symbol, Versions: versions}
+	if current != nil {
+		response.Current = current.Version
+	}
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// HasAcceptedCurrentTerms reports whether `account` has accepted the terms version in effect
+func (s *SmartContract) HasAcceptedCurrentTerms(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	versions, err := getTermsVersions(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	current, err := currentTermsVersion(APIstub, versions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if current == nil {
+		return shim.Error("No terms version is in effect")
+	}
+	accepted, err := getTermsVersionAcceptance(APIstub, account, current.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	responseBytes, err := json.Marshal(currentTermsResponse{Token: symbol, Account: account, Version: current.Version, DocumentHash: current.DocumentHash, Accepted: accepted != nil})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// GetTermsAcceptanceHistory returns every terms version `account` accepted, oldest version first
+func (s *SmartContract) GetTermsAcceptanceHistory(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	acceptances := []termsVersionAcceptance{}
+	_, err := iterate(APIstub, termsVersionAcceptedPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		var acceptance termsVersionAcceptance
+		err := json.Unmarshal(value, &acceptance)
+		if err != nil {
+			return err
+		}
+		acceptances = append(acceptances, acceptance)
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	responseBytes, err := json.Marshal(termsAcceptanceHistoryResponse{Token: symbol, Account: account, Acceptances: acceptances})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// acceptTermsVersion records that the caller accepts the published terms version `published`
+// Any published version can be accepted, including one not yet in effect or one already replaced.
+func acceptTermsVersion(APIstub shim.ChaincodeStubInterface, published termsVersion) peer.Response {
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getTermsVersionAcceptance(APIstub, clientID, published.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error(fmt.Sprintf("Terms version %d was already accepted", published.Version))
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	acceptance := termsVersionAcceptance{Version: published.Version, DocumentHash: published.DocumentHash, AcceptedAt: now, TxID: APIstub.GetTxID()}
+	acceptedKey, err := buildKey(APIstub, termsVersionAcceptedPrefix, []string{clientID, fmt.Sprintf("%020d", published.Version)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	acceptanceBytes, err := json.Marshal(acceptance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(acceptedKey, acceptanceBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", termsVersionAcceptedPrefix, err).Error())
+	}
+
+	err = emitTermsEvent(APIstub, "TermsAccepted", termsEvent{Account: clientID, DocumentHash: published.DocumentHash, Version: published.Version})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getTermsVersion returns the published terms version numbered `version`, or nil if there is none
+// `version` is the decimal string given by the client; anything else is not a version.
+func getTermsVersion(APIstub shim.ChaincodeStubInterface, version string) (*termsVersion, error) {
+	number, err := strconv.Atoi(version)
+	if err != nil || number <= 0 {
+		return nil, nil
+	}
+	versionKey, err := buildKey(APIstub, termsVersionPrefix, []string{fmt.Sprintf("%020d", number)})
+	if err != nil {
+		return nil, err
+	}
+	versionBytes, err := APIstub.GetState(versionKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", termsVersionPrefix, err)
+	}
+	if versionBytes == nil {
+		return nil, nil
+	}
+	var published termsVersion
+	err = json.Unmarshal(versionBytes, &published)
+	if err != nil {
+		return nil, err
+	}
+	return &published, nil
+}
+
+// getTermsVersions returns every published terms version, oldest first
+func getTermsVersions(APIstub shim.ChaincodeStubInterface) ([]termsVersion, error) {
+	versions := []termsVersion{}
+	_, err := iterate(APIstub, termsVersionPrefix, []string{}, 0, "", func(attributes []string, value []byte) error {
+		var published termsVersion
+		err := json.Unmarshal(value, &published)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, published)
+		return nil
+	})
+	return versions, err
+}
+
+// currentTermsVersion returns the latest of `versions` in effect at the transaction time, or nil if none is
+func currentTermsVersion(APIstub shim.ChaincodeStubInterface, versions []termsVersion) (*termsVersion, error) {
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].EffectiveAt <= now {
+			return &versions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getTermsVersionAcceptance returns the acceptance of terms version `version` by `account`, or nil if it did not accept it
+func getTermsVersionAcceptance(APIstub shim.ChaincodeStubInterface, account string, version int) (*termsVersionAcceptance, error) {
+	acceptedKey, err := buildKey(APIstub, termsVersionAcceptedPrefix, []string{account, fmt.Sprintf("%020d", version)})
+	if err != nil {
+		return nil, err
+	}
+	acceptanceBytes, err := APIstub.GetState(acceptedKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", termsVersionAcceptedPrefix, err)
+	}
+	if acceptanceBytes == nil {
+		return nil, nil
+	}
+	var acceptance termsVersionAcceptance
+	err = json.Unmarshal(acceptanceBytes, &acceptance)
+	if err != nil {
+		return nil, err
+	}
+	return &acceptance, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newTermsToken returns a token requiring terms where alice holds 100 and bob accepted termsHash
+func newTermsToken(t *testing.T) *chaintest.Ledger {
+	t.Helper()
+	ledger := newToken(t, `{"termsRequired":true,"documentHash":"`+termsHash+`"}`)
+	mustInvoke(t, ledger, alice, "AcceptTerms", termsHash)
+	mustInvoke(t, ledger, bob, "AcceptTerms", termsHash)
+	fund(t, ledger, alice.Account, 100)
+	return ledger
+}
+
+// listTermsVersions returns the document ListTermsVersions returns
+func listTermsVersions(t *testing.T, ledger *chaintest.Ledger) termsVersionsResponse {
+	t.Helper()
+	var response termsVersionsResponse
+	err := json.Unmarshal([]byte(mustInvoke(t, ledger, alice, "ListTermsVersions")), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestPublishTermsVersion(t *testing.T) {
+	effective := fmt.Sprint(chaintest.StartTime + 100)
+	tests := []struct {
+		name     string
+		caller   chaintest.Identity
+		args     []string
+		expected string
+	}{
+		{"next version", admin, []string{"3", "hash3", effective}, ""},
+		{"skipped versions", admin, []string{"7", "hash7", effective}, ""},
+		{"later effective time", admin, []string{"3", "hash3", fmt.Sprint(chaintest.StartTime + 101)}, ""},
+		{"same version", admin, []string{"2", "hash3", effective}, "Invalid version. Expecting a version above 2"},
+		{"older version", admin, []string{"1", "hash3", effective}, "Invalid version. Expecting a version above 2"},
+		{"earlier effective time", admin, []string{"3", "hash3", fmt.Sprint(chaintest.StartTime + 99)}, "Invalid effective time. Expecting 1700000100 or later"},
+		{"zero version", admin, []string{"0", "hash3", effective}, "Invalid version"},
+		{"not a version", admin, []string{"v3", "hash3", effective}, "Invalid version"},
+		{"no document hash", admin, []string{"3", "", effective}, "Document hash must be a non-empty string"},
+		{"negative effective time", admin, []string{"3", "hash3", "-1"}, "Invalid effective time"},
+		{"not an admin", alice, []string{"3", "hash3", effective}, errUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			mustInvoke(t, ledger, admin, "PublishTermsVersion", "2", "hash2", effective)
+
+			result := ledger.Invoke(test.caller, "PublishTermsVersion", test.args...)
+			versions := listTermsVersions(t, ledger).Versions
+			if test.expected != "" {
+				if result.Status == shim.OK || !strings.HasPrefix(result.Message, test.expected) {
+					t.Fatalf("PublishTermsVersion%q returned %q, expected %q", test.args, result.Message, test.expected)
+				}
+				if len(versions) != 1 {
+					t.Fatalf("refused PublishTermsVersion%q left versions %+v, expected only version 2", test.args, versions)
+				}
+				return
+			}
+			if result.Status != shim.OK {
+				t.Fatalf("PublishTermsVersion%q failed with %q", test.args, result.Message)
+			}
+			if len(versions) != 2 || fmt.Sprint(versions[1].Version) != test.args[0] || versions[1].DocumentHash != test.args[1] ||
+				fmt.Sprint(versions[1].EffectiveAt) != test.args[2] || versions[1].PublishedBy != admin.Account {
+				t.Fatalf("ListTermsVersions returned %+v after PublishTermsVersion%q", versions, test.args)
+			}
+			if len(result.Events) != 1 || result.Events[0].Name != "TermsVersionPublished" {
+				t.Fatalf("PublishTermsVersion%q emitted %+v, expected TermsVersionPublished", test.args, result.Events)
+			}
+		})
+	}
+}
+
+// TestTermsVersionEffectiveBoundary publishes version 1 effective 100 seconds after the start and
+// transfers to bob around that time; until it takes effect the gate checks the document instead
+func TestTermsVersionEffectiveBoundary(t *testing.T) {
+	tests := []struct {
+		name      string
+		offset    int64
+		acceptsV1 bool
+		inEffect  bool
+	}{
+		{"before, not accepted", 99, false, false},
+		{"before, accepted", 99, true, false},
+		{"at, not accepted", 100, false, true},
+		{"at, accepted", 100, true, true},
+		{"after, not accepted", 101, false, true},
+		{"after, accepted", 101, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newTermsToken(t)
+			mustInvoke(t, ledger, admin, "PublishTermsVersion", "1", "hash1", fmt.Sprint(chaintest.StartTime+100))
+			if test.acceptsV1 {
+				mustInvoke(t, ledger, bob, "AcceptTerms", "1")
+			}
+			ledger.Now = chaintest.StartTime + test.offset
+
+			allowed := !test.inEffect || test.acceptsV1
+			result := ledger.Invoke(alice, "Transfer", bob.Account, "10")
+			if allowed != (result.Status == shim.OK) {
+				t.Fatalf("Transfer returned %q, expected it to succeed: %t", result.Message, allowed)
+			}
+			if !allowed && result.Message != "ERR_TERMS_NOT_ACCEPTED: recipient has not accepted terms version 1" {
+				t.Fatalf("Transfer failed with %q, expected ERR_TERMS_NOT_ACCEPTED for version 1", result.Message)
+			}
+
+			current := 0
+			if test.inEffect {
+				current = 1
+			}
+			if got := listTermsVersions(t, ledger).Current; got != current {
+				t.Fatalf("ListTermsVersions reports version %d in effect, expected %d", got, current)
+			}
+			result = ledger.Invoke(admin, "HasAcceptedCurrentTerms", bob.Account)
+			if !test.inEffect {
+				if result.Status == shim.OK || result.Message != "No terms version is in effect" {
+					t.Fatalf("HasAcceptedCurrentTerms returned %q before version 1 took effect", result.Payload)
+				}
+				return
+			}
+			var response currentTermsResponse
+			err := json.Unmarshal(result.Payload, &response)
+			if err != nil {
+				t.Fatalf("HasAcceptedCurrentTerms failed with %q", result.Message)
+			}
+			if response.Version != 1 || response.DocumentHash != "hash1" || response.Accepted != test.acceptsV1 {
+				t.Fatalf("HasAcceptedCurrentTerms returned %s, expected version 1 accepted: %t", result.Payload, test.acceptsV1)
+			}
+		})
+	}
+}
+
+// TestAcceptTermsVersions publishes version 1 in effect and version 2 effective 100 seconds later;
+// bob accepts `before` while version 1 is in effect and `after` once version 2 is. Only an acceptance
+// of version 2 lets him receive, and every acceptance stays in his history.
+func TestAcceptTermsVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   []string
+		after    []string
+		accepted bool
+		history  []int
+	}{
+		{"none", nil, nil, false, []int{}},
+		{"replaced version", []string{"1"}, nil, false, []int{1}},
+		{"new version ahead of its effect", []string{"2"}, nil, true, []int{2}},
+		{"old version after the new one took effect", nil, []string{"1"}, false, []int{1}},
+		{"both versions", []string{"1"}, []string{"2"}, true, []int{1, 2}},
+		{"both versions, newest first", []string{"2"}, []string{"1"}, true, []int{1, 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newTermsToken(t)
+			mustInvoke(t, ledger, admin, "PublishTermsVersion", "1", "hash1", fmt.Sprint(ledger.Now))
+			mustInvoke(t, ledger, admin, "PublishTermsVersion", "2", "hash2", fmt.Sprint(ledger.Now+100))
+			for _, version := range test.before {
+				mustInvoke(t, ledger, bob, "AcceptTerms", version)
+			}
+			ledger.Now += 100
+			for _, version := range test.after {
+				mustInvoke(t, ledger, bob, "AcceptTerms", version)
+			}
+
+			result := ledger.Invoke(alice, "Transfer", bob.Account, "10")
+			if test.accepted != (result.Status == shim.OK) {
+				t.Fatalf("Transfer returned %q, expected it to succeed: %t", result.Message, test.accepted)
+			}
+			var current currentTermsResponse
+			err := json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "HasAcceptedCurrentTerms", bob.Account)), &current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.Version != 2 || current.Accepted != test.accepted {
+				t.Fatalf("HasAcceptedCurrentTerms returned %+v, expected version 2 accepted: %t", current, test.accepted)
+			}
+
+			var history termsAcceptanceHistoryResponse
+			err = json.Unmarshal([]byte(mustInvoke(t, ledger, admin, "GetTermsAcceptanceHistory", bob.Account)), &history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions := []int{}
+			for _, acceptance := range history.Acceptances {
+				versions = append(versions, acceptance.Version)
+				if acceptance.DocumentHash != fmt.Sprintf("hash%d", acceptance.Version) {
+					t.Fatalf("acceptance %+v does not record the hash of its version", acceptance)
+				}
+			}
+			if fmt.Sprint(versions) != fmt.Sprint(test.history) {
+				t.Fatalf("GetTermsAcceptanceHistory lists versions %v, expected %v", versions, test.history)
+			}
+		})
+	}
+}
+
+func TestAcceptTermsVersionRefusals(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		expected string
+	}{
+		{"accepted twice", "1", "Terms version 1 was already accepted"},
+		{"unpublished version", "3", "Document hash does not match the current terms"},
+		{"not a version", "v1", "Document hash does not match the current terms"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newTermsToken(t)
+			mustInvoke(t, ledger, admin, "PublishTermsVersion", "1", "hash1", fmt.Sprint(ledger.Now))
+			mustInvoke(t, ledger, bob, "AcceptTerms", "1")
+			before := ledger.Fork()
+
+			if message := mustFail(t, ledger, bob, "AcceptTerms", test.version); message != test.expected {
+				t.Fatalf("AcceptTerms(%s) failed with %q, expected %q", test.version, message, test.expected)
+			}
+			if !sameState(before, ledger) {
+				t.Fatalf("refused AcceptTerms(%s) changed the state", test.version)
+			}
+		})
+	}
+}
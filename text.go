@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Define length limits, in characters, for free text inputs
+const maxNameLength = 64
+const maxSymbolLength = 16
+const maxMemoLength = 256
+const maxReferenceLength = 128
+const maxNoteLength = 1024
+const maxCategoryLength = 32
+
+// byteOrderMark is rejected anywhere in free text; it usually means the client sent UTF-16
+const byteOrderMark = '\uFEFF'
+
+// sanitizeText validates a free text input and returns its NFC normal form
+// Inputs that are not valid UTF-8, contain control characters or a byte order mark,
+// or are longer than `maxLength` characters after normalization are rejected
+// rather than transformed, so that every endorser stores the same bytes.
+func sanitizeText(field string, value string, maxLength int) (string, error) {
+	if !utf8.ValidString(value) {
+		return "", fmt.Errorf("ERR_INVALID_TEXT: %s is not valid UTF-8", field)
+	}
+	for _, r := range value {
+		if r == byteOrderMark {
+			return "", fmt.Errorf("ERR_INVALID_TEXT: %s contains a byte order mark", field)
+		}
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("ERR_INVALID_TEXT: %s contains control characters", field)
+		}
+	}
+
+	normalized := norm.NFC.String(value)
+	if utf8.RuneCountInString(normalized) > maxLength {
+		return "", fmt.Errorf("ERR_INVALID_TEXT: %s exceeds %d characters", field, maxLength)
+	}
+	return normalized, nil
+}
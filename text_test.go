@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Free text inputs; mixedText holds a decomposed and a precomposed "é", nfcText is its NFC form
+const mixedText = "Cafe\u0301 cr\u00e9me"
+const nfcText = "Caf\u00e9 cr\u00e9me"
+const decomposed = "e\u0301"
+
+// freeTextCall is how a function takes a free text input
+type freeTextCall struct {
+	name string
+	// setup returns the token the call runs on
+	setup  func(t *testing.T) *chaintest.Ledger
+	caller chaintest.Identity
+	// args returns the arguments of the call with `text` as the free text input
+	args func(text string) []string
+}
+
+// fundedToken returns a token where alice holds 100 and bob may spend 50 of them
+func fundedToken(t *testing.T) *chaintest.Ledger {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, alice, "Approve", bob.Account, "50")
+	return ledger
+}
+
+// enforcementToken returns a funded token where admin holds the compliance and burner roles and case-1 is open
+func enforcementToken(t *testing.T) *chaintest.Ledger {
+	ledger := fundedToken(t)
+	mustInvoke(t, ledger, admin, "GrantRole", complianceRole, admin.Account)
+	mustInvoke(t, ledger, admin, "GrantRole", burnerRole, admin.Account)
+	mustInvoke(t, ledger, admin, "OpenCase", "case-1", "court order")
+	return ledger
+}
+
+var freeTextCalls = []freeTextCall{
+	{"Initialize name", func(t *testing.T) *chaintest.Ledger { return chaintest.NewLedger(new(SmartContract)) }, admin,
+		func(text string) []string { return []string{text, "TKN", "0", "0"} }},
+	{"Transfer category", fundedToken, alice,
+		func(text string) []string { return []string{bob.Account, "10", text} }},
+	{"Transfer memo", fundedToken, alice,
+		func(text string) []string { return []string{bob.Account, "10", "", text} }},
+	{"TransferFrom memo", fundedToken, bob,
+		func(text string) []string { return []string{alice.Account, carol.Account, "10", text} }},
+	{"TransferBatch memo", fundedToken, alice,
+		func(text string) []string {
+			// Control characters are escaped as in JSON; anything else, even invalid UTF-8, is passed as is
+			memo := strings.Replace(text, "\x00", `\u0000`, -1)
+			return []string{`[{"to":"` + bob.Account + `","amount":10,"memo":"` + memo + `"}]`}
+		}},
+	{"Approve reference", fundedToken, alice,
+		func(text string) []string { return []string{carol.Account, "10", text} }},
+	{"ApproveBulkByOwner reference", fundedToken, alice,
+		func(text string) []string { return []string{carol.Account, "10", text} }},
+	{"Burn reason", fundedToken, alice,
+		func(text string) []string { return []string{"10", text} }},
+	{"Burn beneficiary", fundedToken, alice,
+		func(text string) []string { return []string{"10", "", text} }},
+	{"ForceBurn reason", enforcementToken, admin,
+		func(text string) []string { return []string{alice.Account, "10", "case-1", text} }},
+	{"OpenCase case ID", enforcementToken, admin,
+		func(text string) []string { return []string{text, "description"} }},
+	{"OpenCase description", enforcementToken, admin,
+		func(text string) []string { return []string{"case-2", text} }},
+	{"SetComplianceNote note", enforcementToken, admin,
+		func(text string) []string { return []string{alice.Account, text} }},
+	{"SetCategoryBudget category", fundedToken, alice,
+		func(text string) []string { return []string{text, "50", "86400"} }},
+	{"CreatePaymentRequest memo", fundedToken, alice,
+		func(text string) []string { return []string{"10", text, "1800000000"} }},
+}
+
+// TestFreeTextInputs runs every function that takes free text with mixed normal forms, a byte
+// order mark and invalid UTF-8. Mixed input is stored and emitted in NFC only; the others are
+// rejected before anything is written.
+func TestFreeTextInputs(t *testing.T) {
+	inputs := []struct {
+		name string
+		text string
+		// rejected is whether the input must fail with ERR_INVALID_TEXT
+		rejected bool
+	}{
+		{"mixed normalization", mixedText, false},
+		{"byte order mark", "\uFEFF" + nfcText, true},
+		{"byte order mark inside", "Caf\uFEFFe", true},
+		{"invalid UTF-8", "Caf\xe9", true},
+		{"UTF-16", "C\x00a\x00f\x00", true},
+	}
+	for _, call := range freeTextCalls {
+		function := strings.Fields(call.name)[0]
+		for _, input := range inputs {
+			t.Run(call.name+"/"+input.name, func(t *testing.T) {
+				ledger := call.setup(t)
+				result := ledger.Invoke(call.caller, function, call.args(input.text)...)
+
+				if input.rejected {
+					if result.Status == shim.OK || !strings.HasPrefix(result.Message, "ERR_INVALID_TEXT") || len(result.Writes) != 0 {
+						t.Fatalf("%s returned %d %q with %d writes, expected ERR_INVALID_TEXT before any write", function, result.Status, result.Message, len(result.Writes))
+					}
+					return
+				}
+				if result.Status != shim.OK {
+					t.Fatalf("%s failed with %q", function, result.Message)
+				}
+				stored := false
+				for _, write := range result.Writes {
+					if bytes.Contains(write.Value, []byte(decomposed)) || strings.Contains(write.Key, decomposed) {
+						t.Fatalf("%s wrote a non-NFC text under %q: %q", function, write.Key, write.Value)
+					}
+					stored = stored || bytes.Contains(write.Value, []byte(nfcText)) || strings.Contains(write.Key, nfcText)
+				}
+				for _, event := range result.Events {
+					if bytes.Contains(event.Payload, []byte(decomposed)) {
+						t.Fatalf("%s emitted a non-NFC text in %s: %s", function, event.Name, event.Payload)
+					}
+					stored = stored || bytes.Contains(event.Payload, []byte(nfcText))
+				}
+				if !stored {
+					t.Fatalf("%s neither stored nor emitted the NFC text %q", function, nfcText)
+				}
+			})
+		}
+	}
+}
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		value     string
+		maxLength int
+		expected  string
+		// err is the start of the expected error message, "" for none
+		err string
+	}{
+		{mixedText, 16, nfcText, ""},
+		{"e\u0301e\u0301", 2, "\u00e9\u00e9", ""},
+		{"\u00e9\u00e9\u00e9", 2, "", "ERR_INVALID_TEXT: memo exceeds 2 characters"},
+		{"", 2, "", ""},
+		{"\uFEFFmemo", 16, "", "ERR_INVALID_TEXT: memo contains a byte order mark"},
+		{"memo\xff", 16, "", "ERR_INVALID_TEXT: memo is not valid UTF-8"},
+		{"\xff\xfem\x00", 16, "", "ERR_INVALID_TEXT: memo is not valid UTF-8"},
+		{"line\nbreak", 16, "", "ERR_INVALID_TEXT: memo contains control characters"},
+		{"nul\x00", 16, "", "ERR_INVALID_TEXT: memo contains control characters"},
+	}
+	for _, test := range tests {
+		got, err := sanitizeText("memo", test.value, test.maxLength)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("sanitizeText(%q, %d) returned %q, %v, expected %s", test.value, test.maxLength, got, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.expected {
+			t.Errorf("sanitizeText(%q, %d) returned %q, %v, expected %q", test.value, test.maxLength, got, err, test.expected)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"strconv"
+	"strings"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
@@ -15,8 +17,14 @@ const symbolKey = "symbol"
 const decimalsKey = "decimals"
 const totalSupplyKey = "totalSupply"
 
+// initializedKey is set by the first successful Initialize
+const initializedKey = "initialized"
+
 // Define objectType names for prefix
+const balancePrefix = "balance"
 const allowancePrefix = "allowance"
+const allowanceReferencePrefix = "allowanceReference"
+const allowanceRecipientPrefix = "allowanceRecipient"
 
 // Define SmartContract structure
 type SmartContract struct {
@@ -24,9 +32,99 @@ type SmartContract struct {
 
 // event provides an organized struct for emitting events
 type event struct {
+	Token string `json:"token"`
 	From  string `json:"from"`
 	To    string `json:"to"`
 	Value int    `json:"value"`
+	// Category is the spending category the sender tagged a Transfer with, if any
+	Category string `json:"category,omitempty"`
+	// Memo identifies the deposit for recipients that require one, see SetMemoRequired
+	Memo string `json:"memo,omitempty"`
+	// Spillover is the part of the amount a capped recipient could not accept, see SetBalanceCap;
+	// Value is then what was actually credited
+	Spillover int `json:"spillover,omitempty"`
+	// TravelRuleHash is the hash of the travel rule data kept in a private collection, see GetTravelRuleData
+	TravelRuleHash string `json:"travelRuleHash,omitempty"`
+	// MinterSession is the session a mint was made under, see CreateMinterSession
+	MinterSession string `json:"minterSession,omitempty"`
+}
+
+// initOptions holds the optional settings passed to Initialize
+type initOptions struct {
+	TermsRequired bool   `json:"termsRequired"`
+	DocumentHash  string `json:"documentHash"`
+	HumanAmounts  bool   `json:"humanAmounts"`
+	// RecentActivity keeps the last RecentActivitySize movements (default 100) of every account
+	RecentActivity     bool `json:"recentActivity"`
+	RecentActivitySize int  `json:"recentActivitySize"`
+	// MaxSupply caps the total supply; changing it later takes MaxSupplyChangeDelay seconds of notice
+	MaxSupply            int   `json:"maxSupply"`
+	MaxSupplyChangeDelay int64 `json:"maxSupplyChangeDelay"`
+	// IdentityMode selects how clients map to accounts: "creator" (default) or "attribute"
+	IdentityMode string `json:"identityMode"`
+	// StrictQueries adds a warning to the response of every query, for clients that submit them by mistake
+	StrictQueries bool `json:"strictQueries"`
+	// MinterOrg restricts Mint to clients of this MSP ID, see checkMinter
+	MinterOrg string `json:"minterOrg"`
+	// RequireAdminOU makes privileged functions require a certificate with the admin OU, see checkAdminOU
+	RequireAdminOU bool `json:"requireAdminOU"`
+	// DevMode enables the Faucet for test networks; FaucetCap is each identity's lifetime faucet allowance
+	DevMode   bool `json:"devMode"`
+	FaucetCap int  `json:"faucetCap"`
+	// PrivateBalances restricts BalanceOf to the owner and auditors, see SetPublicBalance
+	PrivateBalances bool `json:"privateBalances"`
+	// ExperimentalEnabled routes the experimental functions, see SetExperimentalEnabled
+	ExperimentalEnabled bool `json:"experimentalEnabled"`
+	// StrictMode turns warnings into failures, see SetStrictMode
+	StrictMode bool `json:"strictMode"`
+}
+
+// metadataEntry is a key written by Initialize
+type metadataEntry struct {
+	key   string
+	value string
+}
+
+// approvalEvent describes a new allowance
+type approvalEvent struct {
+	Token     string `json:"token"`
+	Owner     string `json:"owner"`
+	Spender   string `json:"spender"`
+	Value     int    `json:"value"`
+	Reference string `json:"reference,omitempty"`
+	// AllowedRecipient is the only account the allowance can be spent to, if set
+	AllowedRecipient string `json:"allowedRecipient,omitempty"`
+}
+
+// balanceResponse is the JSON document returned by BalanceOf in JSON mode
+// Balance is the spendable balance; Held is in active holds and Releasable in expired holds not released yet.
+// Counter is the modification counter of the account, see checkExpectedCounter; it is null until
+// state format 2 is in use, since balances in the integer format keep no counter.
+type balanceResponse struct {
+	Token      string `json:"token"`
+	Account    string `json:"account"`
+	Balance    int    `json:"balance"`
+	Held       int    `json:"held"`
+	Releasable int    `json:"releasable"`
+	Counter    *int64 `json:"counter"`
+}
+
+// allowanceResponse is the JSON document returned by Allowance in JSON mode
+type allowanceResponse struct {
+	Token            string `json:"token"`
+	Owner            string `json:"owner"`
+	Spender          string `json:"spender"`
+	Value            int    `json:"value"`
+	Reference        string `json:"reference,omitempty"`
+	AllowedRecipient string `json:"allowedRecipient,omitempty"`
+}
+
+// allowanceSpentEvent describes a transfer made through an allowance
+type allowanceSpentEvent struct {
+	event
+	Spender   string `json:"spender"`
+	Remaining int    `json:"remaining"`
+	Reference string `json:"reference,omitempty"`
 }
 
 // Init initializes chaincode
@@ -35,267 +133,690 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) peer.Response
 }
 
 // Invoke - Our entry point for Invocations
-func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) peer.Response {
+// A panic in any function is converted to an ERR_PANIC error instead of crashing the chaincode.
+func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) (response peer.Response) {
+	APIstub = &txStub{ChaincodeStubInterface: APIstub}
 	function, args := APIstub.GetFunctionAndParameters()
-	switch function {
-	case "Mint":
-		return s.Mint(APIstub, args)
-	case "Burn":
-		return s.Burn(APIstub, args)
-	case "Transfer":
-		return s.Transfer(APIstub, args)
-	case "BalanceOf":
-		return s.BalanceOf(APIstub, args)
-	case "ClientAccountBalance":
-		return s.ClientAccountBalance(APIstub, args)
-	case "ClientAccountID":
-		return s.ClientAccountID(APIstub, args)
-	case "TotalSupply":
-		return s.TotalSupply(APIstub, args)
-	case "Approve":
-		return s.Approve(APIstub, args)
-	case "Allowance":
-		return s.Allowance(APIstub, args)
-	case "TransferFrom":
-		return s.TransferFrom(APIstub, args)
-	case "Name":
-		return s.Name(APIstub, args)
-	case "Symbol":
-		return s.Symbol(APIstub, args)
-	case "Initialize":
-		return s.Initialize(APIstub, args)
-	default:
-		return shim.Error("Invalid function name")
+	defer func() {
+		if r := recover(); r != nil {
+			response = shim.Error(fmt.Sprintf("ERR_PANIC: %s panicked: %v", function, r))
+		}
+		// Failures carry their code and parameters as payload for clients to localize
+		response = withErrorPayload(APIstub, response)
+	}()
+
+	err := vetoRecoveryOnActivity(APIstub, function)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	return dispatch(s, APIstub, function, args)
 }
 
 // Mint creates new tokens and adds them to minter's account balance
-// This function triggers a Transfer event
+// Only authorized clients can mint, see checkMinter.
+// This function triggers a Transfer event, or a SupplyAlarm event if the mint trips the supply alarm
 func (s *SmartContract) Mint(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 	if len(args) != 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
 	minter := args[0]
-	amount, err := strconv.Atoi(args[1])
+	amount, err := parsePositiveAmount(APIstub, args[1])
 	if err != nil {
-		return shim.Error("Invalid amount. Expecting a numeric string")
+		return shim.Error(err.Error())
 	}
 
-	// Check if caller is authorized to mint tokens
-	// (you may need to implement this authorization logic)
-
-	// Get current balance of minter
-	balanceBytes, err := APIstub.GetState(minter)
+	err = checkInitialized(APIstub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	var balance int
-	if balanceBytes == nil {
-		balance = 0
-	} else {
-		balance, _ = strconv.Atoi(string(balanceBytes))
+	err = checkMinter(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-
-	// Mint tokens
-	balance += amount
-
-	// Update state with new balance
-	err = APIstub.PutState(minter, []byte(strconv.Itoa(balance)))
+	err = checkMintPaused(APIstub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	// Emit Transfer event
-	eventData := event{From: "", To: minter, Value: amount}
-	eventBytes, err := json.Marshal(eventData)
+	err = checkMaxSupply(APIstub, amount)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = APIstub.SetEvent("Transfer", eventBytes)
+
+	credited, err := mintBalance(APIstub, minter, amount)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// Emit Transfer event, or SupplyAlarm if this mint tripped the alarm
+	err = emitMint(APIstub, event{To: minter, Value: credited, Spillover: amount - credited})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
 	return shim.Success(nil)
 }
 
-// Burn redeems tokens from the minter's account balance
-// This function triggers a Transfer event
+// mintBalance creates `amount` tokens in `account` and returns the amount minted
+// Over a spillover cap, only the accepted part is minted. The mint is recorded in the recent
+// activity of the account, so call it at most once per transaction.
+func mintBalance(APIstub shim.ChaincodeStubInterface, account string, amount int) (int, error) {
+	balance, _, err := getBalance(APIstub, account)
+	if err != nil {
+		return 0, err
+	}
+	amount, err = applyBalanceCap(APIstub, account, balance, amount)
+	if err != nil {
+		return 0, err
+	}
+	newBalance, err := checkedAdd(APIstub, balance, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	err = putBalance(APIstub, account, newBalance)
+	if err != nil {
+		return 0, notCommitted(err)
+	}
+	err = adjustSupply(APIstub, amount, supplyReasonMint)
+	if err != nil {
+		return 0, notCommitted(err)
+	}
+	err = recordMovements(APIstub, movement{To: account, Value: amount})
+	if err != nil {
+		return 0, notCommitted(err)
+	}
+	return amount, nil
+}
+
+// Burn redeems tokens from the client account balance
+// Optional second and third arguments record why and on whose behalf the tokens were retired.
+// Every burn produces a retirement certificate, whose ID is returned as payload.
+// Burning from another account takes the burner role, see ForceBurn.
+// This function triggers a Retirement event
 func (s *SmartContract) Burn(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 to 3")
 	}
 
-	minter := args[0]
-	amount, err := strconv.Atoi(args[1])
+	amount, err := parsePositiveAmount(APIstub, args[0])
 	if err != nil {
-		return shim.Error("Invalid amount. Expecting a numeric string")
+		return shim.Error(err.Error())
+	}
+	var reason, beneficiary string
+	if len(args) >= 2 {
+		reason, err = sanitizeText("reason", args[1], maxMemoLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(args) == 3 {
+		beneficiary, err = sanitizeText("beneficiary", args[2], maxReferenceLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
-	// Check if caller is authorized to burn tokens
-	// (you may need to implement this authorization logic)
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Tokens can only be burned from the caller's own account
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	// Get current balance of minter
-	balanceBytes, err := APIstub.GetState(minter)
+	certificate, err := burnBalance(APIstub, owner, amount, reason, beneficiary)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if balanceBytes == nil {
-		return shim.Error("Account not found")
+
+	// Emit Retirement event, which carries the Transfer fields as well
+	err = emitRetirement(APIstub, *certificate)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success([]byte(certificate.CertificateID))
+}
+
+// burnBalance removes `amount` tokens of `account` from the supply and returns the retirement certificate
+// The movement is recorded in the recent activity of the account, so call it at most once per transaction
+func burnBalance(APIstub shim.ChaincodeStubInterface, account string, amount int, reason string, beneficiary string) (*retirement, error) {
+	balance, exists, err := getBalance(APIstub, account)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newCodedError(APIstub, errInvalidAccount, map[string]string{"account": account}, "account %s not found", account)
 	}
-	balance, _ := strconv.Atoi(string(balanceBytes))
 
-	// Ensure minter has enough tokens to burn
+	// Ensure the account has enough tokens to burn
 	if balance < amount {
-		return shim.Error("Insufficient balance")
+		return nil, insufficientBalance(APIstub, balance, amount)
 	}
 
 	// Burn tokens
-	balance -= amount
+	balance, err = checkedSub(APIstub, balance, amount)
+	if err != nil {
+		return nil, err
+	}
 
 	// Update state with new balance
-	err = APIstub.PutState(minter, []byte(strconv.Itoa(balance)))
+	err = putBalance(APIstub, account, balance)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, notCommitted(err)
 	}
 
-	// Emit Transfer event
-	eventData := event{From: minter, To: "", Value: amount}
-	eventBytes, err := json.Marshal(eventData)
+	err = adjustSupply(APIstub, -amount, supplyReasonBurn)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, notCommitted(err)
 	}
-	err = APIstub.SetEvent("Transfer", eventBytes)
+
+	err = recordMovements(APIstub, movement{From: account, Value: amount})
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, notCommitted(err)
 	}
 
-	return shim.Success(nil)
+	certificate, err := createRetirement(APIstub, account, amount, reason, beneficiary)
+	if err != nil {
+		return nil, notCommitted(err)
+	}
+	return certificate, nil
 }
 
-// Transfer transfers tokens from client account to recipient account
+// Transfer transfers tokens from the client account to the recipient account
 // recipient account must be a valid clientID as returned by the ClientID() function
-// This function triggers a Transfer event
+// Optional arguments follow the amount, and may be left empty to skip them:
+// the spending category (see SetCategoryBudget), the memo some recipients require
+// (see SetMemoRequired), the sequence number of sequenced accounts (see EnableSequencing) and
+// the modification counter the caller's account is expected to have (see checkExpectedCounter).
+// Originator and beneficiary data go in the transient field "travelRule"; above the threshold set
+// with SetTravelRuleThreshold they are mandatory.
+// Transfers between the accounts of a trusted pair skip the hooks set with SetTrustedPair.
+// A transfer to the account of a registered receiver chaincode succeeds with a warning in its
+// message, since the chaincode is not notified; see TransferAndCall. In strict mode it fails.
+// A transfer from an account under a two-person rule is held until its approvers approve it, see
+// SetTwoPersonRule; the ID of the pending transfer is returned as payload.
+// This function triggers a Transfer event, or an OutgoingTransferPending event for a held transfer
 func (s *SmartContract) Transfer(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(args) < 2 || len(args) > 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 to 6")
 	}
 
-	from := args[0]
-	to := args[1]
-	amount, err := strconv.Atoi(args[2])
+	to := args[0]
+	amount, err := parsePositiveAmount(APIstub, args[1])
 	if err != nil {
-		return shim.Error("Invalid amount. Expecting a numeric string")
+		return shim.Error(err.Error())
+	}
+	var category string
+	if len(args) >= 3 {
+		category, err = sanitizeText("category", args[2], maxCategoryLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	var memo string
+	if len(args) >= 4 {
+		memo, err = sanitizeText("memo", args[3], maxMemoLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	var sequence string
+	if len(args) >= 5 {
+		sequence = args[4]
+	}
+	var counter string
+	if len(args) == 6 {
+		counter = args[5]
 	}
 
-	// Get balances of sender and recipient
-	fromBalanceBytes, err := APIstub.GetState(from)
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Tokens can only be sent from the caller's own account; see ForceTransfer for operator moves
+	from, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkExpectedCounter(APIstub, from, counter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Transfers within a trusted pair skip the hooks recorded with the pair
+	skipped, err := getSkippedHooks(APIstub, from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !skipped[hookMemo] {
+		err = checkMemo(APIstub, to, memo)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = applySequence(APIstub, from, sequence)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !skipped[hookBudget] {
+		err = spendCategory(APIstub, from, category, amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	var travelRule *pendingTravelRule
+	if !skipped[hookTravelRule] {
+		travelRule, err = prepareTravelRule(APIstub, from, to, amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	rule, err := getTwoPersonRule(APIstub, from)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if fromBalanceBytes == nil {
-		return shim.Error("Sender account not found")
+	if rule != nil {
+		return holdOutgoing(APIstub, *rule, to, amount, category, memo, travelRule)
 	}
-	fromBalance, _ := strconv.Atoi(string(fromBalanceBytes))
 
-	toBalanceBytes, err := APIstub.GetState(to)
+	credited, err := transferBalanceSkipping(APIstub, from, to, amount, skipped)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	var toBalance int
-	if toBalanceBytes == nil {
-		toBalance = 0
-	} else {
-		toBalance, _ = strconv.Atoi(string(toBalanceBytes))
+
+	var travelRuleHash string
+	if travelRule != nil {
+		err = putTravelRule(APIstub, *travelRule)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		travelRuleHash = travelRule.record.Hash
+	}
+
+	// Emit Transfer event
+	err = emitTransfer(APIstub, event{From: from, To: to, Value: credited, Category: category, Memo: memo, Spillover: amount - credited, TravelRuleHash: travelRuleHash})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	// A receiver chaincode only learns of the tokens through TransferAndCall
+	response := shim.Success(nil)
+	r, err := getReceiverOfAccount(APIstub, to)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	if r != nil {
+		return withWarning(APIstub, response, warnReceiverNotNotified, "%s is held by receiver chaincode %s; use TransferAndCall to notify it", to, r.ChaincodeName)
+	}
+	return response
+}
+
+// transferBalance moves `amount` tokens from `from` to `to` and returns the amount credited
+// The recipient's incoming allowlist, terms acceptance and balance cap are enforced here so every
+// transfer path honors them. A recipient with spillover may accept less than `amount`; the rest
+// simply stays with the sender.
+// The movement is recorded in the recent activity of both accounts, so call it at most once per transaction
+func transferBalance(APIstub shim.ChaincodeStubInterface, from string, to string, amount int) (int, error) {
+	return transferBalanceSkipping(APIstub, from, to, amount, nil)
+}
+
+// transferBalanceSkipping is transferBalance without the recipient hooks in `skipped`, see trustedPair
+// The sender's balance is always checked.
+func transferBalanceSkipping(APIstub shim.ChaincodeStubInterface, from string, to string, amount int, skipped hookSet) (int, error) {
+	var err error
+	// Check the recipient accepts transfers from the sender
+	if !skipped[hookAllowlist] {
+		err = checkIncomingAllowed(APIstub, from, to)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if !skipped[hookTerms] {
+		err = checkTermsAccepted(APIstub, to)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Get balances of sender and recipient
+	plan := newSettlementPlan()
+	fromBalance, exists, err := plan.balance(APIstub, from)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, newCodedError(APIstub, errInvalidAccount, map[string]string{"account": from}, "sender account %s not found", from)
+	}
+
+	toBalance, _, err := plan.balance(APIstub, to)
+	if err != nil {
+		return 0, err
 	}
 
 	// Ensure sender has enough tokens to transfer
 	if fromBalance < amount {
-		return shim.Error("Insufficient balance")
+		return 0, insufficientBalance(APIstub, fromBalance, amount)
+	}
+
+	// GetState does not see this transaction's own writes, so crediting
+	// after debiting the same key would create tokens
+	if from == to {
+		return amount, checkNotFrozen(APIstub, from)
+	}
+
+	if !skipped[hookBalanceCap] {
+		amount, err = applyBalanceCap(APIstub, to, toBalance, amount)
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	// Transfer tokens
-	fromBalance -= amount
-	toBalance += amount
+	err = plan.transfer(APIstub, from, to, amount)
+	if err != nil {
+		return 0, err
+	}
+	err = plan.apply(APIstub)
+	if err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
+
+// unprefixedKeys are the token-wide keys stored under a plain name rather than a composite key
+// Settings are listed in settingDefaults. Balances used to be stored under the raw account
+// string too, so an account named after one of these keys never had a balance there, see
+// MigrateLegacyBalances.
+var unprefixedKeys = map[string]bool{
+	nameKey:              true,
+	symbolKey:            true,
+	decimalsKey:          true,
+	totalSupplyKey:       true,
+	initializedKey:       true,
+	activatedKey:         true,
+	balanceImportKey:     true,
+	keySchemaKey:         true,
+	stateFormatKey:       true,
+	maxSupplyProposalKey: true,
+	mintPausedKey:        true,
+	pausedKey:            true,
+	supplyAlarmKey:       true,
+	supplyAlarmWindowKey: true,
+}
+
+// isUnprefixedKey reports whether `key` is a token-wide key stored under a plain name
+func isUnprefixedKey(key string) bool {
+	_, setting := settingDefaults[key]
+	return setting || unprefixedKeys[key]
+}
+
+// getBalance returns the balance of `account` and whether the account exists
+func getBalance(APIstub shim.ChaincodeStubInterface, account string) (int, bool, error) {
+	record, exists, err := getBalanceRecord(APIstub, account)
+	return record.Balance, exists, err
+}
+
+// getBalanceRecord returns the balance record of `account` and whether the account exists
+func getBalanceRecord(APIstub shim.ChaincodeStubInterface, account string) (balanceRecord, bool, error) {
+	record, exists, _, err := getStoredBalance(APIstub, account)
+	return record, exists, err
+}
 
-	// Update sender's balance
-	err = APIstub.PutState(from, []byte(strconv.Itoa(fromBalance)))
+// getStoredBalance returns the balance record of `account`, whether it exists and the legacy key
+// holding part of it, "" if none
+// The caller's balance may still be under its legacy key, see legacyBalanceKey: wholly, or in part
+// if the account was credited under its new key before the caller's first write. Both parts count,
+// and the legacy part moves to the prefixed key on the next write. Balances written under the raw
+// account string before balancePrefix are not read, see MigrateLegacyBalances.
+func getStoredBalance(APIstub shim.ChaincodeStubInterface, account string) (balanceRecord, bool, string, error) {
+	balanceKey, err := buildKey(APIstub, balancePrefix, []string{account})
 	if err != nil {
-		return shim.Error(err.Error())
+		return balanceRecord{}, false, "", err
+	}
+	balanceBytes, err := APIstub.GetState(balanceKey)
+	if err != nil {
+		return balanceRecord{}, false, "", stateError(APIstub, "GetState", balancePrefix, err)
+	}
+	var record balanceRecord
+	if balanceBytes != nil {
+		record, err = decodeBalanceRecord(account, balanceBytes)
+		if err != nil {
+			return balanceRecord{}, false, "", err
+		}
 	}
 
-	// Update recipient's balance
-	err = APIstub.PutState(to, []byte(strconv.Itoa(toBalance)))
+	legacyBytes, legacyKey, err := getLegacyBalance(APIstub, account)
 	if err != nil {
-		return shim.Error(err.Error())
+		return balanceRecord{}, false, "", err
+	}
+	if legacyBytes == nil {
+		return record, balanceBytes != nil, "", nil
 	}
+	legacy, err := decodeBalanceRecord(account, legacyBytes)
+	if err != nil {
+		return balanceRecord{}, false, "", err
+	}
+	record.Balance, err = checkedAdd(APIstub, record.Balance, legacy.Balance)
+	if err != nil {
+		return balanceRecord{}, false, "", err
+	}
+	return record, true, legacyKey, nil
+}
 
-	// Emit Transfer event
-	eventData := event{From: from, To: to, Value: amount}
-	eventBytes, err := json.Marshal(eventData)
+// getLegacyBalance returns the stored balance of `account` under its legacy key and that key
+// The balance is nil if there is none, see legacyBalanceKey.
+func getLegacyBalance(APIstub shim.ChaincodeStubInterface, account string) ([]byte, string, error) {
+	legacyKey, err := legacyBalanceKey(APIstub, account)
+	if err != nil || legacyKey == "" {
+		return nil, "", err
+	}
+	balanceBytes, err := APIstub.GetState(legacyKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, "", stateError(APIstub, "GetState", balancePrefix, err)
 	}
-	err = APIstub.SetEvent("Transfer", eventBytes)
+	return balanceBytes, legacyKey, nil
+}
+
+// debitBalance removes `amount` tokens from `account`
+// Callers must not read or write the same balance again in this transaction
+func debitBalance(APIstub shim.ChaincodeStubInterface, account string, amount int) error {
+	balance, exists, err := getBalance(APIstub, account)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
+	}
+	if !exists {
+		return newCodedError(APIstub, errInvalidAccount, map[string]string{"account": account}, "account %s not found", account)
 	}
+	if balance < amount {
+		return insufficientBalance(APIstub, balance, amount)
+	}
+	balance, err = checkedSub(APIstub, balance, amount)
+	if err != nil {
+		return err
+	}
+	return putBalance(APIstub, account, balance)
+}
 
-	return shim.Success(nil)
+// creditBalance adds `amount` tokens to `account`
+// Callers must not read or write the same balance again in this transaction
+func creditBalance(APIstub shim.ChaincodeStubInterface, account string, amount int) error {
+	balance, _, err := getBalance(APIstub, account)
+	if err != nil {
+		return err
+	}
+	balance, err = checkedAdd(APIstub, balance, amount)
+	if err != nil {
+		return err
+	}
+	return putBalance(APIstub, account, balance)
+}
+
+// putBalance stores the balance of `account` and records the activity on the account
+// Every balance change goes through here so activity tracking, freezes, the account cap and the
+// modification counter cannot be bypassed
+func putBalance(APIstub shim.ChaincodeStubInterface, account string, balance int) error {
+	err := checkNotFrozen(APIstub, account)
+	if err != nil {
+		return err
+	}
+	balanceKey, err := buildKey(APIstub, balancePrefix, []string{account})
+	if err != nil {
+		return err
+	}
+	record, exists, legacyKey, err := getStoredBalance(APIstub, account)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = registerAccount(APIstub, account)
+		if err != nil {
+			return err
+		}
+	}
+	if legacyKey != "" {
+		// The legacy part is in `balance` and moves to the prefixed key; the account is already counted
+		err = APIstub.DelState(legacyKey)
+		if err != nil {
+			return stateError(APIstub, "DelState", balancePrefix, err)
+		}
+	}
+
+	format, err := getStateFormat(APIstub)
+	if err != nil {
+		return err
+	}
+	record.Balance = balance
+	record.Counter++
+	recordBytes, err := encodeBalanceRecord(record, format)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(balanceKey, recordBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", balancePrefix, err)
+	}
+	return recordActivity(APIstub, account)
+}
+
+// emitTransfer emits the Transfer event for a movement, labelled with the token symbol
+// An empty `From` denotes a mint and an empty `To` denotes a burn
+func emitTransfer(APIstub shim.ChaincodeStubInterface, eventData event) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData.Token = symbol
+	eventBytes, err := encodeJSON(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent("Transfer", eventBytes)
 }
 
-// BalanceOf returns the balance of the given account
+// BalanceOf returns the balance of the given account, 0 if it never held tokens
+// With privateBalances, only the owner, auditors and anyone for a public balance can read it.
+// Held tokens are not part of the balance; pass "json" as a second argument to get a JSON
+// document that reports them separately, see Hold, along with the modification counter of the account.
 func (s *SmartContract) BalanceOf(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
 	}
 
 	account := args[0]
+	jsonMode := len(args) == 2 && args[1] == "json"
+	if len(args) == 2 && !jsonMode {
+		return shim.Error("Invalid format. Expecting json")
+	}
 
-	balanceBytes, err := APIstub.GetState(account)
+	err := checkBalanceReadable(APIstub, account)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if balanceBytes == nil {
-		return shim.Error("Account not found")
+
+	// An account that never held tokens has a balance of 0, as in ERC-20
+	record, _, err := getBalanceRecord(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !jsonMode {
+		return shim.Success([]byte(strconv.Itoa(record.Balance)))
 	}
 
-	return shim.Success(balanceBytes)
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	held, releasable, err := getHeldAmounts(APIstub, account, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	format, err := getStateFormat(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	response := balanceResponse{Token: symbol, Account: account, Balance: record.Balance, Held: held, Releasable: releasable}
+	if format >= stateFormatRecord {
+		response.Counter = &record.Counter
+	}
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
 }
 
 // ClientAccountBalance returns the balance of the requesting client's account
 func (s *SmartContract) ClientAccountBalance(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	// In this implementation, the requesting client's account is identified by its certificate
-	// You may need to implement additional logic to identify clients in your actual implementation
-	cert, err := APIstub.GetCreator()
+	clientID, err := getClientID(APIstub)
 	if err != nil {
-		return shim.Error("Failed to get client's certificate")
+		return shim.Error(err.Error())
 	}
-	clientID := string(cert)
 
 	return s.BalanceOf(APIstub, []string{clientID})
 }
 
 // ClientAccountID returns the id of the requesting client's account
-// In this implementation, the client account ID is the client's certificate
+// In the default creator identity mode it is the client's MSP ID and X.509 identity, see
+// creatorIdentityResolver; in attribute mode it is the account attribute of its certificate.
 func (s *SmartContract) ClientAccountID(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	// In this implementation, the requesting client's account is identified by its certificate
-	// You may need to implement additional logic to identify clients in your actual implementation
-	cert, err := APIstub.GetCreator()
+	clientID, err := getClientID(APIstub)
 	if err != nil {
-		return shim.Error("Failed to get client's certificate")
+		return shim.Error(err.Error())
 	}
-	clientID := string(cert)
 
 	return shim.Success([]byte(clientID))
 }
 
+// getTxTime returns the transaction timestamp in unix seconds
+// All time-based checks use it so that every endorser reaches the same result
+func getTxTime(APIstub shim.ChaincodeStubInterface) (int64, error) {
+	txTimestamp, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get transaction timestamp")
+	}
+	return txTimestamp.Seconds, nil
+}
+
 // TotalSupply returns the total supply of tokens
 func (s *SmartContract) TotalSupply(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 	totalSupplyBytes, err := APIstub.GetState(totalSupplyKey)
 	if err != nil {
-		return shim.Error("Failed to get total supply")
+		return shim.Error(stateError(APIstub, "GetState", totalSupplyKey, err).Error())
 	}
 	if totalSupplyBytes == nil {
 		return shim.Error("Total supply not set")
@@ -303,148 +824,362 @@ func (s *SmartContract) TotalSupply(APIstub shim.ChaincodeStubInterface, args []
 	return shim.Success(totalSupplyBytes)
 }
 
-// Approve allows `spender` to withdraw from `owner`'s account, multiple times, up to the `amount`.
+// Approve allows `spender` to withdraw from the client account, multiple times, up to the `amount`.
 // If this function is called again it overwrites the current allowance with the `amount`.
+// An optional third argument records why the allowance was granted, e.g. a PO number.
+// An optional fourth argument restricts the allowance to transfers to that recipient.
+// An optional fifth argument is the modification counter the caller's account is expected to
+// have, see checkExpectedCounter. Optional arguments may be left empty to skip them.
+// This function triggers an Approval event
 func (s *SmartContract) Approve(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(args) < 2 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 to 5")
 	}
 
-	owner := args[0]
-	spender := args[1]
-	amount, err := strconv.Atoi(args[2])
+	spender := args[0]
+	amount, err := parseNonNegativeAmount(APIstub, args[1])
 	if err != nil {
-		return shim.Error("Invalid amount. Expecting a numeric string")
+		return shim.Error(err.Error())
+	}
+	var reference string
+	if len(args) >= 3 {
+		reference, err = sanitizeText("reference", args[2], maxReferenceLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	var allowedRecipient string
+	if len(args) >= 4 {
+		allowedRecipient = args[3]
+	}
+	var counter string
+	if len(args) == 5 {
+		counter = args[4]
 	}
 
-	allowanceKey := allowancePrefix + owner + spender
+	err = checkInitialized(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Allowances can only be granted over the caller's own account
+	owner, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkExpectedCounter(APIstub, owner, counter)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	err = APIstub.PutState(allowanceKey, []byte(strconv.Itoa(amount)))
+	err = setAllowance(APIstub, owner, spender, amount, reference, allowedRecipient)
 	if err != nil {
-		return shim.Error("Failed to set allowance")
+		return shim.Error(err.Error())
+	}
+
+	err = emitApproval(APIstub, owner, spender, amount, reference, allowedRecipient)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	return shim.Success(nil)
 }
 
-// Allowance returns the amount which `spender` is still allowed to withdraw from `owner`.
-func (s *SmartContract) Allowance(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+// setAllowance overwrites the allowance of `spender` over `owner`'s account with `amount`
+// The reference and recipient restriction are replaced as well, so they always describe the current authorization.
+func setAllowance(APIstub shim.ChaincodeStubInterface, owner string, spender string, amount int, reference string, allowedRecipient string) error {
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return err
 	}
 
-	owner := args[0]
-	spender := args[1]
-	allowanceKey := allowancePrefix + owner + spender
+	err = APIstub.PutState(allowanceKey, []byte(strconv.Itoa(amount)))
+	if err != nil {
+		return stateError(APIstub, "PutState", allowancePrefix, err)
+	}
+
+	err = putAllowanceReference(APIstub, owner, spender, reference)
+	if err != nil {
+		return err
+	}
+	err = putAllowedRecipient(APIstub, owner, spender, allowedRecipient)
+	if err != nil {
+		return err
+	}
+
+	// Index the allowance by spender so it can follow a spender rotation
+	return indexSpender(APIstub, owner, spender)
+}
+
+// spendAllowance moves `amount` tokens of `owner` to `to` on the allowance of `spender`
+// It returns the amount credited and the remaining allowance. Spillover stays with the owner
+// and is not spent. `skipped` are the recipient hooks to skip, see transferBalanceSkipping.
+func spendAllowance(APIstub shim.ChaincodeStubInterface, owner string, spender string, to string, amount int, skipped hookSet) (int, int, error) {
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
+	if err != nil {
+		return 0, 0, err
+	}
 
 	allowanceBytes, err := APIstub.GetState(allowanceKey)
 	if err != nil {
-		return shim.Error("Failed to get allowance")
+		return 0, 0, stateError(APIstub, "GetState", allowancePrefix, err)
 	}
 	if allowanceBytes == nil {
-		return shim.Error("Allowance not found")
+		params := map[string]string{"available": "0", "requested": strconv.Itoa(amount)}
+		return 0, 0, newCodedError(APIstub, errAllowanceExceeded, params, "insufficient allowance (have 0, need %d)", amount)
+	}
+
+	allowance, _ := strconv.Atoi(string(allowanceBytes))
+	if allowance < amount {
+		return 0, 0, newCodedError(APIstub, errAllowanceExceeded, map[string]string{"available": strconv.Itoa(allowance), "requested": strconv.Itoa(amount)}, "allowance exceeded")
+	}
+	allowedRecipient, err := getAllowedRecipient(APIstub, owner, spender)
+	if err != nil {
+		return 0, 0, err
+	}
+	if allowedRecipient != "" && allowedRecipient != to {
+		return 0, 0, fmt.Errorf("ERR_RECIPIENT_RESTRICTED: allowance can only be spent to %s", allowedRecipient)
+	}
+
+	credited, err := transferBalanceSkipping(APIstub, owner, to, amount, skipped)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Update spender's allowance; spillover stays with the owner and is not spent
+	allowance, err = checkedSub(APIstub, allowance, credited)
+	if err != nil {
+		return 0, 0, notCommitted(err)
+	}
+	err = APIstub.PutState(allowanceKey, []byte(strconv.Itoa(allowance)))
+	if err != nil {
+		return 0, 0, notCommitted(stateError(APIstub, "PutState", allowancePrefix, err))
 	}
-	return shim.Success(allowanceBytes)
+	return credited, allowance, nil
 }
 
-// TransferFrom transfers `amount` tokens from `from` to `to` using the allowance mechanism.
-// `amount` is then deducted from the caller’s allowance.
-func (s *SmartContract) TransferFrom(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
+// getAllowanceReference returns the reference recorded with an allowance, or "" if there is none
+func getAllowanceReference(APIstub shim.ChaincodeStubInterface, owner string, spender string) (string, error) {
+	referenceKey, err := buildKey(APIstub, allowanceReferencePrefix, []string{owner, spender})
+	if err != nil {
+		return "", err
+	}
+	referenceBytes, err := APIstub.GetState(referenceKey)
+	if err != nil {
+		return "", stateError(APIstub, "GetState", allowanceReferencePrefix, err)
+	}
+	return string(referenceBytes), nil
+}
+
+// putAllowanceReference records the reference of an allowance; an empty reference removes it
+func putAllowanceReference(APIstub shim.ChaincodeStubInterface, owner string, spender string, reference string) error {
+	referenceKey, err := buildKey(APIstub, allowanceReferencePrefix, []string{owner, spender})
+	if err != nil {
+		return err
+	}
+	if reference == "" {
+		err = APIstub.DelState(referenceKey)
+		if err != nil {
+			return stateError(APIstub, "DelState", allowanceReferencePrefix, err)
+		}
+		return nil
+	}
+	err = APIstub.PutState(referenceKey, []byte(reference))
+	if err != nil {
+		return stateError(APIstub, "PutState", allowanceReferencePrefix, err)
+	}
+	return nil
+}
+
+// getAllowedRecipient returns the only recipient an allowance can be spent to, or "" if it is unrestricted
+func getAllowedRecipient(APIstub shim.ChaincodeStubInterface, owner string, spender string) (string, error) {
+	recipientKey, err := buildKey(APIstub, allowanceRecipientPrefix, []string{owner, spender})
+	if err != nil {
+		return "", err
+	}
+	recipientBytes, err := APIstub.GetState(recipientKey)
+	if err != nil {
+		return "", stateError(APIstub, "GetState", allowanceRecipientPrefix, err)
+	}
+	return string(recipientBytes), nil
+}
+
+// putAllowedRecipient restricts an allowance to `allowedRecipient`; an empty recipient lifts the restriction
+func putAllowedRecipient(APIstub shim.ChaincodeStubInterface, owner string, spender string, allowedRecipient string) error {
+	recipientKey, err := buildKey(APIstub, allowanceRecipientPrefix, []string{owner, spender})
+	if err != nil {
+		return err
+	}
+	if allowedRecipient == "" {
+		err = APIstub.DelState(recipientKey)
+		if err != nil {
+			return stateError(APIstub, "DelState", allowanceRecipientPrefix, err)
+		}
+		return nil
+	}
+	err = APIstub.PutState(recipientKey, []byte(allowedRecipient))
+	if err != nil {
+		return stateError(APIstub, "PutState", allowanceRecipientPrefix, err)
+	}
+	return nil
+}
+
+// emitApproval emits the Approval event for a new allowance
+func emitApproval(APIstub shim.ChaincodeStubInterface, owner string, spender string, amount int, reference string, allowedRecipient string) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventData := approvalEvent{Token: symbol, Owner: owner, Spender: spender, Value: amount, Reference: reference, AllowedRecipient: allowedRecipient}
+	eventBytes, err := json.Marshal(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent("Approval", eventBytes)
+}
+
+// Allowance returns the amount which `spender` is still allowed to withdraw from `owner`, 0 if never approved.
+// Pass "json" as a third argument to get a JSON document including the reference and recipient restriction.
+func (s *SmartContract) Allowance(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
 	}
 
 	owner := args[0]
 	spender := args[1]
-	to := args[2]
-	amount, err := strconv.Atoi(args[3])
+	jsonMode := len(args) == 3 && args[2] == "json"
+	if len(args) == 3 && !jsonMode {
+		return shim.Error("Invalid format. Expecting json")
+	}
+	allowanceKey, err := buildAllowanceKey(APIstub, owner, spender)
 	if err != nil {
-		return shim.Error("Invalid amount. Expecting a numeric string")
+		return shim.Error(err.Error())
 	}
 
-	allowanceKey := allowancePrefix + owner + spender
-
 	allowanceBytes, err := APIstub.GetState(allowanceKey)
 	if err != nil {
-		return shim.Error("Failed to get allowance")
+		return shim.Error(stateError(APIstub, "GetState", allowancePrefix, err).Error())
 	}
+	// An allowance that was never approved is 0, as in ERC-20
 	if allowanceBytes == nil {
-		return shim.Error("Allowance not found")
+		allowanceBytes = []byte("0")
+	}
+	if !jsonMode {
+		return shim.Success(allowanceBytes)
 	}
 
 	allowance, _ := strconv.Atoi(string(allowanceBytes))
-	if allowance < amount {
-		return shim.Error("Allowance exceeded")
+	reference, err := getAllowanceReference(APIstub, owner, spender)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-
-	// Get balances of owner and recipient
-	fromBalanceBytes, err := APIstub.GetState(owner)
+	allowedRecipient, err := getAllowedRecipient(APIstub, owner, spender)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if fromBalanceBytes == nil {
-		return shim.Error("Owner account not found")
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	fromBalance, _ := strconv.Atoi(string(fromBalanceBytes))
 
-	toBalanceBytes, err := APIstub.GetState(to)
+	response := allowanceResponse{
+		Token:            symbol,
+		Owner:            owner,
+		Spender:          spender,
+		Value:            allowance,
+		Reference:        reference,
+		AllowedRecipient: allowedRecipient,
+	}
+	responseBytes, err := json.Marshal(response)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	var toBalance int
-	if toBalanceBytes == nil {
-		toBalance = 0
-	} else {
-		toBalance, _ = strconv.Atoi(string(toBalanceBytes))
-	}
+	return shim.Success(responseBytes)
+}
 
-	// Ensure owner has enough tokens to transfer
-	if fromBalance < amount {
-		return shim.Error("Insufficient balance")
+// TransferFrom transfers `amount` tokens from `from` to `to` using the allowance mechanism.
+// The spender is always the client account; only the caller's own allowance from `from` can be spent.
+// The amount credited is then deducted from the caller’s allowance; spillover at a capped recipient is not.
+// An optional fourth argument holds the memo some recipients require, see SetMemoRequired.
+// This function triggers an AllowanceSpent event
+func (s *SmartContract) TransferFrom(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
 	}
 
-	// Transfer tokens
-	fromBalance -= amount
-	toBalance += amount
-
-	// Update owner's balance
-	err = APIstub.PutState(owner, []byte(strconv.Itoa(fromBalance)))
+	owner := args[0]
+	to := args[1]
+	amount, err := parsePositiveAmount(APIstub, args[2])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	var memo string
+	if len(args) == 4 {
+		memo, err = sanitizeText("memo", args[3], maxMemoLength)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
-	// Update recipient's balance
-	err = APIstub.PutState(to, []byte(strconv.Itoa(toBalance)))
+	err = checkInitialized(APIstub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	// Update spender's allowance
-	allowance -= amount
-	err = APIstub.PutState(allowanceKey, []byte(strconv.Itoa(allowance)))
+	spender, err := getClientID(APIstub)
 	if err != nil {
-		return shim.Error("Failed to update allowance")
+		return shim.Error(err.Error())
 	}
-
-	// Emit Transfer event
-	eventData := event{From: owner, To: to, Value: amount}
-	eventBytes, err := json.Marshal(eventData)
+	err = checkMemo(APIstub, to, memo)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = APIstub.SetEvent("Transfer", eventBytes)
+
+	credited, allowance, err := spendAllowance(APIstub, owner, spender, to, amount, nil)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// Emit AllowanceSpent event, which carries the Transfer fields as well
+	// since Fabric only keeps one event per transaction
+	err = emitAllowanceSpent(APIstub, owner, spender, to, credited, allowance, memo, amount-credited)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
 	return shim.Success(nil)
 }
 
+// emitAllowanceSpent emits an AllowanceSpent event for a TransferFrom by `spender`
+func emitAllowanceSpent(APIstub shim.ChaincodeStubInterface, owner string, spender string, to string, amount int, remaining int, memo string, spillover int) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	reference, err := getAllowanceReference(APIstub, owner, spender)
+	if err != nil {
+		return err
+	}
+	eventData := allowanceSpentEvent{
+		event:     event{Token: symbol, From: owner, To: to, Value: amount, Memo: memo, Spillover: spillover},
+		Spender:   spender,
+		Remaining: remaining,
+		Reference: reference,
+	}
+	eventBytes, err := encodeJSON(eventData)
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent("AllowanceSpent", eventBytes)
+}
+
 // Name returns the name of the token
 func (s *SmartContract) Name(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
 	nameBytes, err := APIstub.GetState(nameKey)
 	if err != nil {
-		return shim.Error("Failed to get token name")
+		return shim.Error(stateError(APIstub, "GetState", nameKey, err).Error())
 	}
 	if nameBytes == nil {
 		return shim.Error("Token name not set")
@@ -454,54 +1189,219 @@ func (s *SmartContract) Name(APIstub shim.ChaincodeStubInterface, args []string)
 
 // Symbol returns the symbol of the token
 func (s *SmartContract) Symbol(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(symbol))
+}
+
+// getSymbol returns the token symbol, which labels every event and JSON query response
+// An unset symbol is an error so that nothing is ever emitted without a token label
+func getSymbol(APIstub shim.ChaincodeStubInterface) (string, error) {
 	symbolBytes, err := APIstub.GetState(symbolKey)
 	if err != nil {
-		return shim.Error("Failed to get token symbol")
+		return "", stateError(APIstub, "GetState", symbolKey, err)
 	}
 	if symbolBytes == nil {
-		return shim.Error("Token symbol not set")
+		return "", fmt.Errorf("Token symbol not set")
 	}
-	return shim.Success(symbolBytes)
+	return string(symbolBytes), nil
 }
 
 // Initialize initializes the token's state (name, symbol, decimals, totalSupply)
+// It can only run once: an initialized token fails with "contract already initialized".
+// An optional fifth argument holds JSON options, e.g. {"termsRequired":true,"documentHash":"...","humanAmounts":true,"recentActivity":true}.
+// Every argument is validated before the first write, so a failed write can only come
+// from the state database; the transaction is then rejected as a whole.
 func (s *SmartContract) Initialize(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 or 5")
 	}
 
-	name := args[0]
-	symbol := args[1]
-	decimals, err := strconv.Atoi(args[2])
+	// A partially initialized token can still be initialized to complete it
+	if checkInitialized(APIstub) == nil {
+		return shim.Error("contract already initialized")
+	}
+
+	name, err := sanitizeText("name", args[0], maxNameLength)
 	if err != nil {
+		return shim.Error(err.Error())
+	}
+	symbol, err := sanitizeText("symbol", args[1], maxSymbolLength)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if name == "" || symbol == "" {
+		return shim.Error("Name and symbol must be non-empty strings")
+	}
+	decimals, err := strconv.Atoi(args[2])
+	if err != nil || decimals < 0 {
 		return shim.Error("Invalid decimals. Expecting a numeric string")
 	}
 	totalSupply, err := strconv.Atoi(args[3])
-	if err != nil {
+	if err != nil || totalSupply < 0 {
 		return shim.Error("Invalid total supply. Expecting a numeric string")
 	}
+	var options initOptions
+	if len(args) == 5 {
+		err = json.Unmarshal([]byte(args[4]), &options)
+		if err != nil {
+			return shim.Error("Invalid options. Expecting a JSON object")
+		}
+	}
+	if options.TermsRequired && options.DocumentHash == "" {
+		return shim.Error("A document hash is required when terms are required")
+	}
+	if options.RecentActivitySize < 0 {
+		return shim.Error("Invalid recent activity size. Expecting a positive number")
+	}
+	if options.RecentActivity && options.RecentActivitySize == 0 {
+		options.RecentActivitySize = defaultRecentActivitySize
+	}
+	if options.IdentityMode == "" {
+		options.IdentityMode = identityModeCreator
+	}
+	if _, ok := identityResolvers[options.IdentityMode]; !ok {
+		return shim.Error("Invalid identity mode. Expecting creator or attribute")
+	}
+	if options.FaucetCap < 0 || (options.FaucetCap > 0 && !options.DevMode) {
+		return shim.Error("Invalid faucet cap. Expecting a positive number with devMode")
+	}
+	if options.MaxSupply < 0 || options.MaxSupplyChangeDelay < 0 {
+		return shim.Error("Invalid supply cap options. Expecting non-negative numbers")
+	}
+	if options.MaxSupply > 0 && totalSupply > options.MaxSupply {
+		return shim.Error("Total supply exceeds the supply cap")
+	}
 
-	err = APIstub.PutState(nameKey, []byte(name))
+	// Resolve the first admin before writing anything
+	adminExists, err := hasAnyMember(APIstub, adminRole)
 	if err != nil {
-		return shim.Error("Failed to set token name")
+		return shim.Error(err.Error())
+	}
+	// The identity mode is not stored yet, so resolve the admin's account with the chosen one
+	clientID, err := identityResolvers[options.IdentityMode].ResolveAccount(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	err = APIstub.PutState(symbolKey, []byte(symbol))
+	metadata := []metadataEntry{
+		{nameKey, name},
+		{symbolKey, symbol},
+		{decimalsKey, strconv.Itoa(decimals)},
+	}
+	if options.TermsRequired {
+		metadata = append(metadata, metadataEntry{termsRequiredKey, "true"})
+	}
+	if options.DocumentHash != "" {
+		metadata = append(metadata, metadataEntry{documentHashKey, options.DocumentHash})
+	}
+	if options.HumanAmounts {
+		metadata = append(metadata, metadataEntry{humanAmountsKey, "true"})
+	}
+	if options.StrictQueries {
+		metadata = append(metadata, metadataEntry{strictQueriesKey, "true"})
+	}
+	if options.MinterOrg != "" {
+		metadata = append(metadata, metadataEntry{minterOrgKey, options.MinterOrg})
+	}
+	if options.RequireAdminOU {
+		metadata = append(metadata, metadataEntry{requireAdminOUKey, "true"})
+	}
+	if options.DevMode {
+		metadata = append(metadata, metadataEntry{devModeKey, "true"})
+		if options.FaucetCap > 0 {
+			metadata = append(metadata, metadataEntry{faucetCapKey, strconv.Itoa(options.FaucetCap)})
+		}
+	}
+	if options.PrivateBalances {
+		metadata = append(metadata, metadataEntry{privateBalancesKey, "true"})
+	}
+	if options.ExperimentalEnabled {
+		metadata = append(metadata, metadataEntry{experimentalEnabledKey, "true"})
+	}
+	if options.StrictMode {
+		metadata = append(metadata, metadataEntry{strictModeKey, "true"})
+	}
+	if options.RecentActivity {
+		metadata = append(metadata, metadataEntry{recentActivitySizeKey, strconv.Itoa(options.RecentActivitySize)})
+	}
+	if options.MaxSupply > 0 {
+		metadata = append(metadata, metadataEntry{maxSupplyKey, strconv.Itoa(options.MaxSupply)})
+	}
+	metadata = append(metadata, metadataEntry{maxSupplyDelayKey, strconv.FormatInt(options.MaxSupplyChangeDelay, 10)})
+	metadata = append(metadata, metadataEntry{identityModeKey, options.IdentityMode})
+	metadata = append(metadata, metadataEntry{initializedKey, "true"})
+	for _, m := range metadata {
+		err = APIstub.PutState(m.key, []byte(m.value))
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to initialize token: %s, no changes were committed", stateError(APIstub, "PutState", m.key, err)))
+		}
+	}
+	currentSupply, err := getTotalSupply(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = adjustSupply(APIstub, totalSupply-currentSupply, supplyReasonInitialize)
 	if err != nil {
-		return shim.Error("Failed to set token symbol")
+		return shim.Error(fmt.Sprintf("Failed to initialize token: %s, no changes were committed", err))
+	}
+
+	// Bootstrap the initializing client as the first admin
+	if !adminExists {
+		err = putRoleGrant(APIstub, roleGrant{Role: adminRole, Account: clientID})
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to initialize token: %s, no changes were committed", err))
+		}
 	}
 
-	err = APIstub.PutState(decimalsKey, []byte(strconv.Itoa(decimals)))
+	return shim.Success(nil)
+}
+
+// CheckInitialized succeeds if the token is fully initialized, otherwise it reports
+// which metadata keys are missing
+func (s *SmartContract) CheckInitialized(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	err := checkInitialized(APIstub)
 	if err != nil {
-		return shim.Error("Failed to set token decimals")
+		return shim.Error(err.Error())
 	}
+	return shim.Success(nil)
+}
 
-	err = APIstub.PutState(totalSupplyKey, []byte(strconv.Itoa(totalSupply)))
+// checkInitialized returns an error unless the token is initialized.
+// Tokens initialized before the initialized flag existed count as initialized when every metadata
+// key written by Initialize exists; a partially initialized one is reported with the exact list of
+// missing keys.
+func checkInitialized(APIstub shim.ChaincodeStubInterface) error {
+	initializedBytes, err := APIstub.GetState(initializedKey)
 	if err != nil {
-		return shim.Error("Failed to set token total supply")
+		return stateError(APIstub, "GetState", initializedKey, err)
+	}
+	if string(initializedBytes) == "true" {
+		return nil
 	}
 
-	return shim.Success(nil)
+	metadataKeys := []string{nameKey, symbolKey, decimalsKey, totalSupplyKey}
+
+	var missing []string
+	for _, key := range metadataKeys {
+		value, err := APIstub.GetState(key)
+		if err != nil {
+			return stateError(APIstub, "GetState", key, err)
+		}
+		if value == nil {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) == len(metadataKeys) {
+		return newCodedError(APIstub, errNotInitialized, nil, "contract not initialized")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Token metadata incomplete, missing keys: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
 
 func main() {
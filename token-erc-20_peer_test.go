@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestBalanceOfUnknownAccount(t *testing.T) {
+	ledger := newToken(t, "")
+
+	result := ledger.Invoke(admin, "BalanceOf", carol.Account)
+	if result.Status != shim.OK || string(result.Payload) != "0" {
+		t.Fatalf("BalanceOf of an account that never held tokens returned %q %q, expected 0", result.Payload, result.Message)
+	}
+	if len(result.Writes) != 0 {
+		t.Fatalf("BalanceOf of an unknown account made %d writes, expected none", len(result.Writes))
+	}
+}
+
+func TestBalanceOfEmptiedAccount(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 10)
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+
+	// Both read as 0, but only the emptied account exists
+	if got := balanceOf(t, ledger, alice.Account); got != 0 {
+		t.Fatalf("balance of an emptied account is %d, expected 0", got)
+	}
+	if got := balanceOf(t, ledger, carol.Account); got != 0 {
+		t.Fatalf("balance of an unknown account is %d, expected 0", got)
+	}
+	if got := string(ledger.State(storedBalanceKey(alice.Account))); got != "0" {
+		t.Fatalf("emptied account is stored as %q, expected a zero balance", got)
+	}
+	if stored := ledger.State(storedBalanceKey(carol.Account)); stored != nil {
+		t.Fatalf("unknown account is stored as %q after BalanceOf, expected nothing", stored)
+	}
+
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "1")
+	if !strings.HasPrefix(message, errInsufficientBalance) {
+		t.Fatalf("transfer from an emptied account failed with %q, expected %s", message, errInsufficientBalance)
+	}
+	message = mustFail(t, ledger, carol, "Transfer", bob.Account, "1")
+	if !strings.HasPrefix(message, errInvalidAccount) {
+		t.Fatalf("transfer from an unknown account failed with %q, expected %s", message, errInvalidAccount)
+	}
+}
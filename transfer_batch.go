@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// maxBatchSize bounds the number of recipients of a single TransferBatch
+const maxBatchSize = 500
+
+// batchEntry is one recipient of a TransferBatch as sent by the client
+type batchEntry struct {
+	To     string      `json:"to"`
+	Amount json.Number `json:"amount"`
+	Memo   string      `json:"memo,omitempty"`
+}
+
+// batchTransfer is one credit of a TransferBatch
+// Value is what the recipient was credited; Spillover is what a capped recipient could not accept.
+type batchTransfer struct {
+	To        string `json:"to"`
+	Value     int    `json:"value"`
+	Memo      string `json:"memo,omitempty"`
+	Spillover int    `json:"spillover,omitempty"`
+}
+
+// transferBatchEvent describes a TransferBatch
+// Fabric only keeps one event per transaction, so it lists every credit; Value is their total.
+type transferBatchEvent struct {
+	Token     string          `json:"token"`
+	From      string          `json:"from"`
+	Value     int             `json:"value"`
+	Transfers []batchTransfer `json:"transfers"`
+}
+
+// TransferBatch sends tokens from the caller's account to every recipient of `entriesJSON`
+// `entriesJSON` is a JSON array of {"to": account, "amount": amount, "memo": optional memo} with at
+// most one entry per recipient. Every entry is validated and the total checked against the
+// caller's balance before anything is written, so either every recipient is credited or none is.
+// The recipient checks of Transfer apply to each entry; transfers that need travel rule data must
+// be made with Transfer.
+// This function triggers a TransferBatch event
+func (s *SmartContract) TransferBatch(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	// encoding/json would replace invalid UTF-8 in memos instead of letting sanitizeText reject it
+	if !utf8.ValidString(args[0]) {
+		return shim.Error("ERR_INVALID_TEXT: batch is not valid UTF-8")
+	}
+	var entries []batchEntry
+	err := json.Unmarshal([]byte(args[0]), &entries)
+	if err != nil || len(entries) == 0 {
+		return shim.Error("Invalid batch. Expecting a non-empty JSON array of {\"to\", \"amount\"} entries")
+	}
+	if len(entries) > maxBatchSize {
+		return shim.Error(fmt.Sprintf("Too many entries. Expecting at most %d", maxBatchSize))
+	}
+
+	from, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// Validate every entry and plan the credits before any write
+	plan := newSettlementPlan()
+	transfers := make([]batchTransfer, 0, len(entries))
+	recipients := make(map[string]bool, len(entries))
+	total := 0
+	for i, entry := range entries {
+		transfer, err := planBatchTransfer(APIstub, plan, from, entry)
+		if err != nil {
+			// The entry goes last so the message still starts with the error code
+			return shim.Error(fmt.Sprintf("%s (entry %d)", err, i))
+		}
+		if recipients[transfer.To] {
+			return shim.Error(fmt.Sprintf("Recipient %s is listed twice (entry %d)", transfer.To, i))
+		}
+		if total > math.MaxInt64-transfer.Value {
+			return shim.Error(invalidAmount(APIstub, strconv.Itoa(transfer.Value), "the batch total is out of range").Error())
+		}
+		recipients[transfer.To] = true
+		total += transfer.Value
+		transfers = append(transfers, transfer)
+	}
+
+	err = spendCategory(APIstub, from, "", total)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fromBalance, exists, err := plan.balance(APIstub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !exists {
+		return shim.Error(newCodedError(APIstub, errInvalidAccount, map[string]string{"account": from}, "sender account %s not found", from).Error())
+	}
+	if fromBalance < total {
+		return shim.Error(insufficientBalance(APIstub, fromBalance, total).Error())
+	}
+
+	for _, transfer := range transfers {
+		err = plan.transfer(APIstub, from, transfer.To, transfer.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = plan.apply(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	eventBytes, err := encodeJSON(transferBatchEvent{Token: symbol, From: from, Value: total, Transfers: transfers})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.SetEvent("TransferBatch", eventBytes)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// planBatchTransfer validates one entry of a TransferBatch from `from` and returns its credit
+// The recipient's balance is read through `plan`. Nothing is written.
+func planBatchTransfer(APIstub shim.ChaincodeStubInterface, plan *settlementPlan, from string, entry batchEntry) (batchTransfer, error) {
+	var transfer batchTransfer
+	if entry.To == "" {
+		return transfer, fmt.Errorf("Recipient must be a non-empty string")
+	}
+	// GetState does not see this transaction's own writes, so the sender cannot also be credited
+	if entry.To == from {
+		return transfer, fmt.Errorf("Recipient cannot be the sender")
+	}
+	amount, err := parsePositiveAmount(APIstub, entry.Amount.String())
+	if err != nil {
+		return transfer, err
+	}
+	memo, err := sanitizeText("memo", entry.Memo, maxMemoLength)
+	if err != nil {
+		return transfer, err
+	}
+
+	err = checkMemo(APIstub, entry.To, memo)
+	if err != nil {
+		return transfer, err
+	}
+	travelRule, err := prepareTravelRule(APIstub, from, entry.To, amount)
+	if err != nil {
+		return transfer, err
+	}
+	if travelRule != nil {
+		return transfer, fmt.Errorf("Travel rule data is not supported in a batch. Use Transfer")
+	}
+	err = checkIncomingAllowed(APIstub, from, entry.To)
+	if err != nil {
+		return transfer, err
+	}
+	err = checkTermsAccepted(APIstub, entry.To)
+	if err != nil {
+		return transfer, err
+	}
+
+	toBalance, _, err := plan.balance(APIstub, entry.To)
+	if err != nil {
+		return transfer, err
+	}
+	credited, err := applyBalanceCap(APIstub, entry.To, toBalance, amount)
+	if err != nil {
+		return transfer, err
+	}
+
+	transfer = batchTransfer{To: entry.To, Value: credited, Memo: memo, Spillover: amount - credited}
+	return transfer, nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for the travel rule
+const travelRuleThresholdKey = "travelRuleThreshold"
+
+// Define objectType names for travel rule records
+const travelRulePrefix = "travelRule"
+
+// travelRuleTransientKey is the transient field Transfer reads the originator and beneficiary data from
+const travelRuleTransientKey = "travelRule"
+
+// travelRuleCollectionPrefix names the private data collection shared by a pair of organizations
+// Every pair of organizations that transfer above the threshold needs a collection named
+// travelRule_<MSP>_<MSP>, with the two MSP IDs sorted (travelRule_<MSP> within one organization),
+// whose member policy names both organizations and the regulator, e.g.
+//
+//	[{
+//	  "name": "travelRule_Org1MSP_Org2MSP",
+//	  "policy": "OR('Org1MSP.member', 'Org2MSP.member', 'RegulatorMSP.member')",
+//	  "requiredPeerCount": 1,
+//	  "maxPeerCount": 3,
+//	  "blockToLive": 0,
+//	  "memberOnlyRead": true
+//	}]
+const travelRuleCollectionPrefix = "travelRule"
+
+// travelRuleData is the originator and beneficiary information of a transfer
+// Only the two named fields are required; the rest of the document is kept as sent.
+type travelRuleData struct {
+	Originator  json.RawMessage `json:"originator"`
+	Beneficiary json.RawMessage `json:"beneficiary"`
+}
+
+// travelRuleRecord is the public record of a transfer that carried travel rule data
+// The data itself is only in the private collection; Hash is the hex sha256 of its exact bytes.
+type travelRuleRecord struct {
+	TxID       string `json:"txId"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Value      int    `json:"value"`
+	FromOrg    string `json:"fromOrg"`
+	ToOrg      string `json:"toOrg"`
+	Collection string `json:"collection"`
+	Hash       string `json:"hash"`
+}
+
+// travelRuleResponse is the JSON document returned by GetTravelRuleData
+type travelRuleResponse struct {
+	travelRuleRecord
+	Data json.RawMessage `json:"data"`
+}
+
+// pendingTravelRule is travel rule data validated before a transfer and written after it
+type pendingTravelRule struct {
+	record  travelRuleRecord
+	payload []byte
+}
+
+// SetTravelRuleThreshold makes travel rule data mandatory on transfers above `threshold`
+// Only the compliance role can set the threshold; 0 makes the data optional on every transfer.
+func (s *SmartContract) SetTravelRuleThreshold(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	threshold, err := parseNonNegativeAmount(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = requireRole(APIstub, complianceRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(travelRuleThresholdKey, []byte(strconv.Itoa(threshold)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", travelRuleThresholdKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetTravelRuleData returns the travel rule data of the transfer made in transaction `txID`
+// Only clients of the two organizations involved and the compliance role can read it, and only on
+// peers that belong to the collection. The data is checked against the hash on the public ledger.
+func (s *SmartContract) GetTravelRuleData(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	txID := args[0]
+
+	record, err := getTravelRuleRecord(APIstub, txID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if record == nil {
+		return shim.Error(fmt.Sprintf("No travel rule data for transaction %s", txID))
+	}
+
+	callerMSP, err := getClientMSP(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if callerMSP != record.FromOrg && callerMSP != record.ToOrg {
+		err = requireRole(APIstub, complianceRole)
+		if err != nil {
+			return shim.Error("Caller is not in an organization of this transfer and does not have the compliance role")
+		}
+	}
+
+	dataKey, err := buildKey(APIstub, travelRulePrefix, []string{txID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	payload, err := APIstub.GetPrivateData(record.Collection, dataKey)
+	if err != nil {
+		return shim.Error(collectionError(APIstub, record.Collection, "GetPrivateData", err).Error())
+	}
+	if payload == nil {
+		return shim.Error(fmt.Sprintf("Travel rule data for transaction %s is not available on this peer", txID))
+	}
+	if hashTravelRule(payload) != record.Hash {
+		return shim.Error(fmt.Sprintf("Travel rule data for transaction %s does not match its recorded hash", txID))
+	}
+
+	responseBytes, err := json.Marshal(travelRuleResponse{travelRuleRecord: *record, Data: payload})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// prepareTravelRule validates the travel rule data of a transfer before anything is written
+// It returns nil if the transfer carries no data and is not above the threshold.
+// Data on a transfer above the threshold is mandatory, ERR_TRAVEL_RULE_REQUIRED otherwise.
+func prepareTravelRule(APIstub shim.ChaincodeStubInterface, from string, to string, amount int) (*pendingTravelRule, error) {
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get transient data")
+	}
+	payload := transient[travelRuleTransientKey]
+
+	threshold, err := getIntSetting(APIstub, travelRuleThresholdKey)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		if threshold > 0 && amount > threshold {
+			return nil, fmt.Errorf("ERR_TRAVEL_RULE_REQUIRED: transfers above %d must carry travel rule data in the transient field %s", threshold, travelRuleTransientKey)
+		}
+		return nil, nil
+	}
+
+	var data travelRuleData
+	err = json.Unmarshal(payload, &data)
+	if err != nil || isEmptyJSON(data.Originator) || isEmptyJSON(data.Beneficiary) {
+		return nil, fmt.Errorf("Invalid travel rule data. Expecting a JSON object with originator and beneficiary")
+	}
+
+	fromOrg, err := getClientMSP(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	// The recipient's organization is only known on the ledger through its MSP binding
+	toOrg, err := getMSPBinding(APIstub, to)
+	if err != nil {
+		return nil, err
+	}
+	if toOrg == "" {
+		return nil, fmt.Errorf("ERR_TRAVEL_RULE_REQUIRED: recipient %s must be bound to an MSP to receive travel rule data", to)
+	}
+
+	record := travelRuleRecord{
+		TxID:       APIstub.GetTxID(),
+		From:       from,
+		To:         to,
+		Value:      amount,
+		FromOrg:    fromOrg,
+		ToOrg:      toOrg,
+		Collection: travelRuleCollection(fromOrg, toOrg),
+		Hash:       hashTravelRule(payload),
+	}
+	return &pendingTravelRule{record: record, payload: payload}, nil
+}
+
+// putTravelRule writes the data to the collection of the two organizations and its record to the public ledger
+func putTravelRule(APIstub shim.ChaincodeStubInterface, pending pendingTravelRule) error {
+	recordKey, err := buildKey(APIstub, travelRulePrefix, []string{pending.record.TxID})
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutPrivateData(pending.record.Collection, recordKey, pending.payload)
+	if err != nil {
+		return collectionError(APIstub, pending.record.Collection, "PutPrivateData", err)
+	}
+
+	recordBytes, err := json.Marshal(pending.record)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(recordKey, recordBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", travelRulePrefix, err)
+	}
+	return nil
+}
+
+// getTravelRuleRecord returns the public travel rule record of transaction `txID`, or nil if there is none
+func getTravelRuleRecord(APIstub shim.ChaincodeStubInterface, txID string) (*travelRuleRecord, error) {
+	recordKey, err := buildKey(APIstub, travelRulePrefix, []string{txID})
+	if err != nil {
+		return nil, err
+	}
+	recordBytes, err := APIstub.GetState(recordKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", travelRulePrefix, err)
+	}
+	if recordBytes == nil {
+		return nil, nil
+	}
+	var record travelRuleRecord
+	err = json.Unmarshal(recordBytes, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// isEmptyJSON reports whether a field of the travel rule data is missing or null
+func isEmptyJSON(value json.RawMessage) bool {
+	return len(value) == 0 || string(value) == "null"
+}
+
+// travelRuleCollection returns the name of the collection shared by two organizations
+func travelRuleCollection(orgA string, orgB string) string {
+	if orgA == orgB {
+		return travelRuleCollectionPrefix + "_" + orgA
+	}
+	orgs := []string{orgA, orgB}
+	sort.Strings(orgs)
+	return travelRuleCollectionPrefix + "_" + orgs[0] + "_" + orgs[1]
+}
+
+// hashTravelRule returns the hex sha256 of the exact travel rule bytes sent by the client
+func hashTravelRule(payload []byte) string {
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
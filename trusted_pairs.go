@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for trusted pairs
+const trustedPairPrefix = "trustedPair"
+
+// Define the Transfer hooks a trusted pair can skip
+const hookMemo = "memo"
+const hookBudget = "budget"
+const hookTravelRule = "travelRule"
+const hookAllowlist = "allowlist"
+const hookTerms = "terms"
+const hookBalanceCap = "balanceCap"
+
+// skippableHooks lists the hooks a trusted pair can skip
+// The balance check, MSP binding and sequencing authenticate or protect the sender and are never skipped.
+var skippableHooks = map[string]bool{
+	hookMemo:       true,
+	hookBudget:     true,
+	hookTravelRule: true,
+	hookAllowlist:  true,
+	hookTerms:      true,
+	hookBalanceCap: true,
+}
+
+// hookSet is the set of hooks skipped by a transfer; the nil set skips nothing
+type hookSet map[string]bool
+
+// trustedPair is a pair of accounts whose transfers to each other skip some hooks
+// Accounts are sorted, so the pair applies in both directions.
+type trustedPair struct {
+	Accounts     []string `json:"accounts"`
+	SkippedHooks []string `json:"skippedHooks"`
+	UpdatedBy    string   `json:"updatedBy"`
+	UpdatedAt    int64    `json:"updatedAt"`
+	TxID         string   `json:"txId"`
+}
+
+// trustedPairEvent provides an organized struct for emitting trusted pair events
+type trustedPairEvent struct {
+	Token string `json:"token"`
+	trustedPair
+}
+
+// SetTrustedPair lets Transfer between `accountA` and `accountB` skip the hooks of `skippedHooksJSON`
+// `skippedHooksJSON` is a JSON array of hook names: memo, budget, travelRule, allowlist, terms and
+// balanceCap. Setting a pair again replaces its hooks. Only admins can set trusted pairs.
+// This function triggers a TrustedPairSet event
+func (s *SmartContract) SetTrustedPair(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	accounts, err := sortPair(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var skipped []string
+	err = json.Unmarshal([]byte(args[2]), &skipped)
+	if err != nil || len(skipped) == 0 {
+		return shim.Error("Invalid hooks. Expecting a non-empty JSON array of hook names")
+	}
+	skipped, err = checkSkippedHooks(skipped)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pair := trustedPair{
+		Accounts:     accounts,
+		SkippedHooks: skipped,
+		UpdatedBy:    clientID,
+		UpdatedAt:    now,
+		TxID:         APIstub.GetTxID(),
+	}
+	pairKey, err := buildKey(APIstub, trustedPairPrefix, accounts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pairBytes, err := json.Marshal(pair)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(pairKey, pairBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", trustedPairPrefix, err).Error())
+	}
+
+	err = emitTrustedPairEvent(APIstub, "TrustedPairSet", pair)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// RemoveTrustedPair makes transfers between `accountA` and `accountB` run every hook again
+// Only admins can remove trusted pairs.
+// This function triggers a TrustedPairRemoved event
+func (s *SmartContract) RemoveTrustedPair(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	accounts, err := sortPair(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pair, err := getTrustedPair(APIstub, accounts[0], accounts[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pair == nil {
+		return shim.Error("Trusted pair not found")
+	}
+
+	pairKey, err := buildKey(APIstub, trustedPairPrefix, accounts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.DelState(pairKey)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "DelState", trustedPairPrefix, err).Error())
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pair.UpdatedBy = clientID
+	pair.UpdatedAt = now
+	pair.TxID = APIstub.GetTxID()
+	err = emitTrustedPairEvent(APIstub, "TrustedPairRemoved", *pair)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// GetTrustedPair returns the trusted pair of `accountA` and `accountB` with the hooks it skips
+func (s *SmartContract) GetTrustedPair(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	pair, err := getTrustedPair(APIstub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pair == nil {
+		return shim.Error("Trusted pair not found")
+	}
+
+	pairBytes, err := json.Marshal(pair)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pairBytes)
+}
+
+// getSkippedHooks returns the hooks skipped by a transfer from `from` to `to`
+// Accounts that are not a trusted pair skip nothing.
+func getSkippedHooks(APIstub shim.ChaincodeStubInterface, from string, to string) (hookSet, error) {
+	if from == to {
+		return nil, nil
+	}
+	pair, err := getTrustedPair(APIstub, from, to)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	skipped := make(hookSet, len(pair.SkippedHooks))
+	for _, hook := range pair.SkippedHooks {
+		// Hooks are checked again in case the list of skippable hooks shrank since the pair was set
+		if skippableHooks[hook] {
+			skipped[hook] = true
+		}
+	}
+	return skipped, nil
+}
+
+// getTrustedPair returns the stored trusted pair of two accounts in either order, or nil if there is none
+func getTrustedPair(APIstub shim.ChaincodeStubInterface, accountA string, accountB string) (*trustedPair, error) {
+	accounts, err := sortPair(accountA, accountB)
+	if err != nil {
+		return nil, err
+	}
+	pairKey, err := buildKey(APIstub, trustedPairPrefix, accounts)
+	if err != nil {
+		return nil, err
+	}
+	pairBytes, err := APIstub.GetState(pairKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", trustedPairPrefix, err)
+	}
+	if pairBytes == nil {
+		return nil, nil
+	}
+
+	var pair trustedPair
+	err = json.Unmarshal(pairBytes, &pair)
+	if err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+// sortPair returns two distinct, non-empty accounts in sorted order
+func sortPair(accountA string, accountB string) ([]string, error) {
+	if accountA == "" || accountB == "" {
+		return nil, fmt.Errorf("Accounts must be non-empty strings")
+	}
+	if accountA == accountB {
+		return nil, fmt.Errorf("A trusted pair needs two different accounts")
+	}
+	accounts := []string{accountA, accountB}
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// checkSkippedHooks returns the sorted, deduplicated hooks of `skipped` or an error for a hook that cannot be skipped
+func checkSkippedHooks(skipped []string) ([]string, error) {
+	unique := make(map[string]bool, len(skipped))
+	for _, hook := range skipped {
+		if !skippableHooks[hook] {
+			return nil, fmt.Errorf("Hook %s cannot be skipped", hook)
+		}
+		unique[hook] = true
+	}
+	hooks := make([]string, 0, len(unique))
+	for hook := range unique {
+		hooks = append(hooks, hook)
+	}
+	sort.Strings(hooks)
+	return hooks, nil
+}
+
+// emitTrustedPairEvent emits `name` with the state of the pair, labelled with the token symbol
+func emitTrustedPairEvent(APIstub shim.ChaincodeStubInterface, name string, pair trustedPair) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(trustedPairEvent{Token: symbol, trustedPair: pair})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// allHooks is the skipped-hook list of a pair that skips every skippable hook
+const allHooks = `["memo","budget","travelRule","allowlist","terms","balanceCap"]`
+
+func TestTrustedPairNeverSkipsBalanceCheck(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 5)
+	mustInvoke(t, ledger, admin, "SetTrustedPair", alice.Account, bob.Account, allHooks)
+
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "10")
+	if !strings.HasPrefix(message, errInsufficientBalance) {
+		t.Fatalf("overdrawing transfer within a trusted pair failed with %q, expected %s", message, errInsufficientBalance)
+	}
+	if got := balanceOf(t, ledger, bob.Account); got != 0 {
+		t.Fatalf("recipient balance is %d after an overdrawing transfer, expected 0", got)
+	}
+}
+
+func TestTrustedPairNeverSkipsPause(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, admin, "SetTrustedPair", alice.Account, bob.Account, allHooks)
+	mustInvoke(t, ledger, admin, "Pause")
+
+	message := mustFail(t, ledger, alice, "Transfer", bob.Account, "10")
+	if !strings.HasPrefix(message, errContractPaused) {
+		t.Fatalf("transfer within a trusted pair of a paused contract failed with %q, expected %s", message, errContractPaused)
+	}
+}
+
+func TestTrustedPairSkipsRecordedHooks(t *testing.T) {
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	fund(t, ledger, carol.Account, 100)
+	mustInvoke(t, ledger, bob, "SetIncomingAllowlist", "true")
+	mustFail(t, ledger, alice, "Transfer", bob.Account, "10")
+
+	mustInvoke(t, ledger, admin, "SetTrustedPair", alice.Account, bob.Account, `["allowlist"]`)
+	mustInvoke(t, ledger, alice, "Transfer", bob.Account, "10")
+	// Only the pair skips the hook
+	message := mustFail(t, ledger, carol, "Transfer", bob.Account, "10")
+	if !strings.HasPrefix(message, "ERR_SENDER_NOT_ALLOWED") {
+		t.Fatalf("transfer from outside the pair failed with %q, expected ERR_SENDER_NOT_ALLOWED", message)
+	}
+}
+
+// benchmarkPairTransfers transfers 1 token from alice to bob b.N times; bob has an allowlist
+// that lists alice and requires no memo, so the hooked path reads the allowlist on every transfer
+func benchmarkPairTransfers(b *testing.B, setup func(*chaintest.Ledger)) {
+	ledger := newToken(b, "")
+	fund(b, ledger, alice.Account, b.N)
+	mustInvoke(b, ledger, bob, "SetIncomingAllowlist", "true")
+	mustInvoke(b, ledger, bob, "AddAllowedSender", alice.Account)
+	setup(ledger)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := ledger.Invoke(alice, "Transfer", bob.Account, "1")
+		if result.Status != shim.OK {
+			b.Fatal(result.Message)
+		}
+	}
+}
+
+func BenchmarkTransferHooked(b *testing.B) {
+	benchmarkPairTransfers(b, func(*chaintest.Ledger) {})
+}
+
+func BenchmarkTransferTrustedPair(b *testing.B) {
+	benchmarkPairTransfers(b, func(ledger *chaintest.Ledger) {
+		mustInvoke(b, ledger, admin, "SetTrustedPair", alice.Account, bob.Account, allHooks)
+	})
+}
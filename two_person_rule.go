@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define objectType names for the two-person rule
+const twoPersonRulePrefix = "twoPersonRule"
+const pendingOutgoingPrefix = "pendingOutgoing"
+const pendingOutgoingByAccountPrefix = "pendingOutgoingByAccount"
+
+// Define pending outgoing transfer statuses
+const outgoingPending = "pending"
+const outgoingExecuted = "executed"
+const outgoingCancelled = "cancelled"
+const outgoingExpired = "expired"
+
+// pendingOutgoingTTL is how long a pending outgoing transfer can collect approvals, in seconds
+const pendingOutgoingTTL = 7 * 24 * 60 * 60
+
+// twoPersonGatedFunctions move or authorize moving the caller's tokens other than through
+// Transfer; they fail for an account under a two-person rule, whose tokens only leave through
+// an approved Transfer
+var twoPersonGatedFunctions = map[string]bool{
+	"Burn":                    true,
+	"Hold":                    true,
+	"Approve":                 true,
+	"ApproveBulkByOwner":      true,
+	"ConfirmAllowanceRequest": true,
+	"PayRequest":              true,
+	"ProposeSwap":             true,
+	"AcceptSwap":              true,
+	"ScheduleTransfer":        true,
+	"TransferBatch":           true,
+	"TransferFromSubaccounts": true,
+	"TransferAndCall":         true,
+	"ApproveAndCall":          true,
+}
+
+// twoPersonRule requires `Threshold` of `Approvers` to approve every Transfer from `Account`
+type twoPersonRule struct {
+	Account   string   `json:"account"`
+	Approvers []string `json:"approvers"`
+	Threshold int      `json:"threshold"`
+	UpdatedBy string   `json:"updatedBy"`
+	UpdatedAt int64    `json:"updatedAt"`
+	TxID      string   `json:"txId"`
+}
+
+// twoPersonRuleEvent provides an organized struct for emitting two-person rule events
+type twoPersonRuleEvent struct {
+	Token string `json:"token"`
+	twoPersonRule
+}
+
+// pendingOutgoing is a Transfer from an account under a two-person rule awaiting approval
+// The amount is held in escrow from the Transfer until the transfer is executed, cancelled or
+// refunded after expiry. The approvers and threshold are those of the rule at the time of the Transfer.
+type pendingOutgoing struct {
+	ID             string   `json:"id"`
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	Amount         int      `json:"amount"`
+	Category       string   `json:"category,omitempty"`
+	Memo           string   `json:"memo,omitempty"`
+	TravelRuleHash string   `json:"travelRuleHash,omitempty"`
+	Approvers      []string `json:"approvers"`
+	Threshold      int      `json:"threshold"`
+	Approvals      []string `json:"approvals"`
+	ExpiresAt      int64    `json:"expiresAt"`
+	Status         string   `json:"status"`
+}
+
+// pendingOutgoingEvent provides an organized struct for emitting pending outgoing transfer events
+type pendingOutgoingEvent struct {
+	Token string `json:"token"`
+	pendingOutgoing
+}
+
+// pendingOutgoingResponse is the JSON document returned by ListPendingOutgoing
+type pendingOutgoingResponse struct {
+	Token     string            `json:"token"`
+	Account   string            `json:"account"`
+	Transfers []pendingOutgoing `json:"transfers"`
+}
+
+// SetTwoPersonRule requires `threshold` of the accounts in the JSON array `approverAccountsJSON`
+// to approve every Transfer from `account`; "[]" and "0" remove the rule
+// The owner can set a rule on its account while it has none; after that only admins can change
+// or remove it, so a stolen key cannot lift the rule. Admins can set the rule of any account,
+// such as a treasury account. The owner cannot be one of its approvers.
+// This function triggers a TwoPersonRuleSet or TwoPersonRuleRemoved event
+func (s *SmartContract) SetTwoPersonRule(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	account := args[0]
+	if account == "" {
+		return shim.Error("Account must be a non-empty string")
+	}
+	var approvers []string
+	err := json.Unmarshal([]byte(args[1]), &approvers)
+	if err != nil {
+		return shim.Error("Invalid approvers. Expecting a JSON array of accounts")
+	}
+	approvers, err = checkApprovers(account, approvers)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	threshold, err := strconv.Atoi(args[2])
+	if err != nil || threshold < 0 || threshold > len(approvers) || (threshold == 0) != (len(approvers) == 0) {
+		return shim.Error(fmt.Sprintf("Invalid threshold. Expecting a number between 1 and %d, or 0 without approvers", len(approvers)))
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getTwoPersonRule(APIstub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if clientID != account || existing != nil {
+		err = requireRole(APIstub, adminRole)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	ruleKey, err := buildKey(APIstub, twoPersonRulePrefix, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if threshold == 0 {
+		if existing == nil {
+			return shim.Error("Two-person rule not found")
+		}
+		err = APIstub.DelState(ruleKey)
+		if err != nil {
+			return shim.Error(stateError(APIstub, "DelState", twoPersonRulePrefix, err).Error())
+		}
+		err = emitTwoPersonRuleEvent(APIstub, "TwoPersonRuleRemoved", *existing)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		return shim.Success(nil)
+	}
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rule := twoPersonRule{
+		Account:   account,
+		Approvers: approvers,
+		Threshold: threshold,
+		UpdatedBy: clientID,
+		UpdatedAt: now,
+		TxID:      APIstub.GetTxID(),
+	}
+	ruleBytes, err := json.Marshal(rule)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = APIstub.PutState(ruleKey, ruleBytes)
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", twoPersonRulePrefix, err).Error())
+	}
+
+	err = emitTwoPersonRuleEvent(APIstub, "TwoPersonRuleSet", rule)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ApproveOutgoing approves the pending outgoing transfer `pendingID`; only its approvers can approve
+// The approval that reaches the threshold executes the transfer, which then fails if the
+// recipient cannot receive it yet; the transfer stays pending in that case.
+// This function triggers an OutgoingTransferApproved event, or a Transfer event on execution
+func (s *SmartContract) ApproveOutgoing(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	pending, err := getOpenOutgoing(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pending.effectiveStatus(now) != outgoingPending {
+		return shim.Error("Pending transfer has expired")
+	}
+
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkMSPBinding(APIstub, clientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsString(pending.Approvers, clientID) {
+		return shim.Error("Only an approver of the sender can approve a pending transfer")
+	}
+	if containsString(pending.Approvals, clientID) {
+		return shim.Error("Pending transfer already approved by the caller")
+	}
+	pending.Approvals = append(pending.Approvals, clientID)
+
+	if len(pending.Approvals) < pending.Threshold {
+		err = putPendingOutgoing(APIstub, *pending)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = emitPendingOutgoingEvent(APIstub, "OutgoingTransferApproved", *pending)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		return shim.Success(nil)
+	}
+
+	// The escrow was debited by the Transfer; only the recipient's side is left
+	err = checkIncomingAllowed(APIstub, pending.From, pending.To)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkTermsAccepted(APIstub, pending.To)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = creditBalance(APIstub, pending.To, pending.Amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = recordMovements(APIstub, movement{From: pending.From, To: pending.To, Value: pending.Amount})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	pending.Status = outgoingExecuted
+	err = closePendingOutgoing(APIstub, *pending)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	err = emitTransfer(APIstub, event{From: pending.From, To: pending.To, Value: pending.Amount, Category: pending.Category, Memo: pending.Memo, TravelRuleHash: pending.TravelRuleHash})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// CancelOutgoing cancels the pending outgoing transfer `pendingID` and refunds the escrow to the sender
+// The sender can cancel at any time before execution; once the transfer has expired anyone can
+// refund it.
+// This function triggers an OutgoingTransferCancelled or OutgoingTransferExpired event
+func (s *SmartContract) CancelOutgoing(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	pending, err := getOpenOutgoing(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	status := pending.effectiveStatus(now)
+	if status == outgoingPending {
+		clientID, err := getClientID(APIstub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if clientID != pending.From {
+			return shim.Error("Only the sender can cancel a pending transfer before it expires")
+		}
+		status = outgoingCancelled
+	}
+
+	err = creditBalance(APIstub, pending.From, pending.Amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pending.Status = status
+	err = closePendingOutgoing(APIstub, *pending)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	eventName := "OutgoingTransferCancelled"
+	if status == outgoingExpired {
+		eventName = "OutgoingTransferExpired"
+	}
+	err = emitPendingOutgoingEvent(APIstub, eventName, *pending)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ListPendingOutgoing returns the open outgoing transfers of `account`, oldest first
+// Transfers past their expiry are reported with the expired status until they are refunded.
+func (s *SmartContract) ListPendingOutgoing(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account := args[0]
+
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	transfers := []pendingOutgoing{}
+	_, err = iterate(APIstub, pendingOutgoingByAccountPrefix, []string{account}, 0, "", func(attributes []string, value []byte) error {
+		pending, err := getPendingOutgoing(APIstub, attributes[2])
+		if err != nil {
+			return err
+		}
+		if pending == nil {
+			return nil
+		}
+		pending.Status = pending.effectiveStatus(now)
+		transfers = append(transfers, *pending)
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	responseBytes, err := json.Marshal(pendingOutgoingResponse{Token: symbol, Account: account, Transfers: transfers})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseBytes)
+}
+
+// holdOutgoing escrows a Transfer of `amount` from `from` to `to` until the approvers of `rule` approve it
+// The ID of the pending transfer is returned as payload.
+// This function triggers an OutgoingTransferPending event
+func holdOutgoing(APIstub shim.ChaincodeStubInterface, rule twoPersonRule, to string, amount int, category string, memo string, travelRule *pendingTravelRule) peer.Response {
+	now, err := getTxTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	id, err := newDeterministicID(APIstub, pendingOutgoingPrefix)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pending := pendingOutgoing{
+		ID:        id,
+		From:      rule.Account,
+		To:        to,
+		Amount:    amount,
+		Category:  category,
+		Memo:      memo,
+		Approvers: rule.Approvers,
+		Threshold: rule.Threshold,
+		Approvals: []string{},
+		ExpiresAt: now + pendingOutgoingTTL,
+		Status:    outgoingPending,
+	}
+
+	err = debitBalance(APIstub, pending.From, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if travelRule != nil {
+		err = putTravelRule(APIstub, *travelRule)
+		if err != nil {
+			return shim.Error(notCommitted(err).Error())
+		}
+		pending.TravelRuleHash = travelRule.record.Hash
+	}
+	err = putPendingOutgoing(APIstub, pending)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	indexKey, err := buildKey(APIstub, pendingOutgoingByAccountPrefix, []string{pending.From, fmt.Sprintf("%020d", now), pending.ID})
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+	err = APIstub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(notCommitted(stateError(APIstub, "PutState", pendingOutgoingByAccountPrefix, err)).Error())
+	}
+
+	err = emitPendingOutgoingEvent(APIstub, "OutgoingTransferPending", pending)
+	if err != nil {
+		return shim.Error(notCommitted(err).Error())
+	}
+
+	return shim.Success([]byte(pending.ID))
+}
+
+// checkTwoPersonGated returns an error if `function` is gated and the caller's account is under a two-person rule
+func checkTwoPersonGated(APIstub shim.ChaincodeStubInterface, function string) error {
+	if !twoPersonGatedFunctions[function] {
+		return nil
+	}
+	clientID, err := getClientID(APIstub)
+	if err != nil {
+		return err
+	}
+	rule, err := getTwoPersonRule(APIstub, clientID)
+	if err != nil || rule == nil {
+		return err
+	}
+	return fmt.Errorf("%s is not available to %s, whose outgoing transfers need approval; use Transfer", function, clientID)
+}
+
+// effectiveStatus returns the status of the pending transfer at `now`, taking expiry into account
+// A transfer can no longer be approved at the exact expiry instant.
+func (p pendingOutgoing) effectiveStatus(now int64) string {
+	if p.Status == outgoingPending && now >= p.ExpiresAt {
+		return outgoingExpired
+	}
+	return p.Status
+}
+
+// checkApprovers returns the sorted, deduplicated approvers of `account`
+func checkApprovers(account string, approvers []string) ([]string, error) {
+	unique := make(map[string]bool, len(approvers))
+	for _, approver := range approvers {
+		if approver == "" {
+			return nil, fmt.Errorf("Approvers must be non-empty strings")
+		}
+		if approver == account {
+			return nil, fmt.Errorf("An account cannot approve its own transfers")
+		}
+		unique[approver] = true
+	}
+	sorted := make([]string, 0, len(unique))
+	for approver := range unique {
+		sorted = append(sorted, approver)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// containsString reports whether `values` contains `value`
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getTwoPersonRule returns the two-person rule of `account`, or nil if it has none
+func getTwoPersonRule(APIstub shim.ChaincodeStubInterface, account string) (*twoPersonRule, error) {
+	ruleKey, err := buildKey(APIstub, twoPersonRulePrefix, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	ruleBytes, err := APIstub.GetState(ruleKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", twoPersonRulePrefix, err)
+	}
+	if ruleBytes == nil {
+		return nil, nil
+	}
+	var rule twoPersonRule
+	err = json.Unmarshal(ruleBytes, &rule)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// getOpenOutgoing returns the pending outgoing transfer with the given ID if it was not executed or refunded yet
+func getOpenOutgoing(APIstub shim.ChaincodeStubInterface, id string) (*pendingOutgoing, error) {
+	pending, err := getPendingOutgoing(APIstub, id)
+	if err != nil {
+		return nil, err
+	}
+	if pending == nil {
+		return nil, fmt.Errorf("Pending transfer not found")
+	}
+	if pending.Status != outgoingPending {
+		return nil, fmt.Errorf("Pending transfer is %s", pending.Status)
+	}
+	return pending, nil
+}
+
+// getPendingOutgoing returns the stored pending outgoing transfer, or nil if there is none
+func getPendingOutgoing(APIstub shim.ChaincodeStubInterface, id string) (*pendingOutgoing, error) {
+	pendingKey, err := buildKey(APIstub, pendingOutgoingPrefix, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	pendingBytes, err := APIstub.GetState(pendingKey)
+	if err != nil {
+		return nil, stateError(APIstub, "GetState", pendingOutgoingPrefix, err)
+	}
+	if pendingBytes == nil {
+		return nil, nil
+	}
+	var pending pendingOutgoing
+	err = json.Unmarshal(pendingBytes, &pending)
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// putPendingOutgoing stores the pending outgoing transfer under its ID
+func putPendingOutgoing(APIstub shim.ChaincodeStubInterface, pending pendingOutgoing) error {
+	pendingKey, err := buildKey(APIstub, pendingOutgoingPrefix, []string{pending.ID})
+	if err != nil {
+		return err
+	}
+	pendingBytes, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	err = APIstub.PutState(pendingKey, pendingBytes)
+	if err != nil {
+		return stateError(APIstub, "PutState", pendingOutgoingPrefix, err)
+	}
+	return nil
+}
+
+// closePendingOutgoing stores the final status of a pending outgoing transfer and removes it from the account index
+func closePendingOutgoing(APIstub shim.ChaincodeStubInterface, pending pendingOutgoing) error {
+	err := putPendingOutgoing(APIstub, pending)
+	if err != nil {
+		return err
+	}
+	indexKey, err := buildKey(APIstub, pendingOutgoingByAccountPrefix, []string{pending.From, fmt.Sprintf("%020d", pending.ExpiresAt-pendingOutgoingTTL), pending.ID})
+	if err != nil {
+		return err
+	}
+	err = APIstub.DelState(indexKey)
+	if err != nil {
+		return stateError(APIstub, "DelState", pendingOutgoingByAccountPrefix, err)
+	}
+	return nil
+}
+
+// emitTwoPersonRuleEvent emits `name` with the state of the rule, labelled with the token symbol
+func emitTwoPersonRuleEvent(APIstub shim.ChaincodeStubInterface, name string, rule twoPersonRule) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(twoPersonRuleEvent{Token: symbol, twoPersonRule: rule})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
+
+// emitPendingOutgoingEvent emits `name` describing the current state of the pending transfer
+func emitPendingOutgoingEvent(APIstub shim.ChaincodeStubInterface, name string, pending pendingOutgoing) error {
+	symbol, err := getSymbol(APIstub)
+	if err != nil {
+		return err
+	}
+	eventBytes, err := json.Marshal(pendingOutgoingEvent{Token: symbol, pendingOutgoing: pending})
+	if err != nil {
+		return err
+	}
+	return APIstub.SetEvent(name, eventBytes)
+}
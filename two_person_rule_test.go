@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// dave is the third approver of the two-person rule tests
+var dave = chaintest.NewIdentity("Org2MSP", "dave")
+
+// recipient receives the transfers of the two-person rule tests; it approves nothing
+const recipient = "recipient"
+
+func TestSetTwoPersonRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		caller   chaintest.Identity
+		args     []string
+		expected string
+	}{
+		{"owner", false, alice, []string{alice.Account, `["` + bob.Account + `","` + carol.Account + `"]`, "1"}, ""},
+		{"threshold of every approver", false, alice, []string{alice.Account, `["` + bob.Account + `","` + carol.Account + `"]`, "2"}, ""},
+		{"admin for a treasury", false, admin, []string{"treasury", `["` + bob.Account + `"]`, "1"}, ""},
+		{"admin over an existing rule", true, admin, []string{alice.Account, `["` + carol.Account + `"]`, "1"}, ""},
+		{"admin removes", true, admin, []string{alice.Account, `[]`, "0"}, ""},
+		{"threshold above the approvers", false, alice, []string{alice.Account, `["` + bob.Account + `","` + carol.Account + `"]`, "3"}, "Invalid threshold. Expecting a number between 1 and 2"},
+		{"duplicate approvers count once", false, alice, []string{alice.Account, `["` + bob.Account + `","` + bob.Account + `"]`, "2"}, "Invalid threshold. Expecting a number between 1 and 1"},
+		{"zero threshold with approvers", false, alice, []string{alice.Account, `["` + bob.Account + `"]`, "0"}, "Invalid threshold"},
+		{"threshold without approvers", false, alice, []string{alice.Account, `[]`, "1"}, "Invalid threshold"},
+		{"negative threshold", false, alice, []string{alice.Account, `["` + bob.Account + `"]`, "-1"}, "Invalid threshold"},
+		{"own approver", false, alice, []string{alice.Account, `["` + alice.Account + `"]`, "1"}, "An account cannot approve its own transfers"},
+		{"empty approver", false, alice, []string{alice.Account, `[""]`, "1"}, "Approvers must be non-empty strings"},
+		{"not an array", false, alice, []string{alice.Account, bob.Account, "1"}, "Invalid approvers"},
+		{"another account", false, bob, []string{alice.Account, `["` + bob.Account + `"]`, "1"}, errUnauthorized},
+		{"owner over an existing rule", true, alice, []string{alice.Account, `["` + carol.Account + `"]`, "1"}, errUnauthorized},
+		{"owner removes", true, alice, []string{alice.Account, `[]`, "0"}, errUnauthorized},
+		{"remove without a rule", false, admin, []string{alice.Account, `[]`, "0"}, "Two-person rule not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			if test.existing {
+				mustInvoke(t, ledger, alice, "SetTwoPersonRule", alice.Account, `["`+bob.Account+`"]`, "1")
+			}
+			before := ledger.Fork()
+
+			result := ledger.Invoke(test.caller, "SetTwoPersonRule", test.args...)
+			if test.expected != "" {
+				if result.Status == shim.OK || !strings.HasPrefix(result.Message, test.expected) {
+					t.Fatalf("SetTwoPersonRule%q returned %q, expected %q", test.args, result.Message, test.expected)
+				}
+				if !sameState(before, ledger) {
+					t.Fatalf("refused SetTwoPersonRule%q changed the state", test.args)
+				}
+				return
+			}
+			if result.Status != shim.OK {
+				t.Fatalf("SetTwoPersonRule%q failed with %q", test.args, result.Message)
+			}
+			expectedEvent := "TwoPersonRuleSet"
+			if test.args[2] == "0" {
+				expectedEvent = "TwoPersonRuleRemoved"
+			}
+			if len(result.Events) != 1 || result.Events[0].Name != expectedEvent {
+				t.Fatalf("SetTwoPersonRule%q emitted %+v, expected %s", test.args, result.Events, expectedEvent)
+			}
+		})
+	}
+}
+
+// TestApproveOutgoingThreshold puts alice under a rule with approvers bob, carol and dave and
+// submits `approvals` in order for a Transfer of 30; the transfer executes on approval `executes`
+// (1-based, 0 for never) and each approval fails with the message in `refusals`, if any
+func TestApproveOutgoingThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string
+		approvals []chaintest.Identity
+		refusals  map[int]string
+		executes  int
+	}{
+		{"1 of 3", "1", []chaintest.Identity{bob}, nil, 1},
+		{"2 of 3", "2", []chaintest.Identity{bob, carol}, nil, 2},
+		{"3 of 3", "3", []chaintest.Identity{dave, carol, bob}, nil, 3},
+		{"2 of 3, one approval", "2", []chaintest.Identity{carol}, nil, 0},
+		{"same approver twice", "2", []chaintest.Identity{bob, bob}, map[int]string{2: "Pending transfer already approved by the caller"}, 0},
+		{"sender", "1", []chaintest.Identity{alice}, map[int]string{1: "Only an approver of the sender can approve a pending transfer"}, 0},
+		{"recipient", "1", []chaintest.Identity{chaintest.NewIdentity("Org1MSP", recipient)}, map[int]string{1: "Only an approver of the sender can approve a pending transfer"}, 0},
+		{"after execution", "1", []chaintest.Identity{bob, carol}, map[int]string{2: "Pending transfer is executed"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := newToken(t, "")
+			fund(t, ledger, alice.Account, 100)
+			mustInvoke(t, ledger, alice, "SetTwoPersonRule", alice.Account, `["`+bob.Account+`","`+carol.Account+`","`+dave.Account+`"]`, test.threshold)
+
+			pendingID := mustInvoke(t, ledger, alice, "Transfer", recipient, "30")
+			if got := balanceOf(t, ledger, alice.Account); got != 70 {
+				t.Fatalf("sender holds %d while the transfer is pending, expected 70", got)
+			}
+			for i, approver := range test.approvals {
+				result := ledger.Invoke(approver, "ApproveOutgoing", pendingID)
+				if refusal := test.refusals[i+1]; refusal != "" {
+					if result.Status == shim.OK || result.Message != refusal {
+						t.Fatalf("approval %d returned %q, expected %q", i+1, result.Message, refusal)
+					}
+				} else if result.Status != shim.OK {
+					t.Fatalf("approval %d failed with %q", i+1, result.Message)
+				}
+
+				expected := 0
+				if test.executes != 0 && i+1 >= test.executes {
+					expected = 30
+				}
+				if got := balanceOf(t, ledger, recipient); got != expected {
+					t.Fatalf("recipient holds %d after approval %d, expected %d", got, i+1, expected)
+				}
+			}
+			if got := balanceOf(t, ledger, alice.Account); got != 70 {
+				t.Fatalf("sender holds %d after the approvals, expected 70", got)
+			}
+		})
+	}
+}
+
+// TestPendingOutgoingExpiry approves or refunds a pending transfer `offset` seconds after it was
+// made; from pendingOutgoingTTL on it has expired, cannot be approved and anyone can refund it
+func TestPendingOutgoingExpiry(t *testing.T) {
+	tests := []struct {
+		offset  int64
+		expired bool
+	}{
+		{0, false},
+		{pendingOutgoingTTL - 1, false},
+		{pendingOutgoingTTL, true},
+		{pendingOutgoingTTL + 1, true},
+	}
+	for _, test := range tests {
+		ledger := newToken(t, "")
+		fund(t, ledger, alice.Account, 100)
+		mustInvoke(t, ledger, alice, "SetTwoPersonRule", alice.Account, `["`+bob.Account+`"]`, "1")
+		pendingID := mustInvoke(t, ledger, alice, "Transfer", recipient, "30")
+		ledger.Now += test.offset
+
+		status := outgoingPending
+		if test.expired {
+			status = outgoingExpired
+		}
+		var listed pendingOutgoingResponse
+		err := json.Unmarshal([]byte(mustInvoke(t, ledger, bob, "ListPendingOutgoing", alice.Account)), &listed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listed.Transfers) != 1 || listed.Transfers[0].ID != pendingID || listed.Transfers[0].Status != status {
+			t.Fatalf("ListPendingOutgoing after %d seconds returned %+v, expected %s %s", test.offset, listed.Transfers, pendingID, status)
+		}
+
+		// Only the sender can cancel a transfer that has not expired
+		cancel := ledger.Invoke(carol, "CancelOutgoing", pendingID)
+		if test.expired != (cancel.Status == shim.OK) {
+			t.Fatalf("CancelOutgoing by a third party after %d seconds returned %q, expected it to succeed: %t", test.offset, cancel.Message, test.expired)
+		}
+		approve := ledger.Invoke(bob, "ApproveOutgoing", pendingID)
+		if test.expired {
+			if len(cancel.Events) != 1 || cancel.Events[0].Name != "OutgoingTransferExpired" {
+				t.Fatalf("refund after %d seconds emitted %+v, expected OutgoingTransferExpired", test.offset, cancel.Events)
+			}
+			if approve.Message != "Pending transfer is expired" {
+				t.Fatalf("ApproveOutgoing after the refund returned %q, expected Pending transfer is expired", approve.Message)
+			}
+		} else if approve.Status != shim.OK {
+			t.Fatalf("ApproveOutgoing after %d seconds failed with %q", test.offset, approve.Message)
+		}
+
+		balances := map[string]int{alice.Account: 70, recipient: 30}
+		if test.expired {
+			balances = map[string]int{alice.Account: 100, recipient: 0}
+		}
+		for account, expected := range balances {
+			if got := balanceOf(t, ledger, account); got != expected {
+				t.Fatalf("%s holds %d after %d seconds, expected %d", account, got, test.offset, expected)
+			}
+		}
+		err = json.Unmarshal([]byte(mustInvoke(t, ledger, bob, "ListPendingOutgoing", alice.Account)), &listed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listed.Transfers) != 0 {
+			t.Fatalf("ListPendingOutgoing still lists %+v once the transfer is closed", listed.Transfers)
+		}
+	}
+
+	// An expired transfer cannot be approved before it is refunded either
+	ledger := newToken(t, "")
+	fund(t, ledger, alice.Account, 100)
+	mustInvoke(t, ledger, alice, "SetTwoPersonRule", alice.Account, `["`+bob.Account+`"]`, "1")
+	pendingID := mustInvoke(t, ledger, alice, "Transfer", recipient, "30")
+	ledger.Now += pendingOutgoingTTL
+	if message := mustFail(t, ledger, bob, "ApproveOutgoing", pendingID); message != "Pending transfer has expired" {
+		t.Fatalf("ApproveOutgoing at the expiry failed with %q, expected Pending transfer has expired", message)
+	}
+}
+
+// TestTwoPersonRuleGatesOtherFunctions checks that an account under a rule cannot move tokens
+// other than through Transfer, while an account without a rule transfers at once
+func TestTwoPersonRuleGatesOtherFunctions(t *testing.T) {
+	tests := []struct {
+		function string
+		args     []string
+	}{
+		{"Burn", []string{"10"}},
+		{"Approve", []string{bob.Account, "10"}},
+		{"TransferAndCall", []string{"hook", "10"}},
+	}
+	for _, test := range tests {
+		ledger := newToken(t, "")
+		fund(t, ledger, alice.Account, 100)
+		fund(t, ledger, bob.Account, 100)
+		mustInvoke(t, ledger, alice, "SetTwoPersonRule", alice.Account, `["`+bob.Account+`"]`, "1")
+
+		message := mustFail(t, ledger, alice, test.function, test.args...)
+		if !strings.Contains(message, "is not available to "+alice.Account) {
+			t.Fatalf("%s%q under a two-person rule failed with %q", test.function, test.args, message)
+		}
+		if got := balanceOf(t, ledger, alice.Account); got != 100 {
+			t.Fatalf("%s%q under a two-person rule left alice with %d, expected 100", test.function, test.args, got)
+		}
+
+		mustInvoke(t, ledger, bob, "Transfer", recipient, "10")
+		if got := balanceOf(t, ledger, recipient); got != 10 {
+			t.Fatalf("Transfer from an account without a rule credited %d, expected 10", got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Define key names for strict mode
+const strictModeKey = "strictMode"
+
+// Define the codes of the warnings attached to successful responses
+const warnQuerySubmitted = "WARN_QUERY_SUBMITTED"
+const warnReceiverNotNotified = "WARN_RECEIVER_NOT_NOTIFIED"
+
+// strictErrorCodes maps every warning to the error it becomes in strict mode
+// The peer cannot tell an evaluated query from a submitted one, so with strictQueries set strict
+// mode fails every query with ERR_STRICT_QUERY_SUBMITTED, evaluated or not.
+var strictErrorCodes = map[string]string{
+	warnQuerySubmitted:      "ERR_STRICT_QUERY_SUBMITTED",
+	warnReceiverNotNotified: "ERR_STRICT_RECEIVER_NOT_NOTIFIED",
+}
+
+// SetStrictMode makes warnings fail the transaction ("true") or only be reported ("false")
+// Only admins can change it. The initial value is the strictMode option of Initialize.
+func (s *SmartContract) SetStrictMode(APIstub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	strict, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return shim.Error("Invalid flag. Expecting true or false")
+	}
+
+	err = requireRole(APIstub, adminRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = APIstub.PutState(strictModeKey, []byte(strconv.FormatBool(strict)))
+	if err != nil {
+		return shim.Error(stateError(APIstub, "PutState", strictModeKey, err).Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// withWarning attaches the warning `code` to the message of the successful `response`
+// In strict mode the warning's ERR_STRICT_ error is returned instead, so none of the handler's
+// writes are committed.
+func withWarning(APIstub shim.ChaincodeStubInterface, response peer.Response, code string, format string, args ...interface{}) peer.Response {
+	strictCode, known := strictErrorCodes[code]
+	if !known {
+		return shim.Error(fmt.Sprintf("Unknown warning %s", code))
+	}
+	strict, err := getBoolSetting(APIstub, strictModeKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if strict {
+		err = newCodedError(APIstub, strictCode, nil, format, args...)
+		return shim.Error(notCommitted(err).Error())
+	}
+	response.Message = code + ": " + fmt.Sprintf(format, args...)
+	return response
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NguyenTaHuyHoang/Chaincode-token-erc-20/internal/chaintest"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// warningTriggers hold, for every warning, a call that succeeds with it on a token initialized
+// with `options` and prepared by `setup`
+var warningTriggers = map[string]struct {
+	options  string
+	setup    func(t *testing.T, ledger *chaintest.Ledger)
+	caller   chaintest.Identity
+	function string
+	args     []string
+}{
+	warnQuerySubmitted: {`{"strictQueries":true}`, nil, alice, "TotalSupply", nil},
+	warnReceiverNotNotified: {"", func(t *testing.T, ledger *chaintest.Ledger) {
+		// The record RegisterReceiver stores once the chaincode answered its ping
+		ledger.SetState("\x00"+receiverPrefix+"\x00hook\x00", []byte(`{"chaincodeName":"hook","functionName":"onToken"}`))
+		fund(t, ledger, alice.Account, 100)
+	}, alice, "Transfer", []string{receiverAccountPrefix + "hook", "10"}},
+}
+
+// TestWarnings triggers every warning with strict mode off and on
+// Off, the call succeeds with the warning in its message; on, it fails with the warning's
+// ERR_STRICT_ error and commits nothing.
+func TestWarnings(t *testing.T) {
+	for code, strictCode := range strictErrorCodes {
+		trigger, found := warningTriggers[code]
+		if !found {
+			t.Errorf("%s has no trigger in warningTriggers", code)
+			continue
+		}
+		if !strings.HasPrefix(strictCode, "ERR_STRICT_") {
+			t.Errorf("%s becomes %q in strict mode, expected an ERR_STRICT_ error", code, strictCode)
+		}
+		for _, strict := range []string{"false", "true"} {
+			ledger := newToken(t, trigger.options)
+			if trigger.setup != nil {
+				trigger.setup(t, ledger)
+			}
+			mustInvoke(t, ledger, admin, "SetStrictMode", strict)
+			before := ledger.Fork()
+
+			result := ledger.Invoke(trigger.caller, trigger.function, trigger.args...)
+			if strict == "false" {
+				if result.Status != shim.OK || !strings.HasPrefix(result.Message, code+": ") {
+					t.Errorf("%s%q returned status %d and %q, expected success with %s", trigger.function, trigger.args, result.Status, result.Message, code)
+				}
+				continue
+			}
+			if result.Status == shim.OK || !strings.HasPrefix(result.Message, strictCode+": ") || !strings.HasSuffix(result.Message, "no changes were committed") {
+				t.Errorf("%s%q in strict mode returned status %d and %q, expected %s", trigger.function, trigger.args, result.Status, result.Message, strictCode)
+			}
+			if !sameState(before, ledger) {
+				t.Errorf("%s%q changed the state in strict mode", trigger.function, trigger.args)
+			}
+		}
+	}
+}